@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,7 +31,9 @@ import (
 	"github.com/allegro/akubra/internal/akubra/regions"
 	"github.com/allegro/akubra/internal/akubra/sentry"
 	"github.com/allegro/akubra/internal/akubra/storages"
+	"github.com/allegro/akubra/internal/akubra/storages/auth"
 	"github.com/allegro/akubra/internal/akubra/transport"
+	"github.com/allegro/akubra/internal/akubra/utils"
 
 	_ "github.com/lib/pq"
 )
@@ -56,10 +60,6 @@ var (
 			Bool()
 )
 
-const (
-	postgresConnStringFormat = "sslmode=disable dbname=:dbname: user=:user: password=:password: host=:host: port=:port: connect_timeout=:conntimeout:"
-)
-
 func main() {
 	versionString := fmt.Sprintf("Akubra (%s version)", version)
 	kingpin.Version(versionString)
@@ -82,7 +82,20 @@ func main() {
 	log.Printf("Health check endpoint: %s", conf.Service.Server.HealthCheckEndpoint)
 	mainlog.Printf("starting on port %s", conf.Service.Server.Listen)
 
-	srv := newService(conf, *configFile)
+	storagesAdmin, err := storages.NewAdmin(conf.Service.Server.StorageStateFile)
+	if err != nil {
+		mainlog.Fatalf("Could not set up storages admin: %s", err)
+	}
+	if conf.Service.Server.BreakerGossip.Listen != "" {
+		breakerGossip, err := storages.NewBreakerGossip(conf.Service.Server.BreakerGossip, storagesAdmin)
+		if err != nil {
+			mainlog.Fatalf("Could not set up breaker gossip: %s", err)
+		}
+		storagesAdmin.SetBreakerPublisher(breakerGossip)
+		go breakerGossip.Serve()
+	}
+
+	srv := newService(conf, *configFile, storagesAdmin)
 	srv.startTechnicalEndpoint()
 	startErr := srv.start()
 	if startErr != nil {
@@ -137,6 +150,9 @@ func readVaultConfiguration() (config.Config, error) {
 
 func readFileConfiguration() (config.Config, error) {
 	configReadCloser, err := config.ReadConfiguration(*configFile)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("Could not read configuration file %s: %s", *configFile, err)
+	}
 	log.Println("Read configuration from file")
 	defer func() {
 		err = configReadCloser.Close()
@@ -144,10 +160,6 @@ func readFileConfiguration() (config.Config, error) {
 			log.Debugf("Cannot close configuration, reason: %s", err)
 		}
 	}()
-
-	if err != nil {
-		log.Fatalf("Could not read configuration file {}", *configFile)
-	}
 	return parseConfig(configReadCloser)
 }
 
@@ -175,26 +187,35 @@ func mkServiceLogs(logConf logconfig.LoggingConfig) (accessLog log.Logger, err e
 	return
 }
 
-func newService(cfg config.Config, configPath string) *service {
+func newService(cfg config.Config, configPath string, storagesAdmin *storages.Admin) *service {
 	hh := func(rw http.ResponseWriter, r *http.Request) {}
 	var h = http.HandlerFunc(hh)
-	return &service{config: cfg, configPath: configPath, handler: h}
+	s := &service{config: cfg, configPath: configPath, storagesAdmin: storagesAdmin}
+	s.graph.Store(newHandlerGraph(h, cfg))
+	return s
 }
 
 type service struct {
-	config     config.Config
-	configPath string
-	handler    http.Handler
-	srv        *http.Server
-	ctx        context.Context
+	config        config.Config
+	configPath    string
+	graph         atomic.Value
+	reloadMx      sync.Mutex
+	storagesAdmin *storages.Admin
+	srv           *http.Server
+	ctx           context.Context
 }
 
 func (s *service) start() (err error) {
 	s.ctx = context.Background()
-	s.handler, err = s.createHandler(s.config)
+	err = metrics.Init(s.config.Metrics)
+	if err != nil {
+		log.Printf("Metrics initialization error: %s", err)
+	}
+	graph, err := s.createHandler(s.config)
 	if err != nil {
 		log.Fatalf("Handler creation error: %s", err)
 	}
+	s.graph.Store(graph)
 	srv := &http.Server{
 		Addr:         s.config.Service.Server.Listen,
 		Handler:      s,
@@ -221,17 +242,9 @@ func (s *service) signalsHandler() {
 		signal.Notify(intr, syscall.SIGINT)
 		select {
 		case <-hup:
-			conf, err := readConfiguration()
-			if err != nil {
-				log.Printf("New config is corrupted %s", err)
-				continue
-			}
-			handler, err := s.createHandler(conf)
-			if err != nil {
-				log.Printf("Handler initialization failure %s", err)
+			if _, err := s.reload(); err != nil {
+				log.Printf("Configuration reload failed: %s", err)
 			}
-			s.handler = handler
-			log.Println("Handler replaced")
 		case <-intr:
 			log.Println("Shutting down")
 			err := s.srv.Shutdown(s.ctx)
@@ -244,11 +257,17 @@ func (s *service) signalsHandler() {
 }
 
 func (s *service) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	handler := s.handler
-	handler.ServeHTTP(rw, r)
+	for {
+		graph := s.currentGraph()
+		if graph.acquire() {
+			defer graph.release()
+			graph.handler.ServeHTTP(rw, r)
+			return
+		}
+	}
 }
 
-func (s *service) createHandler(conf config.Config) (http.Handler, error) {
+func (s *service) createHandler(conf config.Config) (*handlerGraph, error) {
 	transportMatcher, err := transport.ConfigureHTTPTransports(conf.Service.Client)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't set up client Transports - err: %q", err)
@@ -257,40 +276,67 @@ func (s *service) createHandler(conf config.Config) (http.Handler, error) {
 	if err != nil {
 		return nil, err
 	}
+	graph := newHandlerGraph(nil, conf)
+	if idleConnsCloser, ok := transportMatcher.(interface{ CloseIdleConnections() }); ok {
+		graph.addCloser(closerFunc(func() error {
+			idleConnsCloser.CloseIdleConnections()
+			return nil
+		}))
+	}
 
 	crdstore.InitializeCredentialsStores(conf.CredentialsStores)
 
 	watchdogRecordFactory := &watchdog.DefaultConsistencyRecordFactory{}
-	consistencyWatchdog := setupWatchdog(s.config.Watchdog)
+	consistencyWatchdog, err := setupWatchdog(conf.Watchdog)
+	if err != nil {
+		return nil, err
+	}
+	if watchdogCloser, ok := consistencyWatchdog.(io.Closer); ok {
+		graph.addCloser(watchdogCloser)
+	}
 
-	storagesFactory := storages.NewStoragesFactory(transportMatcher, &s.config.Watchdog, consistencyWatchdog, watchdogRecordFactory)
-	ignoredSignHeaders := map[string]bool{s.config.Watchdog.ObjectVersionHeaderName: true}
+	storagesFactory := storages.NewStoragesFactory(transportMatcher, &conf.Watchdog, consistencyWatchdog, watchdogRecordFactory)
+	ignoredSignHeaders := map[string]bool{conf.Watchdog.ObjectVersionHeaderName: true}
 	for k, v := range conf.IgnoredCanonicalizedHeaders {
 		ignoredSignHeaders[k] = v
 	}
-	storage, err := storagesFactory.InitStorages(s.config.Shards, s.config.Storages, ignoredSignHeaders)
-
+	storage, err := storagesFactory.InitStorages(conf.Shards, conf.Storages, ignoredSignHeaders)
 	if err != nil {
-		log.Fatalf("Storages initialization problem: %q", err)
-		return nil, err
+		graph.close()
+		return nil, fmt.Errorf("Storages initialization problem: %q", err)
+	}
+	if storagesCloser, ok := storage.(io.Closer); ok {
+		graph.addCloser(storagesCloser)
 	}
 
 	privacyContextSupplier := privacy.NewBasicPrivacyContextSupplier(&conf.Privacy)
 
 	hasher := &metadata.Fnv64Hasher{}
 	conf.BucketMetaDataCache.Hasher = hasher
+	if sqlWatchdog, ok := consistencyWatchdog.(*watchdog.SQLWatchdog); ok {
+		conf.BucketMetaDataCache.DB = sqlWatchdog.DB()
+	}
 	bucketMetaDataCache, err := metadata.NewBucketMetaDataCacheWithFactory(&conf.BucketMetaDataCache)
 	if err != nil {
-		log.Fatalf("Failed to initialize bucket cache: %q", err)
-		return nil, err
+		graph.close()
+		return nil, fmt.Errorf("Failed to initialize bucket cache: %q", err)
+	}
+	if cacheCloser, ok := bucketMetaDataCache.(io.Closer); ok {
+		graph.addCloser(cacheCloser)
 	}
 
-	privacyFilters := []privacy.Filter{privacy.NewBucketPrivacyFilterFunc(bucketMetaDataCache)}
+	privacyFilters, err := privacy.NewFilters(conf.Privacy.Filters)
+	if err != nil {
+		graph.close()
+		return nil, fmt.Errorf("Failed to initialize privacy filters: %q", err)
+	}
+	privacyFilters = append([]privacy.Filter{privacy.NewBucketPrivacyFilterFunc(bucketMetaDataCache)}, privacyFilters...)
 	basicChain := privacy.NewBasicChain(privacyFilters)
 
-	regionsRT, err := regions.NewRegions(s.config, storage,
+	regionsRT, err := regions.NewRegions(conf, storage,
 		consistencyWatchdog, watchdogRecordFactory, conf.Watchdog.ObjectVersionHeaderName)
 	if err != nil {
+		graph.close()
 		return nil, err
 	}
 
@@ -300,45 +346,70 @@ func (s *service) createHandler(conf config.Config) (http.Handler, error) {
 	regionsDecoratedRT = httphandler.Decorate(regionsDecoratedRT,
 		httphandler.ResponseHeadersStripper(conf.Service.Client.ResponseHeadersToStrip),
 		httphandler.PrivacyFilterChain(conf.Privacy.DropOnError, conf.Privacy.DropOnValidation, conf.Privacy.ViolationErrorCode, basicChain),
+		httphandler.BucketPolicyEnforcer(bucketMetaDataCache),
+		// the health check doesn't depend on the bucket meta data
+		httphandler.HealthCheckHandler(conf.Service.Server.HealthCheckEndpoint),
+		regions.VirtualHostedStyleNormalizer(conf.ShardingPolicies),
 		httphandler.PrivacyContextSupplier(privacyContextSupplier),
 		httphandler.AccessLogging(accessLog),
 	)
 
 	handler, err := httphandler.NewHandlerWithRoundTripper(regionsDecoratedRT, conf.Service.Server)
 	if err != nil {
+		graph.close()
 		return nil, err
 	}
 
-	err = metrics.Init(conf.Metrics)
-	if err != nil {
-		log.Printf("Metrics initialization error: %s", err)
-	}
-
-	sentryHandler, err := sentry.CreateSentryHandler(&s.config.Sentry)
+	sentryHandler, err := sentry.CreateSentryHandler(&conf.Sentry)
 	if err != nil {
 		log.Printf("Sentry initialization error: %s", err)
 	} else {
 		handler = sentryHandler.Handle(handler)
 	}
 
-	return handler, nil
+	graph.handler = handler
+	graph.presign = auth.NewPresignHandler(conf.Service.Server.Presign, presignKeys(regionsRT, bucketMetaDataCache))
+	s.storagesAdmin.Attach(storage)
+	if probedStorages, ok := storage.(*storages.Storages); ok {
+		probedStorages.StartHealthProbes()
+	}
+	return graph, nil
 }
 
-func setupWatchdog(watchdogConfig watchdogConfig.WatchdogConfig) watchdog.ConsistencyWatchdog {
-	if watchdogConfig.Type == "" {
-		return nil
+func (s *service) presignHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	presign := s.currentGraph().presign
+	if presign == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
 	}
+	presign.ServeHTTP(w, r)
+}
 
-	consistencyWatchdog, err := watchdog.CreateSQL("postgres",
-		postgresConnStringFormat,
-		[]string{"user", "password", "dbname", "host", "port", "conntimeout"},
-		&watchdogConfig)
+// presignKeys resolves the credentials the shard serving the presigned URL checks it with
+func presignKeys(regionsRT *regions.Regions, fetcher metadata.BucketMetaDataFetcher) auth.PresignKeysResolver {
+	return func(domain, method, path, accessKey string) ([]auth.Keys, error) {
+		bucketMetaData, err := fetcher.Fetch(&metadata.BucketLocation{Name: utils.ExtractBucketFrom(path)})
+		if err != nil {
+			return nil, err
+		}
+		backends, err := regionsRT.Backends(domain, method, path, bucketMetaData)
+		if err == regions.ErrNoSuchDomain {
+			return nil, auth.ErrPresignDomainNotServed
+		}
+		if err != nil {
+			return nil, err
+		}
+		return storages.BackendsKeys(accessKey, backends)
+	}
+}
 
+func setupWatchdog(watchdogConfig watchdogConfig.WatchdogConfig) (watchdog.ConsistencyWatchdog, error) {
+	consistencyWatchdog, err := watchdog.CreateWatchdog(&watchdogConfig)
 	if err != nil {
-		log.Fatalf("Failed to create consistencyWatchdog %s", err)
+		return nil, fmt.Errorf("Failed to create consistencyWatchdog %s", err)
 	}
 
-	return consistencyWatchdog
+	return consistencyWatchdog, nil
 }
 
 func (s *service) startTechnicalEndpoint() {
@@ -349,6 +420,15 @@ func (s *service) startTechnicalEndpoint() {
 		"/configuration/validate",
 		config.ValidateConfigurationHTTPHandler,
 	)
+	serveMuxHandler.HandleFunc(
+		"/configuration/reload",
+		s.reloadConfigurationHTTPHandler,
+	)
+	serveMuxHandler.Handle("/storages", s.storagesAdmin)
+	serveMuxHandler.Handle("/storages/", s.storagesAdmin)
+	serveMuxHandler.Handle("/breakers", s.storagesAdmin.BreakersHandler())
+	serveMuxHandler.Handle("/metrics", metrics.LabelledHandler())
+	serveMuxHandler.HandleFunc("/presign", s.presignHTTPHandler)
 	go func() {
 		srv := &http.Server{
 			Addr:           port,
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/config"
+	"github.com/allegro/akubra/internal/akubra/log"
+)
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// handlerGraph is a request handler together with the resources it was built with
+type handlerGraph struct {
+	handler  http.Handler
+	presign  http.Handler
+	config   config.Config
+	closers  []io.Closer
+	mx       sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+func newHandlerGraph(handler http.Handler, conf config.Config) *handlerGraph {
+	return &handlerGraph{handler: handler, config: conf}
+}
+
+// addCloser registers a resource to be closed once the graph is drained
+func (graph *handlerGraph) addCloser(closer io.Closer) {
+	graph.closers = append(graph.closers, closer)
+}
+
+// acquire registers an in-flight request, it fails if the graph is already being drained
+func (graph *handlerGraph) acquire() bool {
+	graph.mx.Lock()
+	defer graph.mx.Unlock()
+	if graph.draining {
+		return false
+	}
+	graph.inFlight.Add(1)
+	return true
+}
+
+func (graph *handlerGraph) release() {
+	graph.inFlight.Done()
+}
+
+// drain waits for the in-flight requests, at most for timeout, and closes the graph resources
+func (graph *handlerGraph) drain(timeout time.Duration) {
+	graph.mx.Lock()
+	graph.draining = true
+	graph.mx.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		graph.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		log.Println("Old handler drained")
+	case <-time.After(timeout):
+		log.Printf("Old handler not drained within %s, closing its resources anyway", timeout)
+	}
+	graph.close()
+}
+
+// close closes the resources of the graph
+func (graph *handlerGraph) close() {
+	for _, closer := range graph.closers {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close handler resource: %s", err)
+		}
+	}
+}
+
+func (s *service) currentGraph() *handlerGraph {
+	return s.graph.Load().(*handlerGraph)
+}
+
+// reload reads and validates the configuration, builds a new handler graph from it and swaps it
+// with the current one, which is drained in the background
+func (s *service) reload() (config.Diff, error) {
+	s.reloadMx.Lock()
+	defer s.reloadMx.Unlock()
+
+	conf, err := readConfiguration()
+	if err != nil {
+		return config.Diff{}, fmt.Errorf("new config is corrupted: %s", err)
+	}
+	oldGraph := s.currentGraph()
+	diff := config.CompareConf(oldGraph.config.YamlConfig, conf.YamlConfig)
+	if diff.IsEmpty() {
+		log.Println("Configuration unchanged, handler not replaced")
+		return diff, nil
+	}
+	warnAboutRestartRequiringChanges(oldGraph.config, conf)
+
+	graph, err := s.createHandler(conf)
+	if err != nil {
+		return config.Diff{}, fmt.Errorf("handler initialization failure: %s", err)
+	}
+	s.graph.Store(graph)
+	log.Printf("Handler replaced, changed sections: %v", diff.ChangedSections)
+	go oldGraph.drain(oldGraph.config.Service.Server.ShutdownTimeout.Duration)
+	return diff, nil
+}
+
+func warnAboutRestartRequiringChanges(oldConf, newConf config.Config) {
+	oldServer, newServer := oldConf.Service.Server, newConf.Service.Server
+	if oldServer.Listen != newServer.Listen || oldServer.TechnicalEndpointListen != newServer.TechnicalEndpointListen ||
+		oldServer.ReadTimeout != newServer.ReadTimeout || oldServer.WriteTimeout != newServer.WriteTimeout {
+		log.Println("Listen addresses and server timeouts changes require a restart to take effect")
+	}
+	if !reflect.DeepEqual(oldConf.Logging.Mainlog, newConf.Logging.Mainlog) {
+		log.Println("Mainlog changes require a restart to take effect")
+	}
+	if !reflect.DeepEqual(oldConf.Metrics, newConf.Metrics) {
+		log.Println("Metrics changes require a restart to take effect")
+	}
+}
+
+// reloadConfigurationHTTPHandler triggers the configuration reload and responds with the configuration diff
+func (s *service) reloadConfigurationHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	diff, err := s.reload()
+	if err != nil {
+		log.Printf("Configuration reload failed: %s", err)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		log.Printf("Failed to write configuration diff: %s", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/allegro/akubra/internal/akubra/config/vault"
 	"github.com/allegro/akubra/internal/akubra/log"
 	bConf "github.com/allegro/akubra/internal/brim/config"
+	"github.com/allegro/akubra/internal/brim/rebalance"
+	"github.com/allegro/akubra/internal/brim/reconcile"
 	watchdog "github.com/allegro/akubra/internal/brim/watchdog-main"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"io"
@@ -25,6 +27,15 @@ var (
 			Flag("bconfig", "Configuration file path e.g.: \"conf/dev.yaml\"").
 			Short('b').
 			ExistingFile()
+	rebalancedRegion = kingpin.
+				Flag("rebalance", "Moves the objects of the region to the shards of its current ring and exits").
+				String()
+	reconciledShard = kingpin.
+			Flag("reconcile", "Compares the buckets across the storages of the shard, synchronizes the differing objects and exits").
+			String()
+	dryRun = kingpin.
+		Flag("dry-run", "Reports the objects differing across the storages of the reconciled shard without synchronizing them").
+		Bool()
 	akubraVersionVarName = "AKUBRA_VERSION"
 )
 
@@ -39,6 +50,20 @@ func main() {
 		log.Fatalf("Improperly configured %s", err)
 	}
 	go runHealthCheck()
+	if *rebalancedRegion != "" {
+		if err := rebalance.Run(&akubraConf, &brimConf, *rebalancedRegion); err != nil {
+			log.Fatalf("Rebalance of region %s failed: %s", *rebalancedRegion, err)
+		}
+		log.Printf("Rebalance of region %s finished", *rebalancedRegion)
+		return
+	}
+	if *reconciledShard != "" {
+		if err := reconcile.Run(&akubraConf, &brimConf, *reconciledShard, *dryRun); err != nil {
+			log.Fatalf("Reconciliation of shard %s failed: %s", *reconciledShard, err)
+		}
+		log.Printf("Reconciliation of shard %s finished", *reconciledShard)
+		return
+	}
 	watchdog.RunWatchdogWorker(&akubraConf, &brimConf)
 }
 func runHealthCheck() {
@@ -24,3 +24,15 @@ CREATE INDEX consistency_record__inserted_at
   ON consistency_record
     USING btree (object_version DESC);
 
+CREATE TABLE bucket_metadata
+(
+  name                CHARACTER VARYING(254)  PRIMARY KEY,
+  pattern             CHARACTER VARYING(1024) NOT NULL DEFAULT '',
+  is_internal         BOOLEAN                 NOT NULL DEFAULT FALSE,
+  allowed_access_keys CHARACTER VARYING(4096) NOT NULL DEFAULT '',
+  sharding_policy     CHARACTER VARYING(254)  NOT NULL DEFAULT '',
+  read_only           BOOLEAN                 NOT NULL DEFAULT FALSE,
+  frozen              BOOLEAN                 NOT NULL DEFAULT FALSE,
+  max_object_size     BIGINT                  NOT NULL DEFAULT 0,
+  consistency_level   CHARACTER VARYING(16)   NOT NULL DEFAULT ''
+);
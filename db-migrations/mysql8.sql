@@ -0,0 +1,34 @@
+CREATE TABLE consistency_record
+(
+  object_version  BIGINT        NOT NULL,
+  request_id      CHAR(36)      NOT NULL PRIMARY KEY,
+  object_id       VARCHAR(1024) NOT NULL,
+  method          VARCHAR(8)    NOT NULL,
+  domain          VARCHAR(254)  NOT NULL,
+  access_key      VARCHAR(128)  NOT NULL,
+  execution_delay BIGINT        NOT NULL,
+  inserted_at     TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at      TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  error           VARCHAR(1024)          DEFAULT ''
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
+
+CREATE UNIQUE INDEX consistency_record__domain__object_id__inserted_at
+  ON consistency_record (domain, object_id(500), object_version);
+
+CREATE INDEX consistency_record__inserted_at
+  ON consistency_record (object_version DESC);
+
+CREATE TABLE bucket_metadata
+(
+  name                VARCHAR(254)  NOT NULL PRIMARY KEY,
+  pattern             VARCHAR(1024) NOT NULL DEFAULT '',
+  is_internal         BOOLEAN       NOT NULL DEFAULT FALSE,
+  allowed_access_keys VARCHAR(4096) NOT NULL DEFAULT '',
+  sharding_policy     VARCHAR(254)  NOT NULL DEFAULT '',
+  read_only           BOOLEAN       NOT NULL DEFAULT FALSE,
+  frozen              BOOLEAN       NOT NULL DEFAULT FALSE,
+  max_object_size     BIGINT        NOT NULL DEFAULT 0,
+  consistency_level   VARCHAR(16)   NOT NULL DEFAULT ''
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
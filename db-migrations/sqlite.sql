@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS consistency_record
+(
+  object_version  INTEGER       NOT NULL,
+  request_id      VARCHAR(36)   PRIMARY KEY,
+  object_id       VARCHAR(1024) NOT NULL,
+  method          VARCHAR(8)    NOT NULL,
+  domain          VARCHAR(254)  NOT NULL,
+  access_key      VARCHAR(128)  NOT NULL,
+  execution_delay INTEGER       NOT NULL,
+  inserted_at     TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at      TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  error           VARCHAR(1024)          DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS consistency_record__domain__object_id__inserted_at
+  ON consistency_record (domain, object_id, object_version);
+CREATE INDEX IF NOT EXISTS consistency_record__inserted_at
+  ON consistency_record (object_version DESC);
+CREATE TABLE IF NOT EXISTS bucket_metadata
+(
+  name                VARCHAR(254)  PRIMARY KEY,
+  pattern             VARCHAR(1024) NOT NULL DEFAULT '',
+  is_internal         BOOLEAN       NOT NULL DEFAULT FALSE,
+  allowed_access_keys VARCHAR(4096) NOT NULL DEFAULT '',
+  sharding_policy     VARCHAR(254)  NOT NULL DEFAULT '',
+  read_only           BOOLEAN       NOT NULL DEFAULT FALSE,
+  frozen              BOOLEAN       NOT NULL DEFAULT FALSE,
+  max_object_size     BIGINT        NOT NULL DEFAULT 0,
+  consistency_level   VARCHAR(16)   NOT NULL DEFAULT ''
+);
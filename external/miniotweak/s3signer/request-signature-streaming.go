@@ -18,8 +18,11 @@
 package s3signer
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/hmac"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -419,3 +422,131 @@ func discardNextSignature(reader io.ReadCloser) (int, error) {
 	}
 	return int(nextChunkLength), nil
 }
+
+// maxVerifiedChunkSize - the largest chunk accepted by the verifying reader, so that a single
+// chunk can't exhaust the memory.
+const maxVerifiedChunkSize = 16 * 1024 * 1024
+
+// ErrChunkSignatureMismatch - returned by the verifying reader when a chunk signature doesn't match.
+var ErrChunkSignatureMismatch = errors.New("chunk signature mismatch")
+
+// StreamingVerifyV4 - wraps the body of the chunked upload request with a reader which verifies
+// the signature of every chunk before passing it on unchanged. The seed signature is taken from the
+// authorization header, which is expected to be verified already.
+func StreamingVerifyV4(req *http.Request, secretAccessKey string, body io.ReadCloser) (io.ReadCloser, error) {
+	authHeader, err := extractAuthorizationHeader(req.Header.Get("Authorization"))
+	if err != nil || authHeader.version != signV4Algorithm {
+		return nil, fmt.Errorf("error while parsing authorization header")
+	}
+	reqTime, err := time.Parse(iso8601DateFormat, req.Header.Get("x-amz-date"))
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing x-amz-date header")
+	}
+	decodedContentLength := int64(-1)
+	if header := req.Header.Get("x-amz-decoded-content-length"); header != "" {
+		if decodedContentLength, err = strconv.ParseInt(header, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse x-amz-decoded-content-length header")
+		}
+	}
+	return &verifyingStreamingReader{
+		source:               bufio.NewReader(body),
+		closer:               body,
+		reqTime:              reqTime,
+		region:               authHeader.region,
+		service:              authHeader.service,
+		signingKey:           getSigningKey(secretAccessKey, authHeader.region, authHeader.service, reqTime),
+		prevSignature:        authHeader.signature,
+		decodedContentLength: decodedContentLength,
+	}, nil
+}
+
+// verifyingStreamingReader - passes the chunks of the signed chunked upload through once their
+// signatures are verified.
+type verifyingStreamingReader struct {
+	source               *bufio.Reader
+	closer               io.Closer
+	reqTime              time.Time
+	region               string
+	service              string
+	signingKey           []byte
+	prevSignature        string
+	decodedContentLength int64
+	bytesVerified        int64
+	buf                  bytes.Buffer // holds the verified chunk
+	done                 bool
+	err                  error
+}
+
+// Read - reads the verified chunks, the error is returned as soon as a chunk fails the verification.
+func (v *verifyingStreamingReader) Read(buf []byte) (int, error) {
+	for v.buf.Len() == 0 {
+		if v.err != nil {
+			return 0, v.err
+		}
+		if v.done {
+			return 0, io.EOF
+		}
+		v.err = v.verifyNextChunk()
+	}
+	return v.buf.Read(buf)
+}
+
+// Close - closes the underlying body.
+func (v *verifyingStreamingReader) Close() error {
+	return v.closer.Close()
+}
+
+// verifyNextChunk - reads the next chunk, e.g.
+// string(IntHexBase(chunk-size)) + ";chunk-signature=" + signature + \r\n + chunk-data + \r\n
+func (v *verifyingStreamingReader) verifyNextChunk() error {
+	chunkHdr, err := v.source.ReadSlice('\n')
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	chunkHdrLine := strings.TrimSuffix(string(chunkHdr), "\r\n")
+	sizeInHex, signature, found := cutString(chunkHdrLine, ";chunk-signature=")
+	if !found {
+		return fmt.Errorf("malformed chunk header")
+	}
+	chunkSize, err := strconv.ParseInt(sizeInHex, 16, 64)
+	if err != nil || chunkSize < 0 || chunkSize > maxVerifiedChunkSize {
+		return fmt.Errorf("malformed chunk size %q", sizeInHex)
+	}
+
+	chunkData := make([]byte, chunkSize+crlfLen)
+	if _, err = io.ReadFull(v.source, chunkData); err != nil {
+		return unexpectedEOF(err)
+	}
+	if !bytes.HasSuffix(chunkData, []byte("\r\n")) {
+		return fmt.Errorf("malformed chunk trailer")
+	}
+	chunkStringToSign := buildChunkStringToSign(v.reqTime, v.region, v.service, v.prevSignature, chunkData[:chunkSize])
+	if !hmac.Equal([]byte(getSignature(v.signingKey, chunkStringToSign)), []byte(signature)) {
+		return ErrChunkSignatureMismatch
+	}
+	v.prevSignature = signature
+	v.bytesVerified += chunkSize
+	if chunkSize == 0 {
+		if v.decodedContentLength >= 0 && v.bytesVerified != v.decodedContentLength {
+			return fmt.Errorf("chunks length %d doesn't match x-amz-decoded-content-length %d", v.bytesVerified, v.decodedContentLength)
+		}
+		v.done = true
+	}
+	v.buf.WriteString(chunkHdrLine + "\r\n")
+	v.buf.Write(chunkData)
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func cutString(s, sep string) (string, string, bool) {
+	if idx := strings.Index(s, sep); idx >= 0 {
+		return s[:idx], s[idx+len(sep):], true
+	}
+	return s, "", false
+}
@@ -136,4 +136,44 @@ func TestStreamingRequestProxing(t *testing.T) {
 	req.Body.Close()
 }
 
+func TestStreamingVerifyV4(t *testing.T) {
+	reqTime := time.Now().UTC()
+	dataLen := int64(payloadChunkSize + 1024)
+	signedReq := NewRequest("PUT", "/examplebucket/chunkObject.txt", nil)
+	signedReq.Host = "s3.amazonaws.com"
+	signedReq.Body = ioutil.NopCloser(bytes.NewReader(bytes.Repeat([]byte("a"), int(dataLen))))
+	signedReq = StreamingSignV4(signedReq, "access", "secret", "", "us-east-1", "s3", dataLen, reqTime, false)
+	signedPayload, err := ioutil.ReadAll(signedReq.Body)
+	if err != nil {
+		t.Fatalf("Expected no error but received %v", err)
+	}
+
+	verifyingReader, err := StreamingVerifyV4(signedReq, "secret", ioutil.NopCloser(bytes.NewReader(signedPayload)))
+	if err != nil {
+		t.Fatalf("Expected no error but received %v", err)
+	}
+	verifiedPayload, err := ioutil.ReadAll(verifyingReader)
+	if err != nil {
+		t.Errorf("Expected no error but received %v", err)
+	}
+	if !bytes.Equal(signedPayload, verifiedPayload) {
+		t.Errorf("Expected the payload to be passed through unchanged")
+	}
+
+	tamperedPayload := bytes.Replace(signedPayload, []byte("aaaa"), []byte("aaab"), 1)
+	verifyingReader, _ = StreamingVerifyV4(signedReq, "secret", ioutil.NopCloser(bytes.NewReader(tamperedPayload)))
+	verifiedPayload, err = ioutil.ReadAll(verifyingReader)
+	if err != ErrChunkSignatureMismatch {
+		t.Errorf("Expected ErrChunkSignatureMismatch but received %v", err)
+	}
+	if len(verifiedPayload) != 0 {
+		t.Errorf("Expected the tampered chunk not to be passed through")
+	}
+
+	verifyingReader, _ = StreamingVerifyV4(signedReq, "other-secret", ioutil.NopCloser(bytes.NewReader(signedPayload)))
+	if _, err = ioutil.ReadAll(verifyingReader); err != ErrChunkSignatureMismatch {
+		t.Errorf("Expected ErrChunkSignatureMismatch but received %v", err)
+	}
+}
+
 var signedChunkedPayload = []byte("c;chunk-signature=08f6608fdf69bd6ed7c3c6198b78c799e1f88638dd785c4bb4ae1c536115e79e\r\n1tetest1234\n\r\n4;chunk-signature=08f6608fdf69bd6ed7c3c6198b78c799e1f88638dd785c4bb4ae1c536115e79e\r\n123\n\r\n0;")
@@ -19,6 +19,7 @@ package s3signer
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"encoding/hex"
 	"net/http"
 	"sort"
@@ -334,25 +335,99 @@ func VerifyV4(req *http.Request, secretAccessKey string) (bool, error) {
 		return false, fmt.Errorf("incorrect authHeader version %s", origAuthHeader.version)
 	}
 
+	// Get request time from x-amz-date header, or from the signed date header if the former is missing
+	t, err := RequestTimeV4(req)
+	if err != nil {
+		return false, fmt.Errorf("error while parsing x-amz-date or date header")
+	}
+	if req.Header.Get("x-amz-date") == "" && !containsSignedHeader(origAuthHeader.signedHeaders, "date") {
+		return false, fmt.Errorf("date header is not signed")
+	}
+
 	// Remove x-amz-signature from url
 	parsedQuery, err := url.ParseQuery(req.URL.RawQuery)
-	parsedQuery.Get("x-amz-signature")
+	if err != nil {
+		return false, fmt.Errorf("error while parsing query: %s", err)
+	}
 	for key := range parsedQuery {
 		if strings.ToLower(key) == "x-amz-signature" {
 			parsedQuery.Del(key)
 		}
 	}
-	req.URL.RawQuery = parsedQuery.Encode()
 
-	// Get request time from x-amz-date header
-	t, err := time.Parse(iso8601DateFormat, req.Header.Get("x-amz-date"))
+	signature := calculateV4Signature(req, parsedQuery, secretAccessKey, origAuthHeader.signedHeaders,
+		origAuthHeader.region, origAuthHeader.service, t)
+	if hmac.Equal([]byte(signature), []byte(origAuthHeader.signature)) {
+		return true, nil
+	}
+	return false, fmt.Errorf("request signature mismatch")
+}
+
+// RequestTimeV4 returns the time the v4 signed request was signed at, taken from the x-amz-date header,
+// or from the date header if the former is missing
+func RequestTimeV4(req *http.Request) (time.Time, error) {
+	if amzDate := req.Header.Get("x-amz-date"); amzDate != "" {
+		return time.Parse(iso8601DateFormat, amzDate)
+	}
+	t, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+func containsSignedHeader(signedHeadersList, header string) bool {
+	for _, name := range strings.Split(signedHeadersList, ";") {
+		if name == header {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPresignedV4 verify if v4 signature of the presigned request is correct, in accordance with
+// http://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html. The expiration of the
+// request is not checked.
+func VerifyPresignedV4(req *http.Request, secretAccessKey string) (bool, error) {
+	parsedQuery, err := url.ParseQuery(req.URL.RawQuery)
 	if err != nil {
-		return false, fmt.Errorf("error while parsing x-amz-date header")
+		return false, fmt.Errorf("error while parsing query: %s", err)
+	}
+	if parsedQuery.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return false, fmt.Errorf("incorrect X-Amz-Algorithm %s", parsedQuery.Get("X-Amz-Algorithm"))
+	}
+	credential := strings.Split(parsedQuery.Get("X-Amz-Credential"), "/")
+	if len(credential) < 5 || credential[len(credential)-1] != "aws4_request" {
+		return false, fmt.Errorf("error while parsing X-Amz-Credential")
+	}
+	t, err := time.Parse(iso8601DateFormat, parsedQuery.Get("X-Amz-Date"))
+	if err != nil {
+		return false, fmt.Errorf("error while parsing X-Amz-Date")
+	}
+	origSignature := parsedQuery.Get("X-Amz-Signature")
+	parsedQuery.Del("X-Amz-Signature")
+
+	region := credential[len(credential)-3]
+	service := credential[len(credential)-2]
+	signature := calculateV4Signature(req, parsedQuery, secretAccessKey, parsedQuery.Get("X-Amz-SignedHeaders"), region, service, t)
+	if hmac.Equal([]byte(signature), []byte(origSignature)) {
+		return true, nil
 	}
+	return false, fmt.Errorf("request signature mismatch")
+}
+
+// calculateV4Signature calculates the signature of the request with the given query, taking only the
+// signed headers into account. The request is left untouched.
+func calculateV4Signature(req *http.Request, query url.Values, secretAccessKey, signedHeadersList, region, service string, t time.Time) string {
+	verifiedReq := new(http.Request)
+	*verifiedReq = *req
+	verifiedReq.URL = new(url.URL)
+	*verifiedReq.URL = *req.URL
+	verifiedReq.URL.RawQuery = query.Encode()
 
 	// Get list of headers to ignore
 	signedHeaders := map[string]struct{}{}
-	for _, name := range strings.Split(origAuthHeader.signedHeaders, ";") {
+	for _, name := range strings.Split(signedHeadersList, ";") {
 		signedHeaders[name] = struct{}{}
 	}
 	ignoredHeaders := map[string]bool{}
@@ -363,18 +438,13 @@ func VerifyV4(req *http.Request, secretAccessKey string) (bool, error) {
 	}
 
 	// Get canonical request.
-	canonicalRequest := getCanonicalRequest(req, ignoredHeaders, false)
+	canonicalRequest := getCanonicalRequest(verifiedReq, ignoredHeaders, false)
 	// Get string to sign from canonical request.
-	stringToSign := getStringToSignV4(t, origAuthHeader.region, origAuthHeader.service, canonicalRequest)
+	stringToSign := getStringToSignV4(t, region, service, canonicalRequest)
 	// Get hmac signing key.
-	signingKey := getSigningKey(secretAccessKey, origAuthHeader.region, origAuthHeader.service, t)
-
+	signingKey := getSigningKey(secretAccessKey, region, service, t)
 	// Calculate signature.
-	signature := getSignature(signingKey, stringToSign)
-	if signature == origAuthHeader.signature {
-		return true, nil
-	}
-	return false, fmt.Errorf("request signature mismatch")
+	return getSignature(signingKey, stringToSign)
 }
 
 func sanitizeV4DateHeader(req *http.Request, t time.Time) *http.Request {
@@ -68,6 +68,40 @@ func TestNotExcludeNoNonAMZXHeadersWhenNotSigning(t *testing.T) {
 		t.Errorf("X-non-amz-header should be found")
 	}
 }
+func TestVerifyPresignedV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/object?versionId=1", nil)
+	req.Header.Set("X-Amz-Meta-Signed", "signed")
+	req = PreSignV4(req, "access", "secret", "", "us-east-1", "s3", 60)
+
+	if valid, err := VerifyPresignedV4(req, "secret"); !valid || err != nil {
+		t.Errorf("Expected the presigned request to be valid, got %v", err)
+	}
+	if !strings.Contains(req.URL.RawQuery, "X-Amz-Signature") {
+		t.Errorf("Verification shouldn't modify the request")
+	}
+	if valid, _ := VerifyPresignedV4(req, "other-secret"); valid {
+		t.Errorf("Expected the signature to mismatch for other secret")
+	}
+	req.Header.Set("X-Amz-Meta-Signed", "tampered")
+	if valid, _ := VerifyPresignedV4(req, "secret"); valid {
+		t.Errorf("Expected the signature to mismatch for tampered signed header")
+	}
+}
+
+func TestVerifyV4WithUnsignedPayload(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/bucket/object", strings.NewReader("content"))
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	req = SignV4(req, "access", "secret", "", "us-east-1", "s3")
+
+	if valid, err := VerifyV4(req, "secret"); !valid || err != nil {
+		t.Errorf("Expected the request to be valid, got %v", err)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if valid, _ := VerifyV4(req, "secret"); valid {
+		t.Errorf("Expected the signature to mismatch for changed payload hash")
+	}
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
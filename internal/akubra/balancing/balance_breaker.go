@@ -1,11 +1,13 @@
 package balancing
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net/http"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/allegro/akubra/internal/akubra/log"
@@ -159,6 +161,20 @@ func (meter *CallMeter) TimeSpent() float64 {
 	return sum
 }
 
+// Percentile returns the given percentile of call durations tracked during the retention period, 0 if none
+func (meter *CallMeter) Percentile(percentile float64) float64 {
+	now := meter.now()
+	values := make([]float64, 0)
+	for _, series := range meter.histogram.PickLastSeries(meter.retention) {
+		values = append(values, series.ValueRange(now.Add(-meter.retention), now)...)
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	return values[int(math.Floor(float64(len(values)-1)*percentile))]
+}
+
 type dataSeries struct {
 	data []*timeValue
 	mx   sync.Mutex
@@ -171,6 +187,8 @@ func (series *dataSeries) Add(value float64, dateTime time.Time) {
 }
 
 func (series *dataSeries) ValueRangeFun(timeStart, timeEnd time.Time, fun func(*timeValue)) {
+	series.mx.Lock()
+	defer series.mx.Unlock()
 	for _, timeVal := range series.data {
 		if (timeStart == timeVal.date || timeStart.Before(timeVal.date)) && timeEnd.After(timeVal.date) {
 			fun(timeVal)
@@ -319,6 +337,7 @@ type NodeBreaker struct {
 	closeDelay          time.Duration
 	maxDelay            time.Duration
 	state               *openStateTracker
+	stateLock           sync.Mutex
 }
 
 // Record collects call data and returns bool if breaker should be opened
@@ -334,6 +353,8 @@ func (breaker *NodeBreaker) Record(duration time.Duration, success bool) bool {
 
 // ShouldOpen checks if breaker should be opened
 func (breaker *NodeBreaker) ShouldOpen() bool {
+	breaker.stateLock.Lock()
+	defer breaker.stateLock.Unlock()
 	exceeded := breaker.limitsExceeded()
 	if breaker.state != nil {
 		return breaker.isHalfOpen(exceeded)
@@ -345,6 +366,35 @@ func (breaker *NodeBreaker) ShouldOpen() bool {
 	return exceeded
 }
 
+// Trip opens the breaker regardless of the collected call data, it closes again after the cut out duration
+func (breaker *NodeBreaker) Trip() {
+	breaker.stateLock.Lock()
+	defer breaker.stateLock.Unlock()
+	if breaker.state == nil {
+		breaker.openBreaker()
+		return
+	}
+	breaker.state.state = open
+	breaker.state.lastChange = breaker.now()
+}
+
+// State returns the name of the current breaker state
+func (breaker *NodeBreaker) State() string {
+	breaker.stateLock.Lock()
+	defer breaker.stateLock.Unlock()
+	tracker := breaker.state
+	if tracker == nil {
+		return metrics.BreakerClosed
+	}
+	switch tracker.state {
+	case open:
+		return metrics.BreakerOpen
+	case halfopen:
+		return metrics.BreakerHalfOpen
+	}
+	return metrics.BreakerClosed
+}
+
 func (breaker *NodeBreaker) isHalfOpen(exceeded bool) bool {
 	state, changed := breaker.state.currentState(breaker.now(), exceeded)
 	if state == closed {
@@ -510,12 +560,101 @@ func (tracker *openStateTracker) currentState(now time.Time, limitsExceeded bool
 	return tracker.state, changed
 }
 
+// BreakerOverride forces the breaker state regardless of the collected call data
+type BreakerOverride int32
+
+const (
+	// BreakerAutomatic lets the breaker decide on its own
+	BreakerAutomatic BreakerOverride = iota
+	// BreakerForcedOpen keeps the breaker open
+	BreakerForcedOpen
+	// BreakerForcedClosed keeps the breaker closed
+	BreakerForcedClosed
+)
+
 // MeasuredStorage coordinates metrics collection
 type MeasuredStorage struct {
 	Node
 	Breaker
 	http.RoundTripper
-	Name string
+	Name     string
+	override int32
+	inFlight int64
+	latency  movingAverage
+
+	stateMx       sync.Mutex
+	breakerStatus string
+	stateListener func(storage *MeasuredStorage, state string)
+}
+
+// OnBreakerStateChange registers the listener of the breaker state changes made by the breaker itself,
+// the changes made by the overrides and trips aren't reported
+func (ms *MeasuredStorage) OnBreakerStateChange(listener func(storage *MeasuredStorage, state string)) {
+	ms.stateMx.Lock()
+	defer ms.stateMx.Unlock()
+	ms.stateListener = listener
+}
+
+// Trip opens the breaker as if the storage failed, e.g. because other instance found it broken
+func (ms *MeasuredStorage) Trip() {
+	tripper, ok := ms.Breaker.(interface{ Trip() })
+	if !ok {
+		return
+	}
+	tripper.Trip()
+	ms.stateMx.Lock()
+	ms.breakerStatus = metrics.BreakerOpen
+	ms.stateMx.Unlock()
+	ms.Node.SetActive(ms.BreakerOverride() == BreakerForcedClosed)
+}
+
+func (ms *MeasuredStorage) trackBreakerState(state string) {
+	ms.stateMx.Lock()
+	previous := ms.breakerStatus
+	ms.breakerStatus = state
+	listener := ms.stateListener
+	ms.stateMx.Unlock()
+	if previous == "" {
+		previous = metrics.BreakerClosed
+	}
+	if previous != state && listener != nil {
+		listener(ms, state)
+	}
+}
+
+// InFlight returns the number of requests sent to the storage and waiting for the response
+func (ms *MeasuredStorage) InFlight() int64 {
+	return atomic.LoadInt64(&ms.inFlight)
+}
+
+// Latency returns the moving average of the storage call durations
+func (ms *MeasuredStorage) Latency() time.Duration {
+	return ms.latency.Value()
+}
+
+// ForceBreaker sets the breaker override
+func (ms *MeasuredStorage) ForceBreaker(override BreakerOverride) {
+	atomic.StoreInt32(&ms.override, int32(override))
+}
+
+// BreakerOverride returns the current breaker override
+func (ms *MeasuredStorage) BreakerOverride() BreakerOverride {
+	return BreakerOverride(atomic.LoadInt32(&ms.override))
+}
+
+// IsOpen tells if the breaker was open on the last check, taking the override into account
+func (ms *MeasuredStorage) IsOpen() bool {
+	return ms.applyOverride(!ms.Node.IsActive())
+}
+
+func (ms *MeasuredStorage) applyOverride(open bool) bool {
+	switch ms.BreakerOverride() {
+	case BreakerForcedOpen:
+		return true
+	case BreakerForcedClosed:
+		return false
+	}
+	return open
 }
 
 // RoundTrip implements http.RoundTripper
@@ -523,31 +662,72 @@ func (ms *MeasuredStorage) RoundTrip(req *http.Request) (*http.Response, error)
 	start := time.Now()
 	reqID, _ := req.Context().Value(log.ContextreqIDKey).(string)
 	log.Debugf("MeasuredStorage %s: Got request id %s\n", ms.Name, reqID)
+	atomic.AddInt64(&ms.inFlight, 1)
 	resp, err := ms.RoundTripper.RoundTrip(req)
+	atomic.AddInt64(&ms.inFlight, -1)
+	if req.Context().Err() == context.Canceled {
+		// cancelled by the caller, e.g. the hedged read lost, it tells nothing about the storage health
+		return resp, err
+	}
 	duration := time.Since(start)
 	success := backendSuccess(resp, err)
-	open := ms.Breaker.Record(duration, success)
+	open := ms.record(start, duration, success)
 	log.Debugf("MeasuredStorage %s: Request %s took %s was successful: %t, opened breaker %t\n", ms.Name, reqID, duration, success, open)
 
 	ms.Node.UpdateTimeSpent(duration)
-	ms.Node.SetActive(!open)
-	reportMetrics(ms.RoundTripper, start, open)
+	ms.latency.Update(duration)
 	return resp, err
 }
 
+// RecordProbe feeds the breaker with the result of a health probe, the balancing call times are left intact
+func (ms *MeasuredStorage) RecordProbe(duration time.Duration, success bool) bool {
+	open := ms.record(time.Now().Add(-duration), duration, success)
+	log.Debugf("MeasuredStorage %s: Probe took %s was successful: %t, opened breaker %t\n", ms.Name, duration, success, open)
+	return open
+}
+
+func (ms *MeasuredStorage) record(start time.Time, duration time.Duration, success bool) bool {
+	open := ms.applyOverride(ms.Breaker.Record(duration, success))
+	ms.Node.SetActive(!open)
+	state := ms.breakerState(open)
+	if ms.BreakerOverride() == BreakerAutomatic {
+		ms.trackBreakerState(state)
+	}
+	reportMetrics(ms.RoundTripper, start, open, state)
+	return open
+}
+
+// breakerState names the breaker state reported in the metrics, taking the override into account
+func (ms *MeasuredStorage) breakerState(open bool) string {
+	switch ms.BreakerOverride() {
+	case BreakerForcedOpen:
+		return metrics.BreakerOpen
+	case BreakerForcedClosed:
+		return metrics.BreakerClosed
+	}
+	if stateful, ok := ms.Breaker.(interface{ State() string }); ok {
+		return stateful.State()
+	}
+	if open {
+		return metrics.BreakerOpen
+	}
+	return metrics.BreakerClosed
+}
+
 func backendSuccess(response *http.Response, err error) bool {
 	return err == nil && response != nil && response.StatusCode < 500
 }
 
 // IsActive checks Breaker status propagates it to Node compound
 func (ms *MeasuredStorage) IsActive() bool {
-	isActive := !ms.Breaker.ShouldOpen()
+	isActive := !ms.applyOverride(ms.Breaker.ShouldOpen())
 	ms.Node.SetActive(isActive)
 	return ms.Node.IsActive()
 }
 
-func reportMetrics(rt http.RoundTripper, since time.Time, open bool) {
+func reportMetrics(rt http.RoundTripper, since time.Time, open bool, state string) {
 	if b, ok := rt.(*backend.Backend); ok {
+		metrics.SetBreakerState(b.Name, state)
 		prefix := fmt.Sprintf("reqs.backend.%s.balancer", b.Name)
 		metrics.UpdateSince(prefix+".duration", since)
 		if open {
@@ -560,10 +740,16 @@ func reportMetrics(rt http.RoundTripper, since time.Time, open bool) {
 
 // NewBalancerPrioritySet configures prioritized balancers stack
 func NewBalancerPrioritySet(storagesConfig config.Storages, backends map[string]http.RoundTripper) *BalancerPrioritySet {
+	return NewShardBalancerPrioritySet(config.Shard{Storages: storagesConfig}, backends)
+}
+
+// NewShardBalancerPrioritySet configures prioritized balancers stack with the balancing strategy of the shard
+func NewShardBalancerPrioritySet(shardConfig config.Shard, backends map[string]http.RoundTripper) *BalancerPrioritySet {
 	priorities := make([]int, 0)
 	priotitiesFilter := make(map[int]struct{})
 	priorityStorage := make(map[int][]*MeasuredStorage)
-	for _, storageConfig := range storagesConfig {
+	priorityConfig := make(map[int]config.Storages)
+	for _, storageConfig := range shardConfig.Storages {
 		breaker := NewBreaker(storageConfig.BreakerProbeSize,
 			storageConfig.BreakerCallTimeLimit.Duration,
 			storageConfig.BreakerCallTimeLimitPercentile,
@@ -588,23 +774,46 @@ func NewBalancerPrioritySet(storagesConfig config.Storages, backends map[string]
 
 		priorityStorage[storageConfig.Priority] = append(
 			priorityStorage[storageConfig.Priority], mstorage)
+		priorityConfig[storageConfig.Priority] = append(priorityConfig[storageConfig.Priority], storageConfig)
 	}
 	sort.Ints(priorities)
-	bps := &BalancerPrioritySet{balancers: []*ResponseTimeBalancer{}}
+	bps := &BalancerPrioritySet{balancers: []Elector{}, hedging: shardConfig.HedgedReads}
 	for _, key := range priorities {
 		nodes := make([]Node, 0)
 		for _, node := range priorityStorage[key] {
 			nodes = append(nodes, Node(node))
+			bps.storages = append(bps.storages, node)
 		}
-		balancer := &ResponseTimeBalancer{Nodes: nodes}
-		bps.balancers = append(bps.balancers, balancer)
+		bps.balancers = append(bps.balancers, newElector(shardConfig, priorityConfig[key], nodes))
 	}
 	return bps
 }
 
 // BalancerPrioritySet selects storage by priority and availability
 type BalancerPrioritySet struct {
-	balancers []*ResponseTimeBalancer
+	balancers []Elector
+	storages  []*MeasuredStorage
+	hedging   config.HedgedReads
+}
+
+// HedgeDelay tells how long to wait for the storage response before the read is hedged,
+// false means the read shouldn't be hedged
+func (bps *BalancerPrioritySet) HedgeDelay(storage *MeasuredStorage) (time.Duration, bool) {
+	if !bps.hedging.Enabled {
+		return 0, false
+	}
+	delay := bps.hedging.Delay.Duration
+	if meter, ok := storage.Node.(*CallMeter); ok && bps.hedging.DelayPercentile > 0 {
+		if percentile := meter.Percentile(bps.hedging.DelayPercentile); percentile > 0 {
+			delay = time.Duration(percentile)
+		}
+	}
+	return delay, delay > 0
+}
+
+// MeasuredStorages returns the storages of all priority levels
+func (bps *BalancerPrioritySet) MeasuredStorages() []*MeasuredStorage {
+	return append([]*MeasuredStorage{}, bps.storages...)
 }
 
 // GetMostAvailable returns balancer member
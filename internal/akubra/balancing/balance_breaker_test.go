@@ -127,6 +127,47 @@ func TestCallMeterConcurrency(t *testing.T) {
 	require.Equal(t, float64(numberOfSamples), callMeter.Calls(), "Number of calls missmatch")
 }
 
+func TestCallMeterPercentile(t *testing.T) {
+	timer := &mockTimer{
+		baseTime:   time.Now(),
+		advanceDur: 10 * time.Millisecond}
+	callMeter := newCallMeterWithTimer(5*time.Second, time.Second, timer.now)
+	require.Equal(t, float64(0), callMeter.Percentile(0.95))
+
+	for i := 1; i <= 100; i++ {
+		callMeter.UpdateTimeSpent(time.Duration(i) * time.Millisecond)
+		timer.advance()
+	}
+
+	require.Equal(t, float64(95*time.Millisecond), callMeter.Percentile(0.95))
+	require.Equal(t, float64(100*time.Millisecond), callMeter.Percentile(1))
+}
+
+func TestHedgeDelay(t *testing.T) {
+	timer := &mockTimer{
+		baseTime:   time.Now(),
+		advanceDur: 10 * time.Millisecond}
+	meter := newCallMeterWithTimer(5*time.Second, time.Second, timer.now)
+	storage := &MeasuredStorage{Node: meter, Name: "storage"}
+	balancerSet := &BalancerPrioritySet{}
+
+	_, hedged := balancerSet.HedgeDelay(storage)
+	require.False(t, hedged, "reads shouldn't be hedged by default")
+
+	balancerSet.hedging = config.HedgedReads{Enabled: true, Delay: metrics.Interval{Duration: time.Second}, DelayPercentile: 0.5}
+	delay, hedged := balancerSet.HedgeDelay(storage)
+	require.True(t, hedged)
+	require.Equal(t, time.Second, delay, "fixed delay should be used until call times are tracked")
+
+	for i := 1; i <= 10; i++ {
+		meter.UpdateTimeSpent(time.Duration(i) * time.Millisecond)
+		timer.advance()
+	}
+	delay, hedged = balancerSet.HedgeDelay(storage)
+	require.True(t, hedged)
+	require.Equal(t, 5*time.Millisecond, delay)
+}
+
 func TestCallMeterRetention(t *testing.T) {
 	numberOfSamples := 100
 	timer := &mockTimer{
@@ -340,6 +381,21 @@ func TestBreakerRecoveryPeriodsProgressionResetIfOpen(t *testing.T) {
 	require.False(t, breaker.ShouldOpen(), "breaker should be closed after stats reset")
 }
 
+func TestBreakerShouldReportItsState(t *testing.T) {
+	timer := &mockTimer{
+		baseTime:   time.Now(),
+		advanceDur: 1000 * time.Millisecond}
+	breaker := makeTestBreakerWithTimer(timer.now).(*NodeBreaker)
+	require.Equal(t, metrics.BreakerClosed, breaker.State())
+
+	openBreaker(breaker)
+	require.Equal(t, metrics.BreakerOpen, breaker.State())
+
+	checkOpenFor(t, time.Second, breaker, timer)
+	require.False(t, breaker.ShouldOpen())
+	require.Equal(t, metrics.BreakerHalfOpen, breaker.State())
+}
+
 func openBreaker(breaker Breaker) {
 	for i := 0; i < 11; i++ {
 		breaker.Record(1*time.Millisecond, false)
@@ -427,6 +483,137 @@ func TestPriorityLayersPicker(t *testing.T) {
 	require.Nil(t, resp, err)
 }
 
+func TestForcedBreakerOverridesCollectedCallData(t *testing.T) {
+	storagesConfig := config.Storages{
+		{
+			Name:                           "first",
+			Priority:                       0,
+			BreakerProbeSize:               10,
+			BreakerErrorRate:               0.09,
+			BreakerCallTimeLimit:           metrics.Interval{Duration: 500 * time.Millisecond},
+			BreakerCallTimeLimitPercentile: 0.9,
+			BreakerBasicCutOutDuration:     metrics.Interval{Duration: time.Second},
+			BreakerMaxCutOutDuration:       metrics.Interval{Duration: 180 * time.Second},
+			MeterResolution:                metrics.Interval{Duration: 5 * time.Second},
+			MeterRetention:                 metrics.Interval{Duration: 10 * time.Second},
+		},
+		{
+			Name:                           "second",
+			Priority:                       1,
+			BreakerProbeSize:               10,
+			BreakerErrorRate:               0.09,
+			BreakerCallTimeLimit:           metrics.Interval{Duration: 500 * time.Millisecond},
+			BreakerCallTimeLimitPercentile: 0.9,
+			BreakerBasicCutOutDuration:     metrics.Interval{Duration: time.Second},
+			BreakerMaxCutOutDuration:       metrics.Interval{Duration: 180 * time.Second},
+			MeterResolution:                metrics.Interval{Duration: 5 * time.Second},
+			MeterRetention:                 metrics.Interval{Duration: 10 * time.Second},
+		},
+	}
+	errFirstStorageResponse := fmt.Errorf("Error from first")
+	backends := map[string]http.RoundTripper{
+		"first":  &MockRoundTripper{err: errFirstStorageResponse},
+		"second": &MockRoundTripper{},
+	}
+	balancerSet := NewBalancerPrioritySet(storagesConfig, backends)
+	storages := balancerSet.MeasuredStorages()
+	require.Len(t, storages, 2)
+	first := storages[0]
+	require.Equal(t, "first", first.Name)
+
+	first.ForceBreaker(BreakerForcedOpen)
+	require.Equal(t, BreakerForcedOpen, first.BreakerOverride())
+	require.False(t, first.IsActive())
+	require.Equal(t, "second", balancerSet.GetMostAvailable().Name)
+
+	first.ForceBreaker(BreakerForcedClosed)
+	_, err := first.RoundTrip(&http.Request{})
+	require.Equal(t, errFirstStorageResponse, err)
+	require.True(t, first.IsActive())
+	require.Equal(t, "first", balancerSet.GetMostAvailable().Name)
+
+	first.ForceBreaker(BreakerAutomatic)
+	require.False(t, first.IsActive())
+}
+
+func TestMeasuredStorageShouldReportBreakerOpenedByItself(t *testing.T) {
+	storage := &MeasuredStorage{
+		Breaker:      makeTestBreaker(),
+		Node:         NewCallMeter(time.Minute, time.Second),
+		RoundTripper: &MockRoundTripper{err: fmt.Errorf("failure")},
+		Name:         "storage",
+	}
+	states := make([]string, 0)
+	storage.OnBreakerStateChange(func(changed *MeasuredStorage, state string) {
+		require.Equal(t, storage, changed)
+		states = append(states, state)
+	})
+
+	for i := 0; i < 20; i++ {
+		_, _ = storage.RoundTrip(&http.Request{})
+	}
+
+	require.Equal(t, []string{metrics.BreakerOpen}, states)
+}
+
+func TestTripShouldOpenBreakerWithoutReportingIt(t *testing.T) {
+	storage := &MeasuredStorage{
+		Breaker:      makeTestBreaker(),
+		Node:         NewCallMeter(time.Minute, time.Second),
+		RoundTripper: &MockRoundTripper{},
+		Name:         "storage",
+	}
+	storage.OnBreakerStateChange(func(*MeasuredStorage, string) {
+		require.Fail(t, "trip shouldn't be reported")
+	})
+
+	storage.Trip()
+
+	require.False(t, storage.IsActive())
+	require.Equal(t, metrics.BreakerOpen, storage.Breaker.(*NodeBreaker).State())
+}
+
+func TestTripShouldBeSafeWhileRequestsAreRecorded(t *testing.T) {
+	breaker := makeTestBreaker().(*NodeBreaker)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			breaker.Trip()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		breaker.Record(time.Millisecond, true)
+		_ = breaker.State()
+	}
+	<-done
+
+	require.Equal(t, metrics.BreakerOpen, breaker.State())
+}
+
+func TestRecordProbeShouldFeedBreakerWithoutCallTimes(t *testing.T) {
+	meter := NewCallMeter(time.Minute, time.Second)
+	storage := &MeasuredStorage{
+		Breaker:      makeTestBreaker(),
+		Node:         meter,
+		RoundTripper: &MockRoundTripper{},
+		Name:         "storage",
+	}
+	states := make([]string, 0)
+	storage.OnBreakerStateChange(func(_ *MeasuredStorage, state string) {
+		states = append(states, state)
+	})
+
+	for i := 0; i < 20; i++ {
+		storage.RecordProbe(time.Millisecond, false)
+	}
+
+	require.True(t, storage.IsOpen())
+	require.Equal(t, []string{metrics.BreakerOpen}, states)
+	require.Zero(t, meter.Calls())
+}
+
 type MockRoundTripper struct {
 	err error
 }
@@ -0,0 +1,272 @@
+package balancing
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/storages/config"
+)
+
+const (
+	// ewmaSmoothing is the weight of the latest call in the moving average of latency
+	ewmaSmoothing = 0.2
+	// ewmaDecayHalfLife halves the moving average of a node not called for that long, so a node once slow
+	// is eventually elected again and its latency is measured anew
+	ewmaDecayHalfLife = 30 * time.Second
+)
+
+// Elector elects the node to call
+type Elector interface {
+	Elect(skipNodes ...Node) (Node, error)
+}
+
+// LoadTracker is a node tracking its load
+type LoadTracker interface {
+	InFlight() int64
+	Latency() time.Duration
+}
+
+func inFlightOf(node Node) int64 {
+	if tracker, ok := node.(LoadTracker); ok {
+		return tracker.InFlight()
+	}
+	return 0
+}
+
+func latencyOf(node Node) time.Duration {
+	if tracker, ok := node.(LoadTracker); ok {
+		return tracker.Latency()
+	}
+	return 0
+}
+
+func candidates(nodes []Node, skipNodes []Node) []Node {
+	active := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.IsActive() && !inSkipNodes(skipNodes, node) {
+			active = append(active, node)
+		}
+	}
+	return active
+}
+
+// movingAverage is an exponentially weighted moving average of durations decaying in time between the updates
+type movingAverage struct {
+	mx          sync.Mutex
+	value       float64
+	updatedAt   time.Time
+	initialized bool
+	now         func() time.Time
+}
+
+func (average *movingAverage) Update(duration time.Duration) {
+	average.mx.Lock()
+	defer average.mx.Unlock()
+	now := average.clock()
+	if !average.initialized {
+		average.value = float64(duration)
+		average.initialized = true
+	} else {
+		average.value = ewmaSmoothing*float64(duration) + (1-ewmaSmoothing)*average.decayed(now)
+	}
+	average.updatedAt = now
+}
+
+func (average *movingAverage) Value() time.Duration {
+	average.mx.Lock()
+	defer average.mx.Unlock()
+	if !average.initialized {
+		return 0
+	}
+	return time.Duration(average.decayed(average.clock()))
+}
+
+func (average *movingAverage) decayed(now time.Time) float64 {
+	elapsed := now.Sub(average.updatedAt)
+	if elapsed <= 0 {
+		return average.value
+	}
+	return average.value * math.Pow(0.5, float64(elapsed)/float64(ewmaDecayHalfLife))
+}
+
+func (average *movingAverage) clock() time.Time {
+	if average.now != nil {
+		return average.now()
+	}
+	return time.Now()
+}
+
+// PowerOfTwoChoicesElector elects the node with less requests in flight out of two random nodes
+type PowerOfTwoChoicesElector struct {
+	Nodes []Node
+	mx    sync.Mutex
+	rand  *rand.Rand
+}
+
+// NewPowerOfTwoChoicesElector creates PowerOfTwoChoicesElector
+func NewPowerOfTwoChoicesElector(nodes []Node) *PowerOfTwoChoicesElector {
+	return &PowerOfTwoChoicesElector{Nodes: nodes, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Elect implements Elector
+func (elector *PowerOfTwoChoicesElector) Elect(skipNodes ...Node) (Node, error) {
+	active := candidates(elector.Nodes, skipNodes)
+	switch len(active) {
+	case 0:
+		return nil, ErrNoActiveNodes
+	case 1:
+		return active[0], nil
+	}
+	elector.mx.Lock()
+	first := elector.rand.Intn(len(active))
+	second := elector.rand.Intn(len(active) - 1)
+	elector.mx.Unlock()
+	if second >= first {
+		second++
+	}
+	firstInFlight, secondInFlight := inFlightOf(active[first]), inFlightOf(active[second])
+	if secondInFlight < firstInFlight ||
+		(secondInFlight == firstInFlight && latencyOf(active[second]) < latencyOf(active[first])) {
+		return active[second], nil
+	}
+	return active[first], nil
+}
+
+// EWMAElector elects the node with the lowest moving average of latency, nodes not called yet go first,
+// the averages decay while the nodes aren't called, so the slow nodes are retried from time to time
+type EWMAElector struct {
+	Nodes []Node
+}
+
+// Elect implements Elector
+func (elector *EWMAElector) Elect(skipNodes ...Node) (Node, error) {
+	return lowestLatency(candidates(elector.Nodes, skipNodes))
+}
+
+func lowestLatency(nodes []Node) (Node, error) {
+	var elected Node
+	for _, node := range nodes {
+		if elected == nil || latencyOf(node) < latencyOf(elected) {
+			elected = node
+		}
+	}
+	if elected == nil {
+		return nil, ErrNoActiveNodes
+	}
+	return elected, nil
+}
+
+// WeightedRoundRobinElector elects the nodes in turns proportional to their weights
+type WeightedRoundRobinElector struct {
+	Nodes   []Node
+	weights map[Node]int
+	current map[Node]int
+	mx      sync.Mutex
+}
+
+// NewWeightedRoundRobinElector creates WeightedRoundRobinElector, nodes without a positive weight get weight 1
+func NewWeightedRoundRobinElector(nodes []Node, weights []int) *WeightedRoundRobinElector {
+	elector := &WeightedRoundRobinElector{Nodes: nodes, weights: make(map[Node]int), current: make(map[Node]int)}
+	for idx, node := range nodes {
+		weight := 1
+		if idx < len(weights) && weights[idx] > 0 {
+			weight = weights[idx]
+		}
+		elector.weights[node] = weight
+	}
+	return elector
+}
+
+// Elect implements Elector with the smooth weighted round-robin
+func (elector *WeightedRoundRobinElector) Elect(skipNodes ...Node) (Node, error) {
+	active := candidates(elector.Nodes, skipNodes)
+	if len(active) == 0 {
+		return nil, ErrNoActiveNodes
+	}
+	elector.mx.Lock()
+	defer elector.mx.Unlock()
+	var elected Node
+	total := 0
+	for _, node := range active {
+		elector.current[node] += elector.weights[node]
+		total += elector.weights[node]
+		if elected == nil || elector.current[node] > elector.current[elected] {
+			elected = node
+		}
+	}
+	elector.current[elected] -= total
+	return elected, nil
+}
+
+// CostAwareElector elects the cheapest node meeting the latency SLO, more expensive nodes are elected
+// only if all cheaper ones are unavailable or breach the SLO, as the latency averages decay in time
+// the cheaper nodes are retried and the escalation isn't permanent
+type CostAwareElector struct {
+	tiers      [][]Node
+	latencySLO time.Duration
+}
+
+// NewCostAwareElector creates CostAwareElector, costs are the egress costs of the nodes
+func NewCostAwareElector(nodes []Node, costs []float64, latencySLO time.Duration) *CostAwareElector {
+	nodeCosts := make(map[Node]float64)
+	for idx, node := range nodes {
+		if idx < len(costs) {
+			nodeCosts[node] = costs[idx]
+		}
+	}
+	sorted := append([]Node{}, nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return nodeCosts[sorted[i]] < nodeCosts[sorted[j]] })
+	tiers := make([][]Node, 0)
+	for idx, node := range sorted {
+		if idx == 0 || nodeCosts[node] != nodeCosts[sorted[idx-1]] {
+			tiers = append(tiers, []Node{})
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], node)
+	}
+	return &CostAwareElector{tiers: tiers, latencySLO: latencySLO}
+}
+
+// Elect implements Elector
+func (elector *CostAwareElector) Elect(skipNodes ...Node) (Node, error) {
+	all := make([]Node, 0)
+	for _, tier := range elector.tiers {
+		active := candidates(tier, skipNodes)
+		all = append(all, active...)
+		withinSLO := make([]Node, 0, len(active))
+		for _, node := range active {
+			if latencyOf(node) <= elector.latencySLO {
+				withinSLO = append(withinSLO, node)
+			}
+		}
+		if len(withinSLO) > 0 {
+			return lowestLatency(withinSLO)
+		}
+	}
+	// every node breaches the SLO, the fastest one is the best we can do
+	return lowestLatency(all)
+}
+
+func newElector(shardConfig config.Shard, storagesConfig config.Storages, nodes []Node) Elector {
+	switch shardConfig.Balancing {
+	case config.PowerOfTwoChoicesBalancing:
+		return NewPowerOfTwoChoicesElector(nodes)
+	case config.EWMABalancing:
+		return &EWMAElector{Nodes: nodes}
+	case config.WeightedRoundRobinBalancing:
+		weights := make([]int, 0, len(storagesConfig))
+		for _, storageConfig := range storagesConfig {
+			weights = append(weights, storageConfig.Weight)
+		}
+		return NewWeightedRoundRobinElector(nodes, weights)
+	case config.CostAwareBalancing:
+		costs := make([]float64, 0, len(storagesConfig))
+		for _, storageConfig := range storagesConfig {
+			costs = append(costs, storageConfig.EgressCost)
+		}
+		return NewCostAwareElector(nodes, costs, shardConfig.LatencySLO.Duration)
+	}
+	return &ResponseTimeBalancer{Nodes: nodes}
+}
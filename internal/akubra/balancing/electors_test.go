@@ -0,0 +1,170 @@
+package balancing
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/stretchr/testify/require"
+)
+
+type loadNodeMock struct {
+	nodeMock
+	name     string
+	inFlight int64
+	latency  time.Duration
+}
+
+func (node *loadNodeMock) InFlight() int64 {
+	return node.inFlight
+}
+
+func (node *loadNodeMock) Latency() time.Duration {
+	return node.latency
+}
+
+func newLoadNode(name string, inFlight int64, latency time.Duration) *loadNodeMock {
+	return &loadNodeMock{nodeMock: nodeMock{active: true}, name: name, inFlight: inFlight, latency: latency}
+}
+
+func electNames(t *testing.T, elector Elector, count int, skipNodes ...Node) map[string]int {
+	elections := make(map[string]int)
+	for i := 0; i < count; i++ {
+		node, err := elector.Elect(skipNodes...)
+		require.NoError(t, err)
+		elections[node.(*loadNodeMock).name]++
+	}
+	return elections
+}
+
+func TestPowerOfTwoChoicesElectorShouldElectNodeWithLessRequestsInFlight(t *testing.T) {
+	busy := newLoadNode("busy", 10, time.Millisecond)
+	idle := newLoadNode("idle", 0, time.Second)
+	elector := NewPowerOfTwoChoicesElector([]Node{busy, idle})
+
+	require.Equal(t, map[string]int{"idle": 100}, electNames(t, elector, 100))
+	require.Equal(t, map[string]int{"busy": 10}, electNames(t, elector, 10, idle))
+
+	idle.active = false
+	busy.active = false
+	_, err := elector.Elect()
+	require.Equal(t, ErrNoActiveNodes, err)
+}
+
+func TestEWMAElectorShouldElectNodeWithLowestLatency(t *testing.T) {
+	slow := newLoadNode("slow", 0, time.Second)
+	fast := newLoadNode("fast", 5, time.Millisecond)
+	elector := &EWMAElector{Nodes: []Node{slow, fast}}
+
+	require.Equal(t, map[string]int{"fast": 10}, electNames(t, elector, 10))
+	require.Equal(t, map[string]int{"slow": 1}, electNames(t, elector, 1, fast))
+}
+
+func TestMovingAverageShouldDecayWhileNotUpdated(t *testing.T) {
+	now := time.Now()
+	average := &movingAverage{now: func() time.Time { return now }}
+	require.Equal(t, time.Duration(0), average.Value())
+
+	average.Update(time.Second)
+	require.Equal(t, time.Second, average.Value())
+
+	now = now.Add(ewmaDecayHalfLife)
+	require.Equal(t, 500*time.Millisecond, average.Value())
+
+	average.Update(time.Second)
+	require.Equal(t, 600*time.Millisecond, average.Value(), "update should start from the decayed average")
+}
+
+func TestCostAwareElectorShouldRetryCheapNodesOnceTheirLatencyDecays(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	cheap := &averagedNodeMock{nodeMock: nodeMock{active: true}, average: &movingAverage{now: clock}}
+	expensive := &averagedNodeMock{nodeMock: nodeMock{active: true}, average: &movingAverage{now: clock}}
+	elector := NewCostAwareElector([]Node{expensive, cheap}, []float64{0.09, 0.01}, 100*time.Millisecond)
+	cheap.average.Update(time.Second)
+	expensive.average.Update(time.Millisecond)
+
+	elected, err := elector.Elect()
+	require.NoError(t, err)
+	require.Equal(t, expensive, elected)
+
+	now = now.Add(4 * ewmaDecayHalfLife)
+	elected, err = elector.Elect()
+	require.NoError(t, err)
+	require.Equal(t, cheap, elected, "cheap node should be retried once its latency decays below SLO")
+}
+
+type averagedNodeMock struct {
+	nodeMock
+	average *movingAverage
+}
+
+func (node *averagedNodeMock) InFlight() int64 {
+	return 0
+}
+
+func (node *averagedNodeMock) Latency() time.Duration {
+	return node.average.Value()
+}
+
+func TestWeightedRoundRobinElectorShouldElectNodesProportionallyToWeights(t *testing.T) {
+	heavy := newLoadNode("heavy", 0, 0)
+	light := newLoadNode("light", 0, 0)
+	unweighted := newLoadNode("unweighted", 0, 0)
+	elector := NewWeightedRoundRobinElector([]Node{heavy, light, unweighted}, []int{3, 1})
+
+	require.Equal(t, map[string]int{"heavy": 60, "light": 20, "unweighted": 20}, electNames(t, elector, 100))
+
+	light.active = false
+	require.Equal(t, map[string]int{"heavy": 3, "unweighted": 1}, electNames(t, elector, 4))
+}
+
+func TestCostAwareElectorShouldEscalateToExpensiveNodesOnlyIfCheapOnesBreachSLO(t *testing.T) {
+	cheap := newLoadNode("cheap", 0, 50*time.Millisecond)
+	expensive := newLoadNode("expensive", 0, time.Millisecond)
+	elector := NewCostAwareElector([]Node{expensive, cheap}, []float64{0.09, 0.01}, 100*time.Millisecond)
+
+	require.Equal(t, map[string]int{"cheap": 10}, electNames(t, elector, 10))
+
+	cheap.latency = 200 * time.Millisecond
+	require.Equal(t, map[string]int{"expensive": 10}, electNames(t, elector, 10))
+
+	expensive.latency = time.Second
+	require.Equal(t, map[string]int{"cheap": 1}, electNames(t, elector, 1), "fastest node should be elected if all breach SLO")
+
+	cheap.active = false
+	require.Equal(t, map[string]int{"expensive": 1}, electNames(t, elector, 1))
+}
+
+func TestShouldBalanceShardWithConfiguredStrategy(t *testing.T) {
+	storageProperties := func(name string, weight int) config.StorageBreakerProperties {
+		return config.StorageBreakerProperties{
+			Name:                           name,
+			Weight:                         weight,
+			BreakerProbeSize:               10,
+			BreakerErrorRate:               0.1,
+			BreakerCallTimeLimit:           metrics.Interval{Duration: time.Second},
+			BreakerCallTimeLimitPercentile: 0.9,
+			BreakerBasicCutOutDuration:     metrics.Interval{Duration: time.Second},
+			BreakerMaxCutOutDuration:       metrics.Interval{Duration: time.Minute},
+			MeterResolution:                metrics.Interval{Duration: time.Second},
+			MeterRetention:                 metrics.Interval{Duration: time.Minute},
+		}
+	}
+	shardConfig := config.Shard{
+		Storages:  config.Storages{storageProperties("first", 1), storageProperties("second", 2)},
+		Balancing: config.WeightedRoundRobinBalancing,
+	}
+	backends := map[string]http.RoundTripper{"first": &MockRoundTripper{}, "second": &MockRoundTripper{}}
+
+	balancerSet := NewShardBalancerPrioritySet(shardConfig, backends)
+
+	elections := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		elections[balancerSet.GetMostAvailable().Name]++
+	}
+	require.Equal(t, map[string]int{"first": 10, "second": 20}, elections)
+	require.Len(t, balancerSet.MeasuredStorages(), 2)
+}
@@ -68,7 +68,7 @@ func parseConf(file io.Reader) (YamlConfig, error) {
 func ReadConfiguration(configFilePath string) (io.ReadCloser, error) {
 	confFile, err := os.Open(configFilePath)
 	if err != nil {
-		log.Fatalf("[ ERROR ] Problem with opening config file: '%s' - err: %v !", configFilePath, err)
+		log.Printf("[ ERROR ] Problem with opening config file: '%s' - err: %v !", configFilePath, err)
 	}
 	return confFile, err
 }
@@ -78,7 +78,7 @@ func Configure(configReader io.Reader) (conf Config, err error) {
 
 	yconf, err := parseConf(configReader)
 	if err != nil {
-		log.Fatalf("Parsing config file error: %v", err)
+		log.Printf("Parsing config file error: %v", err)
 		return conf, err
 	}
 	conf.YamlConfig = yconf
@@ -108,8 +108,10 @@ func ValidateConf(conf YamlConfig, enableLogicalValidator bool) (bool, map[strin
 		validRegionsEntries, regionsValidationErrors := conf.RegionsEntryLogicalValidator()
 		validTransportsEntries, transportsValidationErrors := conf.TransportsEntryLogicalValidator()
 		validWatchdogEntries, watchdogValidatorsErrors := conf.WatchdogEntryLogicalValidator()
-		valid = valid && validListenPorts && validRegionsEntries && validTransportsEntries && validWatchdogEntries
-		validationErrors = mergeErrors(validationErrors, portsValidationErrors, regionsValidationErrors, transportsValidationErrors, watchdogValidatorsErrors)
+		validStoragesEntries, storagesValidationErrors := conf.StoragesEntryLogicalValidator()
+		validBreakerGossip, breakerGossipValidationErrors := conf.BreakerGossipEntryLogicalValidator()
+		valid = valid && validListenPorts && validRegionsEntries && validTransportsEntries && validWatchdogEntries && validStoragesEntries && validBreakerGossip
+		validationErrors = mergeErrors(validationErrors, portsValidationErrors, regionsValidationErrors, transportsValidationErrors, watchdogValidatorsErrors, storagesValidationErrors, breakerGossipValidationErrors)
 	}
 
 	for propertyName, validatorMessage := range validationErrors {
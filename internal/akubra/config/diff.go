@@ -0,0 +1,69 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// EntriesDiff lists the keys of a configuration map which differ between two configurations
+type EntriesDiff struct {
+	Added   []string `json:"Added,omitempty"`
+	Removed []string `json:"Removed,omitempty"`
+	Changed []string `json:"Changed,omitempty"`
+}
+
+// Diff describes what changed between two configurations. Map sections (e.g. Storages, Shards)
+// are compared key by key, other sections are only reported as changed
+type Diff struct {
+	ChangedSections []string               `json:"ChangedSections"`
+	ChangedEntries  map[string]EntriesDiff `json:"ChangedEntries"`
+}
+
+// IsEmpty tells if the configurations are the same
+func (diff Diff) IsEmpty() bool {
+	return len(diff.ChangedSections) == 0
+}
+
+// CompareConf returns the differences between the old and the new configuration
+func CompareConf(oldConf, newConf YamlConfig) Diff {
+	diff := Diff{ChangedSections: []string{}, ChangedEntries: make(map[string]EntriesDiff)}
+	oldValue := reflect.ValueOf(oldConf)
+	newValue := reflect.ValueOf(newConf)
+	confType := oldValue.Type()
+	for idx := 0; idx < confType.NumField(); idx++ {
+		sectionName := confType.Field(idx).Name
+		oldSection := oldValue.Field(idx)
+		newSection := newValue.Field(idx)
+		if reflect.DeepEqual(oldSection.Interface(), newSection.Interface()) {
+			continue
+		}
+		diff.ChangedSections = append(diff.ChangedSections, sectionName)
+		if oldSection.Kind() == reflect.Map && oldSection.Type().Key().Kind() == reflect.String {
+			diff.ChangedEntries[sectionName] = compareEntries(oldSection, newSection)
+		}
+	}
+	return diff
+}
+
+func compareEntries(oldEntries, newEntries reflect.Value) EntriesDiff {
+	entriesDiff := EntriesDiff{}
+	for _, key := range oldEntries.MapKeys() {
+		newEntry := newEntries.MapIndex(key)
+		if !newEntry.IsValid() {
+			entriesDiff.Removed = append(entriesDiff.Removed, key.String())
+			continue
+		}
+		if !reflect.DeepEqual(oldEntries.MapIndex(key).Interface(), newEntry.Interface()) {
+			entriesDiff.Changed = append(entriesDiff.Changed, key.String())
+		}
+	}
+	for _, key := range newEntries.MapKeys() {
+		if !oldEntries.MapIndex(key).IsValid() {
+			entriesDiff.Added = append(entriesDiff.Added, key.String())
+		}
+	}
+	sort.Strings(entriesDiff.Added)
+	sort.Strings(entriesDiff.Removed)
+	sort.Strings(entriesDiff.Changed)
+	return entriesDiff
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	storages "github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareConfShouldReportNoChangesForEqualConfigs(t *testing.T) {
+	conf := YamlConfig{Storages: storages.StoragesMap{"storage1": {Type: "passthrough"}}}
+
+	diff := CompareConf(conf, conf)
+
+	assert.True(t, diff.IsEmpty())
+	assert.Empty(t, diff.ChangedEntries)
+}
+
+func TestCompareConfShouldReportChangedEntriesAndSections(t *testing.T) {
+	oldConf := YamlConfig{
+		Storages: storages.StoragesMap{
+			"kept":    {Type: "passthrough"},
+			"changed": {Type: "passthrough"},
+			"removed": {Type: "passthrough"},
+		},
+		ShardingPolicies: regionsconfig.ShardingPolicies{"policy": {Domains: []string{"a.local"}}},
+	}
+	newConf := YamlConfig{
+		Storages: storages.StoragesMap{
+			"kept":    {Type: "passthrough"},
+			"changed": {Type: "passthrough", Maintenance: true},
+			"added":   {Type: "passthrough"},
+		},
+		ShardingPolicies:            regionsconfig.ShardingPolicies{"policy": {Domains: []string{"a.local"}}},
+		IgnoredCanonicalizedHeaders: map[string]bool{"X-Header": true},
+	}
+	newConf.Service.Server.Listen = ":8080"
+
+	diff := CompareConf(oldConf, newConf)
+
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, []string{"Service", "Storages", "IgnoredCanonicalizedHeaders"}, diff.ChangedSections)
+	assert.Equal(t, EntriesDiff{Added: []string{"added"}, Removed: []string{"removed"}, Changed: []string{"changed"}}, diff.ChangedEntries["Storages"])
+	assert.Equal(t, EntriesDiff{Added: []string{"X-Header"}}, diff.ChangedEntries["IgnoredCanonicalizedHeaders"])
+	assert.NotContains(t, diff.ChangedEntries, "ShardingPolicies")
+}
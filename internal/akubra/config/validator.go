@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/allegro/akubra/internal/akubra/privacy"
 	confregions "github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/allegro/akubra/internal/akubra/storages/config"
 	set "github.com/deckarep/golang-set"
@@ -21,6 +22,8 @@ type fetcherValidator = func(conf map[string]string) error
 var fetcherConfigValidators = map[string]fetcherValidator{
 	"fake": fakeFetcherConfigValidator,
 	"http": httpFetcherConfigValidator,
+	"file": fileFetcherConfigValidator,
+	"sql":  sqlFetcherConfigValidator,
 }
 
 // NoEmptyValuesInSliceValidator for strings in slice
@@ -87,6 +90,25 @@ func (c *YamlConfig) validateRegionCluster(policyName string, policies confregio
 		}
 	}
 
+	for _, policy := range policies.PreviousShards {
+		_, exists := c.Shards[policy.ShardName]
+		if !exists {
+			errList = append(errList, fmt.Errorf("Previous shard \"%s\" in policy \"%s\" is not defined", policy.ShardName, policyName))
+		}
+		if policy.Weight < 0 || policy.Weight > 1 {
+			errList = append(errList, fmt.Errorf("Weight for previous shard \"%s\" in policy \"%s\" is not valid", policy.ShardName, policyName))
+		}
+	}
+
+	if policies.WriteQuorum < 0 {
+		errList = append(errList, fmt.Errorf("WriteQuorum of policy \"%s\" can't be negative", policyName))
+	}
+	for _, policy := range policies.Shards {
+		if shard, exists := c.Shards[policy.ShardName]; exists && policies.WriteQuorum > len(shard.Storages) {
+			errList = append(errList, fmt.Errorf("WriteQuorum of policy \"%s\" exceeds the number of storages of shard \"%s\"", policyName, policy.ShardName))
+		}
+	}
+
 	if "" == policies.ConsistencyLevel {
 		errList = append(errList, fmt.Errorf("Policy '%s' is missing consistency level", policyName))
 	}
@@ -142,11 +164,72 @@ func (c *YamlConfig) ListenPortsLogicalValidator() (valid bool, validationErrors
 	return valid, errorsList
 }
 
+// StoragesEntryLogicalValidator checks the correctness of "Storages" part of configuration file
+func (c YamlConfig) StoragesEntryLogicalValidator() (valid bool, validationErrors map[string][]error) {
+	errList := make([]error, 0)
+	supportedAddressingStyles := map[config.AddressingStyle]bool{
+		"":                        true,
+		config.PathStyle:          true,
+		config.VirtualHostedStyle: true,
+	}
+	for storageName, storage := range c.Storages {
+		if !supportedAddressingStyles[storage.AddressingStyle] {
+			errList = append(errList, fmt.Errorf("AddressingStyle '%s' of storage '%s' is not supported", storage.AddressingStyle, storageName))
+		}
+		probe := storage.HealthProbe
+		if probe.Interval.Duration < 0 || probe.Timeout.Duration < 0 || probe.MaintenanceAfter < 0 {
+			errList = append(errList, fmt.Errorf("HealthProbe Interval, Timeout and MaintenanceAfter of storage '%s' can't be negative", storageName))
+		}
+		if probe.Path != "" && !strings.HasPrefix(probe.Path, "/") {
+			errList = append(errList, fmt.Errorf("HealthProbe Path of storage '%s' has to start with '/'", storageName))
+		}
+	}
+	supportedBalancingStrategies := map[config.BalancingStrategy]bool{
+		"":                                 true,
+		config.ResponseTimeBalancing:       true,
+		config.PowerOfTwoChoicesBalancing:  true,
+		config.EWMABalancing:               true,
+		config.WeightedRoundRobinBalancing: true,
+		config.CostAwareBalancing:          true,
+	}
+	for shardName, shard := range c.Shards {
+		if !supportedBalancingStrategies[shard.Balancing] {
+			errList = append(errList, fmt.Errorf("Balancing '%s' of shard '%s' is not supported", shard.Balancing, shardName))
+		}
+		if shard.Balancing == config.CostAwareBalancing && shard.LatencySLO.Duration <= 0 {
+			errList = append(errList, fmt.Errorf("Cost-aware balancing of shard '%s' requires LatencySLO", shardName))
+		}
+		for _, storage := range shard.Storages {
+			if storage.Weight < 0 || storage.EgressCost < 0 {
+				errList = append(errList, fmt.Errorf("Weight and EgressCost of storage '%s' in shard '%s' can't be negative", storage.Name, shardName))
+			}
+		}
+		hedging := shard.HedgedReads
+		if !hedging.Enabled {
+			continue
+		}
+		if hedging.DelayPercentile < 0 || hedging.DelayPercentile > 1 {
+			errList = append(errList, fmt.Errorf("HedgedReads DelayPercentile of shard '%s' has to be between 0 and 1", shardName))
+		}
+		if hedging.Delay.Duration <= 0 && hedging.DelayPercentile == 0 {
+			errList = append(errList, fmt.Errorf("HedgedReads of shard '%s' require Delay or DelayPercentile", shardName))
+		}
+	}
+	validationErrors, valid = prepareErrors(errList, "StoragesEntryLogicalValidator")
+	return
+}
+
 // WatchdogEntryLogicalValidator validates ConsistencyWatchdog's config depending on the types of watchdogs defined
 func (c YamlConfig) WatchdogEntryLogicalValidator() (valid bool, validationErrors map[string][]error) {
 	errList := make([]error, 0)
 	supportedWatchdogs := map[string][]string{
-		"sql": {"dialect", "user", "password", "dbname", "host", "port", "maxopenconns", "maxidleconns", "connmaxlifetime", "conntimeout"},
+		"sql":    {"dialect", "maxopenconns", "maxidleconns", "connmaxlifetime"},
+		"sqlite": {"path"},
+	}
+	supportedSQLDialects := map[string][]string{
+		"postgres": {"user", "password", "dbname", "host", "port", "conntimeout"},
+		"mysql":    {"user", "password", "dbname", "host", "port", "conntimeout"},
+		"sqlite3":  {"path"},
 	}
 	if c.Watchdog.Type == "" {
 		return true, validationErrors
@@ -165,7 +248,15 @@ func (c YamlConfig) WatchdogEntryLogicalValidator() (valid bool, validationError
 		errMsg := fmt.Errorf("watchog of type '%s' is not supported", c.Watchdog.Type)
 		errList = append(errList, errMsg)
 	}
-	for _, requiredField := range supportedWatchdogs[c.Watchdog.Type] {
+	requiredFields := append([]string{}, supportedWatchdogs[c.Watchdog.Type]...)
+	if dialect, dialectPresent := c.Watchdog.Props["dialect"]; dialectPresent && c.Watchdog.Type == "sql" {
+		dialectFields, dialectSupported := supportedSQLDialects[strings.ToLower(dialect)]
+		if !dialectSupported {
+			errList = append(errList, fmt.Errorf("dialect '%s' of watchdog '%s' is not supported", dialect, c.Watchdog.Type))
+		}
+		requiredFields = append(requiredFields, dialectFields...)
+	}
+	for _, requiredField := range requiredFields {
 		if _, paramPresent := c.Watchdog.Props[requiredField]; !paramPresent {
 			errMsg := fmt.Sprintf("param '%s' for watchdog '%s' is missing", requiredField, c.Watchdog.Type)
 			errList = append(errList, errors.New(errMsg))
@@ -207,21 +298,47 @@ func (c YamlConfig) CredentialsStoresEntryLogicalValidator() (valid bool, valida
 		validationErrors, valid = prepareErrors(errList, "CredentialsStoresEntryLogicalValidator")
 		return
 	}
+	presign := c.Service.Server.Presign
+	if presign.Scheme != "" && presign.Scheme != "http" && presign.Scheme != "https" {
+		errList = append(errList, fmt.Errorf("Presign scheme '%s' is not supported", presign.Scheme))
+	}
 
 	validationErrors, valid = prepareErrors(errList, "CredentialsStoresEntryLogicalValidator")
 	return
 }
 
+//BreakerGossipEntryLogicalValidator requires the secret signing the breaker gossip messages
+func (c YamlConfig) BreakerGossipEntryLogicalValidator() (valid bool, validationErrors map[string][]error) {
+	errList := make([]error, 0)
+	gossip := c.Service.Server.BreakerGossip
+	if (gossip.Listen != "" || len(gossip.Peers) > 0) && gossip.Secret == "" {
+		errList = append(errList, errors.New("BreakerGossip requires Secret when Listen or Peers are set"))
+	}
+	validationErrors, valid = prepareErrors(errList, "BreakerGossipEntryLogicalValidator")
+	return
+}
+
 //PrivacyEntryLogicalValidator validates privacy config
 func (c YamlConfig) PrivacyEntryLogicalValidator() (valid bool, validationErrors map[string][]error) {
 	errList := make([]error, 0)
 	requiredProperties := map[string]*string{
 		"IsInternalNetworkHeaderName": &c.Privacy.IsInternalNetworkHeaderName}
 	for name, val := range requiredProperties {
-		if *val == "" {
+		if *val == "" && len(c.Privacy.InternalNetworks) == 0 {
 			errList = append(errList, fmt.Errorf("'%s' cant be empty", name))
 		}
 	}
+	networks := map[string][]string{
+		"InternalNetworks": c.Privacy.InternalNetworks,
+		"TrustedProxies":   c.Privacy.TrustedProxies}
+	for name, cidrs := range networks {
+		if _, err := privacy.ParseNetworks(cidrs); err != nil {
+			errList = append(errList, fmt.Errorf("'%s' is invalid: %s", name, err))
+		}
+	}
+	if _, err := privacy.NewFilters(c.Privacy.Filters); err != nil {
+		errList = append(errList, fmt.Errorf("'Filters' are invalid: %s", err))
+	}
 	validationErrors, valid = prepareErrors(errList, "PrivacyEntryLogicalValidator")
 	return
 }
@@ -243,10 +360,22 @@ func (c YamlConfig) BucketMetaDataCacheEntryLogicalValidator() (valid bool, vali
 			errList = append(errList, fmt.Errorf("'%s' cant be smaller or equal to zero", name))
 		}
 	}
+	notNegative := map[string]time.Duration{
+		"StaleWhileRevalidate": c.BucketMetaDataCache.StaleWhileRevalidate,
+		"StaleIfError":         c.BucketMetaDataCache.StaleIfError}
+	for name, val := range notNegative {
+		if val < 0 {
+			errList = append(errList, fmt.Errorf("'%s' cant be negative", name))
+		}
+	}
 	validatorErrors := validator(c.BucketMetaDataCache.FetcherProps)
 	if validatorErrors != nil {
 		errList = append(errList, validatorErrors)
 	}
+	watchdogType := strings.ToLower(c.Watchdog.Type)
+	if c.BucketMetaDataCache.FetcherType == "sql" && watchdogType != "sql" && watchdogType != "sqlite" {
+		errList = append(errList, errors.New("'sql' fetcher requires 'sql' or 'sqlite' watchdog"))
+	}
 	validationErrors, valid = prepareErrors(errList, "BucketMetaDataCacheEntryLogicalValidator")
 	return
 }
@@ -331,3 +460,31 @@ func httpFetcherConfigValidator(conf map[string]string) error {
 	}
 	return nil
 }
+
+func fileFetcherConfigValidator(conf map[string]string) error {
+	value, present := conf["Path"]
+	if !present || value == "" {
+		return errors.New("'Path' property is missing")
+	}
+	valueD, present := conf["ReloadInterval"]
+	if !present {
+		return nil
+	}
+	interval, e := time.ParseDuration(valueD)
+	if e != nil || interval <= 0 {
+		return errors.New("'ReloadInterval' not parsable")
+	}
+	return nil
+}
+
+func sqlFetcherConfigValidator(conf map[string]string) error {
+	value, present := conf["PatternsReloadInterval"]
+	if !present {
+		return nil
+	}
+	interval, e := time.ParseDuration(value)
+	if e != nil || interval <= 0 {
+		return errors.New("'PatternsReloadInterval' not parsable")
+	}
+	return nil
+}
@@ -251,6 +251,51 @@ func TestValidatorShouldFailWithInvalidWeight(t *testing.T) {
 		validationErrors["RegionsEntryLogicalValidator"][0])
 }
 
+func TestValidatorShouldFailWithMissingPreviousCluster(t *testing.T) {
+	regionConfig := shardsconfig.Policies{
+		Shards:         []shardsconfig.Policy{{ShardName: "cluster1test", Weight: 1}},
+		PreviousShards: []shardsconfig.Policy{{ShardName: "someothercluster", Weight: 1}},
+		Domains:        []string{"domain.dc"},
+	}
+	var size httphandlerconfig.HumanSizeUnits
+	size.SizeInBytes = 2048
+	regions := map[string]shardsconfig.Policies{"testregion": regionConfig}
+	yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+		"127.0.0.1:1234", "127.0.0.1:1235", regions, nil, config.WatchdogConfig{}, nil,
+		privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+
+	valid, validationErrors := yamlConfig.RegionsEntryLogicalValidator()
+	assert.False(t, valid)
+	assert.Equal(
+		t,
+		errors.New("Previous shard \"someothercluster\" in policy \"testregion\" is not defined"),
+		validationErrors["RegionsEntryLogicalValidator"][0])
+}
+
+func TestValidatorShouldFailWithWriteQuorumExceedingShardStorages(t *testing.T) {
+	for quorum, expectedError := range map[int]error{
+		-1: errors.New("WriteQuorum of policy \"testregion\" can't be negative"),
+		2:  errors.New("WriteQuorum of policy \"testregion\" exceeds the number of storages of shard \"cluster1test\""),
+	} {
+		regionConfig := shardsconfig.Policies{
+			Shards:           []shardsconfig.Policy{{ShardName: "cluster1test", Weight: 1}},
+			Domains:          []string{"domain.dc"},
+			ConsistencyLevel: shardsconfig.None,
+			WriteQuorum:      quorum,
+		}
+		var size httphandlerconfig.HumanSizeUnits
+		size.SizeInBytes = 2048
+		regions := map[string]shardsconfig.Policies{"testregion": regionConfig}
+		yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+			"127.0.0.1:1234", "127.0.0.1:1235", regions, nil, config.WatchdogConfig{}, nil,
+			privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+
+		valid, validationErrors := yamlConfig.RegionsEntryLogicalValidator()
+		assert.False(t, valid)
+		assert.Equal(t, []error{expectedError}, validationErrors["RegionsEntryLogicalValidator"])
+	}
+}
+
 func TestValidatorShouldFailWithMissingClusterDomain(t *testing.T) {
 	multiClusterConfig := shardsconfig.Policy{
 		ShardName: "cluster1test",
@@ -542,6 +587,51 @@ func TestShouldFailWhenWatchdogConfigDoesNotHaveAllOfTheFieldsProvided(t *testin
 	assert.False(t, valid)
 }
 
+func TestShouldValidateSQLiteWatchdogConfig(t *testing.T) {
+	var size httphandlerconfig.HumanSizeUnits
+	size.SizeInBytes = 2048
+	watchdogConfig := config.WatchdogConfig{Type: "sqlite", ObjectVersionHeaderName: "x-amz-meta-akubra", Props: map[string]string{}}
+	yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+		"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, watchdogConfig, nil,
+		privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+	valid, errList := yamlConfig.WatchdogEntryLogicalValidator()
+	assert.Contains(t, errList["WatchdogEntryLogicalValidator"], errors.New("param 'path' for watchdog 'sqlite' is missing"))
+	assert.False(t, valid)
+
+	yamlConfig.Watchdog.Props["path"] = "/var/lib/akubra/watchdog.db"
+	valid, _ = yamlConfig.WatchdogEntryLogicalValidator()
+	assert.True(t, valid)
+}
+
+func TestShouldValidateWatchdogPropsRequiredBySQLDialect(t *testing.T) {
+	var size httphandlerconfig.HumanSizeUnits
+	size.SizeInBytes = 2048
+	watchdogConfig := config.WatchdogConfig{
+		Type:                    "sql",
+		ObjectVersionHeaderName: "x-amz-meta-akubra",
+		Props: map[string]string{
+			"dialect":         "sqlite3",
+			"maxopenconns":    "1",
+			"maxidleconns":    "1",
+			"connmaxlifetime": "1h",
+		}}
+	yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+		"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, watchdogConfig, nil,
+		privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+	valid, errList := yamlConfig.WatchdogEntryLogicalValidator()
+	assert.Equal(t, []error{errors.New("param 'path' for watchdog 'sql' is missing")}, errList["WatchdogEntryLogicalValidator"])
+	assert.False(t, valid)
+
+	yamlConfig.Watchdog.Props["path"] = "/var/lib/akubra/watchdog.db"
+	valid, _ = yamlConfig.WatchdogEntryLogicalValidator()
+	assert.True(t, valid)
+
+	yamlConfig.Watchdog.Props["dialect"] = "oracle"
+	valid, errList = yamlConfig.WatchdogEntryLogicalValidator()
+	assert.Contains(t, errList["WatchdogEntryLogicalValidator"], errors.New("dialect 'oracle' of watchdog 'sql' is not supported"))
+	assert.False(t, valid)
+}
+
 func TestCredentialsStoresValidation(t *testing.T) {
 
 	for _, testCase := range []struct {
@@ -595,6 +685,49 @@ func TestCredentialsStoresValidation(t *testing.T) {
 	}
 }
 
+func TestShouldValidatePresignConfig(t *testing.T) {
+	var size httphandlerconfig.HumanSizeUnits
+	size.SizeInBytes = 2048
+	yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+		"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, config.WatchdogConfig{},
+		crdStoreConig.CredentialsStoreMap{"store1": {Default: true, Type: "Vault", Properties: map[string]string{
+			"Endpoint": "http://localhost:8200", "Timeout": "300", "MaxRetries": "3", "PathPrefix": "/secret",
+		}}},
+		privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+	yamlConfig.Service.Server.Presign = httphandlerconfig.Presign{Scheme: "ftp"}
+
+	valid, errList := yamlConfig.CredentialsStoresEntryLogicalValidator()
+	assert.False(t, valid)
+	assert.Contains(t, errList["CredentialsStoresEntryLogicalValidator"], errors.New("Presign scheme 'ftp' is not supported"))
+
+	yamlConfig.Service.Server.Presign = httphandlerconfig.Presign{Scheme: "https"}
+	valid, _ = yamlConfig.CredentialsStoresEntryLogicalValidator()
+	assert.True(t, valid)
+}
+
+func TestShouldRequireBreakerGossipSecret(t *testing.T) {
+	for _, testCase := range []struct {
+		gossip        httphandlerconfig.BreakerGossip
+		expectedValid bool
+	}{
+		{httphandlerconfig.BreakerGossip{}, true},
+		{httphandlerconfig.BreakerGossip{Listen: ":7946", Peers: []string{"peer:7946"}, Secret: "secret"}, true},
+		{httphandlerconfig.BreakerGossip{Listen: ":7946"}, false},
+		{httphandlerconfig.BreakerGossip{Peers: []string{"peer:7946"}}, false},
+	} {
+		yamlConfig := YamlConfig{}
+		yamlConfig.Service.Server.BreakerGossip = testCase.gossip
+
+		valid, errList := yamlConfig.BreakerGossipEntryLogicalValidator()
+
+		assert.Equal(t, testCase.expectedValid, valid, "%v", testCase.gossip)
+		if !testCase.expectedValid {
+			assert.Contains(t, errList["BreakerGossipEntryLogicalValidator"],
+				errors.New("BreakerGossip requires Secret when Listen or Peers are set"))
+		}
+	}
+}
+
 func TestPrivacyConfigValidation(t *testing.T) {
 	for _, testCase := range []struct {
 		caseName       string
@@ -613,6 +746,22 @@ func TestPrivacyConfigValidation(t *testing.T) {
 		},
 			[]error{},
 		},
+		{"Should validate config with internal networks only", privacy.Config{
+			InternalNetworks: []string{"10.0.0.0/8"},
+			TrustedProxies:   []string{"172.16.0.1"},
+			Filters:          []privacy.FilterConfig{{Type: "MethodAllowlist", Methods: []string{"GET"}}},
+		},
+			[]error{},
+		},
+		{"Should fail on invalid networks", privacy.Config{
+			InternalNetworks: []string{"10.0.0.0/33"},
+		},
+			[]error{errors.New(`'InternalNetworks' is invalid: invalid CIDR "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`)}},
+		{"Should fail on invalid filters", privacy.Config{
+			IsInternalNetworkHeaderName: "x",
+			Filters:                     []privacy.FilterConfig{{Type: "Unknown"}},
+		},
+			[]error{errors.New(`'Filters' are invalid: filter #0: unknown type "Unknown"`)}},
 	} {
 
 		var size httphandlerconfig.HumanSizeUnits
@@ -721,6 +870,57 @@ func TestBucketCacheConfigValidation(t *testing.T) {
 		},
 			[]error{},
 		},
+		{"Should fail if 'Path' property is missing", metadata.BucketMetaDataCacheConfig{
+			MaxCacheSizeInMB: 1,
+			ShardsCount:      1,
+			FetcherType:      "file",
+			FetcherProps:     map[string]string{"ReloadInterval": "10s"},
+		},
+			[]error{errors.New("'Path' property is missing")},
+		},
+		{"Should validate file fetcher", metadata.BucketMetaDataCacheConfig{
+			MaxCacheSizeInMB: 1,
+			ShardsCount:      1,
+			FetcherType:      "file",
+			FetcherProps:     map[string]string{"Path": "/etc/akubra/buckets.yaml", "ReloadInterval": "10s"},
+		},
+			[]error{},
+		},
+		{"Should fail if sql fetcher 'PatternsReloadInterval' is not parsable", metadata.BucketMetaDataCacheConfig{
+			MaxCacheSizeInMB: 1,
+			ShardsCount:      1,
+			FetcherType:      "sql",
+			FetcherProps:     map[string]string{"PatternsReloadInterval": "never"},
+		},
+			[]error{errors.New("'PatternsReloadInterval' not parsable")},
+		},
+		{"Should fail if 'StaleIfError' is negative", metadata.BucketMetaDataCacheConfig{
+			MaxCacheSizeInMB: 1,
+			ShardsCount:      1,
+			StaleIfError:     -time.Second,
+			FetcherType:      "fake",
+			FetcherProps:     map[string]string{"AllInternal": "false"},
+		},
+			[]error{errors.New("'StaleIfError' cant be negative")},
+		},
+		{"Should validate stale-while-revalidate", metadata.BucketMetaDataCacheConfig{
+			LifeWindow:           time.Minute,
+			StaleWhileRevalidate: time.Minute,
+			StaleIfError:         time.Hour,
+			MaxCacheSizeInMB:     1,
+			ShardsCount:          1,
+			FetcherType:          "fake",
+			FetcherProps:         map[string]string{"AllInternal": "false"},
+		},
+			[]error{},
+		},
+		{"Should fail if sql fetcher is used without sql watchdog", metadata.BucketMetaDataCacheConfig{
+			MaxCacheSizeInMB: 1,
+			ShardsCount:      1,
+			FetcherType:      "sql",
+		},
+			[]error{errors.New("'sql' fetcher requires 'sql' or 'sqlite' watchdog")},
+		},
 	} {
 
 		var size httphandlerconfig.HumanSizeUnits
@@ -768,3 +968,135 @@ func prepareTransportsForEntryLogicalValidatorTest(maxIdleConns, maxIdleConnsPer
 		},
 	}
 }
+
+func TestStoragesConfigValidation(t *testing.T) {
+	for _, testCase := range []struct {
+		caseName       string
+		storages       config2.StoragesMap
+		expectedErrors []error
+	}{
+		{"Should validate storages with supported addressing styles", config2.StoragesMap{
+			"default":       {},
+			"path":          {AddressingStyle: config2.PathStyle},
+			"virtualHosted": {AddressingStyle: config2.VirtualHostedStyle},
+		},
+			[]error{},
+		},
+		{"Should fail on unsupported addressing style", config2.StoragesMap{
+			"test": {AddressingStyle: "domain"},
+		},
+			[]error{errors.New("AddressingStyle 'domain' of storage 'test' is not supported")}},
+	} {
+		var size httphandlerconfig.HumanSizeUnits
+		size.SizeInBytes = 2048
+		yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+			"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, config.WatchdogConfig{}, crdStoreConig.CredentialsStoreMap{},
+			privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+		yamlConfig.Storages = testCase.storages
+
+		valid, errList := yamlConfig.StoragesEntryLogicalValidator()
+		assert.Equal(t, len(testCase.expectedErrors) == 0, valid, testCase.caseName)
+		for idx := range testCase.expectedErrors {
+			assert.Contains(t, errList["StoragesEntryLogicalValidator"], testCase.expectedErrors[idx], testCase.caseName)
+		}
+	}
+}
+
+func TestHedgedReadsConfigValidation(t *testing.T) {
+	for _, testCase := range []struct {
+		caseName       string
+		hedgedReads    config2.HedgedReads
+		expectedErrors []error
+	}{
+		{"Should validate disabled hedged reads", config2.HedgedReads{DelayPercentile: 2}, []error{}},
+		{"Should validate hedged reads with delay", config2.HedgedReads{Enabled: true, Delay: metrics.Interval{Duration: time.Millisecond}}, []error{}},
+		{"Should validate hedged reads with delay percentile", config2.HedgedReads{Enabled: true, DelayPercentile: 0.95}, []error{}},
+		{"Should fail on hedged reads without delay", config2.HedgedReads{Enabled: true},
+			[]error{errors.New("HedgedReads of shard 'test' require Delay or DelayPercentile")}},
+		{"Should fail on delay percentile out of range", config2.HedgedReads{Enabled: true, DelayPercentile: 95},
+			[]error{errors.New("HedgedReads DelayPercentile of shard 'test' has to be between 0 and 1")}},
+	} {
+		var size httphandlerconfig.HumanSizeUnits
+		size.SizeInBytes = 2048
+		yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+			"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, config.WatchdogConfig{}, crdStoreConig.CredentialsStoreMap{},
+			privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+		yamlConfig.Shards = config2.ShardsMap{"test": {HedgedReads: testCase.hedgedReads}}
+
+		valid, errList := yamlConfig.StoragesEntryLogicalValidator()
+		assert.Equal(t, len(testCase.expectedErrors) == 0, valid, testCase.caseName)
+		for idx := range testCase.expectedErrors {
+			assert.Contains(t, errList["StoragesEntryLogicalValidator"], testCase.expectedErrors[idx], testCase.caseName)
+		}
+	}
+}
+
+func TestBalancingConfigValidation(t *testing.T) {
+	for _, testCase := range []struct {
+		caseName       string
+		shard          config2.Shard
+		expectedErrors []error
+	}{
+		{"Should validate default balancing", config2.Shard{}, []error{}},
+		{"Should validate weighted round-robin balancing", config2.Shard{
+			Balancing: config2.WeightedRoundRobinBalancing,
+			Storages:  config2.Storages{{Name: "storage", Weight: 2}},
+		}, []error{}},
+		{"Should validate cost-aware balancing", config2.Shard{
+			Balancing:  config2.CostAwareBalancing,
+			LatencySLO: metrics.Interval{Duration: time.Second},
+			Storages:   config2.Storages{{Name: "storage", EgressCost: 0.05}},
+		}, []error{}},
+		{"Should fail on unsupported balancing", config2.Shard{Balancing: "random"},
+			[]error{errors.New("Balancing 'random' of shard 'test' is not supported")}},
+		{"Should fail on cost-aware balancing without SLO", config2.Shard{Balancing: config2.CostAwareBalancing},
+			[]error{errors.New("Cost-aware balancing of shard 'test' requires LatencySLO")}},
+		{"Should fail on negative weight", config2.Shard{Storages: config2.Storages{{Name: "storage", Weight: -1}}},
+			[]error{errors.New("Weight and EgressCost of storage 'storage' in shard 'test' can't be negative")}},
+	} {
+		var size httphandlerconfig.HumanSizeUnits
+		size.SizeInBytes = 2048
+		yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+			"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, config.WatchdogConfig{}, crdStoreConig.CredentialsStoreMap{},
+			privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+		yamlConfig.Shards = config2.ShardsMap{"test": testCase.shard}
+
+		valid, errList := yamlConfig.StoragesEntryLogicalValidator()
+		assert.Equal(t, len(testCase.expectedErrors) == 0, valid, testCase.caseName)
+		for idx := range testCase.expectedErrors {
+			assert.Contains(t, errList["StoragesEntryLogicalValidator"], testCase.expectedErrors[idx], testCase.caseName)
+		}
+	}
+}
+
+func TestHealthProbeConfigValidation(t *testing.T) {
+	for _, testCase := range []struct {
+		caseName       string
+		healthProbe    config2.HealthProbe
+		expectedErrors []error
+	}{
+		{"Should validate disabled health probe", config2.HealthProbe{}, []error{}},
+		{"Should validate health probe of canary object", config2.HealthProbe{
+			Interval:         metrics.Interval{Duration: time.Second},
+			Path:             "/canary/object",
+			MaintenanceAfter: 3,
+		}, []error{}},
+		{"Should fail on negative interval", config2.HealthProbe{Interval: metrics.Interval{Duration: -time.Second}},
+			[]error{errors.New("HealthProbe Interval, Timeout and MaintenanceAfter of storage 'storage' can't be negative")}},
+		{"Should fail on relative path", config2.HealthProbe{Interval: metrics.Interval{Duration: time.Second}, Path: "canary"},
+			[]error{errors.New("HealthProbe Path of storage 'storage' has to start with '/'")}},
+	} {
+		var size httphandlerconfig.HumanSizeUnits
+		size.SizeInBytes = 2048
+		yamlConfig := PrepareYamlConfig(size, 31, 45, "127.0.0.1:81",
+			"127.0.0.1:1234", "127.0.0.1:1235", nil, nil, config.WatchdogConfig{}, crdStoreConig.CredentialsStoreMap{},
+			privacy.Config{}, metadata.BucketMetaDataCacheConfig{})
+		yamlConfig.Storages = config2.StoragesMap{"storage": {HealthProbe: testCase.healthProbe}}
+
+		valid, errList := yamlConfig.StoragesEntryLogicalValidator()
+		assert.Equal(t, len(testCase.expectedErrors) == 0, valid, testCase.caseName)
+		for idx := range testCase.expectedErrors {
+			assert.Contains(t, errList["StoragesEntryLogicalValidator"], testCase.expectedErrors[idx], testCase.caseName)
+		}
+	}
+}
@@ -16,11 +16,21 @@ import (
 type Server struct {
 	// Maximum accepted body size
 	BodyMaxSize HumanSizeUnits `yaml:"BodyMaxSize,omitempty"`
+	// Number of request body bytes kept in memory, the remainder is spilled to a temporary file
+	BodyMemoryBufferSize HumanSizeUnits `yaml:"BodyMemoryBufferSize,omitempty"`
+	// Directory for spilled request bodies, system temporary directory is used if empty
+	BodyBufferDirectory string `yaml:"BodyBufferDirectory,omitempty"`
 	// Max number of incoming requests to process in parallel
 	MaxConcurrentRequests   int32  `yaml:"MaxConcurrentRequests" validate:"min=1"`
 	Listen                  string `yaml:"Listen,omitempty" validate:"regexp=^(([0-9]+[.][0-9]+[.][0-9]+[.][0-9]+)?[:][0-9]+)$"`
 	TechnicalEndpointListen string `yaml:"TechnicalEndpointListen,omitempty" validate:"regexp=^(([0-9]+[.][0-9]+[.][0-9]+[.][0-9]+)?[:][0-9]+)$"`
 	HealthCheckEndpoint     string `yaml:"HealthCheckEndpoint,omitempty" validate:"regexp=^([/a-z0-9]+)$"`
+	// File keeping the storages maintenance and breaker overrides set with the technical endpoint across restarts
+	StorageStateFile string `yaml:"StorageStateFile,omitempty"`
+	// BreakerGossip shares the breakers opened by the instance with its peers
+	BreakerGossip BreakerGossip `yaml:"BreakerGossip,omitempty"`
+	// Presign configures the presigned URLs generated by the technical endpoint
+	Presign Presign `yaml:"Presign,omitempty"`
 	// ReadTimeout is client request max duration
 	ReadTimeout metrics.Interval `yaml:"ReadTimeout" validate:"nonzero"`
 	// WriteTimeout is server request max processing time
@@ -29,6 +39,24 @@ type Server struct {
 	ShutdownTimeout metrics.Interval `yaml:"ShutdownTimeout" validate:"nonzero"`
 }
 
+// BreakerGossip configures the exchange of opened breakers between akubra instances
+type BreakerGossip struct {
+	// Listen is the UDP address receiving the breakers opened by the peers, the gossip is disabled if empty
+	Listen string `yaml:"Listen,omitempty"`
+	// Peers are the UDP addresses of the other instances
+	Peers []string `yaml:"Peers,omitempty"`
+	// Secret signs the messages, the messages with invalid signatures are dropped
+	Secret string `yaml:"Secret,omitempty"`
+}
+
+// Presign configures the presigned URLs generated by the technical endpoint
+type Presign struct {
+	// Scheme of the generated URLs, http if empty
+	Scheme string `yaml:"Scheme,omitempty"`
+	// Region the URLs are signed for, us-east-1 if empty
+	Region string `yaml:"Region,omitempty"`
+}
+
 // AdditionalHeaders type fields in yaml configuration will parse list of special headers
 type AdditionalHeaders map[string]string
 
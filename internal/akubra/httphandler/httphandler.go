@@ -107,6 +107,10 @@ func prepareRequestWithContextValues(req *http.Request, requestID string) (*http
 			return req, err
 		}
 		reqCtx = context.WithValue(reqCtx, AuthHeader, &authHeader)
+	} else if presignedAuth, err := utils.ParsePresignedV4Query(req.URL.Query()); err == nil {
+		reqCtx = context.WithValue(reqCtx, AuthHeader, &presignedAuth)
+	} else if err != utils.ErrNoAuthHeader {
+		return req, err
 	}
 	return req.WithContext(context.WithValue(reqCtx, Domain, reqHost)), nil
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/allegro/akubra/internal/akubra/httphandler/config"
 	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metadata"
 	"github.com/allegro/akubra/internal/akubra/privacy"
 	"github.com/allegro/akubra/internal/akubra/utils"
 )
@@ -316,8 +317,77 @@ func (sizeLimitter *bodySizeLimitter) RoundTrip(req *http.Request) (*http.Respon
 	return sizeLimitter.roundTripper.RoundTrip(req)
 }
 
+//validateIncomingRequest checks the body size against the limit, lowered to the bucket max object size if it's set
 func (sizeLimitter *bodySizeLimitter) validateIncomingRequest(req *http.Request) int {
-	return config.RequestHeaderContentLengthValidator(*req, sizeLimitter.bodySizeLimit)
+	bodySizeLimit := sizeLimitter.bodySizeLimit
+	bucketMetaData := metadata.FromContext(req.Context())
+	if bucketMetaData != nil && bucketMetaData.MaxObjectSize > 0 && bucketMetaData.MaxObjectSize < bodySizeLimit {
+		bodySizeLimit = bucketMetaData.MaxObjectSize
+	}
+	return config.RequestHeaderContentLengthValidator(*req, bodySizeLimit)
+}
+
+// BucketPolicyEnforcer supplies the request context with the metadata of the requested bucket
+// and rejects the requests violating the bucket policy
+func BucketPolicyEnforcer(fetcher metadata.BucketMetaDataFetcher) Decorator {
+	return func(roundTripper http.RoundTripper) http.RoundTripper {
+		return &bucketPolicyEnforcer{
+			roundTripper: roundTripper,
+			fetcher:      fetcher,
+		}
+	}
+}
+
+type bucketPolicyEnforcer struct {
+	roundTripper http.RoundTripper
+	fetcher      metadata.BucketMetaDataFetcher
+}
+
+func (enforcer *bucketPolicyEnforcer) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucketName := utils.ExtractBucketFrom(req.URL.Path)
+	if bucketName == "" {
+		return enforcer.roundTripper.RoundTrip(req)
+	}
+	bucketMetaData, err := enforcer.fetcher.Fetch(&metadata.BucketLocation{Name: bucketName})
+	// the routing and the restrictions of the bucket are unknown, so the request is rejected rather than sent
+	// to the shards of the domain unrestricted
+	if err != nil {
+		log.Printf("Rejected request %s to bucket %s, couldn't fetch meta data: %s", utils.RequestID(req), bucketName, err)
+		return makeResponse(req, http.StatusServiceUnavailable, "Bucket meta data unavailable.", "text/plain"), nil
+	}
+	if bucketMetaData == nil {
+		return enforcer.roundTripper.RoundTrip(req)
+	}
+	if violation := bucketPolicyViolation(req, bucketMetaData); violation != "" {
+		log.Printf("Rejected request %s to bucket %s: %s", utils.RequestID(req), bucketName, violation)
+		return makeResponse(req, http.StatusForbidden, violation, "text/plain"), nil
+	}
+	reqCtx := context.WithValue(req.Context(), metadata.BucketMetaDataContextKey, bucketMetaData)
+	return enforcer.roundTripper.RoundTrip(req.WithContext(reqCtx))
+}
+
+func bucketPolicyViolation(req *http.Request, bucketMetaData *metadata.BucketMetaData) string {
+	if len(bucketMetaData.AllowedAccessKeys) > 0 && !contains(bucketMetaData.AllowedAccessKeys, utils.RequestAccessKey(req)) {
+		return "Access key not allowed on the bucket."
+	}
+	isModification := req.Method != http.MethodGet && req.Method != http.MethodHead && req.Method != http.MethodOptions
+	if bucketMetaData.Frozen && isModification {
+		return "Bucket is frozen."
+	}
+	isUpload := (req.Method == http.MethodPut || req.Method == http.MethodPost) && !utils.IsMultiDeleteRequest(req)
+	if bucketMetaData.ReadOnly && isUpload {
+		return "Bucket is read-only."
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
 }
 
 // Decorate returns http.Roundtripper wraped with all passed decorators
@@ -3,6 +3,7 @@ package httphandler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +14,8 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metadata"
+	"github.com/allegro/akubra/internal/akubra/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -151,3 +154,104 @@ func TestResponseHeaderStripper(t *testing.T) {
 	assert.Empty(t, res.Header.Get("x-akubra-custom-header"))
 	assert.Empty(t, res.Header.Get("x-akubra-custom-header-2"))
 }
+
+type staticBucketMetaDataFetcher map[string]*metadata.BucketMetaData
+
+func (fetcher staticBucketMetaDataFetcher) Fetch(bucketLocation *metadata.BucketLocation) (*metadata.BucketMetaData, error) {
+	return fetcher[bucketLocation.Name], nil
+}
+
+type contextRecordingRoundTripper struct {
+	bucketMetaData *metadata.BucketMetaData
+}
+
+func (recorder *contextRecordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder.bucketMetaData = metadata.FromContext(req.Context())
+	return &http.Response{StatusCode: http.StatusOK, Request: req}, nil
+}
+
+func TestBucketPolicyEnforcer(t *testing.T) {
+	fetcher := staticBucketMetaDataFetcher{
+		"frozen":   {Name: "frozen", Frozen: true},
+		"readonly": {Name: "readonly", ReadOnly: true},
+		"private":  {Name: "private", AllowedAccessKeys: []string{"allowed"}},
+	}
+	for _, testCase := range []struct {
+		method       string
+		url          string
+		accessKey    string
+		expectedCode int
+	}{
+		{http.MethodGet, "http://localhost/frozen/object", "", http.StatusOK},
+		{http.MethodPut, "http://localhost/frozen/object", "", http.StatusForbidden},
+		{http.MethodDelete, "http://localhost/frozen/object", "", http.StatusForbidden},
+		{http.MethodPut, "http://localhost/readonly/object", "", http.StatusForbidden},
+		{http.MethodPost, "http://localhost/readonly/object?uploads", "", http.StatusForbidden},
+		{http.MethodDelete, "http://localhost/readonly/object", "", http.StatusOK},
+		{http.MethodPost, "http://localhost/readonly?delete", "", http.StatusOK},
+		{http.MethodGet, "http://localhost/private/object", "allowed", http.StatusOK},
+		{http.MethodGet, "http://localhost/private/object", "other", http.StatusForbidden},
+		{http.MethodGet, "http://localhost/private/object", "", http.StatusForbidden},
+		{http.MethodPut, "http://localhost/unknown/object", "", http.StatusOK},
+		{http.MethodGet, "http://localhost/", "", http.StatusOK},
+	} {
+		request := httptest.NewRequest(testCase.method, testCase.url, nil)
+		if testCase.accessKey != "" {
+			request.Header.Set("Authorization", "AWS "+testCase.accessKey+":c2lnbmF0dXJl")
+		}
+		recorder := &contextRecordingRoundTripper{}
+
+		resp, err := BucketPolicyEnforcer(fetcher)(recorder).RoundTrip(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expectedCode, resp.StatusCode, "%s %s", testCase.method, testCase.url)
+		if resp.StatusCode == http.StatusOK {
+			assert.Equal(t, fetcher[utils.ExtractBucketFrom(request.URL.Path)], recorder.bucketMetaData)
+		}
+	}
+}
+
+type failingBucketMetaDataFetcher struct{}
+
+func (failingBucketMetaDataFetcher) Fetch(bucketLocation *metadata.BucketLocation) (*metadata.BucketMetaData, error) {
+	return nil, errors.New("bucket index unavailable")
+}
+
+func TestBucketPolicyEnforcerShouldRejectRequestsIfMetaDataCantBeFetched(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		recorder := &contextRecordingRoundTripper{}
+		request := httptest.NewRequest(method, "http://localhost/bucket/object", nil)
+
+		resp, err := BucketPolicyEnforcer(failingBucketMetaDataFetcher{})(recorder).RoundTrip(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, method)
+	}
+	resp, err := BucketPolicyEnforcer(failingBucketMetaDataFetcher{})(&contextRecordingRoundTripper{}).
+		RoundTrip(httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestBodySizeLimitterShouldApplyBucketMaxObjectSize(t *testing.T) {
+	fetcher := staticBucketMetaDataFetcher{"small": {Name: "small", MaxObjectSize: 512}}
+	for _, testCase := range []struct {
+		url          string
+		size         string
+		expectedCode int
+	}{
+		{"http://localhost/small/object", "512", http.StatusOK},
+		{"http://localhost/small/object", "513", http.StatusRequestEntityTooLarge},
+		{"http://localhost/other/object", "1024", http.StatusOK},
+		{"http://localhost/other/object", "1025", http.StatusRequestEntityTooLarge},
+	} {
+		request := httptest.NewRequest(http.MethodPut, testCase.url, nil)
+		request.Header.Set("Content-Length", testCase.size)
+		rt := Decorate(&contextRecordingRoundTripper{}, BodySizeLimitter(1024), BucketPolicyEnforcer(fetcher))
+
+		resp, err := rt.RoundTrip(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expectedCode, resp.StatusCode, "%s %s", testCase.url, testCase.size)
+	}
+}
@@ -2,10 +2,13 @@ package metadata
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -15,12 +18,19 @@ import (
 	"github.com/allegro/akubra/internal/akubra/discovery"
 	"github.com/allegro/akubra/internal/akubra/log"
 	"github.com/allegro/akubra/internal/akubra/metrics"
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/allegro/bigcache"
+	"github.com/jinzhu/gorm"
 )
 
+//BucketMetaDataContextKey is the key under which the BucketMetaData of the requested bucket can be found in context.Context
+const BucketMetaDataContextKey = log.ContextKey("BucketMetaData")
+
 var evictKey = fmt.Sprintf("_%s_", strings.Repeat("x", 64))
 var metaDataNotFound = &BucketMetaData{}
 
+const fetchTimeLength = 8
+
 var (
 	discoveryClient  discovery.Client
 	fetcherFactories map[string]BucketMetaDataFetcherFactory
@@ -31,6 +41,8 @@ func init() {
 	fetcherFactories = map[string]BucketMetaDataFetcherFactory{
 		"fake": &FakeBucketMetaDataFetcherFactory{},
 		"http": NewBucketIndexRestServiceFactory(discoveryClient),
+		"file": &BucketMetaDataFileFetcherFactory{},
+		"sql":  &SQLBucketMetaDataFetcherFactory{},
 	}
 }
 
@@ -42,6 +54,24 @@ type BucketMetaData struct {
 	IsInternal bool
 	//Pattern is the pattern that the name of the bucket was matched to
 	Pattern string
+	//AllowedAccessKeys are the only access keys allowed to access the bucket, any if empty
+	AllowedAccessKeys []string
+	//ShardingPolicy is the name of the sharding policy used for the bucket instead of the one matched by domain
+	ShardingPolicy string
+	//ReadOnly tells that objects can't be uploaded to the bucket, they can still be deleted
+	ReadOnly bool
+	//Frozen tells that the bucket can't be modified at all
+	Frozen bool
+	//MaxObjectSize is the maximum size in bytes of the object uploaded to the bucket, unlimited if not positive
+	MaxObjectSize int64
+	//ConsistencyLevel is used for the bucket instead of the sharding policy one
+	ConsistencyLevel regionsconfig.ConsistencyLevel
+}
+
+//FromContext returns the BucketMetaData supplied to the context, nil if there is none
+func FromContext(ctx context.Context) *BucketMetaData {
+	bucketMetaData, _ := ctx.Value(BucketMetaDataContextKey).(*BucketMetaData)
+	return bucketMetaData
 }
 
 //BucketLocation describes where to find the bucket
@@ -59,6 +89,10 @@ type BucketMetaDataFetcher interface {
 type BucketMetaDataCacheConfig struct {
 	//LifeWindow is time after which entry will be invalidated
 	LifeWindow time.Duration `yaml:"LifeWindow"`
+	//StaleWhileRevalidate is time after LifeWindow during which the expired entry is served while it's refreshed in the background
+	StaleWhileRevalidate time.Duration `yaml:"StaleWhileRevalidate"`
+	//StaleIfError is time after LifeWindow during which the expired entry is served if it can't be fetched
+	StaleIfError time.Duration `yaml:"StaleIfError"`
 	//MaxCacheSizeInMB is the hard max that the cache will not exceed
 	MaxCacheSizeInMB int `yaml:"MaxCacheSizeInMB"`
 	//ShardsCount is the number of shards
@@ -69,6 +103,8 @@ type BucketMetaDataCacheConfig struct {
 	FetcherProps map[string]string `yaml:"FetcherProps"`
 	//Hasher is the hash function that will be used to hash the keys
 	Hasher bigcache.Hasher
+	//DB is the database of the watchdog, used by the fetchers reading from it
+	DB *gorm.DB
 }
 
 //BucketMetaDataFetcherFactory creates an instance of fecher given using the given config
@@ -76,24 +112,43 @@ type BucketMetaDataFetcherFactory interface {
 	Create(config map[string]string) (BucketMetaDataFetcher, error)
 }
 
+//DBFetcherFactory is a BucketMetaDataFetcherFactory of the fetchers reading from the database of the watchdog
+type DBFetcherFactory interface {
+	WithDB(db *gorm.DB) BucketMetaDataFetcherFactory
+}
+
 //NewBucketMetaDataCacheWithFactory uses the factory to create a fetcher
 func NewBucketMetaDataCacheWithFactory(conf *BucketMetaDataCacheConfig) (BucketMetaDataFetcher, error) {
 	fetcherFactory, supported := fetcherFactories[conf.FetcherType]
 	if !supported {
 		return nil, fmt.Errorf("fetcher of type %s is unsupported", conf.FetcherType)
 	}
+	if dbFetcherFactory, usesDB := fetcherFactory.(DBFetcherFactory); usesDB {
+		fetcherFactory = dbFetcherFactory.WithDB(conf.DB)
+	}
 	fetcher, err := fetcherFactory.Create(conf.FetcherProps)
 	if err != nil {
 		return nil, err
 	}
-	return NewBucketMetaDataCache(conf, fetcher)
+	cache, err := NewBucketMetaDataCache(conf, fetcher)
+	if err != nil {
+		if fetcherCloser, ok := fetcher.(io.Closer); ok {
+			_ = fetcherCloser.Close()
+		}
+		return nil, err
+	}
+	return cache, nil
 }
 
 //NewBucketMetaDataCache wraps the supplies fetcher with a cache layer
 func NewBucketMetaDataCache(conf *BucketMetaDataCacheConfig, fetcher BucketMetaDataFetcher) (BucketMetaDataFetcher, error) {
+	entryLifeWindow := conf.LifeWindow
+	if conf.LifeWindow > 0 {
+		entryLifeWindow += maxDuration(conf.StaleWhileRevalidate, conf.StaleIfError)
+	}
 	bigcacheConf := bigcache.Config{
 		Shards:           conf.ShardsCount,
-		LifeWindow:       conf.LifeWindow,
+		LifeWindow:       entryLifeWindow,
 		Hasher:           conf.Hasher,
 		HardMaxCacheSize: conf.MaxCacheSizeInMB,
 	}
@@ -111,6 +166,12 @@ func NewBucketMetaDataCache(conf *BucketMetaDataCacheConfig, fetcher BucketMetaD
 		patterns:                 make([]*regexp.Regexp, 0),
 		patternsLock:             sync.Mutex{},
 		lifeWindow:               conf.LifeWindow,
+		entryLifeWindow:          entryLifeWindow,
+		staleWhileRevalidate:     conf.StaleWhileRevalidate,
+		staleIfError:             conf.StaleIfError,
+		fetches:                  make(map[string]*bucketMetaDataFetch),
+		lookupsCount:             make(map[string]uint),
+		done:                     make(chan struct{}),
 	}
 
 	go metaDataCache.evictExpired()
@@ -118,6 +179,13 @@ func NewBucketMetaDataCache(conf *BucketMetaDataCacheConfig, fetcher BucketMetaD
 	return metaDataCache, nil
 }
 
+const (
+	cacheHit       = "hit"
+	cacheMiss      = "miss"
+	cacheStale     = "stale"
+	cacheCoalesced = "coalesced"
+)
+
 //BucketMetaDataCache is a wrapper that caches the answers given by the wrapped BucketMetaDataFetcher
 type BucketMetaDataCache struct {
 	bucketMetaDataFetcher    BucketMetaDataFetcher
@@ -127,75 +195,155 @@ type BucketMetaDataCache struct {
 	bucketNamePatternMapping map[uint64]*regexp.Regexp
 	patternsLock             sync.Mutex
 	lifeWindow               time.Duration
+	entryLifeWindow          time.Duration
+	staleWhileRevalidate     time.Duration
+	staleIfError             time.Duration
+	fetchesLock              sync.Mutex
+	fetches                  map[string]*bucketMetaDataFetch
 	statsLock                sync.Mutex
 	queriesCount             uint
-	hitsCount                uint
+	lookupsCount             map[string]uint
+	done                     chan struct{}
+	closeOnce                sync.Once
 }
 
-//Fetch first consults the cache for BucketMetaData and only fetches it when it's not in the cache
-func (bucketCache *BucketMetaDataCache) Fetch(bucketLocation *BucketLocation) (*BucketMetaData, error) {
-	isHit := true
-	defer bucketCache.updateStats(&isHit)
-	bucketMetaData := bucketCache.findByDirectMapping(bucketLocation.Name, false)
-	if bucketMetaData != nil {
-		if bucketMetaData == metaDataNotFound {
-			return nil, nil
-		}
-		return bucketMetaData, nil
+//cachedBucketMetaData is an entry of the cache, metaDataNotFound is cached for the buckets with no metadata
+type cachedBucketMetaData struct {
+	metaData  *BucketMetaData
+	fetchedAt time.Time
+}
+
+func (entry *cachedBucketMetaData) result() *BucketMetaData {
+	if entry.metaData == metaDataNotFound {
+		return nil
 	}
+	return entry.metaData
+}
 
-	bucketMetaData = bucketCache.findByPattern(bucketLocation.Name)
-	if bucketMetaData != nil {
-		if bucketMetaData == metaDataNotFound {
-			return nil, nil
+//bucketMetaDataFetch is a fetch in progress, the concurrent fetches of the same bucket wait for its result
+type bucketMetaDataFetch struct {
+	done     chan struct{}
+	metaData *BucketMetaData
+	err      error
+}
+
+//Fetch first consults the cache for BucketMetaData and only fetches it when it's not in the cache. The expired entry
+//is served during StaleWhileRevalidate while it's refreshed in the background, and during StaleIfError if it can't be fetched
+func (bucketCache *BucketMetaDataCache) Fetch(bucketLocation *BucketLocation) (*BucketMetaData, error) {
+	lookup := cacheMiss
+	defer bucketCache.updateStats(&lookup)
+	entry := bucketCache.findByDirectMapping(bucketLocation.Name, false)
+	if entry == nil {
+		entry = bucketCache.findByPattern(bucketLocation.Name)
+	}
+	if entry != nil {
+		entryAge := time.Since(entry.fetchedAt)
+		if bucketCache.lifeWindow <= 0 || entryAge < bucketCache.lifeWindow {
+			lookup = cacheHit
+			return entry.result(), nil
+		}
+		if entryAge < bucketCache.lifeWindow+bucketCache.staleWhileRevalidate {
+			lookup = cacheStale
+			bucketCache.revalidate(bucketLocation)
+			return entry.result(), nil
 		}
-		return bucketMetaData, nil
 	}
 
-	isHit = false
-	fetchStartTime := time.Now()
-	metaData, err := bucketCache.fetchAndCache(bucketLocation)
+	metaData, coalesced, err := bucketCache.fetchCoalesced(bucketLocation)
+	if coalesced {
+		lookup = cacheCoalesced
+	}
 	if err == nil {
-		metrics.UpdateSince("metadata.bucket.fetch.ok", fetchStartTime)
 		return metaData, nil
 	}
-	metrics.UpdateSince("metadata.bucket.fetch.err", fetchStartTime)
+	if entry != nil && time.Since(entry.fetchedAt) < bucketCache.lifeWindow+bucketCache.staleIfError {
+		log.Printf("Serving stale metadata of bucket %s, failed to fetch it: %s", bucketLocation.Name, err)
+		lookup = cacheStale
+		return entry.result(), nil
+	}
 	return nil, err
 }
 
-func (bucketCache *BucketMetaDataCache) findByDirectMapping(bucketName string, isPattern bool) *BucketMetaData {
-	bucketMetaDataBytes, err := bucketCache.cache.Get(bucketName)
+//fetchCoalesced fetches the bucket metadata, or waits for the result of the fetch of the bucket already in progress
+func (bucketCache *BucketMetaDataCache) fetchCoalesced(bucketLocation *BucketLocation) (*BucketMetaData, bool, error) {
+	fetch, inProgress := bucketCache.startFetch(bucketLocation.Name)
+	if inProgress {
+		<-fetch.done
+		return fetch.metaData, true, fetch.err
+	}
+	bucketCache.runFetch(bucketLocation, fetch)
+	return fetch.metaData, false, fetch.err
+}
+
+//revalidate refreshes the bucket metadata in the background, unless it's being fetched already
+func (bucketCache *BucketMetaDataCache) revalidate(bucketLocation *BucketLocation) {
+	fetch, inProgress := bucketCache.startFetch(bucketLocation.Name)
+	if inProgress {
+		return
+	}
+	go bucketCache.runFetch(&BucketLocation{Name: bucketLocation.Name}, fetch)
+}
+
+func (bucketCache *BucketMetaDataCache) startFetch(bucketName string) (*bucketMetaDataFetch, bool) {
+	bucketCache.fetchesLock.Lock()
+	defer bucketCache.fetchesLock.Unlock()
+	if fetch, inProgress := bucketCache.fetches[bucketName]; inProgress {
+		return fetch, true
+	}
+	fetch := &bucketMetaDataFetch{done: make(chan struct{})}
+	bucketCache.fetches[bucketName] = fetch
+	return fetch, false
+}
+
+func (bucketCache *BucketMetaDataCache) runFetch(bucketLocation *BucketLocation, fetch *bucketMetaDataFetch) {
+	fetchStartTime := time.Now()
+	fetch.metaData, fetch.err = bucketCache.fetchAndCache(bucketLocation)
+	if fetch.err == nil {
+		metrics.UpdateSince("metadata.bucket.fetch.ok", fetchStartTime)
+	} else {
+		metrics.UpdateSince("metadata.bucket.fetch.err", fetchStartTime)
+	}
+	bucketCache.fetchesLock.Lock()
+	delete(bucketCache.fetches, bucketLocation.Name)
+	bucketCache.fetchesLock.Unlock()
+	close(fetch.done)
+}
+
+func (bucketCache *BucketMetaDataCache) findByDirectMapping(bucketName string, isPattern bool) *cachedBucketMetaData {
+	entryBytes, err := bucketCache.cache.Get(bucketName)
 	if err != nil {
 		return nil
 	}
-	bucketMetaData, err := decodeBucketMetaData(bucketMetaDataBytes)
+	entry, err := decodeCacheEntry(entryBytes)
 	if err != nil {
 		log.Debugf("failed to decode metadata for bucket %s: %s", bucketName, err)
 		_ = bucketCache.cache.Delete(bucketName)
 		return nil
 	}
 	//hash collision handling
-	if bucketMetaData != metaDataNotFound && !isPattern && bucketMetaData.Name != bucketName {
+	if entry.metaData != metaDataNotFound && !isPattern && entry.metaData.Name != bucketName {
 		return nil
 	}
-	return bucketMetaData
+	return entry
 }
 
-func (bucketCache *BucketMetaDataCache) findByPattern(bucketName string) *BucketMetaData {
+func (bucketCache *BucketMetaDataCache) findByPattern(bucketName string) *cachedBucketMetaData {
 	bucketNameHash := bucketCache.hasher.Sum64(bucketName)
-	metaData := bucketCache.findByBucketNameToPatternMapping(bucketNameHash)
-	if metaData != nil {
-		return metaData
+	entry := bucketCache.findByBucketNameToPatternMapping(bucketNameHash)
+	if entry != nil {
+		return entry
 	}
 	return bucketCache.findPatternThatMatches(bucketName)
 }
 
-func (bucketCache *BucketMetaDataCache) findByBucketNameToPatternMapping(bucketNameHash uint64) *BucketMetaData {
+func (bucketCache *BucketMetaDataCache) findByBucketNameToPatternMapping(bucketNameHash uint64) *cachedBucketMetaData {
+	bucketCache.patternsLock.Lock()
 	pattern, found := bucketCache.bucketNamePatternMapping[bucketNameHash]
+	bucketCache.patternsLock.Unlock()
 	if found {
-		metadata := bucketCache.findByDirectMapping(pattern.String(), true)
-		if metadata != nil {
-			return metadata
+		entry := bucketCache.findByDirectMapping(pattern.String(), true)
+		if entry != nil {
+			return entry
 		}
 		bucketCache.patternsLock.Lock()
 		defer bucketCache.patternsLock.Unlock()
@@ -204,13 +352,12 @@ func (bucketCache *BucketMetaDataCache) findByBucketNameToPatternMapping(bucketN
 	return nil
 }
 
-func (bucketCache *BucketMetaDataCache) findPatternThatMatches(bucketName string) *BucketMetaData {
-	patterns := bucketCache.patterns //to avoid strange behaviour during concurrent access
-	for _, pattern := range patterns {
+func (bucketCache *BucketMetaDataCache) findPatternThatMatches(bucketName string) *cachedBucketMetaData {
+	bucketCache.patternsLock.Lock()
+	defer bucketCache.patternsLock.Unlock()
+	for _, pattern := range bucketCache.patterns {
 		matched := pattern.MatchString(bucketName)
 		if matched {
-			bucketCache.patternsLock.Lock()
-			defer bucketCache.patternsLock.Unlock()
 			bucketCache.bucketNamePatternMapping[bucketCache.hasher.Sum64(bucketName)] = pattern
 			return bucketCache.findByDirectMapping(pattern.String(), true)
 		}
@@ -228,11 +375,12 @@ func (bucketCache *BucketMetaDataCache) fetchAndCache(bucketLocation *BucketLoca
 }
 
 func (bucketCache *BucketMetaDataCache) cacheResult(bucketName string, metaData *BucketMetaData) {
+	fetchedAt := time.Now()
 	if metaData == nil {
-		_ = bucketCache.cache.Set(bucketName, []byte{})
+		_ = bucketCache.cache.Set(bucketName, encodeFetchTime(fetchedAt))
 		return
 	}
-	encodedMetaData, err := encodeBucketMetaData(metaData)
+	encodedMetaData, err := encodeCacheEntry(metaData, fetchedAt)
 	if err != nil {
 		log.Debugf("failed to cache result for bucket %s: %s", metaData.Name, err)
 		return
@@ -240,49 +388,112 @@ func (bucketCache *BucketMetaDataCache) cacheResult(bucketName string, metaData
 	if metaData.Pattern != "" {
 		pattern, err := regexp.Compile(metaData.Pattern)
 		if err == nil {
-			bucketCache.patterns = append(bucketCache.patterns, pattern)
-			_ = bucketCache.cache.Set(metaData.Pattern, encodedMetaData.Bytes())
+			bucketCache.addPattern(pattern)
+			_ = bucketCache.cache.Set(metaData.Pattern, encodedMetaData)
 		}
 		return
 	}
-	_ = bucketCache.cache.Set(metaData.Name, encodedMetaData.Bytes())
+	_ = bucketCache.cache.Set(metaData.Name, encodedMetaData)
+}
+
+func (bucketCache *BucketMetaDataCache) addPattern(pattern *regexp.Regexp) {
+	bucketCache.patternsLock.Lock()
+	defer bucketCache.patternsLock.Unlock()
+	for _, knownPattern := range bucketCache.patterns {
+		if knownPattern.String() == pattern.String() {
+			return
+		}
+	}
+	bucketCache.patterns = append(bucketCache.patterns, pattern)
 }
 
 func (bucketCache *BucketMetaDataCache) evictExpired() {
-	if bucketCache.lifeWindow == 0 {
+	if bucketCache.entryLifeWindow == 0 {
 		return
 	}
 	for {
 		_ = bucketCache.cache.Set(evictKey, []byte{})
-		time.Sleep(bucketCache.lifeWindow)
+		select {
+		case <-bucketCache.done:
+			return
+		case <-time.After(bucketCache.entryLifeWindow):
+		}
 	}
 }
 
-func (bucketCache *BucketMetaDataCache) updateStats(isHit *bool) {
+//Close stops the background routines of the cache and releases it along with the fetcher
+func (bucketCache *BucketMetaDataCache) Close() error {
+	var err error
+	bucketCache.closeOnce.Do(func() {
+		close(bucketCache.done)
+		err = bucketCache.cache.Close()
+		if fetcherCloser, ok := bucketCache.bucketMetaDataFetcher.(io.Closer); ok {
+			if closeErr := fetcherCloser.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+func (bucketCache *BucketMetaDataCache) updateStats(lookup *string) {
+	metrics.ObserveBucketMetaDataLookup(*lookup)
 	bucketCache.statsLock.Lock()
 	defer bucketCache.statsLock.Unlock()
 	bucketCache.queriesCount++
-	if *isHit {
-		bucketCache.hitsCount++
-	}
+	bucketCache.lookupsCount[*lookup]++
 }
 
 func (bucketCache *BucketMetaDataCache) sendStats() {
 	for {
 		bucketCache.statsLock.Lock()
 		queriesCount := bucketCache.queriesCount
-		hitsCount := bucketCache.hitsCount
+		lookupsCount := bucketCache.lookupsCount
 		bucketCache.queriesCount = 0
-		bucketCache.hitsCount = 0
+		bucketCache.lookupsCount = make(map[string]uint)
 		bucketCache.statsLock.Unlock()
 		var hitPercentage float64
 		if queriesCount > 0 {
-			hitPercentage = (float64(hitsCount) * 100) / float64(queriesCount)
+			hitPercentage = (float64(lookupsCount[cacheHit]) * 100) / float64(queriesCount)
 		}
 		metrics.UpdateGauge("metadata.bucket.cache.queries", int64(queriesCount))
 		metrics.UpdateGauge("metadata.bucket.cache.hit-ratio", int64(hitPercentage))
-		time.Sleep(time.Second * 15)
+		for _, lookup := range []string{cacheHit, cacheMiss, cacheStale, cacheCoalesced} {
+			metrics.UpdateGauge(fmt.Sprintf("metadata.bucket.cache.%s", lookup), int64(lookupsCount[lookup]))
+		}
+		select {
+		case <-bucketCache.done:
+			return
+		case <-time.After(time.Second * 15):
+		}
+	}
+}
+
+//encodeCacheEntry prefixes the encoded metadata with the time it was fetched at, the time alone means metaDataNotFound
+func encodeCacheEntry(metaData *BucketMetaData, fetchedAt time.Time) ([]byte, error) {
+	encodedMetaData, err := encodeBucketMetaData(metaData)
+	if err != nil {
+		return nil, err
+	}
+	return append(encodeFetchTime(fetchedAt), encodedMetaData.Bytes()...), nil
+}
+
+func encodeFetchTime(fetchedAt time.Time) []byte {
+	fetchTimeBytes := make([]byte, fetchTimeLength)
+	binary.BigEndian.PutUint64(fetchTimeBytes, uint64(fetchedAt.UnixNano()))
+	return fetchTimeBytes
+}
+
+func decodeCacheEntry(entryBytes []byte) (*cachedBucketMetaData, error) {
+	if len(entryBytes) < fetchTimeLength {
+		return nil, errors.New("cache entry too short")
 	}
+	metaData, err := decodeBucketMetaData(entryBytes[fetchTimeLength:])
+	if err != nil {
+		return nil, err
+	}
+	fetchedAt := time.Unix(0, int64(binary.BigEndian.Uint64(entryBytes[:fetchTimeLength])))
+	return &cachedBucketMetaData{metaData: metaData, fetchedAt: fetchedAt}, nil
 }
 
 func decodeBucketMetaData(metaDataBytes []byte) (*BucketMetaData, error) {
@@ -306,6 +517,13 @@ func encodeBucketMetaData(metaData *BucketMetaData) (*bytes.Buffer, error) {
 	return &buffer, nil
 }
 
+func maxDuration(first, second time.Duration) time.Duration {
+	if first > second {
+		return first
+	}
+	return second
+}
+
 //Fnv64Hasher wraps the stdlib hasher to bigcache's Hasher type
 type Fnv64Hasher struct{}
 
@@ -0,0 +1,209 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultFileReloadInterval = 10 * time.Second
+
+//bucketMetaDataEntry is the representation of BucketMetaData in the file, exactly one of Name and Pattern is set
+type bucketMetaDataEntry struct {
+	Name              string   `yaml:"Name" json:"Name"`
+	Pattern           string   `yaml:"Pattern" json:"Pattern"`
+	IsInternal        bool     `yaml:"IsInternal" json:"IsInternal"`
+	AllowedAccessKeys []string `yaml:"AllowedAccessKeys" json:"AllowedAccessKeys"`
+	ShardingPolicy    string   `yaml:"ShardingPolicy" json:"ShardingPolicy"`
+	ReadOnly          bool     `yaml:"ReadOnly" json:"ReadOnly"`
+	Frozen            bool     `yaml:"Frozen" json:"Frozen"`
+	MaxObjectSize     int64    `yaml:"MaxObjectSize" json:"MaxObjectSize"`
+	ConsistencyLevel  string   `yaml:"ConsistencyLevel" json:"ConsistencyLevel"`
+}
+
+type bucketMetaDataFile struct {
+	Buckets []bucketMetaDataEntry `yaml:"Buckets" json:"Buckets"`
+}
+
+//bucketMetaDataIndex finds the metadata of the bucket by its name, then by the first pattern matching it
+type bucketMetaDataIndex struct {
+	buckets  map[string]*BucketMetaData
+	patterns []*regexp.Regexp
+	matched  []*BucketMetaData
+}
+
+func newBucketMetaDataIndex(entries []bucketMetaDataEntry) (*bucketMetaDataIndex, error) {
+	index := &bucketMetaDataIndex{buckets: make(map[string]*BucketMetaData)}
+	for idx, entry := range entries {
+		if (entry.Name == "") == (entry.Pattern == "") {
+			return nil, fmt.Errorf("bucket #%d should have either 'Name' or 'Pattern'", idx)
+		}
+		bucketMetaData := entry.toBucketMetaData()
+		if entry.Name != "" {
+			index.buckets[entry.Name] = bucketMetaData
+			continue
+		}
+		pattern, err := regexp.Compile(entry.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bucket #%d pattern not compilable: %s", idx, err)
+		}
+		index.patterns = append(index.patterns, pattern)
+		index.matched = append(index.matched, bucketMetaData)
+	}
+	return index, nil
+}
+
+func (entry *bucketMetaDataEntry) toBucketMetaData() *BucketMetaData {
+	return &BucketMetaData{
+		Name:              entry.Name,
+		Pattern:           entry.Pattern,
+		IsInternal:        entry.IsInternal,
+		AllowedAccessKeys: entry.AllowedAccessKeys,
+		ShardingPolicy:    entry.ShardingPolicy,
+		ReadOnly:          entry.ReadOnly,
+		Frozen:            entry.Frozen,
+		MaxObjectSize:     entry.MaxObjectSize,
+		ConsistencyLevel:  parseConsistencyLevel(entry.Name+entry.Pattern, entry.ConsistencyLevel),
+	}
+}
+
+//find returns a copy of the bucket metadata, the pattern metadata is named after the bucket, so it can be
+//cached by BucketMetaDataCache under the pattern
+func (index *bucketMetaDataIndex) find(bucketName string) *BucketMetaData {
+	if bucketMetaData, found := index.buckets[bucketName]; found {
+		copied := *bucketMetaData
+		return &copied
+	}
+	for idx, pattern := range index.patterns {
+		if pattern.MatchString(bucketName) {
+			copied := *index.matched[idx]
+			copied.Name = bucketName
+			return &copied
+		}
+	}
+	return nil
+}
+
+//BucketMetaDataFileFetcher is an implementation of BucketMetaDataFetcher that reads the metadata from a YAML or JSON
+//file and reloads it when the file changes
+type BucketMetaDataFileFetcher struct {
+	path           string
+	reloadInterval time.Duration
+	indexLock      sync.RWMutex
+	index          *bucketMetaDataIndex
+	modTime        time.Time
+	size           int64
+	done           chan struct{}
+	closeOnce      sync.Once
+}
+
+//BucketMetaDataFileFetcherFactory creates instances of BucketMetaDataFileFetcher
+type BucketMetaDataFileFetcherFactory struct{}
+
+//Create creates an instance of BucketMetaDataFileFetcher
+func (factory *BucketMetaDataFileFetcherFactory) Create(config map[string]string) (BucketMetaDataFetcher, error) {
+	path, present := config["Path"]
+	if !present || path == "" {
+		return nil, errors.New("failed to create BucketMetaDataFileFetcher, 'Path' missing")
+	}
+	reloadInterval := defaultFileReloadInterval
+	if value, present := config["ReloadInterval"]; present {
+		var err error
+		reloadInterval, err = time.ParseDuration(value)
+		if err != nil || reloadInterval <= 0 {
+			return nil, errors.New("failed to create BucketMetaDataFileFetcher, 'ReloadInterval' not parsable")
+		}
+	}
+	return NewBucketMetaDataFileFetcher(path, reloadInterval)
+}
+
+//NewBucketMetaDataFileFetcher loads the file and starts watching it for changes
+func NewBucketMetaDataFileFetcher(path string, reloadInterval time.Duration) (*BucketMetaDataFileFetcher, error) {
+	fetcher := &BucketMetaDataFileFetcher{path: path, reloadInterval: reloadInterval, done: make(chan struct{})}
+	if _, err := fetcher.reloadIfChanged(); err != nil {
+		return nil, fmt.Errorf("failed to create BucketMetaDataFileFetcher: %s", err)
+	}
+	go fetcher.watch()
+	return fetcher, nil
+}
+
+//Fetch finds the bucket metadata in the last loaded file
+func (fetcher *BucketMetaDataFileFetcher) Fetch(bucketLocation *BucketLocation) (*BucketMetaData, error) {
+	fetcher.indexLock.RLock()
+	defer fetcher.indexLock.RUnlock()
+	return fetcher.index.find(bucketLocation.Name), nil
+}
+
+//Close stops watching the file
+func (fetcher *BucketMetaDataFileFetcher) Close() error {
+	fetcher.closeOnce.Do(func() {
+		close(fetcher.done)
+	})
+	return nil
+}
+
+func (fetcher *BucketMetaDataFileFetcher) watch() {
+	for {
+		select {
+		case <-fetcher.done:
+			return
+		case <-time.After(fetcher.reloadInterval):
+		}
+		reloaded, err := fetcher.reloadIfChanged()
+		if err != nil {
+			log.Printf("Bucket metadata file %s not reloaded, keeping the previous one: %s", fetcher.path, err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Bucket metadata file %s reloaded", fetcher.path)
+		}
+	}
+}
+
+//reloadIfChanged loads the file if its modification time or size changed since the last load attempt
+func (fetcher *BucketMetaDataFileFetcher) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(fetcher.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(fetcher.modTime) && info.Size() == fetcher.size {
+		return false, nil
+	}
+	fetcher.modTime = info.ModTime()
+	fetcher.size = info.Size()
+	index, err := loadBucketMetaDataFile(fetcher.path)
+	if err != nil {
+		return false, err
+	}
+	fetcher.indexLock.Lock()
+	defer fetcher.indexLock.Unlock()
+	fetcher.index = index
+	return true, nil
+}
+
+func loadBucketMetaDataFile(path string) (*bucketMetaDataIndex, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file bucketMetaDataFile
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(content, &file)
+	} else {
+		err = yaml.UnmarshalStrict(content, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", path, err)
+	}
+	return newBucketMetaDataIndex(file.Buckets)
+}
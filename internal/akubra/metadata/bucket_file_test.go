@@ -0,0 +1,98 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bucketMetaDataYAML = `Buckets:
+  - Name: reports
+    IsInternal: true
+    AllowedAccessKeys: [reporter]
+    ConsistencyLevel: Strong
+  - Pattern: ^tmp-\d+$
+    Frozen: true
+`
+
+func writeBucketMetaDataFile(t *testing.T, path, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestFileFetcherShouldFindBucketsByNameAndPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bucket-metadata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "buckets.yaml")
+	writeBucketMetaDataFile(t, path, bucketMetaDataYAML)
+
+	fetcher, err := (&BucketMetaDataFileFetcherFactory{}).Create(map[string]string{"Path": path})
+	require.NoError(t, err)
+	defer fetcher.(*BucketMetaDataFileFetcher).Close()
+
+	metaData, err := fetcher.Fetch(&BucketLocation{Name: "reports"})
+	require.NoError(t, err)
+	assert.Equal(t, &BucketMetaData{Name: "reports", IsInternal: true, AllowedAccessKeys: []string{"reporter"},
+		ConsistencyLevel: regionsconfig.Strong}, metaData)
+
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "tmp-123"})
+	require.NoError(t, err)
+	assert.Equal(t, &BucketMetaData{Name: "tmp-123", Pattern: `^tmp-\d+$`, Frozen: true}, metaData)
+
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "tmp-abc"})
+	require.NoError(t, err)
+	assert.Nil(t, metaData)
+}
+
+func TestFileFetcherShouldReloadChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bucket-metadata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "buckets.json")
+	writeBucketMetaDataFile(t, path, `{"Buckets": [{"Name": "reports", "ReadOnly": true}]}`)
+
+	fetcher, err := NewBucketMetaDataFileFetcher(path, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer fetcher.Close()
+	metaData, err := fetcher.Fetch(&BucketLocation{Name: "reports"})
+	require.NoError(t, err)
+	assert.True(t, metaData.ReadOnly)
+
+	writeBucketMetaDataFile(t, path, `{"Buckets": [{"Name": "reports", "Frozen": true}, {"Name": "other"}]}`)
+	for deadline := time.Now().Add(time.Second); !metaData.Frozen && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		metaData, _ = fetcher.Fetch(&BucketLocation{Name: "reports"})
+	}
+	assert.True(t, metaData.Frozen)
+	assert.False(t, metaData.ReadOnly)
+
+	writeBucketMetaDataFile(t, path, `{"Buckets": [{"Name": "reports", "Pattern": "^reports$"}]}`)
+	time.Sleep(50 * time.Millisecond)
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "reports"})
+	require.NoError(t, err)
+	assert.True(t, metaData.Frozen, "invalid file shouldn't replace the loaded one")
+}
+
+func TestFileFetcherFactoryShouldFailOnInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bucket-metadata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	invalidPath := filepath.Join(dir, "invalid.yaml")
+	writeBucketMetaDataFile(t, invalidPath, "Buckets:\n  - Pattern: '('\n")
+
+	for _, config := range []map[string]string{
+		{},
+		{"Path": filepath.Join(dir, "missing.yaml")},
+		{"Path": invalidPath},
+		{"Path": invalidPath, "ReloadInterval": "often"},
+	} {
+		_, err := (&BucketMetaDataFileFetcherFactory{}).Create(config)
+		assert.Error(t, err, config)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/allegro/akubra/internal/akubra/discovery"
 	akubraHttp "github.com/allegro/akubra/internal/akubra/http"
 	"github.com/allegro/akubra/internal/akubra/log"
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
 )
 
 const (
@@ -101,8 +102,14 @@ func createBreaker(config map[string]string) (balancing.Breaker, error) {
 	), nil
 }
 type bucketMataDataJSON struct {
-	BucketName string `json:"bucketName"`
-	Visibility string `json:"bucketVisibility"`
+	BucketName        string   `json:"bucketName"`
+	Visibility        string   `json:"bucketVisibility"`
+	AllowedAccessKeys []string `json:"allowedAccessKeys"`
+	ShardingPolicy    string   `json:"shardingPolicy"`
+	ReadOnly          bool     `json:"readOnly"`
+	Frozen            bool     `json:"frozen"`
+	MaxObjectSize     int64    `json:"maxObjectSize"`
+	ConsistencyLevel  string   `json:"consistencyLevel"`
 }
 
 //NewBucketIndexRestService creates an instance of BucketIndexRestService
@@ -145,8 +152,25 @@ func (service *BucketIndexRestService) Fetch(bucketLocation *BucketLocation) (*B
 	log.Debugf("Fetched info for bucket %s, visibility: %s", bucketLocation.Name, metaDataJSON.Visibility)
 
 	return &BucketMetaData{
-		Name:       metaDataJSON.BucketName,
-		IsInternal: strings.ToLower(metaDataJSON.Visibility) == internal}, nil
+		Name:              metaDataJSON.BucketName,
+		IsInternal:        strings.ToLower(metaDataJSON.Visibility) == internal,
+		AllowedAccessKeys: metaDataJSON.AllowedAccessKeys,
+		ShardingPolicy:    metaDataJSON.ShardingPolicy,
+		ReadOnly:          metaDataJSON.ReadOnly,
+		Frozen:            metaDataJSON.Frozen,
+		MaxObjectSize:     metaDataJSON.MaxObjectSize,
+		ConsistencyLevel:  parseConsistencyLevel(bucketLocation.Name, metaDataJSON.ConsistencyLevel)}, nil
+}
+
+//parseConsistencyLevel returns the consistency level of the bucket, unknown levels are ignored
+func parseConsistencyLevel(bucketName, level string) regionsconfig.ConsistencyLevel {
+	consistencyLevel := regionsconfig.ConsistencyLevel(level)
+	switch consistencyLevel {
+	case "", regionsconfig.None, regionsconfig.Weak, regionsconfig.Strong:
+		return consistencyLevel
+	}
+	log.Printf("Ignoring unknown consistency level %q of bucket %s", level, bucketName)
+	return ""
 }
 
 func (service *BucketIndexRestService) createBucketMetaDataRequest(bucketLocation *BucketLocation) (*http.Request, error) {
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"testing"
 
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -135,6 +136,33 @@ func TestBucketMetaDataFetching(t *testing.T) {
 	}
 }
 
+func TestBucketPolicyMetaDataFetching(t *testing.T) {
+	expectedHTTPRequest, _ := http.NewRequest(http.MethodGet, "service://mock/buckets/test", nil)
+	metaDataJSON := `{"bucketName": "test", "bucketVisibility": "public", "allowedAccessKeys": ["key1", "key2"],
+		"shardingPolicy": "archive", "readOnly": true, "frozen": true, "maxObjectSize": 1048576, "consistencyLevel": "Strong"}`
+	indexServiceResp := http.Response{
+		StatusCode: http.StatusOK,
+		Request:    expectedHTTPRequest,
+		Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(metaDataJSON))),
+	}
+	httpClient := httpClientMock{Mock: &mock.Mock{}}
+	httpClient.On("Do", expectedHTTPRequest).Return(&indexServiceResp, nil)
+	indexService := NewBucketIndexRestService(&httpClient, "service://mock")
+
+	metaData, err := indexService.Fetch(&BucketLocation{Name: "test"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &BucketMetaData{
+		Name:              "test",
+		AllowedAccessKeys: []string{"key1", "key2"},
+		ShardingPolicy:    "archive",
+		ReadOnly:          true,
+		Frozen:            true,
+		MaxObjectSize:     1048576,
+		ConsistencyLevel:  regionsconfig.Strong}, metaData)
+	assert.Equal(t, regionsconfig.ConsistencyLevel(""), parseConsistencyLevel("test", "Paranoid"))
+}
+
 func (httpClient *httpClientMock) Do(request *http.Request) (*http.Response, error) {
 	args := httpClient.Called(request)
 	var response *http.Response
@@ -0,0 +1,165 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/jinzhu/gorm"
+)
+
+const defaultSQLPatternsReloadInterval = time.Minute
+
+//SQLBucketMetaData is a SQL representation of BucketMetaData, the rows with pattern apply to the buckets
+//matching it and their name only identifies them
+type SQLBucketMetaData struct {
+	Name              string `gorm:"column:name"`
+	Pattern           string `gorm:"column:pattern"`
+	IsInternal        bool   `gorm:"column:is_internal"`
+	AllowedAccessKeys string `gorm:"column:allowed_access_keys"`
+	ShardingPolicy    string `gorm:"column:sharding_policy"`
+	ReadOnly          bool   `gorm:"column:read_only"`
+	Frozen            bool   `gorm:"column:frozen"`
+	MaxObjectSize     int64  `gorm:"column:max_object_size"`
+	ConsistencyLevel  string `gorm:"column:consistency_level"`
+}
+
+//TableName provides the table name for bucket_metadata
+func (SQLBucketMetaData) TableName() string {
+	return "bucket_metadata"
+}
+
+func (row *SQLBucketMetaData) toEntry() bucketMetaDataEntry {
+	entry := bucketMetaDataEntry{
+		Name:             row.Name,
+		Pattern:          row.Pattern,
+		IsInternal:       row.IsInternal,
+		ShardingPolicy:   row.ShardingPolicy,
+		ReadOnly:         row.ReadOnly,
+		Frozen:           row.Frozen,
+		MaxObjectSize:    row.MaxObjectSize,
+		ConsistencyLevel: row.ConsistencyLevel,
+	}
+	if row.Pattern != "" {
+		entry.Name = ""
+	}
+	for _, accessKey := range strings.Split(row.AllowedAccessKeys, ",") {
+		if accessKey = strings.TrimSpace(accessKey); accessKey != "" {
+			entry.AllowedAccessKeys = append(entry.AllowedAccessKeys, accessKey)
+		}
+	}
+	return entry
+}
+
+//SQLBucketMetaDataFetcher is an implementation of BucketMetaDataFetcher that reads the bucket_metadata table,
+//the pattern rows are compiled once and reloaded every patternsReloadInterval
+type SQLBucketMetaDataFetcher struct {
+	db                     *gorm.DB
+	patternsReloadInterval time.Duration
+	patternsLock           sync.Mutex
+	patterns               *bucketMetaDataIndex
+	patternsLoadedAt       time.Time
+	now                    func() time.Time
+}
+
+//NewSQLBucketMetaDataFetcher creates an instance of SQLBucketMetaDataFetcher
+func NewSQLBucketMetaDataFetcher(db *gorm.DB, patternsReloadInterval time.Duration) BucketMetaDataFetcher {
+	return &SQLBucketMetaDataFetcher{db: db, patternsReloadInterval: patternsReloadInterval, now: time.Now}
+}
+
+//Fetch reads the row of the bucket, the loaded patterns are matched if the bucket has no row
+func (fetcher *SQLBucketMetaDataFetcher) Fetch(bucketLocation *BucketLocation) (*BucketMetaData, error) {
+	var rows []SQLBucketMetaData
+	err := fetcher.query(&rows, "name = ? AND pattern = ''", bucketLocation.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bucket %s metadata: %s", bucketLocation.Name, err)
+	}
+	if len(rows) > 0 {
+		entry := rows[0].toEntry()
+		return entry.toBucketMetaData(), nil
+	}
+	patterns, err := fetcher.loadedPatterns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bucket %s metadata patterns: %s", bucketLocation.Name, err)
+	}
+	return patterns.find(bucketLocation.Name), nil
+}
+
+//loadedPatterns returns the compiled pattern rows, reloading them once they are older than patternsReloadInterval.
+//The previously loaded patterns are kept if the reload fails
+func (fetcher *SQLBucketMetaDataFetcher) loadedPatterns() (*bucketMetaDataIndex, error) {
+	fetcher.patternsLock.Lock()
+	defer fetcher.patternsLock.Unlock()
+	now := fetcher.now()
+	if fetcher.patterns != nil && now.Sub(fetcher.patternsLoadedAt) < fetcher.patternsReloadInterval {
+		return fetcher.patterns, nil
+	}
+	var rows []SQLBucketMetaData
+	if err := fetcher.query(&rows, "pattern <> ''"); err != nil {
+		if fetcher.patterns != nil {
+			log.Printf("Bucket metadata patterns not reloaded, keeping the previous ones: %s", err)
+			return fetcher.patterns, nil
+		}
+		return nil, err
+	}
+	patterns := &bucketMetaDataIndex{}
+	for _, row := range rows {
+		pattern, err := regexp.Compile(row.Pattern)
+		if err != nil {
+			log.Printf("Skipping bucket metadata %s, pattern %q not compilable: %s", row.Name, row.Pattern, err)
+			continue
+		}
+		entry := row.toEntry()
+		patterns.patterns = append(patterns.patterns, pattern)
+		patterns.matched = append(patterns.matched, entry.toBucketMetaData())
+	}
+	fetcher.patterns = patterns
+	fetcher.patternsLoadedAt = now
+	return patterns, nil
+}
+
+func (fetcher *SQLBucketMetaDataFetcher) query(rows *[]SQLBucketMetaData, where string, args ...interface{}) error {
+	queryStartTime := time.Now()
+	err := fetcher.db.
+		Where(where, args...).
+		Order("name").
+		Find(rows).
+		Error
+	if err != nil {
+		metrics.UpdateSince("metadata.bucket.sql.err", queryStartTime)
+		return err
+	}
+	metrics.UpdateSince("metadata.bucket.sql.ok", queryStartTime)
+	return nil
+}
+
+//SQLBucketMetaDataFetcherFactory creates instances of SQLBucketMetaDataFetcher using the database of the watchdog
+type SQLBucketMetaDataFetcherFactory struct {
+	db *gorm.DB
+}
+
+//WithDB returns the factory using the given database
+func (factory *SQLBucketMetaDataFetcherFactory) WithDB(db *gorm.DB) BucketMetaDataFetcherFactory {
+	return &SQLBucketMetaDataFetcherFactory{db: db}
+}
+
+//Create creates an instance of SQLBucketMetaDataFetcher
+func (factory *SQLBucketMetaDataFetcherFactory) Create(config map[string]string) (BucketMetaDataFetcher, error) {
+	if factory.db == nil {
+		return nil, errors.New("failed to create SQLBucketMetaDataFetcher, the watchdog has no database")
+	}
+	patternsReloadInterval := defaultSQLPatternsReloadInterval
+	if value, present := config["PatternsReloadInterval"]; present {
+		var err error
+		patternsReloadInterval, err = time.ParseDuration(value)
+		if err != nil || patternsReloadInterval <= 0 {
+			return nil, errors.New("failed to create SQLBucketMetaDataFetcher, 'PatternsReloadInterval' not parsable")
+		}
+	}
+	return NewSQLBucketMetaDataFetcher(factory.db, patternsReloadInterval), nil
+}
@@ -0,0 +1,111 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bucketMetaDataSQLiteSchema = `CREATE TABLE bucket_metadata
+(
+  name                VARCHAR(254)  PRIMARY KEY,
+  pattern             VARCHAR(1024) NOT NULL DEFAULT '',
+  is_internal         BOOLEAN       NOT NULL DEFAULT FALSE,
+  allowed_access_keys VARCHAR(4096) NOT NULL DEFAULT '',
+  sharding_policy     VARCHAR(254)  NOT NULL DEFAULT '',
+  read_only           BOOLEAN       NOT NULL DEFAULT FALSE,
+  frozen              BOOLEAN       NOT NULL DEFAULT FALSE,
+  max_object_size     BIGINT        NOT NULL DEFAULT 0,
+  consistency_level   VARCHAR(16)   NOT NULL DEFAULT ''
+);`
+
+func TestSQLFetcherShouldReadBucketsByNameAndPattern(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Exec(bucketMetaDataSQLiteSchema).Error)
+	require.NoError(t, db.Exec(`INSERT INTO bucket_metadata (name, allowed_access_keys, max_object_size, consistency_level, sharding_policy)
+		VALUES ('tmp-1', 'key1, key2', 1024, 'None', 'archive')`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO bucket_metadata (name, pattern, read_only) VALUES ('temporary', '^tmp-', TRUE)`).Error)
+
+	fetcher, err := (&SQLBucketMetaDataFetcherFactory{}).WithDB(db).Create(map[string]string{})
+	require.NoError(t, err)
+
+	metaData, err := fetcher.Fetch(&BucketLocation{Name: "tmp-1"})
+	require.NoError(t, err)
+	assert.Equal(t, &BucketMetaData{Name: "tmp-1", AllowedAccessKeys: []string{"key1", "key2"}, MaxObjectSize: 1024,
+		ConsistencyLevel: regionsconfig.None, ShardingPolicy: "archive"}, metaData)
+
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "tmp-2"})
+	require.NoError(t, err)
+	assert.Equal(t, &BucketMetaData{Name: "tmp-2", Pattern: "^tmp-", ReadOnly: true}, metaData)
+
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "other"})
+	require.NoError(t, err)
+	assert.Nil(t, metaData)
+
+	_, err = (&SQLBucketMetaDataFetcherFactory{}).Create(map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestSQLFetcherShouldSkipPatternsThatDontCompile(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Exec(bucketMetaDataSQLiteSchema).Error)
+	require.NoError(t, db.Exec(`INSERT INTO bucket_metadata (name, pattern, read_only) VALUES ('broken', '^tmp-(', TRUE)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO bucket_metadata (name, pattern, frozen) VALUES ('temporary', '^tmp-', TRUE)`).Error)
+
+	fetcher := NewSQLBucketMetaDataFetcher(db, time.Minute)
+
+	metaData, err := fetcher.Fetch(&BucketLocation{Name: "tmp-1"})
+	require.NoError(t, err)
+	assert.Equal(t, &BucketMetaData{Name: "tmp-1", Pattern: "^tmp-", Frozen: true}, metaData)
+}
+
+func TestSQLFetcherShouldReloadPatternsOnlyAfterReloadInterval(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Exec(bucketMetaDataSQLiteSchema).Error)
+	require.NoError(t, db.Exec(`INSERT INTO bucket_metadata (name, pattern, read_only) VALUES ('temporary', '^tmp-', TRUE)`).Error)
+
+	now := time.Now()
+	fetcher := NewSQLBucketMetaDataFetcher(db, time.Minute).(*SQLBucketMetaDataFetcher)
+	fetcher.now = func() time.Time { return now }
+
+	metaData, err := fetcher.Fetch(&BucketLocation{Name: "tmp-1"})
+	require.NoError(t, err)
+	assert.True(t, metaData.ReadOnly)
+	loaded := fetcher.patterns
+
+	require.NoError(t, db.Exec(`UPDATE bucket_metadata SET read_only = FALSE WHERE name = 'temporary'`).Error)
+	now = now.Add(30 * time.Second)
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "tmp-2"})
+	require.NoError(t, err)
+	assert.True(t, metaData.ReadOnly)
+	assert.True(t, loaded == fetcher.patterns)
+
+	now = now.Add(time.Minute)
+	metaData, err = fetcher.Fetch(&BucketLocation{Name: "tmp-3"})
+	require.NoError(t, err)
+	assert.False(t, metaData.ReadOnly)
+}
+
+func TestSQLFetcherFactoryShouldParsePatternsReloadInterval(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	fetcher, err := (&SQLBucketMetaDataFetcherFactory{}).WithDB(db).Create(map[string]string{"PatternsReloadInterval": "5s"})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, fetcher.(*SQLBucketMetaDataFetcher).patternsReloadInterval)
+
+	_, err = (&SQLBucketMetaDataFetcherFactory{}).WithDB(db).Create(map[string]string{"PatternsReloadInterval": "never"})
+	assert.Error(t, err)
+}
@@ -3,12 +3,15 @@ package metadata
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/allegro/bigcache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type FetcherMock struct {
@@ -24,9 +27,15 @@ func TestFetchingAndDirectCachingBucketMetaData(t *testing.T) {
 	bucketNameHash := uint64(123)
 	bucketLocation := BucketLocation{Name: bucketName}
 	expectedMetaData := BucketMetaData{
-		Name:       bucketName,
-		Pattern:    "",
-		IsInternal: true}
+		Name:              bucketName,
+		Pattern:           "",
+		IsInternal:        true,
+		AllowedAccessKeys: []string{"key"},
+		ShardingPolicy:    "policy",
+		ReadOnly:          true,
+		Frozen:            true,
+		MaxObjectSize:     1024,
+		ConsistencyLevel:  regionsconfig.Strong}
 
 	fetcherMock := FetcherMock{Mock: &mock.Mock{}}
 	fetcherMock.On("Fetch", &bucketLocation).Return(&expectedMetaData, nil)
@@ -186,6 +195,109 @@ func TestCacheInvalidation(t *testing.T) {
 	fetcherMock.AssertNumberOfCalls(t, "Fetch", 3)
 }
 
+func TestShouldServeStaleBucketMetaDataWhileRevalidating(t *testing.T) {
+	bucketLocation := BucketLocation{Name: "bucket"}
+	staleMetaData := BucketMetaData{Name: "bucket", IsInternal: true}
+	freshMetaData := BucketMetaData{Name: "bucket", IsInternal: false}
+
+	fetcherMock := FetcherMock{Mock: &mock.Mock{}}
+	fetcherMock.On("Fetch", &bucketLocation).Return(&staleMetaData, nil).Once()
+	fetcherMock.On("Fetch", &bucketLocation).Return(&freshMetaData, nil)
+
+	cacheConfig := prepareCacheConfig(20*time.Millisecond, &FakeHasher{hashes: map[string]uint64{"bucket": 1, evictKey: 9999}})
+	cacheConfig.StaleWhileRevalidate = time.Hour
+	metaDataCache, err := NewBucketMetaDataCache(cacheConfig, &fetcherMock)
+	require.NoError(t, err)
+
+	metaData, err := metaDataCache.Fetch(&bucketLocation)
+	require.NoError(t, err)
+	assert.Equal(t, staleMetaData, *metaData)
+
+	time.Sleep(30 * time.Millisecond)
+	metaData, err = metaDataCache.Fetch(&bucketLocation)
+	require.NoError(t, err)
+	assert.Equal(t, staleMetaData, *metaData)
+
+	for deadline := time.Now().Add(time.Second); metaData.IsInternal && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		metaData, err = metaDataCache.Fetch(&bucketLocation)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, freshMetaData, *metaData)
+}
+
+func TestShouldServeStaleBucketMetaDataIfFetchFails(t *testing.T) {
+	bucketLocation := BucketLocation{Name: "bucket"}
+	expectedMetaData := BucketMetaData{Name: "bucket", IsInternal: true}
+
+	fetcherMock := FetcherMock{Mock: &mock.Mock{}}
+	fetcherMock.On("Fetch", &bucketLocation).Return(&expectedMetaData, nil).Once()
+	fetcherMock.On("Fetch", &bucketLocation).Return(nil, errors.New("index unavailable"))
+
+	cacheConfig := prepareCacheConfig(20*time.Millisecond, &FakeHasher{hashes: map[string]uint64{"bucket": 1, evictKey: 9999}})
+	cacheConfig.StaleIfError = time.Hour
+	metaDataCache, err := NewBucketMetaDataCache(cacheConfig, &fetcherMock)
+	require.NoError(t, err)
+
+	_, err = metaDataCache.Fetch(&bucketLocation)
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	metaData, err := metaDataCache.Fetch(&bucketLocation)
+	require.NoError(t, err)
+	assert.Equal(t, expectedMetaData, *metaData)
+	fetcherMock.AssertNumberOfCalls(t, "Fetch", 2)
+}
+
+func TestShouldNotServeStaleBucketMetaDataAfterStaleIfError(t *testing.T) {
+	bucketLocation := BucketLocation{Name: "bucket"}
+	fetchErr := errors.New("index unavailable")
+
+	fetcherMock := FetcherMock{Mock: &mock.Mock{}}
+	fetcherMock.On("Fetch", &bucketLocation).Return(&BucketMetaData{Name: "bucket"}, nil).Once()
+	fetcherMock.On("Fetch", &bucketLocation).Return(nil, fetchErr)
+
+	cacheConfig := prepareCacheConfig(20*time.Millisecond, &FakeHasher{hashes: map[string]uint64{"bucket": 1, evictKey: 9999}})
+	cacheConfig.StaleIfError = 10 * time.Millisecond
+	metaDataCache, err := NewBucketMetaDataCache(cacheConfig, &fetcherMock)
+	require.NoError(t, err)
+
+	_, err = metaDataCache.Fetch(&bucketLocation)
+	require.NoError(t, err)
+
+	time.Sleep(40 * time.Millisecond)
+	metaData, err := metaDataCache.Fetch(&bucketLocation)
+	assert.Nil(t, metaData)
+	assert.Equal(t, fetchErr, err)
+}
+
+func TestShouldCoalesceConcurrentFetchesOfTheBucket(t *testing.T) {
+	bucketLocation := BucketLocation{Name: "bucket"}
+	expectedMetaData := BucketMetaData{Name: "bucket"}
+	concurrentFetches := 10
+
+	fetcherMock := FetcherMock{Mock: &mock.Mock{}}
+	fetcherMock.On("Fetch", &bucketLocation).Return(&expectedMetaData, nil).WaitUntil(time.After(100 * time.Millisecond))
+
+	cacheConfig := prepareCacheConfig(0, &FakeHasher{hashes: map[string]uint64{"bucket": 1}})
+	metaDataCache, err := NewBucketMetaDataCache(cacheConfig, &fetcherMock)
+	require.NoError(t, err)
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < concurrentFetches; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			metaData, err := metaDataCache.Fetch(&bucketLocation)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedMetaData, *metaData)
+		}()
+	}
+	waitGroup.Wait()
+
+	fetcherMock.AssertNumberOfCalls(t, "Fetch", 1)
+}
+
 func TestCreatingFakeFetcherUsingFactory(t *testing.T) {
 	conf := BucketMetaDataCacheConfig{
 		FetcherType: "fake",
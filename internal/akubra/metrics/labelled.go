@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	promhttp "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type contextKey string
+
+// Region is the context key of the name of the region handling the request
+const Region = contextKey("Region")
+
+const (
+	namespace = "akubra"
+	// StatusError labels the requests which failed without a response
+	StatusError = "error"
+)
+
+// Breaker states reported by the breaker state gauge
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var breakerStates = []string{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+// bodySizeBuckets range from 1KB to 1GB
+var bodySizeBuckets = prometheus.ExponentialBuckets(1024, 4, 11)
+
+var (
+	labelledRegistry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests handled by the regions",
+	}, []string{"region", "method", "status", "consistency_level"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the requests handled by the regions",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"region", "method", "status"})
+
+	requestBodySize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_body_size_bytes",
+		Help:      "Size of the bodies of the requests handled by the regions",
+		Buckets:   bodySizeBuckets,
+	}, []string{"region", "method"})
+
+	responseBodySize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "response_body_size_bytes",
+		Help:      "Size of the bodies of the responses returned by the regions",
+		Buckets:   bodySizeBuckets,
+	}, []string{"region", "method"})
+
+	shardRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "shard_request_duration_seconds",
+		Help:      "Duration of the requests sent to the shards",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"shard", "region", "method", "status"})
+
+	backendRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Duration of the requests sent to the storages",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "method", "status"})
+
+	backendResponseBodySize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_response_body_size_bytes",
+		Help:      "Size of the bodies of the responses returned by the storages",
+		Buckets:   bodySizeBuckets,
+	}, []string{"backend", "method"})
+
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_state",
+		Help:      "State of the storage breaker, 1 for the current state",
+	}, []string{"backend", "state"})
+
+	storageHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_healthy",
+		Help:      "Result of the last health probe of the storage, 1 if healthy",
+	}, []string{"backend"})
+
+	storageProbeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_probe_duration_seconds",
+		Help:      "Duration of the storage health probes",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "status"})
+
+	watchdogRecordsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watchdog_records_total",
+		Help:      "Consistency records logged by the watchdog",
+	}, []string{"consistency_level", "status"})
+
+	bucketMetaDataLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bucket_metadata_lookups_total",
+		Help:      "Bucket metadata cache lookups by their result",
+	}, []string{"result"})
+)
+
+func init() {
+	labelledRegistry.MustRegister(requestsTotal, requestDuration, requestBodySize, responseBodySize,
+		shardRequestDuration, backendRequestDuration, backendResponseBodySize, breakerState, storageHealthy, storageProbeDuration, watchdogRecordsTotal, bucketMetaDataLookupsTotal)
+}
+
+// StatusLabel returns the status code as the label value, or StatusError if there is no response
+func StatusLabel(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return StatusError
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// ObserveRequest records a request handled by a region
+func ObserveRequest(region, method, consistencyLevel string, resp *http.Response, err error, requestSize int64, since time.Time) {
+	status := StatusLabel(resp, err)
+	requestsTotal.WithLabelValues(region, method, status, consistencyLevel).Inc()
+	requestDuration.WithLabelValues(region, method, status).Observe(time.Since(since).Seconds())
+	if requestSize > 0 {
+		requestBodySize.WithLabelValues(region, method).Observe(float64(requestSize))
+	}
+	if resp != nil && resp.ContentLength >= 0 {
+		responseBodySize.WithLabelValues(region, method).Observe(float64(resp.ContentLength))
+	}
+}
+
+// ObserveShardRequest records a request sent to a shard
+func ObserveShardRequest(shard, region, method string, resp *http.Response, err error, since time.Time) {
+	shardRequestDuration.WithLabelValues(shard, region, method, StatusLabel(resp, err)).Observe(time.Since(since).Seconds())
+}
+
+// ObserveBackendRequest records a request sent to a storage
+func ObserveBackendRequest(backend, method string, resp *http.Response, err error, since time.Time) {
+	backendRequestDuration.WithLabelValues(backend, method, StatusLabel(resp, err)).Observe(time.Since(since).Seconds())
+	if resp != nil && resp.ContentLength >= 0 {
+		backendResponseBodySize.WithLabelValues(backend, method).Observe(float64(resp.ContentLength))
+	}
+}
+
+// SetBreakerState marks the current state of the storage breaker
+func SetBreakerState(backend, state string) {
+	for _, knownState := range breakerStates {
+		value := float64(0)
+		if knownState == state {
+			value = 1
+		}
+		breakerState.WithLabelValues(backend, knownState).Set(value)
+	}
+}
+
+// ObserveHealthProbe records the result of a storage health probe
+func ObserveHealthProbe(backend string, resp *http.Response, err error, healthy bool, duration time.Duration) {
+	value := float64(0)
+	if healthy {
+		value = 1
+	}
+	storageHealthy.WithLabelValues(backend).Set(value)
+	storageProbeDuration.WithLabelValues(backend, StatusLabel(resp, err)).Observe(duration.Seconds())
+}
+
+// ObserveWatchdogRecord records the logging of a consistency record
+func ObserveWatchdogRecord(consistencyLevel string, err error) {
+	status := "ok"
+	if err != nil {
+		status = StatusError
+	}
+	watchdogRecordsTotal.WithLabelValues(consistencyLevel, status).Inc()
+}
+
+// ObserveBucketMetaDataLookup records a bucket metadata cache lookup, the result is hit, miss, stale or coalesced
+func ObserveBucketMetaDataLookup(result string) {
+	bucketMetaDataLookupsTotal.WithLabelValues(result).Inc()
+}
+
+// LabelledHandler serves the labelled metrics in the Prometheus format
+func LabelledHandler() http.Handler {
+	return promhttp.HandlerFor(labelledRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrapeLabelled(t *testing.T) string {
+	recorder := httptest.NewRecorder()
+	LabelledHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	body, err := ioutil.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestShouldLabelRequestsWithRegionMethodStatusAndConsistencyLevel(t *testing.T) {
+	// given
+	resp := &http.Response{StatusCode: http.StatusCreated, ContentLength: 2048}
+
+	// when
+	ObserveRequest("labelled-region", http.MethodPut, "strong", resp, nil, 4096, time.Now())
+	ObserveRequest("labelled-region", http.MethodPut, "strong", nil, errors.New("failed"), 4096, time.Now())
+
+	// then
+	assert.Equal(t, float64(1), testutil.ToFloat64(requestsTotal.WithLabelValues("labelled-region", http.MethodPut, "201", "strong")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(requestsTotal.WithLabelValues("labelled-region", http.MethodPut, StatusError, "strong")))
+	scraped := scrapeLabelled(t)
+	assert.Contains(t, scraped, `akubra_request_duration_seconds_count{method="PUT",region="labelled-region",status="201"} 1`)
+	assert.Contains(t, scraped, `akubra_request_body_size_bytes_count{method="PUT",region="labelled-region"} 2`)
+	assert.Contains(t, scraped, `akubra_response_body_size_bytes_count{method="PUT",region="labelled-region"} 1`)
+}
+
+func TestShouldLabelShardAndBackendRequests(t *testing.T) {
+	// given
+	resp := &http.Response{StatusCode: http.StatusOK, ContentLength: -1}
+
+	// when
+	ObserveShardRequest("labelled-shard", "labelled-region", http.MethodGet, resp, nil, time.Now())
+	ObserveBackendRequest("labelled-backend", http.MethodGet, resp, nil, time.Now())
+
+	// then
+	scraped := scrapeLabelled(t)
+	assert.Contains(t, scraped, `akubra_shard_request_duration_seconds_count{method="GET",region="labelled-region",shard="labelled-shard",status="200"} 1`)
+	assert.Contains(t, scraped, `akubra_backend_request_duration_seconds_count{backend="labelled-backend",method="GET",status="200"} 1`)
+	assert.NotContains(t, scraped, `akubra_backend_response_body_size_bytes_count{backend="labelled-backend"`)
+}
+
+func TestShouldMarkOnlyCurrentBreakerState(t *testing.T) {
+	// when
+	SetBreakerState("breaker-backend", BreakerOpen)
+	SetBreakerState("breaker-backend", BreakerHalfOpen)
+
+	// then
+	assert.Equal(t, float64(0), testutil.ToFloat64(breakerState.WithLabelValues("breaker-backend", BreakerOpen)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(breakerState.WithLabelValues("breaker-backend", BreakerHalfOpen)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(breakerState.WithLabelValues("breaker-backend", BreakerClosed)))
+}
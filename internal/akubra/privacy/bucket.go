@@ -37,13 +37,17 @@ func (filter *BucketPrivacyFilter) Filter(req *http.Request, prvCtx *Context) (V
 	if bucketName == "" {
 		return NoViolation, nil
 	}
-	reqID := utils.RequestID(req)
-	log.Debugf("Asking for bucket %s metadata on reqID %s", bucketName, reqID)
-	bucketLocation := metadata.BucketLocation{Name: bucketName}
-	bucketMetaData, err := filter.bucketMetaDataFetcher.Fetch(&bucketLocation)
-	log.Debugf("Got bucket %s metadata on reqID %s", bucketName, reqID)
-	if err != nil {
-		return NoViolation, fmt.Errorf("failed to verify bucket privacy, could't fetch meta data: %s", err)
+	bucketMetaData := metadata.FromContext(req.Context())
+	if bucketMetaData == nil {
+		reqID := utils.RequestID(req)
+		log.Debugf("Asking for bucket %s metadata on reqID %s", bucketName, reqID)
+		bucketLocation := metadata.BucketLocation{Name: bucketName}
+		var err error
+		bucketMetaData, err = filter.bucketMetaDataFetcher.Fetch(&bucketLocation)
+		log.Debugf("Got bucket %s metadata on reqID %s", bucketName, reqID)
+		if err != nil {
+			return NoViolation, fmt.Errorf("failed to verify bucket privacy, could't fetch meta data: %s", err)
+		}
 	}
 
 	if bucketMetaData == nil {
@@ -1,6 +1,7 @@
 package privacy
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -109,6 +110,19 @@ func TestShouldNotFetchMetadataIfTheNetworkIsInternal(t *testing.T) {
 	fetcherMock.AssertNotCalled(t, "Fetch", bucketLocation)
 }
 
+func TestShouldUseBucketMetaDataFromTheRequestContext(t *testing.T) {
+	fetcherMock := &BucketMetaDataFetcherMock{Mock: &mock.Mock{}}
+	req := requestWithBasicContext("123", "bucket", "obj")
+	bucketMetaData := &metadata.BucketMetaData{Name: "bucket", IsInternal: true}
+	req = req.WithContext(context.WithValue(req.Context(), metadata.BucketMetaDataContextKey, bucketMetaData))
+
+	violation, err := NewBucketPrivacyFilterFunc(fetcherMock)(req, &Context{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, InternalNetworkBucket, violation)
+	fetcherMock.AssertNotCalled(t, "Fetch", mock.Anything)
+}
+
 func (fetcher *BucketMetaDataFetcherMock) Fetch(bucketLocation *metadata.BucketLocation) (*metadata.BucketMetaData, error) {
 	args := fetcher.Called(bucketLocation)
 	var metaData *metadata.BucketMetaData
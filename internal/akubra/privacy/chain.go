@@ -20,6 +20,12 @@ const (
 	//InternalNetworkBucket means that access to internal-network-only bucket has been requested
 	//from an external network
 	InternalNetworkBucket
+	//MethodNotAllowed means that the request method is not allowed on the bucket
+	MethodNotAllowed
+	//AccessKeyNotAllowed means that the request access key is not allowed on the bucket
+	AccessKeyNotAllowed
+	//OutsideTimeWindow means that the bucket has been requested outside of its access time window
+	OutsideTimeWindow
 )
 
 //ErrPrivacyContextNotPresent indicates that the privacy.Context is not present in request's context.Context
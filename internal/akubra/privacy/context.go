@@ -3,6 +3,7 @@ package privacy
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/allegro/akubra/internal/akubra/log"
@@ -31,22 +32,46 @@ type Config struct {
 	DropOnError                  bool   `yaml:"DropOnError"`
 	DropOnValidation             bool   `yaml:"DropOnValidation"`
 	ViolationErrorCode           int    `yaml:"ViolationErrorCode"`
+	//InternalNetworks are the CIDRs the source IP of internal network requests belongs to
+	InternalNetworks []string `yaml:"InternalNetworks"`
+	//TrustedProxies are the CIDRs of the proxies which X-Forwarded-For header is trusted
+	TrustedProxies []string `yaml:"TrustedProxies"`
+	//Filters are the filters from the registry run after the bucket privacy filter
+	Filters []FilterConfig `yaml:"Filters"`
 }
 
-//BasicPrivacyContextSupplier is a basic implemtation of ContextSupplier
+//BasicPrivacyContextSupplier is a basic implemtation of ContextSupplier. A request is from the internal network
+//if all of the configured rules say so: the network header has the configured value and the source IP belongs
+//to the internal networks
 type BasicPrivacyContextSupplier struct {
-	config *Config
+	config           *Config
+	internalNetworks []*net.IPNet
+	trustedProxies   []*net.IPNet
 }
 
 //NewBasicPrivacyContextSupplier creates an instance of BasicPrivacyContextSupplier
 func NewBasicPrivacyContextSupplier(config *Config) ContextSupplier {
-	return &BasicPrivacyContextSupplier{config: config}
+	internalNetworks, err := ParseNetworks(config.InternalNetworks)
+	if err != nil {
+		log.Printf("Privacy: ignoring internal networks, %s", err)
+	}
+	trustedProxies, err := ParseNetworks(config.TrustedProxies)
+	if err != nil {
+		log.Printf("Privacy: ignoring trusted proxies, %s", err)
+	}
+	return &BasicPrivacyContextSupplier{config: config, internalNetworks: internalNetworks, trustedProxies: trustedProxies}
 }
 
 //Supply supplies the request with basic privacy info
 func (basicSupplier *BasicPrivacyContextSupplier) Supply(req *http.Request) (*http.Request, error) {
-	headerValue := req.Header.Get(basicSupplier.config.IsInternalNetworkHeaderName)
-	isInternalNetwork := headerValue == basicSupplier.config.IsInternalNetworkHeaderValue
+	isInternalNetwork := true
+	if basicSupplier.config.IsInternalNetworkHeaderName != "" {
+		headerValue := req.Header.Get(basicSupplier.config.IsInternalNetworkHeaderName)
+		isInternalNetwork = headerValue == basicSupplier.config.IsInternalNetworkHeaderValue
+	}
+	if len(basicSupplier.config.InternalNetworks) > 0 {
+		isInternalNetwork = isInternalNetwork && containsIP(basicSupplier.internalNetworks, sourceIP(req, basicSupplier.trustedProxies))
+	}
 	privacyContext := &Context{
 		isInternalNetwork: isInternalNetwork,
 	}
@@ -111,3 +111,68 @@ func prepareConfig() *Config {
 		IsInternalNetworkHeaderValue: "",
 	}
 }
+
+func TestShouldClassifyRequestsBySourceIP(t *testing.T) {
+	config := &Config{
+		InternalNetworks: []string{"10.0.0.0/8", "192.168.1.1"},
+		TrustedProxies:   []string{"172.16.0.0/12"},
+	}
+	supplier := NewBasicPrivacyContextSupplier(config)
+
+	for _, testCase := range []struct {
+		caseName     string
+		remoteAddr   string
+		forwardedFor []string
+		internal     bool
+	}{
+		{"internal peer", "10.1.2.3:1234", nil, true},
+		{"internal single host", "192.168.1.1:1234", nil, true},
+		{"external peer", "8.8.8.8:1234", nil, false},
+		{"forwarded for ignored from untrusted peer", "8.8.8.8:1234", []string{"10.1.2.3"}, false},
+		{"internal client behind trusted proxy", "172.16.0.1:1234", []string{"10.1.2.3"}, true},
+		{"external client behind trusted proxy", "172.16.0.1:1234", []string{"8.8.8.8"}, false},
+		{"spoofed forwarded for behind trusted proxy", "172.16.0.1:1234", []string{"10.1.2.3, 8.8.8.8"}, false},
+		{"internal client behind proxies chain", "172.16.0.1:1234", []string{"10.1.2.3", "172.16.0.2"}, true},
+		{"malformed forwarded for", "172.16.0.1:1234", []string{"10.1.2.3, unknown"}, false},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/object", nil)
+		assert.Nil(t, err)
+		req.RemoteAddr = testCase.remoteAddr
+		for _, forwardedFor := range testCase.forwardedFor {
+			req.Header.Add("X-Forwarded-For", forwardedFor)
+		}
+
+		req, err = supplier.Supply(req)
+		assert.Nil(t, err)
+
+		privacyContext := req.Context().Value(RequestPrivacyContextKey).(*Context)
+		assert.Equal(t, testCase.internal, privacyContext.isInternalNetwork, testCase.caseName)
+	}
+}
+
+func TestShouldRequireBothHeaderAndSourceIPToBeInternal(t *testing.T) {
+	config := prepareConfig()
+	config.InternalNetworks = []string{"10.0.0.0/8"}
+	supplier := NewBasicPrivacyContextSupplier(config)
+
+	for _, testCase := range []struct {
+		remoteAddr string
+		untrusted  string
+		internal   bool
+	}{
+		{"10.1.2.3:1234", "", true},
+		{"10.1.2.3:1234", "1", false},
+		{"8.8.8.8:1234", "", false},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/object", nil)
+		assert.Nil(t, err)
+		req.RemoteAddr = testCase.remoteAddr
+		req.Header.Set(config.IsInternalNetworkHeaderName, testCase.untrusted)
+
+		req, err = supplier.Supply(req)
+		assert.Nil(t, err)
+
+		privacyContext := req.Context().Value(RequestPrivacyContextKey).(*Context)
+		assert.Equal(t, testCase.internal, privacyContext.isInternalNetwork)
+	}
+}
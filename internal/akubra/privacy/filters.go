@@ -0,0 +1,157 @@
+package privacy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/utils"
+)
+
+const timeWindowLayout = "15:04"
+
+//FilterConfig is a configuration of a filter from the filters registry
+type FilterConfig struct {
+	//Type is the name of the filter in the registry
+	Type string `yaml:"Type"`
+	//Buckets are the buckets the filter applies to, all buckets if empty
+	Buckets []string `yaml:"Buckets"`
+	//ExternalOnly makes the filter skip the requests from the internal network
+	ExternalOnly bool `yaml:"ExternalOnly"`
+	//Methods are the request methods allowed by MethodAllowlist filter
+	Methods []string `yaml:"Methods"`
+	//AccessKeys are the access keys allowed by AccessKeyAllowlist filter
+	AccessKeys []string `yaml:"AccessKeys"`
+	//From is the daily start of TimeWindow filter window, formatted as 15:04
+	From string `yaml:"From"`
+	//To is the daily end of TimeWindow filter window, formatted as 15:04, it may be earlier than From
+	To string `yaml:"To"`
+	//TimeZone is the IANA time zone of TimeWindow filter window, UTC if empty
+	TimeZone string `yaml:"TimeZone"`
+}
+
+//FilterFactory creates a Filter using the given config
+type FilterFactory = func(config FilterConfig) (Filter, error)
+
+var filterFactories = map[string]FilterFactory{
+	"MethodAllowlist":    newMethodAllowlistFilter,
+	"AccessKeyAllowlist": newAccessKeyAllowlistFilter,
+	"TimeWindow":         newTimeWindowFilter,
+}
+
+//NewFilters creates the configured filters using the filters registry, in the order of configs
+func NewFilters(configs []FilterConfig) ([]Filter, error) {
+	filters := make([]Filter, 0, len(configs))
+	for idx, config := range configs {
+		factory, registered := filterFactories[config.Type]
+		if !registered {
+			return nil, fmt.Errorf("filter #%d: unknown type %q", idx, config.Type)
+		}
+		filter, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("filter #%d of type %s: %s", idx, config.Type, err)
+		}
+		filters = append(filters, scoped(config, filter))
+	}
+	return filters, nil
+}
+
+//scoped limits the filter to the configured buckets and network
+func scoped(config FilterConfig, filter Filter) Filter {
+	buckets := toSet(config.Buckets, strings.TrimSpace)
+	return func(req *http.Request, prvCtx *Context) (ViolationType, error) {
+		if config.ExternalOnly && prvCtx.isInternalNetwork {
+			return NoViolation, nil
+		}
+		bucketName := utils.ExtractBucketFrom(req.URL.Path)
+		if bucketName == "" {
+			return NoViolation, nil
+		}
+		if _, applies := buckets[bucketName]; len(buckets) > 0 && !applies {
+			return NoViolation, nil
+		}
+		return filter(req, prvCtx)
+	}
+}
+
+func newMethodAllowlistFilter(config FilterConfig) (Filter, error) {
+	if len(config.Methods) == 0 {
+		return nil, fmt.Errorf("no Methods allowed")
+	}
+	methods := toSet(config.Methods, strings.ToUpper)
+	return func(req *http.Request, prvCtx *Context) (ViolationType, error) {
+		if _, allowed := methods[req.Method]; !allowed {
+			return MethodNotAllowed, nil
+		}
+		return NoViolation, nil
+	}, nil
+}
+
+func newAccessKeyAllowlistFilter(config FilterConfig) (Filter, error) {
+	if len(config.AccessKeys) == 0 {
+		return nil, fmt.Errorf("no AccessKeys allowed")
+	}
+	accessKeys := toSet(config.AccessKeys, strings.TrimSpace)
+	return func(req *http.Request, prvCtx *Context) (ViolationType, error) {
+		if _, allowed := accessKeys[utils.RequestAccessKey(req)]; !allowed {
+			return AccessKeyNotAllowed, nil
+		}
+		return NoViolation, nil
+	}, nil
+}
+
+func newTimeWindowFilter(config FilterConfig) (Filter, error) {
+	from, err := time.Parse(timeWindowLayout, config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid From: %s", err)
+	}
+	to, err := time.Parse(timeWindowLayout, config.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid To: %s", err)
+	}
+	if from.Equal(to) {
+		return nil, fmt.Errorf("From and To can't be equal")
+	}
+	location, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TimeZone: %s", err)
+	}
+	window := &timeWindow{from: minuteOfDay(from), to: minuteOfDay(to), location: location, now: time.Now}
+	return window.filter, nil
+}
+
+type timeWindow struct {
+	from     int
+	to       int
+	location *time.Location
+	now      func() time.Time
+}
+
+func (window *timeWindow) filter(req *http.Request, prvCtx *Context) (ViolationType, error) {
+	if !window.contains(window.now().In(window.location)) {
+		return OutsideTimeWindow, nil
+	}
+	return NoViolation, nil
+}
+
+//contains tells if the moment is in the window, the window wraps around midnight if it ends before it starts
+func (window *timeWindow) contains(moment time.Time) bool {
+	minute := minuteOfDay(moment)
+	if window.from < window.to {
+		return minute >= window.from && minute < window.to
+	}
+	return minute >= window.from || minute < window.to
+}
+
+func minuteOfDay(moment time.Time) int {
+	return moment.Hour()*60 + moment.Minute()
+}
+
+func toSet(values []string, normalize func(string) string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[normalize(value)] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,117 @@
+package privacy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldCreateFiltersFromRegistry(t *testing.T) {
+	filters, err := NewFilters([]FilterConfig{
+		{Type: "MethodAllowlist", Methods: []string{"get"}},
+		{Type: "AccessKeyAllowlist", AccessKeys: []string{"key"}},
+		{Type: "TimeWindow", From: "08:00", To: "16:00", TimeZone: "Europe/Warsaw"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, filters, 3)
+
+	for _, config := range []FilterConfig{
+		{Type: "Unknown"},
+		{Type: "MethodAllowlist"},
+		{Type: "AccessKeyAllowlist"},
+		{Type: "TimeWindow", From: "8", To: "16:00"},
+		{Type: "TimeWindow", From: "08:00", To: "08:00"},
+		{Type: "TimeWindow", From: "08:00", To: "16:00", TimeZone: "Nowhere/Town"},
+	} {
+		_, err := NewFilters([]FilterConfig{config})
+		assert.Error(t, err, config)
+	}
+}
+
+func TestMethodAllowlistShouldApplyToConfiguredBucketsAndNetwork(t *testing.T) {
+	filters, err := NewFilters([]FilterConfig{{Type: "MethodAllowlist", Buckets: []string{"public"}, ExternalOnly: true,
+		Methods: []string{http.MethodGet, http.MethodHead}}})
+	require.NoError(t, err)
+	filter := filters[0]
+
+	for _, testCase := range []struct {
+		method    string
+		bucket    string
+		internal  bool
+		violation ViolationType
+	}{
+		{http.MethodGet, "public", false, NoViolation},
+		{http.MethodPut, "public", false, MethodNotAllowed},
+		{http.MethodPut, "public", true, NoViolation},
+		{http.MethodPut, "other", false, NoViolation},
+		{http.MethodPut, "", false, NoViolation},
+	} {
+		req := requestWithBasicContext("123", testCase.bucket, "obj")
+		req.Method = testCase.method
+
+		violation, err := filter(req, &Context{isInternalNetwork: testCase.internal})
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.violation, violation, testCase)
+	}
+}
+
+func TestAccessKeyAllowlistShouldCheckHeaderAndPresignedURLs(t *testing.T) {
+	filters, err := NewFilters([]FilterConfig{{Type: "AccessKeyAllowlist", AccessKeys: []string{"allowed"}}})
+	require.NoError(t, err)
+	filter := filters[0]
+
+	for _, testCase := range []struct {
+		authorization string
+		query         string
+		violation     ViolationType
+	}{
+		{"AWS allowed:c2lnbmF0dXJl", "", NoViolation},
+		{"AWS other:c2lnbmF0dXJl", "", AccessKeyNotAllowed},
+		{"", "X-Amz-Credential=allowed%2F20201017%2Fregion%2Fs3%2Faws4_request", NoViolation},
+		{"", "AWSAccessKeyId=other", AccessKeyNotAllowed},
+		{"", "", AccessKeyNotAllowed},
+	} {
+		req := requestWithBasicContext("123", "bucket", "obj")
+		req.URL.RawQuery = testCase.query
+		if testCase.authorization != "" {
+			req.Header.Set("Authorization", testCase.authorization)
+		}
+
+		violation, err := filter(req, &Context{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.violation, violation, testCase)
+	}
+}
+
+func TestTimeWindowShouldHandleWindowsWrappingAroundMidnight(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	require.NoError(t, err)
+	day := &timeWindow{from: 8 * 60, to: 16 * 60, location: warsaw}
+	night := &timeWindow{from: 22 * 60, to: 6 * 60, location: time.UTC}
+
+	for _, testCase := range []struct {
+		window    *timeWindow
+		now       time.Time
+		violation ViolationType
+	}{
+		{day, time.Date(2020, 10, 17, 7, 0, 0, 0, time.UTC), NoViolation},
+		{day, time.Date(2020, 10, 17, 5, 59, 0, 0, time.UTC), OutsideTimeWindow},
+		{day, time.Date(2020, 10, 17, 14, 0, 0, 0, time.UTC), OutsideTimeWindow},
+		{night, time.Date(2020, 10, 17, 23, 0, 0, 0, time.UTC), NoViolation},
+		{night, time.Date(2020, 10, 17, 5, 59, 0, 0, time.UTC), NoViolation},
+		{night, time.Date(2020, 10, 17, 6, 0, 0, 0, time.UTC), OutsideTimeWindow},
+	} {
+		now := testCase.now
+		testCase.window.now = func() time.Time { return now }
+
+		violation, err := testCase.window.filter(requestWithBasicContext("123", "bucket", "obj"), &Context{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.violation, violation, now)
+	}
+}
@@ -0,0 +1,76 @@
+package privacy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+//ParseNetworks parses CIDRs, a plain IP is treated as a single host network
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", cidr)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %s", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//sourceIP returns the IP the request comes from. If the peer is a trusted proxy, X-Forwarded-For is walked
+//from the right and the first address not belonging to a trusted proxy is returned, nil if it's malformed
+func sourceIP(req *http.Request, trustedProxies []*net.IPNet) net.IP {
+	peerIP := parseHostIP(req.RemoteAddr)
+	if !containsIP(trustedProxies, peerIP) {
+		return peerIP
+	}
+	forwardedFor := make([]string, 0)
+	for _, header := range req.Header.Values(forwardedForHeader) {
+		forwardedFor = append(forwardedFor, strings.Split(header, ",")...)
+	}
+	clientIP := peerIP
+	for idx := len(forwardedFor) - 1; idx >= 0; idx-- {
+		forwardedIP := parseHostIP(strings.TrimSpace(forwardedFor[idx]))
+		if forwardedIP == nil {
+			return nil
+		}
+		clientIP = forwardedIP
+		if !containsIP(trustedProxies, forwardedIP) {
+			return clientIP
+		}
+	}
+	return clientIP
+}
+
+func parseHostIP(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(strings.Trim(address, "[]"))
+}
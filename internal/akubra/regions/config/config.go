@@ -30,6 +30,18 @@ type Policies struct {
 	ConsistencyLevel ConsistencyLevel `yaml:"ConsistencyLevel"`
 	// ReadRepair tells akubra that it should emit sync entries when it detects inconsistencies between storage when reading data
 	ReadRepair bool `yaml:"ReadRepair"`
+	// VirtualHostedStyle tells akubra to accept '<bucket>.<domain>' hosts for the policy domains
+	VirtualHostedStyle bool `yaml:"VirtualHostedStyle"`
+	// MultipartFanOut tells akubra to upload multipart objects to all storages of the shard instead of one of them
+	MultipartFanOut bool `yaml:"MultipartFanOut"`
+	// WriteQuorum is the number of storages of the shard which have to succeed before an object PUT/DELETE is answered,
+	// the first success is enough if not set
+	WriteQuorum int `yaml:"WriteQuorum"`
+	// VerifyReads tells akubra to compare the object reads across all storages of the shard, answer with the newest
+	// object version and request a read repair if the storages diverge
+	VerifyReads bool `yaml:"VerifyReads"`
+	// PreviousShards are the shards of the region before the last change of Shards, kept while brim rebalances the region
+	PreviousShards []Policy `yaml:"PreviousShards"`
 }
 
 // ShardingPolicies maps name with Region definition
@@ -3,16 +3,21 @@ package regions
 import (
 	"bytes"
 	"context"
+	"errors"
 	"github.com/allegro/akubra/internal/akubra/utils"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/allegro/akubra/internal/akubra/watchdog"
 
 	"github.com/allegro/akubra/internal/akubra/config"
 	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metadata"
+	"github.com/allegro/akubra/internal/akubra/metrics"
 	"github.com/allegro/akubra/internal/akubra/sharding"
 	storage "github.com/allegro/akubra/internal/akubra/storages"
 )
@@ -22,10 +27,16 @@ const (
 	Domain = log.ContextKey("Domain")
 )
 
+// ErrNoSuchDomain is returned if no sharding policy serves the domain
+var ErrNoSuchDomain = errors.New("no region found for the domain")
+
 // Regions container for multiclusters
 type Regions struct {
-	multiCluters map[string]sharding.ShardsRingAPI
-	defaultRing  sharding.ShardsRingAPI
+	multiCluters        map[string]sharding.ShardsRingAPI
+	defaultRing         sharding.ShardsRingAPI
+	bodyMemoryBuffer    int64
+	bodyBufferDirectory string
+	policyRings         map[string]sharding.ShardsRingAPI
 }
 
 func (rg Regions) assignShardsRing(domain string, shardRing sharding.ShardsRingAPI) {
@@ -47,38 +58,79 @@ func (rg Regions) getNoSuchDomainResponse(req *http.Request) *http.Response {
 
 // RoundTrip performs round trip to target
 func (rg Regions) RoundTrip(req *http.Request) (*http.Response, error) {
+	since := time.Now()
 	reqHost, _, err := net.SplitHostPort(req.Host)
 	if err != nil {
 		reqHost = req.Host
 	}
-	shardsRing := rg.defaultRing
-	req, err = prepareRequestBody(req)
+	req, body := rg.prepareRequestBody(req)
+	if body != nil {
+		defer body.Release()
+	}
+	shardsRing := rg.shardsRing(reqHost, metadata.FromContext(req.Context()))
+	if shardsRing == nil {
+		return rg.getNoSuchDomainResponse(req), nil
+	}
+	ringProps := shardsRing.GetRingProps()
+	req = req.WithContext(shardingPolicyContext(req, ringProps))
+	resp, err := shardsRing.DoRequest(req)
+	metrics.ObserveRequest(ringProps.Region, req.Method, string(ringProps.ConsistencyLevel), resp, err, req.ContentLength, since)
+	return resp, err
+}
+
+// Backends returns the storages checking the authorization of the request to the path of the domain, the bucket
+// meta data routes the request like in RoundTrip
+func (rg Regions) Backends(domain, method, path string, bucketMetaData *metadata.BucketMetaData) ([]*storage.StorageClient, error) {
+	shardsRing := rg.shardsRing(domain, bucketMetaData)
+	if shardsRing == nil {
+		return nil, ErrNoSuchDomain
+	}
+	// the bucket requests and the deletes are sent to all shards of the region
+	if method == http.MethodDelete || !strings.Contains(strings.Trim(path, "/"), "/") {
+		backends := make([]*storage.StorageClient, 0)
+		for _, shard := range shardsRing.GetShards() {
+			backends = append(backends, shard.Backends()...)
+		}
+		return backends, nil
+	}
+	shard, err := shardsRing.Pick(path)
 	if err != nil {
 		return nil, err
 	}
-	if ringForRequest, foundRingForRequest := rg.multiCluters[reqHost]; foundRingForRequest {
+	return shard.Backends(), nil
+}
+
+func (rg Regions) shardsRing(host string, bucketMetaData *metadata.BucketMetaData) sharding.ShardsRingAPI {
+	shardsRing := rg.defaultRing
+	if ringForRequest, foundRingForRequest := rg.multiCluters[host]; foundRingForRequest {
 		shardsRing = ringForRequest
 	}
-	if shardsRing == nil {
-		return rg.getNoSuchDomainResponse(req), nil
+	if bucketMetaData != nil && bucketMetaData.ShardingPolicy != "" {
+		if ringForBucket, foundRingForBucket := rg.policyRings[bucketMetaData.ShardingPolicy]; foundRingForBucket {
+			shardsRing = ringForBucket
+		} else {
+			log.Debugf("Sharding policy %q of bucket %s not found, using the domain one", bucketMetaData.ShardingPolicy, bucketMetaData.Name)
+		}
 	}
-	req = req.WithContext(shardingPolicyContext(req, shardsRing.GetRingProps()))
-	return shardsRing.DoRequest(req)
+	return shardsRing
 }
 
-func prepareRequestBody(request *http.Request) (*http.Request, error) {
+//prepareRequestBody makes the body replayable without reading it upfront, the returned
+//body has to be released once the request is dispatched to the shards
+func (rg Regions) prepareRequestBody(request *http.Request) (*http.Request, *utils.ReplayableBody) {
 	if request.Body == nil {
 		return request, nil
 	}
-	bodyBytes, err := utils.ReadRequestBody(request)
-	if err != nil {
-		return nil, err
-	}
-	request.GetBody = func() (closer io.ReadCloser, e error) {
-		return ioutil.NopCloser(bytes.NewBuffer(bodyBytes)), nil
+	if request.Body == http.NoBody {
+		request.GetBody = func() (io.ReadCloser, error) {
+			return http.NoBody, nil
+		}
+		return request, nil
 	}
-	request.Body, _ = request.GetBody()
-	return request, nil
+	body := utils.NewReplayableBody(request.Body, rg.bodyMemoryBuffer, rg.bodyBufferDirectory)
+	request.GetBody = body.NewReader
+	request.Body, _ = body.NewReader()
+	return request, body
 }
 
 func shardingPolicyContext(request *http.Request, shardProps *sharding.RingProps) context.Context {
@@ -89,6 +141,10 @@ func shardingPolicyContext(request *http.Request, shardProps *sharding.RingProps
 	shardingContext = context.WithValue(shardingContext, watchdog.NoErrorsDuringRequest, &noErrorsDuringRequest)
 	shardingContext = context.WithValue(shardingContext, watchdog.ReadRepairObjectVersion, &readRepairObjectVersion)
 	shardingContext = context.WithValue(shardingContext, watchdog.MultiPartUpload, &successfulMultipart)
+	shardingContext = context.WithValue(shardingContext, storage.MultipartFanOut, shardProps.MultipartFanOut)
+	shardingContext = context.WithValue(shardingContext, storage.WriteQuorum, shardProps.WriteQuorum)
+	shardingContext = context.WithValue(shardingContext, storage.VerifyReads, shardProps.VerifyReads)
+	shardingContext = context.WithValue(shardingContext, metrics.Region, shardProps.Region)
 	return context.WithValue(shardingContext, watchdog.ReadRepair, shardProps.ReadRepair)
 }
 
@@ -97,17 +153,21 @@ func NewRegions(conf config.Config,
 	storages storage.ClusterStorage,
 	consistencyWatchdog watchdog.ConsistencyWatchdog,
 	recordFactory watchdog.ConsistencyRecordFactory,
-	watchdogVersionHeader string) (http.RoundTripper, error) {
+	watchdogVersionHeader string) (*Regions, error) {
 
 	ringFactory := sharding.NewRingFactory(conf, storages, consistencyWatchdog, recordFactory, watchdogVersionHeader)
 	regions := &Regions{
-		multiCluters: make(map[string]sharding.ShardsRingAPI),
+		multiCluters:        make(map[string]sharding.ShardsRingAPI),
+		bodyMemoryBuffer:    conf.Service.Server.BodyMemoryBufferSize.SizeInBytes,
+		bodyBufferDirectory: conf.Service.Server.BodyBufferDirectory,
+		policyRings:         make(map[string]sharding.ShardsRingAPI),
 	}
 	for name, regionConfig := range conf.ShardingPolicies {
 		regionRing, err := ringFactory.RegionRing(name, conf, regionConfig)
 		if err != nil {
 			return nil, err
 		}
+		regions.policyRings[name] = regionRing
 
 		for _, domain := range regionConfig.Domains {
 			regions.assignShardsRing(domain, regionRing)
@@ -1,11 +1,15 @@
 package regions
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
 	"github.com/allegro/akubra/internal/akubra/storages"
 	"net/http"
 	"testing"
 
+	"github.com/allegro/akubra/internal/akubra/metadata"
+	"github.com/allegro/akubra/internal/akubra/metrics"
 	"github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/allegro/akubra/internal/akubra/sharding"
 	"github.com/allegro/akubra/internal/akubra/watchdog"
@@ -85,6 +89,10 @@ func TestShouldReturnResponseFromShardsRing(t *testing.T) {
 	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), watchdog.NoErrorsDuringRequest, &noErrors))
 	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), watchdog.ReadRepairObjectVersion, &readRepairVersion))
 	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), watchdog.MultiPartUpload, &multipart))
+	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), storages.MultipartFanOut, shardProps.MultipartFanOut))
+	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), storages.WriteQuorum, shardProps.WriteQuorum))
+	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), storages.VerifyReads, shardProps.VerifyReads))
+	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), metrics.Region, shardProps.Region))
 	requestWithHostAndContext = requestWithHostAndContext.WithContext(context.WithValue(requestWithHostAndContext.Context(), watchdog.ReadRepair, shardProps.ReadRepair))
 
 	shardsRingMock.On("DoRequest", requestWithHostAndContext).Return(expectedResponse)
@@ -103,6 +111,10 @@ func TestShouldReturnResponseFromShardsRing(t *testing.T) {
 	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), watchdog.NoErrorsDuringRequest, &noErrors))
 	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), watchdog.ReadRepairObjectVersion, &readRepairVersion))
 	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), watchdog.MultiPartUpload, &multipart))
+	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), storages.MultipartFanOut, shardProps.MultipartFanOut))
+	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), storages.WriteQuorum, shardProps.WriteQuorum))
+	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), storages.VerifyReads, shardProps.VerifyReads))
+	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), metrics.Region, shardProps.Region))
 	defaultRequestWithContext = defaultRequestWithContext.WithContext(context.WithValue(defaultRequestWithContext.Context(), watchdog.ReadRepair, shardProps.ReadRepair))
 
 	shardsRingMock.On("DoRequest", defaultRequestWithContext).Return(expectedResponse)
@@ -136,6 +148,10 @@ func TestShouldReturnResponseFromShardsRingOnHostWithPort(t *testing.T) {
 	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), watchdog.NoErrorsDuringRequest, &noErrors))
 	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), watchdog.ReadRepairObjectVersion, &readRepairVersion))
 	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), watchdog.MultiPartUpload, &multipart))
+	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), storages.MultipartFanOut, shardProps.MultipartFanOut))
+	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), storages.WriteQuorum, shardProps.WriteQuorum))
+	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), storages.VerifyReads, shardProps.VerifyReads))
+	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), metrics.Region, shardProps.Region))
 	requestWithContext = requestWithContext.WithContext(context.WithValue(requestWithContext.Context(), watchdog.ReadRepair, shardProps.ReadRepair))
 
 	shardsRingMock.On("DoRequest", requestWithContext).Return(expectedResponse)
@@ -146,3 +162,62 @@ func TestShouldReturnResponseFromShardsRingOnHostWithPort(t *testing.T) {
 	response, _ := regions.RoundTrip(request)
 	assert.Equal(t, 200, response.StatusCode)
 }
+
+func TestShouldMakeRequestBodyReplayable(t *testing.T) {
+	regions := &Regions{
+		multiCluters: make(map[string]sharding.ShardsRingAPI),
+	}
+	payload := "object content"
+	request := &http.Request{Host: "test1.qxlint", Body: ioutil.NopCloser(bytes.NewBufferString(payload))}
+	shardsRingMock := &ShardsRingMock{}
+	shardsRingMock.On("GetRingProps").Return(&sharding.RingProps{ConsistencyLevel: config.None})
+	shardsRingMock.On("DoRequest", mock.Anything).Return(&http.Response{StatusCode: 200}).Run(func(args mock.Arguments) {
+		req := args.Get(0).(*http.Request)
+		for i := 0; i < 2; i++ {
+			body, err := req.GetBody()
+			assert.NoError(t, err)
+			read, err := ioutil.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, string(read))
+			assert.NoError(t, body.Close())
+		}
+	})
+	regions.assignShardsRing("test1.qxlint", shardsRingMock)
+
+	response, err := regions.RoundTrip(request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	shardsRingMock.AssertNumberOfCalls(t, "DoRequest", 1)
+}
+
+func TestShouldUseShardingPolicyOfTheBucket(t *testing.T) {
+	domainRing := &ShardsRingMock{}
+	domainRing.On("GetRingProps").Return(&sharding.RingProps{ConsistencyLevel: config.None})
+	domainRing.On("DoRequest", mock.Anything).Return(&http.Response{StatusCode: http.StatusOK})
+	bucketRing := &ShardsRingMock{}
+	bucketRing.On("GetRingProps").Return(&sharding.RingProps{ConsistencyLevel: config.None})
+	bucketRing.On("DoRequest", mock.Anything).Return(&http.Response{StatusCode: http.StatusAccepted})
+	regions := &Regions{
+		multiCluters: map[string]sharding.ShardsRingAPI{"test1.qxlint": domainRing},
+		policyRings:  map[string]sharding.ShardsRingAPI{"domain": domainRing, "archive": bucketRing},
+	}
+
+	for _, testCase := range []struct {
+		shardingPolicy string
+		expectedCode   int
+	}{
+		{"archive", http.StatusAccepted},
+		{"", http.StatusOK},
+		{"unknown", http.StatusOK},
+	} {
+		request := &http.Request{Host: "test1.qxlint"}
+		bucketMetaData := &metadata.BucketMetaData{Name: "bucket", ShardingPolicy: testCase.shardingPolicy}
+		request = request.WithContext(context.WithValue(context.Background(), metadata.BucketMetaDataContextKey, bucketMetaData))
+
+		response, err := regions.RoundTrip(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expectedCode, response.StatusCode, testCase.shardingPolicy)
+	}
+}
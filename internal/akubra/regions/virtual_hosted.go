@@ -0,0 +1,89 @@
+package regions
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	"github.com/allegro/akubra/internal/akubra/log"
+	regionsconfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	"github.com/allegro/akubra/internal/akubra/utils"
+)
+
+// VirtualHostedStyleResolver recognizes '<bucket>.<domain>' hosts of the sharding policies
+// which accept virtual-hosted-style requests
+type VirtualHostedStyleResolver struct {
+	domains              map[string]bool
+	virtualHostedDomains []string
+}
+
+// NewVirtualHostedStyleResolver creates a resolver for the given sharding policies
+func NewVirtualHostedStyleResolver(policies regionsconfig.ShardingPolicies) *VirtualHostedStyleResolver {
+	resolver := &VirtualHostedStyleResolver{domains: make(map[string]bool)}
+	for _, policy := range policies {
+		for _, domain := range policy.Domains {
+			resolver.domains[domain] = true
+			if policy.VirtualHostedStyle {
+				resolver.virtualHostedDomains = append(resolver.virtualHostedDomains, domain)
+			}
+		}
+	}
+	return resolver
+}
+
+// Resolve splits the host into the bucket and the policy domain, ok is false if the host
+// is not a virtual-hosted-style host of any policy
+func (resolver *VirtualHostedStyleResolver) Resolve(host string) (bucket string, domain string, ok bool) {
+	if resolver == nil || resolver.domains[host] {
+		return "", "", false
+	}
+	for _, candidate := range resolver.virtualHostedDomains {
+		suffix := "." + candidate
+		if strings.HasSuffix(host, suffix) && len(candidate) > len(domain) && len(host) > len(suffix) {
+			bucket, domain = strings.TrimSuffix(host, suffix), candidate
+		}
+	}
+	return bucket, domain, domain != ""
+}
+
+// Normalize rewrites virtual-hosted-style requests to path-style requests addressed to the policy domain
+func (resolver *VirtualHostedStyleResolver) Normalize(req *http.Request) *http.Request {
+	if _, normalized := req.Context().Value(utils.ClientAddressingKey).(*utils.ClientAddressing); normalized {
+		return req
+	}
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	bucket, domain, ok := resolver.Resolve(host)
+	if !ok {
+		return req
+	}
+	log.Debugf("Request %s addressed to bucket %s in virtual-hosted-style on domain %s", utils.RequestID(req), bucket, domain)
+	normalizedHost := domain
+	if port != "" {
+		normalizedHost = net.JoinHostPort(domain, port)
+	}
+	req = utils.ToPathStyle(req, bucket, normalizedHost)
+	return req.WithContext(context.WithValue(req.Context(), httphandler.Domain, domain))
+}
+
+// VirtualHostedStyleNormalizer normalizes virtual-hosted-style requests before they reach the wrapped round tripper
+func VirtualHostedStyleNormalizer(policies regionsconfig.ShardingPolicies) httphandler.Decorator {
+	resolver := NewVirtualHostedStyleResolver(policies)
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return virtualHostedStyleNormalizer{resolver: resolver, roundTripper: rt}
+	}
+}
+
+type virtualHostedStyleNormalizer struct {
+	resolver     *VirtualHostedStyleResolver
+	roundTripper http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (normalizer virtualHostedStyleNormalizer) RoundTrip(req *http.Request) (*http.Response, error) {
+	return normalizer.roundTripper.RoundTrip(normalizer.resolver.Normalize(req))
+}
@@ -0,0 +1,72 @@
+package regions
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	"github.com/allegro/akubra/internal/akubra/regions/config"
+	"github.com/allegro/akubra/internal/akubra/sharding"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var virtualHostedPolicies = config.ShardingPolicies{
+	"vhost": {Domains: []string{"akubra.local", "s3.akubra.local"}, VirtualHostedStyle: true},
+	"path":  {Domains: []string{"path.local"}},
+}
+
+func TestVirtualHostedStyleResolverShouldResolveBucketAndDomain(t *testing.T) {
+	resolver := NewVirtualHostedStyleResolver(virtualHostedPolicies)
+	for _, testCase := range []struct {
+		host           string
+		expectedBucket string
+		expectedDomain string
+		expectedOk     bool
+	}{
+		{"bucket.akubra.local", "bucket", "akubra.local", true},
+		{"bucket.s3.akubra.local", "bucket", "s3.akubra.local", true},
+		{"my.dotted.bucket.akubra.local", "my.dotted.bucket", "akubra.local", true},
+		{"s3.akubra.local", "", "", false},
+		{"akubra.local", "", "", false},
+		{"bucket.path.local", "", "", false},
+		{"bucket.other.local", "", "", false},
+	} {
+		bucket, domain, ok := resolver.Resolve(testCase.host)
+		assert.Equal(t, testCase.expectedOk, ok, testCase.host)
+		assert.Equal(t, testCase.expectedBucket, bucket, testCase.host)
+		assert.Equal(t, testCase.expectedDomain, domain, testCase.host)
+	}
+}
+
+func TestVirtualHostedStyleResolverShouldNormalizeRequest(t *testing.T) {
+	resolver := NewVirtualHostedStyleResolver(virtualHostedPolicies)
+	req, _ := http.NewRequest(http.MethodPut, "http://bucket.akubra.local:8080/some/key", nil)
+	req = req.WithContext(context.WithValue(req.Context(), httphandler.Domain, "bucket.akubra.local"))
+
+	normalized := resolver.Normalize(req)
+
+	assert.Equal(t, "akubra.local:8080", normalized.Host)
+	assert.Equal(t, "/bucket/some/key", normalized.URL.Path)
+	assert.Equal(t, "akubra.local", normalized.Context().Value(httphandler.Domain))
+	assert.Equal(t, "bucket.akubra.local:8080", utils.ClientAddressedRequest(normalized).Host)
+	assert.Equal(t, normalized, resolver.Normalize(normalized))
+}
+
+func TestRegionsShouldRouteVirtualHostedStyleRequestToParentDomain(t *testing.T) {
+	regions := &Regions{multiCluters: make(map[string]sharding.ShardsRingAPI)}
+	shardsRingMock := &ShardsRingMock{}
+	shardsRingMock.On("GetRingProps").Return(&sharding.RingProps{ConsistencyLevel: config.None})
+	shardsRingMock.On("DoRequest", mock.Anything).Return(&http.Response{StatusCode: 200})
+	regions.assignShardsRing("akubra.local", shardsRingMock)
+	req, _ := http.NewRequest(http.MethodGet, "http://bucket.akubra.local/key", nil)
+
+	response, err := VirtualHostedStyleNormalizer(virtualHostedPolicies)(regions).RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	routedRequest := shardsRingMock.Calls[1].Arguments.Get(0).(*http.Request)
+	assert.Equal(t, "/bucket/key", routedRequest.URL.Path)
+}
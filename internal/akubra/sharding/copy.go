@@ -0,0 +1,263 @@
+package sharding
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/types"
+	"github.com/allegro/akubra/internal/akubra/utils"
+)
+
+const (
+	copySourceHeader        = "X-Amz-Copy-Source"
+	copySourceRangeHeader   = "X-Amz-Copy-Source-Range"
+	copySourceHeadersPrefix = "X-Amz-Copy-Source-"
+	metadataDirectiveHeader = "X-Amz-Metadata-Directive"
+	taggingDirectiveHeader  = "X-Amz-Tagging-Directive"
+	contentSha256Header     = "X-Amz-Content-Sha256"
+	unsignedPayload         = "UNSIGNED-PAYLOAD"
+	s3TimeFormat            = "2006-01-02T15:04:05.000Z"
+)
+
+// copySourceConditions maps the copy preconditions to the conditional headers of the source read
+var copySourceConditions = map[string]string{
+	"X-Amz-Copy-Source-If-Match":            "If-Match",
+	"X-Amz-Copy-Source-If-None-Match":       "If-None-Match",
+	"X-Amz-Copy-Source-If-Modified-Since":   "If-Modified-Since",
+	"X-Amz-Copy-Source-If-Unmodified-Since": "If-Unmodified-Since",
+}
+
+// copiedSourceHeaders are the object headers kept on copy unless the metadata is replaced
+var copiedSourceHeaders = []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Content-Language", "Cache-Control", "Expires"}
+
+// shardAuthorizer checks the client signature without sending the request to the shard
+type shardAuthorizer interface {
+	Authorize(req *http.Request) (*http.Response, error)
+}
+
+// isCopyRequest tells if the request is a CopyObject or an UploadPartCopy request
+func isCopyRequest(req *http.Request) bool {
+	return req.Method == http.MethodPut && req.Header.Get(copySourceHeader) != "" && utils.IsObjectPath(req.URL.Path)
+}
+
+// copySource extracts the path and the version of the copied object from the copy request
+func copySource(req *http.Request) (string, string, error) {
+	source := req.Header.Get(copySourceHeader)
+	versionID := ""
+	if idx := strings.Index(source, "?"); idx >= 0 {
+		query, err := url.ParseQuery(source[idx+1:])
+		if err != nil {
+			return "", "", err
+		}
+		versionID = query.Get("versionId")
+		source = source[:idx]
+	}
+	sourcePath, err := url.PathUnescape(source)
+	if err != nil {
+		return "", "", err
+	}
+	sourcePath = "/" + strings.TrimPrefix(sourcePath, "/")
+	if !utils.IsObjectPath(sourcePath) {
+		return "", "", fmt.Errorf("invalid copy source %q", req.Header.Get(copySourceHeader))
+	}
+	return sourcePath, versionID, nil
+}
+
+// copyObject forwards the copy to the destination shard if the source is kept there too, otherwise the source
+// is read from its shard and streamed to the destination shard as a regular upload
+func (sr ShardsRing) copyObject(req *http.Request) (*http.Response, error) {
+	sourcePath, versionID, err := copySource(req)
+	if err != nil {
+		return nil, err
+	}
+	destinationShard, err := sr.Pick(req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	sourceShard, err := sr.Pick(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if sourceShard.Name() == destinationShard.Name() && !sr.isRebalanced(sourcePath) {
+		_, resp, err := sr.call(destinationShard, req)
+		return resp, err
+	}
+
+	reqID := utils.RequestID(req)
+	log.Debugf("Copy of %s to %s across shards %s and %s, reqID %s",
+		sourcePath, req.URL.Path, sourceShard.Name(), destinationShard.Name(), reqID)
+	if authorizer, ok := destinationShard.(shardAuthorizer); ok {
+		resp, err := authorizer.Authorize(req)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+	}
+	// the client signature doesn't match the requests made on its behalf, they are signed again by the storages
+	authorizedCtx := context.WithValue(req.Context(), httphandler.AuthHeader, nil)
+
+	_, sourceResp, err := sr.call(sourceShard, sourceRequest(authorizedCtx, req, sourcePath, versionID))
+	if err != nil {
+		return nil, err
+	}
+	if sourceResp.StatusCode < http.StatusOK || sourceResp.StatusCode >= http.StatusMultipleChoices {
+		return sourceResp, nil
+	}
+
+	body := utils.NewReplayableBody(sourceResp.Body, sr.bodyMemoryBuffer, sr.bodyBufferDirectory)
+	defer body.Release()
+	uploadReq, err := sr.uploadRequest(authorizedCtx, req, sourceResp, body)
+	if err != nil {
+		return nil, err
+	}
+	uploadResp, err := sr.send(destinationShard, uploadReq)
+	if err != nil || uploadResp.StatusCode != http.StatusOK {
+		return uploadResp, err
+	}
+	return copyResponse(req, sourceResp, uploadResp)
+}
+
+// sourceRequest reads the copied object, or its range if a part is copied
+func sourceRequest(ctx context.Context, req *http.Request, sourcePath, versionID string) *http.Request {
+	sourceURL := *req.URL
+	sourceURL.Path = sourcePath
+	sourceURL.RawPath = ""
+	sourceURL.RawQuery = ""
+	if versionID != "" {
+		sourceURL.RawQuery = url.Values{"versionId": {versionID}}.Encode()
+	}
+	sourceReq := req.Clone(ctx)
+	sourceReq.Method = http.MethodGet
+	sourceReq.URL = &sourceURL
+	sourceReq.Body = http.NoBody
+	sourceReq.GetBody = func() (io.ReadCloser, error) {
+		return http.NoBody, nil
+	}
+	sourceReq.ContentLength = 0
+	for headerName := range req.Header {
+		if isCopySourceHeader(headerName) || isObjectHeader(headerName) {
+			sourceReq.Header.Del(headerName)
+		}
+	}
+	for copyCondition, condition := range copySourceConditions {
+		if value := req.Header.Get(copyCondition); value != "" {
+			sourceReq.Header.Set(condition, value)
+		}
+	}
+	if sourceRange := req.Header.Get(copySourceRangeHeader); sourceRange != "" {
+		sourceReq.Header.Set("Range", sourceRange)
+	}
+	sourceReq.Header.Del(metadataDirectiveHeader)
+	sourceReq.Header.Del(taggingDirectiveHeader)
+	sourceReq.Header.Del("Content-Length")
+	sourceReq.Header.Del("Content-Md5")
+	sourceReq.Header.Set(contentSha256Header, unsignedPayload)
+	return sourceReq
+}
+
+// uploadRequest turns the copy request into an upload of the source object
+func (sr ShardsRing) uploadRequest(ctx context.Context, req *http.Request, sourceResp *http.Response, body *utils.ReplayableBody) (*http.Request, error) {
+	uploadReq := req.Clone(ctx)
+	for headerName := range req.Header {
+		if isCopySourceHeader(headerName) {
+			uploadReq.Header.Del(headerName)
+		}
+	}
+	uploadReq.Header.Del(metadataDirectiveHeader)
+	uploadReq.Header.Del(taggingDirectiveHeader)
+	uploadReq.Header.Del("Content-Md5")
+	uploadReq.Header.Set(contentSha256Header, unsignedPayload)
+
+	isPartCopy := utils.IsMultiPartUploadRequest(req)
+	if !isPartCopy && !strings.EqualFold(req.Header.Get(metadataDirectiveHeader), "REPLACE") {
+		for headerName := range req.Header {
+			if isObjectHeader(headerName) {
+				uploadReq.Header.Del(headerName)
+			}
+		}
+		for headerName, values := range sourceResp.Header {
+			if isObjectHeader(headerName) && !strings.EqualFold(headerName, sr.watchdogVersionHeaderName) {
+				uploadReq.Header[headerName] = append([]string{}, values...)
+			}
+		}
+	}
+
+	uploadReq.ContentLength = sourceResp.ContentLength
+	if uploadReq.ContentLength < 0 {
+		return nil, fmt.Errorf("unknown length of copy source %s", req.Header.Get(copySourceHeader))
+	}
+	uploadReq.Header.Set("Content-Length", strconv.FormatInt(uploadReq.ContentLength, 10))
+	uploadReq.GetBody = body.NewReader
+	var err error
+	uploadReq.Body, err = body.NewReader()
+	return uploadReq, err
+}
+
+func isCopySourceHeader(headerName string) bool {
+	canonicalName := http.CanonicalHeaderKey(headerName)
+	return canonicalName == copySourceHeader || strings.HasPrefix(canonicalName, copySourceHeadersPrefix)
+}
+
+func isObjectHeader(headerName string) bool {
+	canonicalName := http.CanonicalHeaderKey(headerName)
+	if strings.HasPrefix(canonicalName, "X-Amz-Meta-") {
+		return true
+	}
+	for _, copiedHeader := range copiedSourceHeaders {
+		if canonicalName == copiedHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// copyResponse answers the client the way the storage answers a native copy
+func copyResponse(req *http.Request, sourceResp, uploadResp *http.Response) (*http.Response, error) {
+	if uploadResp.Body != nil {
+		closeBody(uploadResp, utils.RequestID(req))
+	}
+	lastModified := time.Now().UTC().Format(s3TimeFormat)
+	etag := uploadResp.Header.Get("ETag")
+	var result interface{} = types.CopyObjectResult{LastModified: lastModified, ETag: etag}
+	if utils.IsMultiPartUploadRequest(req) {
+		result = types.CopyPartResult{LastModified: lastModified, ETag: etag}
+	}
+	bodyBytes, err := xml.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	bodyBytes = append([]byte(xml.Header), bodyBytes...)
+
+	header := http.Header{}
+	for _, headerName := range []string{"Date", "Server", "X-Amz-Request-Id", "X-Amz-Id-2", "X-Amz-Version-Id"} {
+		if value := uploadResp.Header.Get(headerName); value != "" {
+			header.Set(headerName, value)
+		}
+	}
+	if sourceVersionID := sourceResp.Header.Get("X-Amz-Version-Id"); sourceVersionID != "" {
+		header.Set("X-Amz-Copy-Source-Version-Id", sourceVersionID)
+	}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	return &http.Response{
+		Status:        uploadResp.Status,
+		StatusCode:    uploadResp.StatusCode,
+		Proto:         uploadResp.Proto,
+		ProtoMajor:    uploadResp.ProtoMajor,
+		ProtoMinor:    uploadResp.ProtoMinor,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(bodyBytes)),
+		ContentLength: int64(len(bodyBytes)),
+		Request:       req,
+	}, nil
+}
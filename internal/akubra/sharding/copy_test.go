@@ -0,0 +1,190 @@
+package sharding
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/allegro/akubra/internal/akubra/storages"
+	"github.com/serialx/hashring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type copyShard struct {
+	mx       sync.Mutex
+	name     string
+	objects  map[string]string
+	requests []*http.Request
+	bodies   []string
+}
+
+func (shard *copyShard) Name() string { return shard.name }
+
+func (shard *copyShard) Backends() []*storages.StorageClient { return nil }
+
+func (shard *copyShard) RoundTrip(req *http.Request) (*http.Response, error) {
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+	body := ""
+	if req.Body != nil {
+		bodyBytes, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(bodyBytes)
+	}
+	shard.requests = append(shard.requests, req)
+	shard.bodies = append(shard.bodies, body)
+	header := http.Header{}
+	if req.Method == http.MethodPut {
+		header.Set("ETag", `"etag"`)
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+	}
+	content, ok := shard.objects[req.URL.Path]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Header: header, Body: ioutil.NopCloser(strings.NewReader("<Error/>")), Request: req}, nil
+	}
+	status := http.StatusOK
+	if byteRange := req.Header.Get("Range"); byteRange != "" {
+		var from, to int
+		_, _ = fmt.Sscanf(byteRange, "bytes=%d-%d", &from, &to)
+		content = content[from : to+1]
+		status = http.StatusPartialContent
+	}
+	header.Set("Content-Type", "text/plain")
+	header.Set("X-Amz-Meta-Color", "blue")
+	header.Set("X-Amz-Meta-Version", "123")
+	return &http.Response{StatusCode: status, Header: header, ContentLength: int64(len(content)),
+		Body: ioutil.NopCloser(strings.NewReader(content)), Request: req}, nil
+}
+
+func newCopyRing(t *testing.T, shards ...*copyShard) ShardsRing {
+	shardClusterMap := make(map[string]storages.NamedShardClient)
+	weights := make(map[string]int)
+	for _, shard := range shards {
+		shardClusterMap[shard.name] = shard
+		weights[shard.name] = 100
+	}
+	return ShardsRing{
+		ring:                      hashring.NewWithWeights(weights),
+		shardClusterMap:           shardClusterMap,
+		clusterRegressionMap:      map[string]storages.NamedShardClient{},
+		ringProps:                 &RingProps{},
+		watchdogVersionHeaderName: "X-Amz-Meta-Version",
+	}
+}
+
+// objectPathOnShard finds an object path that the ring assigns to the shard
+func objectPathOnShard(t *testing.T, ring ShardsRing, shardName, keyPrefix string) string {
+	for idx := 0; idx < 1000; idx++ {
+		path := fmt.Sprintf("/bucket/%s-%d", keyPrefix, idx)
+		if shard, err := ring.Pick(path); err == nil && shard.Name() == shardName {
+			return path
+		}
+	}
+	require.FailNow(t, "no path for shard "+shardName)
+	return ""
+}
+
+func newCopyRequest(t *testing.T, destinationPath, sourcePath string) *http.Request {
+	req, err := http.NewRequest(http.MethodPut, "http://akubra"+destinationPath, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Amz-Copy-Source", strings.TrimPrefix(sourcePath, "/"))
+	req.Header.Set("X-Amz-Meta-Color", "red")
+	return req
+}
+
+func TestShouldStreamCopiedObjectFromSourceShardToDestinationShard(t *testing.T) {
+	source, destination := &copyShard{name: "source", objects: map[string]string{}}, &copyShard{name: "destination"}
+	ring := newCopyRing(t, source, destination)
+	sourcePath, destinationPath := objectPathOnShard(t, ring, "source", "object"), objectPathOnShard(t, ring, "destination", "object")
+	source.objects[sourcePath] = "content"
+
+	resp, err := ring.DoRequest(newCopyRequest(t, destinationPath, sourcePath))
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "<CopyObjectResult")
+	assert.Contains(t, string(body), "<ETag>&#34;etag&#34;</ETag>")
+	require.Len(t, source.requests, 1)
+	assert.Equal(t, http.MethodGet, source.requests[0].Method)
+	assert.Equal(t, sourcePath, source.requests[0].URL.Path)
+	require.Len(t, destination.requests, 1)
+	upload := destination.requests[0]
+	assert.Equal(t, http.MethodPut, upload.Method)
+	assert.Equal(t, destinationPath, upload.URL.Path)
+	assert.Equal(t, "content", destination.bodies[0])
+	assert.Equal(t, int64(len("content")), upload.ContentLength)
+	assert.Empty(t, upload.Header.Get("X-Amz-Copy-Source"))
+	assert.Equal(t, "blue", upload.Header.Get("X-Amz-Meta-Color"))
+	assert.Equal(t, "text/plain", upload.Header.Get("Content-Type"))
+	assert.Empty(t, upload.Header.Get("X-Amz-Meta-Version"))
+}
+
+func TestShouldKeepRequestMetadataOnCopyWithReplaceDirective(t *testing.T) {
+	source, destination := &copyShard{name: "source", objects: map[string]string{}}, &copyShard{name: "destination"}
+	ring := newCopyRing(t, source, destination)
+	sourcePath, destinationPath := objectPathOnShard(t, ring, "source", "object"), objectPathOnShard(t, ring, "destination", "object")
+	source.objects[sourcePath] = "content"
+	req := newCopyRequest(t, destinationPath, sourcePath)
+	req.Header.Set("X-Amz-Metadata-Directive", "REPLACE")
+
+	_, err := ring.DoRequest(req)
+	require.NoError(t, err)
+
+	require.Len(t, destination.requests, 1)
+	assert.Equal(t, "red", destination.requests[0].Header.Get("X-Amz-Meta-Color"))
+	assert.Empty(t, destination.requests[0].Header.Get("X-Amz-Metadata-Directive"))
+}
+
+func TestShouldCopyPartRangeFromSourceShard(t *testing.T) {
+	source, destination := &copyShard{name: "source", objects: map[string]string{}}, &copyShard{name: "destination"}
+	ring := newCopyRing(t, source, destination)
+	sourcePath, destinationPath := objectPathOnShard(t, ring, "source", "object"), objectPathOnShard(t, ring, "destination", "object")
+	source.objects[sourcePath] = "content"
+	req := newCopyRequest(t, destinationPath+"?partNumber=2&uploadId=upload", sourcePath)
+	req.Header.Set("X-Amz-Copy-Source-Range", "bytes=1-3")
+
+	resp, err := ring.DoRequest(req)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Contains(t, string(body), "<CopyPartResult")
+	assert.Equal(t, "bytes=1-3", source.requests[0].Header.Get("Range"))
+	assert.Equal(t, "ont", destination.bodies[0])
+	assert.Equal(t, "partNumber=2&uploadId=upload", destination.requests[0].URL.RawQuery)
+}
+
+func TestShouldReturnSourceShardResponseIfCopiedObjectIsMissing(t *testing.T) {
+	source, destination := &copyShard{name: "source", objects: map[string]string{}}, &copyShard{name: "destination"}
+	ring := newCopyRing(t, source, destination)
+	sourcePath, destinationPath := objectPathOnShard(t, ring, "source", "object"), objectPathOnShard(t, ring, "destination", "object")
+
+	resp, err := ring.DoRequest(newCopyRequest(t, destinationPath, sourcePath))
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, destination.requests)
+}
+
+func TestShouldForwardCopyWithinShardToTheShard(t *testing.T) {
+	source, destination := &copyShard{name: "source", objects: map[string]string{}}, &copyShard{name: "destination"}
+	ring := newCopyRing(t, source, destination)
+	sourcePath, destinationPath := objectPathOnShard(t, ring, "source", "object"), objectPathOnShard(t, ring, "source", "copy")
+	req := newCopyRequest(t, destinationPath, sourcePath)
+	req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+
+	_, err := ring.DoRequest(req)
+	require.NoError(t, err)
+
+	require.Len(t, source.requests, 1)
+	assert.Equal(t, http.MethodPut, source.requests[0].Method)
+	assert.NotEmpty(t, source.requests[0].Header.Get("X-Amz-Copy-Source"))
+	assert.Empty(t, destination.requests)
+}
@@ -0,0 +1,214 @@
+package sharding
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/storages"
+	"github.com/allegro/akubra/internal/akubra/types"
+	"github.com/allegro/akubra/internal/akubra/utils"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// shardDeleteBatch holds the objects of a multi-object delete which hash to the shard, or which fall back to it
+// if the batch is a cleanup one
+type shardDeleteBatch struct {
+	shard   storages.NamedShardClient
+	cleanup bool
+	objects []types.ObjectIdentifier
+	result  types.DeleteResult
+}
+
+// multiDelete splits the objects of a multi-object delete by their shards, sends a batch delete to each of
+// the shards and merges the results. The objects are deleted from their regression shards too, as reads fall
+// back to them, but only the deletes on the shards of the objects are recorded by the watchdog and reported.
+// The regression shards are cleaned up on a best-effort basis
+func (sr ShardsRing) multiDelete(req *http.Request) (*http.Response, error) {
+	reqID := utils.RequestID(req)
+	deleteRequest, err := utils.ReadDeleteObjectsRequest(req)
+	if err != nil {
+		log.Printf("Unparsable multi-object delete body of req %s, sending it to all shards: %s", reqID, err)
+		return sr.send(sr.allClustersRoundTripper, req)
+	}
+
+	bucket := utils.ExtractBucketFrom(req.URL.Path)
+	batches := make(map[string]*shardDeleteBatch)
+	for _, object := range deleteRequest.Objects {
+		shard, err := sr.Pick(fmt.Sprintf("/%s/%s", bucket, object.Key))
+		if err != nil {
+			return nil, err
+		}
+		for idx, objectShard := range sr.regressionChain(shard) {
+			cleanup := idx > 0
+			batchKey := objectShard.Name()
+			if cleanup {
+				batchKey += "/cleanup"
+			}
+			batch, ok := batches[batchKey]
+			if !ok {
+				batch = &shardDeleteBatch{shard: objectShard, cleanup: cleanup}
+				batches[batchKey] = batch
+			}
+			batch.objects = append(batch.objects, object)
+		}
+	}
+
+	for _, batch := range batches {
+		if authorizer, ok := batch.shard.(shardAuthorizer); ok {
+			resp, err := authorizer.Authorize(req)
+			if resp != nil || err != nil {
+				return resp, err
+			}
+		}
+	}
+	// the client signature doesn't match the batches, they are signed again by the storages
+	authorizedCtx := context.WithValue(req.Context(), httphandler.AuthHeader, nil)
+	cleanupCtx := context.WithValue(authorizedCtx, storages.SkipConsistencyRecord, true)
+
+	wg := sync.WaitGroup{}
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *shardDeleteBatch) {
+			defer wg.Done()
+			if !batch.cleanup {
+				batch.result = sr.deleteBatch(authorizedCtx, req, deleteRequest.Quiet, batch)
+				return
+			}
+			result := sr.deleteBatch(cleanupCtx, req, true, batch)
+			for _, deleteError := range result.Errors {
+				log.Printf("Multi-object delete cleanup of %s on regression shard %s failed for req %s: %s",
+					deleteError.Key, batch.shard.Name(), utils.RequestID(req), deleteError.Message)
+			}
+		}(batch)
+	}
+	wg.Wait()
+	return multiDeleteResponse(req, batches)
+}
+
+// regressionChain returns the shard followed by the shards regressionCall falls back to
+func (sr ShardsRing) regressionChain(shard storages.NamedShardClient) []storages.NamedShardClient {
+	chain := []storages.NamedShardClient{shard}
+	visited := map[string]bool{shard.Name(): true}
+	for {
+		next, ok := sr.clusterRegressionMap[chain[len(chain)-1].Name()]
+		if !ok || visited[next.Name()] {
+			return chain
+		}
+		visited[next.Name()] = true
+		chain = append(chain, next)
+	}
+}
+
+func (sr ShardsRing) deleteBatch(ctx context.Context, req *http.Request, quiet bool, batch *shardDeleteBatch) types.DeleteResult {
+	batchReq, err := deleteBatchRequest(ctx, req, quiet, batch.objects)
+	if err != nil {
+		return failedDeleteResult(batch.objects, err.Error())
+	}
+	resp, err := sr.send(batch.shard, batchReq)
+	if err != nil {
+		log.Printf("Multi-object delete on shard %s failed for req %s: %s", batch.shard.Name(), utils.RequestID(req), err)
+		return failedDeleteResult(batch.objects, err.Error())
+	}
+	defer closeBody(resp, utils.RequestID(req))
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Multi-object delete on shard %s failed for req %s: %s", batch.shard.Name(), utils.RequestID(req), resp.Status)
+		return failedDeleteResult(batch.objects, fmt.Sprintf("shard responded with status %d", resp.StatusCode))
+	}
+	result := types.DeleteResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Printf("Unparsable multi-object delete result of shard %s for req %s: %s", batch.shard.Name(), utils.RequestID(req), err)
+		return failedDeleteResult(batch.objects, "unparsable storage response")
+	}
+	return result
+}
+
+func deleteBatchRequest(ctx context.Context, req *http.Request, quiet bool, objects []types.ObjectIdentifier) (*http.Request, error) {
+	body, err := xml.Marshal(types.DeleteObjectsRequest{Quiet: quiet, Objects: objects})
+	if err != nil {
+		return nil, err
+	}
+	checksum := md5.Sum(body)
+	batchReq := req.Clone(ctx)
+	batchReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	batchReq.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	batchReq.ContentLength = int64(len(body))
+	batchReq.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	batchReq.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(checksum[:]))
+	batchReq.Header.Set(contentSha256Header, unsignedPayload)
+	return batchReq, nil
+}
+
+func failedDeleteResult(objects []types.ObjectIdentifier, message string) types.DeleteResult {
+	result := types.DeleteResult{}
+	for _, object := range objects {
+		result.Errors = append(result.Errors, types.DeleteError{
+			Key:       object.Key,
+			VersionID: object.VersionID,
+			Code:      "InternalError",
+			Message:   message,
+		})
+	}
+	return result
+}
+
+func multiDeleteResponse(req *http.Request, batches map[string]*shardDeleteBatch) (*http.Response, error) {
+	merged := types.DeleteResult{XMLName: xml.Name{Space: s3Namespace, Local: "DeleteResult"}}
+	// only the shards of the objects are reported, the regression shards are cleaned up on a best-effort basis
+	failed := make(map[types.ObjectIdentifier]bool)
+	for _, batch := range batches {
+		for _, deleteError := range batch.result.Errors {
+			object := types.ObjectIdentifier{Key: deleteError.Key, VersionID: deleteError.VersionID}
+			if !failed[object] {
+				failed[object] = true
+				merged.Errors = append(merged.Errors, deleteError)
+			}
+		}
+	}
+	deleted := make(map[types.ObjectIdentifier]bool)
+	for _, batch := range batches {
+		for _, deletedObject := range batch.result.Deleted {
+			object := types.ObjectIdentifier{Key: deletedObject.Key, VersionID: deletedObject.VersionID}
+			if !failed[object] && !deleted[object] {
+				deleted[object] = true
+				merged.Deleted = append(merged.Deleted, deletedObject)
+			}
+		}
+	}
+	sort.SliceStable(merged.Deleted, func(i, j int) bool { return merged.Deleted[i].Key < merged.Deleted[j].Key })
+	sort.SliceStable(merged.Errors, func(i, j int) bool { return merged.Errors[i].Key < merged.Errors[j].Key })
+
+	bodyBytes, err := xml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	bodyBytes = append([]byte(xml.Header), bodyBytes...)
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(bodyBytes)),
+		ContentLength: int64(len(bodyBytes)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,199 @@
+package sharding
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/allegro/akubra/internal/akubra/storages"
+	"github.com/allegro/akubra/internal/akubra/types"
+	"github.com/serialx/hashring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type deleteShard struct {
+	mx          sync.Mutex
+	name        string
+	fail        bool
+	keys        []string
+	checksum    string
+	cleanupKeys []string
+}
+
+func (shard *deleteShard) Name() string { return shard.name }
+
+func (shard *deleteShard) Backends() []*storages.StorageClient { return nil }
+
+func (shard *deleteShard) RoundTrip(req *http.Request) (*http.Response, error) {
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+	if shard.fail {
+		return nil, errors.New("shard unavailable")
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	checksum := md5.Sum(body)
+	shard.checksum = base64.StdEncoding.EncodeToString(checksum[:])
+	deleteRequest := types.DeleteObjectsRequest{}
+	if err := xml.Unmarshal(body, &deleteRequest); err != nil {
+		return nil, err
+	}
+	result := types.DeleteResult{}
+	cleanup, _ := req.Context().Value(storages.SkipConsistencyRecord).(bool)
+	for _, object := range deleteRequest.Objects {
+		shard.keys = append(shard.keys, object.Key)
+		if cleanup {
+			shard.cleanupKeys = append(shard.cleanupKeys, object.Key)
+		}
+		result.Deleted = append(result.Deleted, types.DeletedObject{Key: object.Key})
+	}
+	resultBody, _ := xml.Marshal(result)
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(resultBody)), Request: req}, nil
+}
+
+func newDeleteRing(shards ...*deleteShard) ShardsRing {
+	shardClusterMap := make(map[string]storages.NamedShardClient)
+	weights := make(map[string]int)
+	for _, shard := range shards {
+		shardClusterMap[shard.name] = shard
+		weights[shard.name] = 100
+	}
+	return ShardsRing{
+		ring:                 hashring.NewWithWeights(weights),
+		shardClusterMap:      shardClusterMap,
+		clusterRegressionMap: map[string]storages.NamedShardClient{},
+		ringProps:            &RingProps{},
+	}
+}
+
+func newMultiDeleteRequest(t *testing.T, keys ...string) *http.Request {
+	deleteRequest := types.DeleteObjectsRequest{}
+	for _, key := range keys {
+		deleteRequest.Objects = append(deleteRequest.Objects, types.ObjectIdentifier{Key: key})
+	}
+	body, err := xml.Marshal(deleteRequest)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "http://akubra/bucket?delete", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req
+}
+
+func readDeleteResult(t *testing.T, resp *http.Response) types.DeleteResult {
+	result := types.DeleteResult{}
+	require.NoError(t, xml.NewDecoder(resp.Body).Decode(&result))
+	return result
+}
+
+func keysOfShard(t *testing.T, ring ShardsRing, shardName string, count int) []string {
+	keys := make([]string, 0, count)
+	for idx := 0; idx < 1000 && len(keys) < count; idx++ {
+		key := fmt.Sprintf("object-%d", idx)
+		if shard, err := ring.Pick("/bucket/" + key); err == nil && shard.Name() == shardName {
+			keys = append(keys, key)
+		}
+	}
+	require.Len(t, keys, count)
+	return keys
+}
+
+func TestShouldSplitMultiObjectDeleteByShardsOfTheKeys(t *testing.T) {
+	first, second := &deleteShard{name: "first"}, &deleteShard{name: "second"}
+	ring := newDeleteRing(first, second)
+	firstKeys, secondKeys := keysOfShard(t, ring, "first", 2), keysOfShard(t, ring, "second", 3)
+
+	resp, err := ring.DoRequest(newMultiDeleteRequest(t, append(append([]string{}, firstKeys...), secondKeys...)...))
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.ElementsMatch(t, firstKeys, first.keys)
+	assert.ElementsMatch(t, secondKeys, second.keys)
+	assert.NotEmpty(t, first.checksum)
+	result := readDeleteResult(t, resp)
+	deletedKeys := make([]string, 0, len(result.Deleted))
+	for _, deleted := range result.Deleted {
+		deletedKeys = append(deletedKeys, deleted.Key)
+	}
+	allKeys := append(append([]string{}, firstKeys...), secondKeys...)
+	sort.Strings(allKeys)
+	assert.Equal(t, allKeys, deletedKeys)
+	assert.Empty(t, result.Errors)
+}
+
+func TestShouldReportKeysOfFailedShardAsMultiObjectDeleteErrors(t *testing.T) {
+	first, second := &deleteShard{name: "first"}, &deleteShard{name: "second", fail: true}
+	ring := newDeleteRing(first, second)
+	firstKeys, secondKeys := keysOfShard(t, ring, "first", 1), keysOfShard(t, ring, "second", 1)
+
+	resp, err := ring.DoRequest(newMultiDeleteRequest(t, firstKeys[0], secondKeys[0]))
+	require.NoError(t, err)
+
+	result := readDeleteResult(t, resp)
+	require.Len(t, result.Deleted, 1)
+	assert.Equal(t, firstKeys[0], result.Deleted[0].Key)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, secondKeys[0], result.Errors[0].Key)
+	assert.Equal(t, "InternalError", result.Errors[0].Code)
+}
+
+func TestShouldDeleteObjectsFromTheirRegressionShards(t *testing.T) {
+	first, second, regression := &deleteShard{name: "first"}, &deleteShard{name: "second"}, &deleteShard{name: "regression"}
+	ring := newDeleteRing(first, second)
+	ring.clusterRegressionMap = map[string]storages.NamedShardClient{"first": regression, "regression": first}
+	firstKeys, secondKeys := keysOfShard(t, ring, "first", 2), keysOfShard(t, ring, "second", 1)
+
+	resp, err := ring.DoRequest(newMultiDeleteRequest(t, firstKeys[0], firstKeys[1], secondKeys[0]))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, firstKeys, first.keys)
+	assert.Empty(t, first.cleanupKeys)
+	assert.ElementsMatch(t, firstKeys, regression.keys)
+	assert.ElementsMatch(t, firstKeys, regression.cleanupKeys)
+	assert.ElementsMatch(t, secondKeys, second.keys)
+	assert.Empty(t, second.cleanupKeys)
+	result := readDeleteResult(t, resp)
+	assert.Len(t, result.Deleted, 3)
+	assert.Empty(t, result.Errors)
+}
+
+func TestShouldSplitCleanupOfRegressionShardFromItsOwnObjects(t *testing.T) {
+	first, second := &deleteShard{name: "first"}, &deleteShard{name: "second"}
+	ring := newDeleteRing(first, second)
+	ring.clusterRegressionMap = map[string]storages.NamedShardClient{"first": second}
+	firstKeys, secondKeys := keysOfShard(t, ring, "first", 1), keysOfShard(t, ring, "second", 1)
+
+	resp, err := ring.DoRequest(newMultiDeleteRequest(t, firstKeys[0], secondKeys[0]))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{firstKeys[0], secondKeys[0]}, second.keys)
+	assert.Equal(t, firstKeys, second.cleanupKeys)
+	assert.Len(t, readDeleteResult(t, resp).Deleted, 2)
+}
+
+func TestShouldIgnoreFailedCleanupOfRegressionShard(t *testing.T) {
+	first, regression := &deleteShard{name: "first"}, &deleteShard{name: "regression", fail: true}
+	ring := newDeleteRing(first)
+	ring.clusterRegressionMap = map[string]storages.NamedShardClient{"first": regression}
+
+	resp, err := ring.DoRequest(newMultiDeleteRequest(t, "object"))
+	require.NoError(t, err)
+
+	result := readDeleteResult(t, resp)
+	require.Len(t, result.Deleted, 1)
+	assert.Equal(t, "object", result.Deleted[0].Key)
+	assert.Empty(t, result.Errors)
+}
@@ -0,0 +1,94 @@
+package sharding
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	regionsConfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	"github.com/allegro/akubra/internal/akubra/storages"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/serialx/hashring"
+)
+
+// RingDiff tells which keys are kept by other shards after the shards of a region have changed
+type RingDiff struct {
+	previousRing   *hashring.HashRing
+	currentRing    *hashring.HashRing
+	previousShards []string
+}
+
+// NewRingDiff compares the ring of the previous shards of a region with the ring of the current ones
+func NewRingDiff(previous, current []regionsConfig.Policy) *RingDiff {
+	previousShards := make([]string, 0, len(previous))
+	for _, policy := range previous {
+		previousShards = append(previousShards, policy.ShardName)
+	}
+	return &RingDiff{
+		previousRing:   hashring.NewWithWeights(policiesWeights(previous)),
+		currentRing:    hashring.NewWithWeights(policiesWeights(current)),
+		previousShards: previousShards,
+	}
+}
+
+// Moved returns the previous and the current shard of the key, moved is true if the shards differ
+func (diff *RingDiff) Moved(key string) (from, to string, moved bool) {
+	from, previousOk := diff.previousRing.GetNode(key)
+	to, currentOk := diff.currentRing.GetNode(key)
+	return from, to, previousOk && currentOk && from != to
+}
+
+// PreviousShards returns the names of the shards the keys are moved from
+func (diff *RingDiff) PreviousShards() []string {
+	return diff.previousShards
+}
+
+func policiesWeights(policies []regionsConfig.Policy) map[string]int {
+	res := make(map[string]int)
+	for _, clusterConfig := range policies {
+		res[clusterConfig.ShardName] = int(math.Floor(clusterConfig.Weight * 100))
+	}
+	return res
+}
+
+// call sends the request to the shard, falling back to the regression shards or, while the region is rebalanced,
+// to the shard which kept the object before
+func (sr ShardsRing) call(cl storages.NamedShardClient, req *http.Request) (string, *http.Response, error) {
+	if sr.rebalance == nil {
+		return sr.regressionCall(cl, cl.Name(), req)
+	}
+	return sr.rebalanceCall(cl, req)
+}
+
+// rebalanceCall writes to the shard of the current ring (or its regression shards) only, reads fall back to the
+// shard of the previous ring and its regression shards until brim moves the object
+func (sr ShardsRing) rebalanceCall(cl storages.NamedShardClient, req *http.Request) (string, *http.Response, error) {
+	shardName, resp, err := sr.regressionCall(cl, cl.Name(), req)
+	if !isReadRequest(req) || !shouldCallRegression(req, resp, err) {
+		return shardName, resp, err
+	}
+	from, _, moved := sr.rebalance.Moved(req.URL.Path)
+	previousShard, ok := sr.shardClusterMap[from]
+	if !moved || !ok {
+		return shardName, resp, err
+	}
+	reqID := utils.RequestID(req)
+	if resp != nil && resp.Body != nil {
+		closeBody(resp, reqID)
+	}
+	log.Debugf("Object %s not found on shard %s, reading it from previous shard %s, reqID %s", req.URL.Path, cl.Name(), from, reqID)
+	return sr.regressionCall(previousShard, previousShard.Name(), req)
+}
+
+// isRebalanced tells if the object may still be kept by a shard of the previous ring
+func (sr ShardsRing) isRebalanced(key string) bool {
+	if sr.rebalance == nil {
+		return false
+	}
+	_, _, moved := sr.rebalance.Moved(key)
+	return moved
+}
+
+func isReadRequest(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
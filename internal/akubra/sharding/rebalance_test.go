@@ -0,0 +1,106 @@
+package sharding
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	regionsConfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	"github.com/allegro/akubra/internal/akubra/storages"
+	"github.com/serialx/hashring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRebalancedRing(previous, current *copyShard) ShardsRing {
+	return ShardsRing{
+		ring:                 hashring.NewWithWeights(map[string]int{current.name: 100}),
+		shardClusterMap:      map[string]storages.NamedShardClient{previous.name: previous, current.name: current},
+		clusterRegressionMap: map[string]storages.NamedShardClient{},
+		ringProps:            &RingProps{},
+		rebalance: NewRingDiff(
+			[]regionsConfig.Policy{{ShardName: previous.name, Weight: 1}},
+			[]regionsConfig.Policy{{ShardName: current.name, Weight: 1}}),
+	}
+}
+
+func TestRingDiffShouldTellWhichShardsTheKeyIsMovedBetween(t *testing.T) {
+	diff := NewRingDiff(
+		[]regionsConfig.Policy{{ShardName: "old", Weight: 1}},
+		[]regionsConfig.Policy{{ShardName: "old", Weight: 0}, {ShardName: "new", Weight: 1}})
+
+	from, to, moved := diff.Moved("/bucket/key")
+
+	assert.True(t, moved)
+	assert.Equal(t, "old", from)
+	assert.Equal(t, "new", to)
+	assert.Equal(t, []string{"old"}, diff.PreviousShards())
+}
+
+func TestRingDiffShouldNotMoveKeysOfUnchangedRing(t *testing.T) {
+	policies := []regionsConfig.Policy{{ShardName: "first", Weight: 1}, {ShardName: "second", Weight: 1}}
+	diff := NewRingDiff(policies, policies)
+
+	for _, key := range []string{"/bucket/a", "/bucket/b", "/bucket/c", "/other/d"} {
+		_, _, moved := diff.Moved(key)
+		assert.False(t, moved, key)
+	}
+}
+
+func TestShouldReadObjectFromPreviousShardWhileItIsNotMovedYet(t *testing.T) {
+	previous := &copyShard{name: "previous", objects: map[string]string{"/bucket/object": "content"}}
+	current := &copyShard{name: "current", objects: map[string]string{}}
+	ring := newRebalancedRing(previous, current)
+	req, err := http.NewRequest(http.MethodGet, "http://akubra/bucket/object", nil)
+	require.NoError(t, err)
+	req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+
+	resp, err := ring.DoRequest(req)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "content", string(body))
+	assert.Len(t, current.requests, 1)
+	assert.Len(t, previous.requests, 1)
+}
+
+func TestShouldWriteObjectToCurrentShardOnlyWhileRegionIsRebalanced(t *testing.T) {
+	previous := &copyShard{name: "previous", objects: map[string]string{}}
+	current := &copyShard{name: "current", objects: map[string]string{}}
+	ring := newRebalancedRing(previous, current)
+	req, err := http.NewRequest(http.MethodPut, "http://akubra/bucket/object", strings.NewReader("content"))
+	require.NoError(t, err)
+	req.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(strings.NewReader("content")), nil }
+
+	resp, err := ring.DoRequest(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, current.requests, 1)
+	assert.Equal(t, "content", current.bodies[0])
+	assert.Empty(t, previous.requests)
+}
+
+func TestShouldFallBackToRegressionShardsWhileRegionIsRebalanced(t *testing.T) {
+	previous := &copyShard{name: "previous", objects: map[string]string{}}
+	previousRegression := &copyShard{name: "previous-regression", objects: map[string]string{"/bucket/object": "content"}}
+	current := &copyShard{name: "current", objects: map[string]string{}}
+	ring := newRebalancedRing(previous, current)
+	ring.clusterRegressionMap = map[string]storages.NamedShardClient{previous.name: previousRegression}
+	req, err := http.NewRequest(http.MethodGet, "http://akubra/bucket/object", nil)
+	require.NoError(t, err)
+	req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+
+	resp, err := ring.DoRequest(req)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "content", string(body))
+	assert.Len(t, current.requests, 1)
+	assert.Len(t, previous.requests, 1)
+	assert.Len(t, previousRegression.requests, 1)
+}
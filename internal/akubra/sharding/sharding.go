@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/allegro/akubra/internal/akubra/config"
 	"github.com/allegro/akubra/internal/akubra/watchdog"
-	"math"
 
 	"github.com/allegro/akubra/internal/akubra/log"
 	regionsConfig "github.com/allegro/akubra/internal/akubra/regions/config"
@@ -41,11 +40,7 @@ func (rf RingFactory) createRegressionMap(config regionsConfig.Policies) (map[st
 }
 
 func (rf RingFactory) getRegionClustersWeights(regionCfg regionsConfig.Policies) map[string]int {
-	res := make(map[string]int)
-	for _, clusterConfig := range regionCfg.Shards {
-		res[clusterConfig.ShardName] = int(math.Floor(clusterConfig.Weight * 100))
-	}
-	return res
+	return policiesWeights(regionCfg.Shards)
 }
 
 func (rf RingFactory) makeRegionClusterMap(clientClusters map[string]int) (map[string]storages.NamedShardClient, error) {
@@ -63,8 +58,21 @@ func (rf RingFactory) makeRegionClusterMap(clientClusters map[string]int) (map[s
 // RegionRing returns ShardsRing for region
 func (rf RingFactory) RegionRing(name string, conf config.Config, regionCfg regionsConfig.Policies) (ShardsRingAPI, error) {
 	clustersWeights := rf.getRegionClustersWeights(regionCfg)
+	regionClusters := make(map[string]int, len(clustersWeights))
+	for name, weight := range clustersWeights {
+		regionClusters[name] = weight
+	}
+	var rebalance *RingDiff
+	if len(regionCfg.PreviousShards) > 0 {
+		rebalance = NewRingDiff(regionCfg.PreviousShards, regionCfg.Shards)
+		for _, previousShard := range regionCfg.PreviousShards {
+			if _, ok := regionClusters[previousShard.ShardName]; !ok {
+				regionClusters[previousShard.ShardName] = 0
+			}
+		}
+	}
 
-	shardClusterMap, err := rf.makeRegionClusterMap(clustersWeights)
+	shardClusterMap, err := rf.makeRegionClusterMap(regionClusters)
 	for name, shard := range shardClusterMap {
 		s := shard
 		if rf.consistencyWatchdog != nil {
@@ -102,9 +110,16 @@ func (rf RingFactory) RegionRing(name string, conf config.Config, regionCfg regi
 		allClustersRoundTripper:   allBackendsRoundTripper,
 		watchdogVersionHeaderName: conf.Watchdog.ObjectVersionHeaderName,
 		clusterRegressionMap:      regressionMap,
+		bodyMemoryBuffer:          conf.Service.Server.BodyMemoryBufferSize.SizeInBytes,
+		bodyBufferDirectory:       conf.Service.Server.BodyBufferDirectory,
+		rebalance:                 rebalance,
 		ringProps: &RingProps{
+			Region:           name,
 			ConsistencyLevel: regionCfg.ConsistencyLevel,
 			ReadRepair:       regionCfg.ReadRepair,
+			MultipartFanOut:  regionCfg.MultipartFanOut,
+			WriteQuorum:      regionCfg.WriteQuorum,
+			VerifyReads:      regionCfg.VerifyReads,
 		}}, nil
 }
 
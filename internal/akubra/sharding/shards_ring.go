@@ -23,8 +23,12 @@ const (
 
 //RingProps describes the properties of a ring regarding it's consistency level
 type RingProps struct {
+	Region           string
 	ConsistencyLevel config.ConsistencyLevel
 	ReadRepair       bool
+	MultipartFanOut  bool
+	WriteQuorum      int
+	VerifyReads      bool
 }
 
 // ShardsRingAPI interface
@@ -44,6 +48,9 @@ type ShardsRing struct {
 	clusterRegressionMap      map[string]storages.NamedShardClient
 	ringProps                 *RingProps
 	watchdogVersionHeaderName string
+	bodyMemoryBuffer          int64
+	bodyBufferDirectory       string
+	rebalance                 *RingDiff
 }
 
 func (sr ShardsRing) isBucketPath(path string) bool {
@@ -98,6 +105,9 @@ func (sr ShardsRing) send(roundTripper http.RoundTripper, req *http.Request) (*h
 	if err != nil {
 		return nil, err
 	}
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
 	req.Body = newBody
 	return roundTripper.RoundTrip(req)
 }
@@ -144,17 +154,23 @@ func shouldCallRegression(request *http.Request, response *http.Response, err er
 
 // DoRequest performs http requests to all backends that should be reached within this shards ring and with given method
 func (sr ShardsRing) DoRequest(req *http.Request) (resp *http.Response, rerr error) {
+	if utils.IsMultiDeleteRequest(req) && sr.rebalance == nil {
+		return sr.multiDelete(req)
+	}
 	if req.Method == http.MethodDelete || sr.isBucketPath(req.URL.Path) {
 		return sr.allClustersRoundTripper.RoundTrip(req)
 	}
+	if isCopyRequest(req) {
+		return sr.copyObject(req)
+	}
 
 	cl, err := sr.Pick(req.URL.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	successClusterName, resp, err := sr.regressionCall(cl, cl.Name(), req)
-	if err == nil && req.Method == http.MethodGet && successClusterName != cl.Name() {
+	successClusterName, resp, err := sr.call(cl, req)
+	if err == nil && sr.rebalance == nil && req.Method == http.MethodGet && successClusterName != cl.Name() {
 		utils.PutResponseHeaderToContext(req.Context(), watchdog.ReadRepairObjectVersion, resp, sr.watchdogVersionHeaderName)
 	}
 
@@ -0,0 +1,541 @@
+package storages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/balancing"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+)
+
+const (
+	breakerAutomatic = "auto"
+	breakerOpen      = "open"
+	breakerClosed    = "closed"
+	adminBodyMaxSize = 4 * 1024
+	// inheritedBreakerMaxAge limits the age of the opened breakers restored from the state file
+	inheritedBreakerMaxAge = 10 * time.Minute
+)
+
+var (
+	// ErrStorageNotFound is returned for storages missing in the configuration
+	ErrStorageNotFound = errors.New("no such storage")
+	// ErrStoragesNotReady is returned if no storages were attached to the admin yet
+	ErrStoragesNotReady = errors.New("storages not initialized yet")
+	// ErrUnknownBreakerState is returned for breaker states other than auto, open and closed
+	ErrUnknownBreakerState = errors.New("unknown breaker state")
+)
+
+// StorageState describes the runtime state of a storage
+type StorageState struct {
+	Name                  string         `json:"Name"`
+	Maintenance           bool           `json:"Maintenance"`
+	MaintenanceOverridden bool           `json:"MaintenanceOverridden"`
+	Breakers              []BreakerState `json:"Breakers"`
+	Health                *StorageHealth `json:"Health,omitempty"`
+}
+
+// BreakerState describes the breaker of a storage in a shard
+type BreakerState struct {
+	Shard    string `json:"Shard"`
+	Open     bool   `json:"Open"`
+	Override string `json:"Override"`
+}
+
+// adminState holds the runtime overrides and the last known opened breakers, it is the format of the state file
+type adminState struct {
+	Maintenance  map[string]bool      `json:"Maintenance"`
+	Breakers     map[string]string    `json:"Breakers"`
+	OpenBreakers map[string]time.Time `json:"OpenBreakers,omitempty"`
+}
+
+// BreakerPublisher spreads the breakers opened by the instance to the other instances
+type BreakerPublisher interface {
+	Publish(storage string, openedAt time.Time)
+}
+
+// Admin changes the maintenance mode and the breakers of storages at runtime. The changes are kept
+// across handler reloads and, if the state file is set, across restarts
+type Admin struct {
+	mx        sync.Mutex
+	storages  *Storages
+	state     adminState
+	stateFile string
+	publisher BreakerPublisher
+}
+
+// NewAdmin creates Admin, the overrides are restored from stateFile if it exists
+func NewAdmin(stateFile string) (*Admin, error) {
+	admin := &Admin{
+		stateFile: stateFile,
+		state: adminState{
+			Maintenance:  make(map[string]bool),
+			Breakers:     make(map[string]string),
+			OpenBreakers: make(map[string]time.Time),
+		},
+	}
+	if stateFile == "" {
+		return admin, nil
+	}
+	stateBytes, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return admin, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read storages state file %s: %s", stateFile, err)
+	}
+	if err := json.Unmarshal(stateBytes, &admin.state); err != nil {
+		return nil, fmt.Errorf("corrupted storages state file %s: %s", stateFile, err)
+	}
+	if admin.state.Maintenance == nil {
+		admin.state.Maintenance = make(map[string]bool)
+	}
+	if admin.state.Breakers == nil {
+		admin.state.Breakers = make(map[string]string)
+	}
+	if admin.state.OpenBreakers == nil {
+		admin.state.OpenBreakers = make(map[string]time.Time)
+	}
+	for name, override := range admin.state.Breakers {
+		if _, err := parseBreakerOverride(override); err != nil {
+			return nil, fmt.Errorf("corrupted storages state file %s: storage %s: %s", stateFile, name, err)
+		}
+	}
+	return admin, nil
+}
+
+// Attach applies the overrides to the storages and makes them the subject of further changes
+func (admin *Admin) Attach(storage ClusterStorage) {
+	storages, ok := storage.(*Storages)
+	if !ok {
+		log.Printf("Storages admin: unsupported storages type %T", storage)
+		return
+	}
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	for name, maintenance := range admin.state.Maintenance {
+		if backend, found := storages.Backends[name]; found {
+			backend.SetMaintenance(maintenance)
+			log.Printf("Storages admin: restored storage %q maintenance mode %t", name, maintenance)
+		}
+	}
+	for name, override := range admin.state.Breakers {
+		breakerOverride, _ := parseBreakerOverride(override)
+		for _, measuredStorage := range storages.measuredStorages(name) {
+			measuredStorage.ForceBreaker(breakerOverride)
+		}
+		if _, found := storages.Backends[name]; found {
+			log.Printf("Storages admin: restored storage %q breaker state %s", name, override)
+		}
+	}
+	for name, openedAt := range admin.state.OpenBreakers {
+		if time.Since(openedAt) > inheritedBreakerMaxAge {
+			delete(admin.state.OpenBreakers, name)
+			continue
+		}
+		if _, overridden := admin.state.Breakers[name]; overridden {
+			continue
+		}
+		for _, measuredStorage := range storages.measuredStorages(name) {
+			measuredStorage.Trip()
+		}
+		log.Printf("Storages admin: restored storage %q breaker opened at %s", name, openedAt)
+	}
+	for name := range storages.Backends {
+		for _, measuredStorage := range storages.measuredStorages(name) {
+			measuredStorage.OnBreakerStateChange(admin.breakerStateChanged)
+		}
+	}
+	admin.storages = storages
+}
+
+// SetBreakerPublisher makes the admin publish the breakers opened by the instance
+func (admin *Admin) SetBreakerPublisher(publisher BreakerPublisher) {
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	admin.publisher = publisher
+}
+
+// OpenBreakers returns the storages with opened breakers and the time they were opened at
+func (admin *Admin) OpenBreakers() map[string]time.Time {
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	openBreakers := make(map[string]time.Time, len(admin.state.OpenBreakers))
+	for name, openedAt := range admin.state.OpenBreakers {
+		openBreakers[name] = openedAt
+	}
+	return openBreakers
+}
+
+// TripBreaker opens the breakers of the storage found broken by other instance, the breakers
+// forced with overrides are left intact
+func (admin *Admin) TripBreaker(name string, openedAt time.Time, source string) error {
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	if err := admin.checkStorage(name); err != nil {
+		return err
+	}
+	if _, overridden := admin.state.Breakers[name]; overridden {
+		log.Debugf("Storages admin: storage %q breaker is overridden, ignoring trip from %s", name, source)
+		return nil
+	}
+	for _, measuredStorage := range admin.storages.measuredStorages(name) {
+		measuredStorage.Trip()
+	}
+	admin.state.OpenBreakers[name] = openedAt
+	if err := admin.persist(); err != nil {
+		log.Printf("Storages admin: %s", err)
+	}
+	log.Printf("Storages admin: storage %q breaker tripped by %s", name, source)
+	return nil
+}
+
+// breakerStateChanged records the breakers opened and closed by the instance and publishes the opened ones
+func (admin *Admin) breakerStateChanged(storage *balancing.MeasuredStorage, state string) {
+	admin.mx.Lock()
+	_, known := admin.state.OpenBreakers[storage.Name]
+	switch {
+	case state == metrics.BreakerOpen && !known:
+		admin.state.OpenBreakers[storage.Name] = time.Now()
+	case state == metrics.BreakerClosed && known:
+		delete(admin.state.OpenBreakers, storage.Name)
+	default:
+		admin.mx.Unlock()
+		return
+	}
+	openedAt := admin.state.OpenBreakers[storage.Name]
+	publisher := admin.publisher
+	if err := admin.persist(); err != nil {
+		log.Printf("Storages admin: %s", err)
+	}
+	admin.mx.Unlock()
+	log.Printf("Storages admin: storage %q breaker %s", storage.Name, state)
+	if state == metrics.BreakerOpen && publisher != nil {
+		publisher.Publish(storage.Name, openedAt)
+	}
+}
+
+// List returns the states of all storages sorted by name
+func (admin *Admin) List() ([]StorageState, error) {
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	if admin.storages == nil {
+		return nil, ErrStoragesNotReady
+	}
+	states := make([]StorageState, 0, len(admin.storages.Backends))
+	for name := range admin.storages.Backends {
+		states = append(states, admin.storageState(name))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states, nil
+}
+
+// Get returns the state of the storage
+func (admin *Admin) Get(name string) (StorageState, error) {
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	if err := admin.checkStorage(name); err != nil {
+		return StorageState{}, err
+	}
+	return admin.storageState(name), nil
+}
+
+// SetMaintenance puts the storage into or takes it out of maintenance mode, nil restores the configured mode
+func (admin *Admin) SetMaintenance(name string, maintenance *bool, requester string) (StorageState, error) {
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	if err := admin.checkStorage(name); err != nil {
+		return StorageState{}, err
+	}
+	previous, overridden := admin.state.Maintenance[name]
+	if maintenance == nil {
+		delete(admin.state.Maintenance, name)
+	} else {
+		admin.state.Maintenance[name] = *maintenance
+	}
+	if err := admin.persist(); err != nil {
+		if overridden {
+			admin.state.Maintenance[name] = previous
+		} else {
+			delete(admin.state.Maintenance, name)
+		}
+		return StorageState{}, err
+	}
+	backend := admin.storages.Backends[name]
+	if maintenance == nil {
+		backend.ResetMaintenance()
+		log.Printf("Storages admin: storage %q maintenance mode reset to configured %t by %s", name, backend.IsInMaintenance(), requester)
+	} else {
+		backend.SetMaintenance(*maintenance)
+		log.Printf("Storages admin: storage %q maintenance mode set to %t by %s", name, *maintenance, requester)
+	}
+	return admin.storageState(name), nil
+}
+
+// ForceBreaker forces the breakers of the storage open or closed, "auto" lets them work on their own again
+func (admin *Admin) ForceBreaker(name string, state string, requester string) (StorageState, error) {
+	override, err := parseBreakerOverride(state)
+	if err != nil {
+		return StorageState{}, err
+	}
+	admin.mx.Lock()
+	defer admin.mx.Unlock()
+	if err := admin.checkStorage(name); err != nil {
+		return StorageState{}, err
+	}
+	previous, overridden := admin.state.Breakers[name]
+	if override == balancing.BreakerAutomatic {
+		delete(admin.state.Breakers, name)
+	} else {
+		admin.state.Breakers[name] = state
+	}
+	if err := admin.persist(); err != nil {
+		if overridden {
+			admin.state.Breakers[name] = previous
+		} else {
+			delete(admin.state.Breakers, name)
+		}
+		return StorageState{}, err
+	}
+	for _, measuredStorage := range admin.storages.measuredStorages(name) {
+		measuredStorage.ForceBreaker(override)
+	}
+	log.Printf("Storages admin: storage %q breaker set to %s by %s", name, state, requester)
+	return admin.storageState(name), nil
+}
+
+func (admin *Admin) checkStorage(name string) error {
+	if admin.storages == nil {
+		return ErrStoragesNotReady
+	}
+	if _, ok := admin.storages.Backends[name]; !ok {
+		return ErrStorageNotFound
+	}
+	return nil
+}
+
+func (admin *Admin) storageState(name string) StorageState {
+	backend := admin.storages.Backends[name]
+	state := StorageState{
+		Name:                  name,
+		Maintenance:           backend.IsInMaintenance(),
+		MaintenanceOverridden: backend.IsMaintenanceOverridden(),
+		Breakers:              []BreakerState{},
+	}
+	if health, probed := admin.storages.Health(name); probed {
+		state.Health = &health
+	}
+	shardNames := make([]string, 0)
+	measuredStorages := admin.storages.measuredStorages(name)
+	for shardName := range measuredStorages {
+		shardNames = append(shardNames, shardName)
+	}
+	sort.Strings(shardNames)
+	for _, shardName := range shardNames {
+		measuredStorage := measuredStorages[shardName]
+		state.Breakers = append(state.Breakers, BreakerState{
+			Shard:    shardName,
+			Open:     measuredStorage.IsOpen(),
+			Override: breakerOverrideName(measuredStorage.BreakerOverride()),
+		})
+	}
+	return state
+}
+
+// persist writes the overrides to the state file, the file is replaced atomically
+func (admin *Admin) persist() error {
+	if admin.stateFile == "" {
+		return nil
+	}
+	stateBytes, err := json.MarshalIndent(admin.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(admin.stateFile), filepath.Base(admin.stateFile)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot persist storages state: %s", err)
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	_, err = tmpFile.Write(stateBytes)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), admin.stateFile)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot persist storages state: %s", err)
+	}
+	return nil
+}
+
+// measuredStorages returns the balancer members of the storage by shard name
+func (st *Storages) measuredStorages(name string) map[string]*balancing.MeasuredStorage {
+	measuredStorages := make(map[string]*balancing.MeasuredStorage)
+	for shardName, namedShardClient := range st.ShardClients {
+		shardClient, ok := namedShardClient.(*ShardClient)
+		if !ok || shardClient.balancer == nil {
+			continue
+		}
+		for _, measuredStorage := range shardClient.balancer.MeasuredStorages() {
+			if measuredStorage.Name == name {
+				measuredStorages[shardName] = measuredStorage
+			}
+		}
+	}
+	return measuredStorages
+}
+
+func parseBreakerOverride(state string) (balancing.BreakerOverride, error) {
+	switch state {
+	case breakerAutomatic:
+		return balancing.BreakerAutomatic, nil
+	case breakerOpen:
+		return balancing.BreakerForcedOpen, nil
+	case breakerClosed:
+		return balancing.BreakerForcedClosed, nil
+	}
+	return balancing.BreakerAutomatic, fmt.Errorf("%w %q", ErrUnknownBreakerState, state)
+}
+
+func breakerOverrideName(override balancing.BreakerOverride) string {
+	switch override {
+	case balancing.BreakerForcedOpen:
+		return breakerOpen
+	case balancing.BreakerForcedClosed:
+		return breakerClosed
+	}
+	return breakerAutomatic
+}
+
+// ServeHTTP handles the admin API:
+//
+//	GET /storages - states of all storages
+//	GET /storages/{name} - state of the storage
+//	PUT /storages/{name}/maintenance {"Maintenance": true} - sets the maintenance mode
+//	DELETE /storages/{name}/maintenance - restores the configured maintenance mode
+//	PUT /storages/{name}/breaker {"Breaker": "open"} - forces the breaker "open", "closed" or back to "auto"
+//	DELETE /storages/{name}/breaker - lets the breaker work on its own again
+func (admin *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/storages"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		states, err := admin.List()
+		writeAdminResult(w, states, err)
+		return
+	}
+	segments := strings.Split(path, "/")
+	name := segments[0]
+	requester := r.RemoteAddr
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		state, err := admin.Get(name)
+		writeAdminResult(w, state, err)
+	case len(segments) == 2 && segments[1] == "maintenance" && r.Method == http.MethodPut:
+		var body struct{ Maintenance *bool }
+		if err := decodeAdminBody(r, &body); err != nil || body.Maintenance == nil {
+			writeAdminError(w, http.StatusBadRequest, `expected {"Maintenance": true|false} body`)
+			return
+		}
+		state, err := admin.SetMaintenance(name, body.Maintenance, requester)
+		writeAdminResult(w, state, err)
+	case len(segments) == 2 && segments[1] == "maintenance" && r.Method == http.MethodDelete:
+		state, err := admin.SetMaintenance(name, nil, requester)
+		writeAdminResult(w, state, err)
+	case len(segments) == 2 && segments[1] == "breaker" && r.Method == http.MethodPut:
+		var body struct{ Breaker string }
+		if err := decodeAdminBody(r, &body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, `expected {"Breaker": "open"|"closed"|"auto"} body`)
+			return
+		}
+		state, err := admin.ForceBreaker(name, body.Breaker, requester)
+		writeAdminResult(w, state, err)
+	case len(segments) == 2 && segments[1] == "breaker" && r.Method == http.MethodDelete:
+		state, err := admin.ForceBreaker(name, breakerAutomatic, requester)
+		writeAdminResult(w, state, err)
+	case len(segments) <= 2:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// BreakersHandler exports and imports the opened breakers:
+//
+//	GET /breakers - storages with opened breakers and the time they were opened at
+//	PUT /breakers {"storage": "2006-01-02T15:04:05Z"} - opens the breakers of the storages
+func (admin *Admin) BreakersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeAdminResult(w, admin.OpenBreakers(), nil)
+		case http.MethodPut:
+			var openBreakers map[string]time.Time
+			if err := decodeAdminBody(r, &openBreakers); err != nil {
+				writeAdminError(w, http.StatusBadRequest, `expected {"storage": "opened at"} body`)
+				return
+			}
+			for name, openedAt := range openBreakers {
+				if err := admin.TripBreaker(name, openedAt, r.RemoteAddr); err != nil {
+					writeAdminResult(w, nil, fmt.Errorf("storage %q: %w", name, err))
+					return
+				}
+			}
+			writeAdminResult(w, admin.OpenBreakers(), nil)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func decodeAdminBody(r *http.Request, body interface{}) error {
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Debugf("Cannot close request body: %s", err)
+		}
+	}()
+	return json.NewDecoder(io.LimitReader(r.Body, adminBodyMaxSize)).Decode(body)
+}
+
+func writeAdminResult(w http.ResponseWriter, result interface{}, err error) {
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		if encodeErr := json.NewEncoder(w).Encode(result); encodeErr != nil {
+			log.Printf("Storages admin: cannot write response: %s", encodeErr)
+		}
+	case errors.Is(err, ErrStorageNotFound):
+		writeAdminError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrUnknownBreakerState):
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrStoragesNotReady):
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		log.Printf("Storages admin: %s", err)
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := io.WriteString(w, message); err != nil {
+		log.Debugf("Storages admin: cannot write error response: %s", err)
+	}
+}
@@ -0,0 +1,235 @@
+package storages
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/balancing"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminTestStorages() *Storages {
+	backends := map[string]*StorageClient{
+		"storage1": {Name: "storage1", RoundTripper: http.DefaultTransport},
+		"storage2": {Name: "storage2", RoundTripper: http.DefaultTransport, Storage: config.Storage{Maintenance: true}},
+	}
+	shardStorages := config.Storages{}
+	for _, name := range []string{"storage1", "storage2"} {
+		shardStorages = append(shardStorages, config.StorageBreakerProperties{
+			Name:                           name,
+			BreakerProbeSize:               10,
+			BreakerErrorRate:               0.1,
+			BreakerCallTimeLimit:           metrics.Interval{Duration: time.Second},
+			BreakerCallTimeLimitPercentile: 0.9,
+			BreakerBasicCutOutDuration:     metrics.Interval{Duration: time.Second},
+			BreakerMaxCutOutDuration:       metrics.Interval{Duration: time.Minute},
+			MeterResolution:                metrics.Interval{Duration: time.Second},
+			MeterRetention:                 metrics.Interval{Duration: time.Minute},
+		})
+	}
+	shard := &ShardClient{
+		name:     "shard1",
+		backends: []*StorageClient{backends["storage1"], backends["storage2"]},
+		balancer: balancing.NewBalancerPrioritySet(shardStorages, convertToRoundTrippersMap(backends)),
+	}
+	return &Storages{ShardClients: map[string]NamedShardClient{"shard1": shard}, Backends: backends}
+}
+
+func adminRequest(t *testing.T, admin *Admin, method, path, body string) (*httptest.ResponseRecorder, StorageState) {
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	state := StorageState{}
+	if recorder.Code == http.StatusOK && path != "/storages" {
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &state))
+	}
+	return recorder, state
+}
+
+func TestAdminShouldListStorages(t *testing.T) {
+	admin, err := NewAdmin("")
+	require.NoError(t, err)
+	recorder, _ := adminRequest(t, admin, http.MethodGet, "/storages", "")
+	require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	admin.Attach(newAdminTestStorages())
+	recorder, _ = adminRequest(t, admin, http.MethodGet, "/storages", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var states []StorageState
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &states))
+	require.Len(t, states, 2)
+	require.Equal(t, "storage1", states[0].Name)
+	require.False(t, states[0].Maintenance)
+	require.Equal(t, []BreakerState{{Shard: "shard1", Open: false, Override: "auto"}}, states[0].Breakers)
+	require.Equal(t, "storage2", states[1].Name)
+	require.True(t, states[1].Maintenance)
+	require.False(t, states[1].MaintenanceOverridden)
+}
+
+func TestAdminShouldToggleMaintenance(t *testing.T) {
+	storages := newAdminTestStorages()
+	admin, err := NewAdmin("")
+	require.NoError(t, err)
+	admin.Attach(storages)
+
+	recorder, state := adminRequest(t, admin, http.MethodPut, "/storages/storage1/maintenance", `{"Maintenance": true}`)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.True(t, state.Maintenance)
+	require.True(t, state.MaintenanceOverridden)
+	require.True(t, storages.Backends["storage1"].IsInMaintenance())
+
+	recorder, state = adminRequest(t, admin, http.MethodDelete, "/storages/storage1/maintenance", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.False(t, state.Maintenance)
+	require.False(t, state.MaintenanceOverridden)
+
+	recorder, _ = adminRequest(t, admin, http.MethodPut, "/storages/storage1/maintenance", `{}`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder, _ = adminRequest(t, admin, http.MethodPut, "/storages/unknown/maintenance", `{"Maintenance": true}`)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+	recorder, _ = adminRequest(t, admin, http.MethodPost, "/storages/storage1/maintenance", `{"Maintenance": true}`)
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestAdminShouldForceBreaker(t *testing.T) {
+	storages := newAdminTestStorages()
+	admin, err := NewAdmin("")
+	require.NoError(t, err)
+	admin.Attach(storages)
+
+	recorder, state := adminRequest(t, admin, http.MethodPut, "/storages/storage1/breaker", `{"Breaker": "open"}`)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "open", state.Breakers[0].Override)
+	require.True(t, state.Breakers[0].Open)
+	measuredStorage := storages.measuredStorages("storage1")["shard1"]
+	require.Equal(t, balancing.BreakerForcedOpen, measuredStorage.BreakerOverride())
+	require.False(t, measuredStorage.IsActive())
+
+	recorder, state = adminRequest(t, admin, http.MethodDelete, "/storages/storage1/breaker", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "auto", state.Breakers[0].Override)
+	require.True(t, measuredStorage.IsActive())
+
+	recorder, _ = adminRequest(t, admin, http.MethodPut, "/storages/storage1/breaker", `{"Breaker": "ajar"}`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestAdminShouldRestoreOverridesFromStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "storages-state.json")
+	admin, err := NewAdmin(stateFile)
+	require.NoError(t, err)
+	admin.Attach(newAdminTestStorages())
+	maintenance := false
+	_, err = admin.SetMaintenance("storage2", &maintenance, "test")
+	require.NoError(t, err)
+	_, err = admin.ForceBreaker("storage1", "closed", "test")
+	require.NoError(t, err)
+
+	restoredAdmin, err := NewAdmin(stateFile)
+	require.NoError(t, err)
+	storages := newAdminTestStorages()
+	restoredAdmin.Attach(storages)
+
+	require.False(t, storages.Backends["storage2"].IsInMaintenance())
+	require.Equal(t, balancing.BreakerForcedClosed, storages.measuredStorages("storage1")["shard1"].BreakerOverride())
+}
+
+func TestAdminShouldRefuseCorruptedStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "storages-state.json")
+	require.NoError(t, ioutil.WriteFile(stateFile, []byte(`{"Breakers": {"storage1": "ajar"}}`), 0600))
+
+	admin, err := NewAdmin(stateFile)
+
+	require.Nil(t, admin)
+	require.Error(t, err)
+}
+
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("storage is down")
+}
+
+type breakerPublisherMock struct {
+	published map[string]time.Time
+}
+
+func (publisher *breakerPublisherMock) Publish(storage string, openedAt time.Time) {
+	publisher.published[storage] = openedAt
+}
+
+func TestAdminShouldPersistAndPublishBreakersOpenedByTheInstance(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "storages-state.json")
+	storages := newAdminTestStorages()
+	admin, err := NewAdmin(stateFile)
+	require.NoError(t, err)
+	publisher := &breakerPublisherMock{published: make(map[string]time.Time)}
+	admin.SetBreakerPublisher(publisher)
+	admin.Attach(storages)
+
+	measuredStorage := storages.measuredStorages("storage1")["shard1"]
+	measuredStorage.RoundTripper = failingRoundTripper{}
+	for i := 0; i < 3; i++ {
+		_, _ = measuredStorage.RoundTrip(httptest.NewRequest(http.MethodGet, "/bucket/key", nil))
+	}
+
+	openBreakers := admin.OpenBreakers()
+	require.Contains(t, openBreakers, "storage1")
+	require.Equal(t, openBreakers, publisher.published)
+
+	restoredStorages := newAdminTestStorages()
+	restoredAdmin, err := NewAdmin(stateFile)
+	require.NoError(t, err)
+	restoredAdmin.Attach(restoredStorages)
+	require.False(t, restoredStorages.measuredStorages("storage1")["shard1"].IsActive())
+	require.True(t, restoredStorages.measuredStorages("storage2")["shard1"].IsActive())
+}
+
+func TestAdminShouldNotRestoreOutdatedOpenedBreakers(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "storages-state.json")
+	outdated := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	require.NoError(t, ioutil.WriteFile(stateFile, []byte(`{"OpenBreakers": {"storage1": "`+outdated+`"}}`), 0600))
+	admin, err := NewAdmin(stateFile)
+	require.NoError(t, err)
+	storages := newAdminTestStorages()
+
+	admin.Attach(storages)
+
+	require.True(t, storages.measuredStorages("storage1")["shard1"].IsActive())
+	require.Empty(t, admin.OpenBreakers())
+}
+
+func TestBreakersHandlerShouldImportOpenedBreakers(t *testing.T) {
+	storages := newAdminTestStorages()
+	admin, err := NewAdmin("")
+	require.NoError(t, err)
+	admin.Attach(storages)
+	_, err = admin.ForceBreaker("storage2", "closed", "test")
+	require.NoError(t, err)
+	openedAt := time.Now().UTC().Truncate(time.Second)
+
+	recorder := httptest.NewRecorder()
+	body := `{"storage1": "` + openedAt.Format(time.RFC3339) + `", "storage2": "` + openedAt.Format(time.RFC3339) + `"}`
+	admin.BreakersHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/breakers", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.False(t, storages.measuredStorages("storage1")["shard1"].IsActive())
+	require.True(t, storages.measuredStorages("storage2")["shard1"].IsActive(), "forced breakers should be left intact")
+	var exported map[string]time.Time
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &exported))
+	require.Len(t, exported, 1)
+	require.True(t, openedAt.Equal(exported["storage1"]))
+
+	recorder = httptest.NewRecorder()
+	admin.BreakersHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/breakers", strings.NewReader(`{"unknown": "`+openedAt.Format(time.RFC3339)+`"}`)))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allegro/akubra/external/miniotweak/s3signer"
+	"github.com/allegro/akubra/internal/akubra/crdstore"
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	httphandlerconfig "github.com/allegro/akubra/internal/akubra/httphandler/config"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/utils"
+)
+
+const (
+	// DefaultPresignExpiry is the validity of the presigned URL if the caller doesn't specify it
+	DefaultPresignExpiry = time.Hour
+
+	defaultPresignScheme = "http"
+	defaultPresignRegion = "us-east-1"
+	presignService       = "s3"
+)
+
+var presignMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodHead:   true,
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+}
+
+var presignQueryParams = []string{
+	"X-Amz-Algorithm",
+	"X-Amz-Credential",
+	"X-Amz-Date",
+	"X-Amz-Expires",
+	"X-Amz-SignedHeaders",
+	"X-Amz-Signature",
+	"X-Amz-Security-Token",
+}
+
+// ErrPresignDomainNotServed is returned by PresignKeysResolver if no sharding policy serves the domain
+var ErrPresignDomainNotServed = errors.New("the domain is not served by akubra")
+
+// PresignKeysResolver returns the credentials ShardAuthenticator checks the requests with to the path of the domain,
+// signed with the access key
+type PresignKeysResolver func(domain, method, path, accessKey string) ([]Keys, error)
+
+// PresignedURL is the URL generated by PresignHandler
+type PresignedURL struct {
+	URL     string    `json:"URL"`
+	Method  string    `json:"Method"`
+	Expires time.Time `json:"Expires"`
+}
+
+// PresignHandler generates presigned akubra URLs:
+//
+//	GET /presign?domain=<domain>&bucket=<bucket>&key=<key>&method=<method>&expires=<seconds>
+//
+// The request has to be V4 signed with the credentials the shard serving the URL checks the requests with,
+// the URL is signed with the same credentials, so ShardAuthenticator accepts it and the storage signers
+// re-sign it per storage
+type PresignHandler struct {
+	config      httphandlerconfig.Presign
+	resolveKeys PresignKeysResolver
+}
+
+// NewPresignHandler creates PresignHandler signing the URLs with the credentials returned by resolveKeys
+func NewPresignHandler(config httphandlerconfig.Presign, resolveKeys PresignKeysResolver) *PresignHandler {
+	if config.Scheme == "" {
+		config.Scheme = defaultPresignScheme
+	}
+	if config.Region == "" {
+		config.Region = defaultPresignRegion
+	}
+	return &PresignHandler{config: config, resolveKeys: resolveKeys}
+}
+
+// ServeHTTP implements http.Handler interface
+func (handler *PresignHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	domain := query.Get("domain")
+	bucket := query.Get("bucket")
+	method := strings.ToUpper(query.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	expiry := DefaultPresignExpiry
+	if value := query.Get("expires"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > MaxPresignExpiry {
+			writeResponse(w, ErrorResponse(r, ErrMalformedExpires))
+			return
+		}
+		expiry = time.Duration(seconds) * time.Second
+	}
+	switch {
+	case domain == "":
+		writeResponse(w, domainNotServedResponse(r))
+		return
+	case bucket == "" || strings.Contains(bucket, "/"):
+		writeResponse(w, utils.ResponseS3Error(r, http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid"))
+		return
+	case !presignMethods[method]:
+		writeResponse(w, utils.ResponseS3Error(r, http.StatusBadRequest, "InvalidArgument", "The method can't be presigned"))
+		return
+	}
+
+	path := "/" + bucket
+	if key := query.Get("key"); key != "" {
+		path += "/" + key
+	}
+	keys, errResp := handler.authenticate(r, hostWithoutPort(domain), method, path)
+	if errResp != nil {
+		writeResponse(w, errResp)
+		return
+	}
+	presigned := handler.presign(keys, method, domain, path, expiry)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(presigned); err != nil {
+		log.Printf("Presign: cannot write response: %s", err)
+	}
+}
+
+// authenticate checks the signature of the request with the credentials of the shard serving the path, the response
+// is returned if the caller can't be authenticated or the shard can't accept a presigned URL
+func (handler *PresignHandler) authenticate(r *http.Request, domain, method, path string) (Keys, *http.Response) {
+	authHeader, err := utils.ParseAuthorizationHeader(r.Header.Get("Authorization"))
+	if err != nil {
+		return Keys{}, utils.ResponseForbidden(r)
+	}
+	if authHeader.Version != utils.SignV4Algorithm {
+		return Keys{}, ErrorResponse(r, ErrUnsupportedSignatureVersion)
+	}
+	backendsKeys, err := handler.resolveKeys(domain, method, path, authHeader.AccessKey)
+	switch {
+	case err == ErrPresignDomainNotServed:
+		return Keys{}, domainNotServedResponse(r)
+	case err == crdstore.ErrCredentialsNotFound:
+		return Keys{}, utils.ResponseS3Error(r, http.StatusForbidden, "InvalidAccessKeyId", "The access key Id you provided does not exist in our records.")
+	case err != nil:
+		log.Printf("Presign: failed to fetch the credentials of %s: %s", authHeader.AccessKey, err)
+		return Keys{}, utils.ResponseS3Error(r, http.StatusInternalServerError, "InternalError", "Failed to fetch the credentials")
+	case len(backendsKeys) == 0:
+		return Keys{}, utils.ResponseS3Error(r, http.StatusBadRequest, "InvalidArgument", "The storages of the bucket don't check the signatures")
+	}
+	// ShardAuthenticator checks the URL against the credentials of every storage, a single signature matches
+	// all of them only if they are the same
+	keys := backendsKeys[0]
+	for _, backendKeys := range backendsKeys[1:] {
+		if backendKeys != keys {
+			return Keys{}, utils.ResponseS3Error(r, http.StatusNotImplemented, "NotImplemented", "The storages of the bucket check the signatures with different credentials")
+		}
+	}
+	authReq := r.WithContext(context.WithValue(r.Context(), httphandler.AuthHeader, &authHeader))
+	if errCode := DoesSignMatch(authReq, keys, noHeadersIgnored); errCode != ErrNone {
+		return Keys{}, ErrorResponse(r, errCode)
+	}
+	return keys, nil
+}
+
+func domainNotServedResponse(r *http.Request) *http.Response {
+	return utils.ResponseS3Error(r, http.StatusBadRequest, "InvalidArgument", "The domain is not served by akubra")
+}
+
+func (handler *PresignHandler) presign(keys Keys, method, domain, path string, expiry time.Duration) PresignedURL {
+	req := &http.Request{
+		Method: method,
+		URL:    &url.URL{Scheme: handler.config.Scheme, Host: domain, Path: path},
+		Host:   domain,
+		Header: make(http.Header),
+	}
+	req = s3signer.PreSignV4(req, keys.AccessKeyID, keys.SecretAccessKey, "", handler.config.Region, presignService, int64(expiry/time.Second))
+	signedAt, err := time.Parse(amzDateFormat, req.URL.Query().Get("X-Amz-Date"))
+	if err != nil {
+		signedAt = now().UTC()
+	}
+	return PresignedURL{URL: req.URL.String(), Method: method, Expires: signedAt.Add(expiry)}
+}
+
+// stripPresignedQuery removes the authorization of v4 presigned URL from the query of the request
+func stripPresignedQuery(req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("X-Amz-Algorithm") == "" {
+		return
+	}
+	for _, param := range presignQueryParams {
+		query.Del(param)
+	}
+	strippedURL := *req.URL
+	strippedURL.RawQuery = query.Encode()
+	req.URL = &strippedURL
+}
+
+func hostWithoutPort(hostPort string) string {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return host
+}
+
+func writeResponse(w http.ResponseWriter, resp *http.Response) {
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Debugf("Presign: cannot close response body: %s", err)
+		}
+	}()
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Debugf("Presign: cannot write error response: %s", err)
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/external/miniotweak/s3signer"
+	"github.com/allegro/akubra/internal/akubra/crdstore"
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	httphandlerconfig "github.com/allegro/akubra/internal/akubra/httphandler/config"
+	"github.com/allegro/akubra/internal/akubra/types"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestPresignHandlerShouldGenerateURLsSignedWithCallerCredentials(t *testing.T) {
+	handler := testPresignHandler()
+	req := httptest.NewRequest(http.MethodGet,
+		"http://localhost:8071/presign?domain=akubra.local:8080&bucket=bucket&key=dir/obj&method=put&expires=600", nil)
+	req = s3signer.SignV4(req, "access", "secret", "", "us-east-1", "s3")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var presigned PresignedURL
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&presigned))
+	assert.Equal(t, http.MethodPut, presigned.Method)
+	assert.True(t, strings.HasPrefix(presigned.URL, "https://akubra.local:8080/bucket/dir/obj?"))
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), presigned.Expires, 5*time.Second)
+
+	presignedReq, err := http.NewRequest(presigned.Method, presigned.URL, nil)
+	require.NoError(t, err)
+	authHeader, err := utils.ParsePresignedV4Query(presignedReq.URL.Query())
+	require.NoError(t, err)
+	presignedReq = presignedReq.WithContext(context.WithValue(presignedReq.Context(), httphandler.AuthHeader, &authHeader))
+	assert.Equal(t, ErrNone, DoesSignMatch(presignedReq, Keys{AccessKeyID: "access", SecretAccessKey: "secret"}, nil))
+	assert.Equal(t, ErrSignatureDoesNotMatch, DoesSignMatch(presignedReq, Keys{AccessKeyID: "access", SecretAccessKey: "other"}, nil))
+}
+
+func TestPresignHandlerShouldRejectInvalidRequests(t *testing.T) {
+	signV4 := func(access, secret string) func(req *http.Request) *http.Request {
+		return func(req *http.Request) *http.Request {
+			return s3signer.SignV4(req, access, secret, "", "us-east-1", "s3")
+		}
+	}
+	for _, testCase := range []struct {
+		name           string
+		query          string
+		sign           func(req *http.Request) *http.Request
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"unsigned request", "domain=akubra.local&bucket=bucket",
+			func(req *http.Request) *http.Request { return req }, http.StatusForbidden, "AccessDenied"},
+		{"V2 signed request", "domain=akubra.local&bucket=bucket",
+			func(req *http.Request) *http.Request { return s3signer.SignV2(req, "access", "secret", nil) },
+			http.StatusBadRequest, "InvalidRequest"},
+		{"wrong secret", "domain=akubra.local&bucket=bucket", signV4("access", "other"),
+			http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"unknown access key", "domain=akubra.local&bucket=bucket", signV4("unknown", "secret"),
+			http.StatusForbidden, "InvalidAccessKeyId"},
+		{"unknown domain", "domain=other.local&bucket=bucket", signV4("access", "secret"),
+			http.StatusBadRequest, "InvalidArgument"},
+		{"storages not checking signatures", "domain=passthrough.local&bucket=bucket", signV4("access", "secret"),
+			http.StatusBadRequest, "InvalidArgument"},
+		{"storages with different credentials", "domain=mixed.local&bucket=bucket", signV4("access", "secret"),
+			http.StatusNotImplemented, "NotImplemented"},
+		{"missing bucket", "domain=akubra.local&key=obj", signV4("access", "secret"),
+			http.StatusBadRequest, "InvalidBucketName"},
+		{"unsupported method", "domain=akubra.local&bucket=bucket&method=POST", signV4("access", "secret"),
+			http.StatusBadRequest, "InvalidArgument"},
+		{"too long expiry", "domain=akubra.local&bucket=bucket&expires=604801", signV4("access", "secret"),
+			http.StatusBadRequest, "AuthorizationQueryParametersError"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := testCase.sign(httptest.NewRequest(http.MethodGet, "http://localhost:8071/presign?"+testCase.query, nil))
+			recorder := httptest.NewRecorder()
+			testPresignHandler().ServeHTTP(recorder, req)
+
+			assert.Equal(t, testCase.expectedStatus, recorder.Code)
+			var errorResponse types.ErrorResponse
+			require.NoError(t, xml.NewDecoder(recorder.Body).Decode(&errorResponse))
+			assert.Equal(t, testCase.expectedCode, errorResponse.Code)
+		})
+	}
+}
+
+func TestShouldResignPresignedRequestsWithStorageCredentials(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://akubra.local/bucket/obj?versionId=1", nil)
+	req = s3signer.PreSignV4(req, "access", "secret", "", "eu-central-1", "s3", 600)
+	authHeader, err := utils.ParsePresignedV4Query(req.URL.Query())
+	require.NoError(t, err)
+
+	signed, err := sign(req, authHeader, "storage.local", "storage-access", "storage-secret", noHeadersIgnored, v4IgnoredHeaders)
+	require.NoError(t, err)
+
+	assert.Equal(t, "versionId=1", signed.URL.RawQuery)
+	assert.Equal(t, "storage.local", signed.Host)
+	assert.Contains(t, signed.Header.Get("Authorization"), "Credential=storage-access/")
+	assert.Contains(t, signed.Header.Get("Authorization"), "/eu-central-1/s3/aws4_request")
+	verified, err := s3signer.VerifyV4(signed, "storage-secret")
+	assert.NoError(t, err)
+	assert.True(t, verified)
+}
+
+func TestForceSignShouldDropPresignedQueryAuthorization(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "http://akubra.local/bucket/obj?versionId=1", nil)
+	req = s3signer.PreSignV4(req, "access", "secret", "", "us-east-1", "s3", 600)
+
+	var forwarded *http.Request
+	rt := ForceSignDecorator(Keys{AccessKeyID: "storage-access", SecretAccessKey: "storage-secret"}, "storage.local", "", nil)(
+		roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			forwarded = req
+			return &http.Response{StatusCode: http.StatusOK, Request: req}, nil
+		}))
+	_, err := rt.RoundTrip(req)
+
+	require.NoError(t, err)
+	assert.Equal(t, "versionId=1", forwarded.URL.RawQuery)
+	assert.True(t, strings.HasPrefix(forwarded.Header.Get("Authorization"), "AWS storage-access:"))
+}
+
+func testPresignHandler() *PresignHandler {
+	return NewPresignHandler(httphandlerconfig.Presign{Scheme: "https"}, func(domain, method, path, accessKey string) ([]Keys, error) {
+		keys := Keys{AccessKeyID: "access", SecretAccessKey: "secret"}
+		switch {
+		case domain == "passthrough.local":
+			return nil, nil
+		case domain == "mixed.local":
+			return []Keys{keys, {AccessKeyID: "access", SecretAccessKey: "other"}}, nil
+		case domain != "akubra.local":
+			return nil, ErrPresignDomainNotServed
+		case accessKey != "access":
+			return nil, crdstore.ErrCredentialsNotFound
+		}
+		return []Keys{keys, keys}, nil
+	})
+}
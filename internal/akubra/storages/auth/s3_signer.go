@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -22,9 +23,74 @@ type APIErrorCode int
 const (
 	ErrSignatureDoesNotMatch APIErrorCode = iota
 	ErrUnsupportedSignatureVersion
+	ErrRequestTimeTooSkewed
+	ErrMissingDateHeader
+	ErrExpiredPresignRequest
+	ErrRequestNotReadyYet
+	ErrMalformedPresignedDate
+	ErrMalformedExpires
+	ErrInvalidChunkedUpload
 	ErrNone
 )
 
+const (
+	// MaxClockSkew is the largest accepted difference between the request time and the server time
+	MaxClockSkew = 15 * time.Minute
+	// MaxPresignExpiry is the longest accepted validity of the presigned URL
+	MaxPresignExpiry = 7 * 24 * time.Hour
+
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+type apiError struct {
+	Code           string
+	Description    string
+	HTTPStatusCode int
+}
+
+var apiErrors = map[APIErrorCode]apiError{
+	ErrSignatureDoesNotMatch: {
+		Code:           "SignatureDoesNotMatch",
+		Description:    "The request signature we calculated does not match the signature you provided. Check your key and signing method.",
+		HTTPStatusCode: http.StatusForbidden},
+	ErrUnsupportedSignatureVersion: {
+		Code:           "InvalidRequest",
+		Description:    "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.",
+		HTTPStatusCode: http.StatusBadRequest},
+	ErrRequestTimeTooSkewed: {
+		Code:           "RequestTimeTooSkewed",
+		Description:    "The difference between the request time and the server's time is too large.",
+		HTTPStatusCode: http.StatusForbidden},
+	ErrMissingDateHeader: {
+		Code:           "AccessDenied",
+		Description:    "AWS authentication requires a valid Date or x-amz-date header",
+		HTTPStatusCode: http.StatusForbidden},
+	ErrExpiredPresignRequest: {
+		Code:           "AccessDenied",
+		Description:    "Request has expired",
+		HTTPStatusCode: http.StatusForbidden},
+	ErrRequestNotReadyYet: {
+		Code:           "AccessDenied",
+		Description:    "Request is not valid yet",
+		HTTPStatusCode: http.StatusForbidden},
+	ErrMalformedPresignedDate: {
+		Code:           "AuthorizationQueryParametersError",
+		Description:    "X-Amz-Date must be in the ISO8601 Long Format \"yyyyMMdd'T'HHmmss'Z'\"",
+		HTTPStatusCode: http.StatusBadRequest},
+	ErrMalformedExpires: {
+		Code:           "AuthorizationQueryParametersError",
+		Description:    "X-Amz-Expires must be a number of seconds between 1 and 604800",
+		HTTPStatusCode: http.StatusBadRequest},
+	ErrInvalidChunkedUpload: {
+		Code:           "InvalidRequest",
+		Description:    "The headers of the chunked upload are invalid",
+		HTTPStatusCode: http.StatusBadRequest},
+}
+
+var now = time.Now
+
 var v4IgnoredHeaders = map[string]bool{
 	"Authorization":   true,
 	"Content-Type":    true,
@@ -56,14 +122,10 @@ func DoesSignMatch(r *http.Request, cred Keys, ignoredCanonicalizedHeaders map[s
 			return ErrSignatureDoesNotMatch
 		}
 	case utils.SignV4Algorithm:
-		result, err := s3signer.VerifyV4(r, cred.SecretAccessKey)
-		if err != nil {
-			reqID := r.Context().Value(log.ContextreqIDKey)
-			log.Printf("Error while verifying V4 Signature for request %s: %s", reqID, err)
-		}
-		if !result {
-			return ErrSignatureDoesNotMatch
+		if authHeader.Presigned {
+			return doesPresignedV4SignMatch(r, cred)
 		}
+		return doesV4SignMatch(r, cred)
 	default:
 		return ErrUnsupportedSignatureVersion
 	}
@@ -71,6 +133,102 @@ func DoesSignMatch(r *http.Request, cred Keys, ignoredCanonicalizedHeaders map[s
 	return ErrNone
 }
 
+func doesV4SignMatch(r *http.Request, cred Keys) APIErrorCode {
+	reqTime, err := s3signer.RequestTimeV4(r)
+	if err != nil {
+		return ErrMissingDateHeader
+	}
+	if skew := now().Sub(reqTime); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return ErrRequestTimeTooSkewed
+	}
+	result, err := s3signer.VerifyV4(utils.ClientAddressedRequest(r), cred.SecretAccessKey)
+	if err != nil {
+		log.Printf("Error while verifying V4 Signature for request %s: %s", utils.RequestID(r), err)
+	}
+	if !result {
+		return ErrSignatureDoesNotMatch
+	}
+	return ErrNone
+}
+
+func doesPresignedV4SignMatch(r *http.Request, cred Keys) APIErrorCode {
+	query := r.URL.Query()
+	reqTime, err := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return ErrMalformedPresignedDate
+	}
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires <= 0 || time.Duration(expires)*time.Second > MaxPresignExpiry {
+		return ErrMalformedExpires
+	}
+	currentTime := now()
+	if reqTime.Sub(currentTime) > MaxClockSkew {
+		return ErrRequestNotReadyYet
+	}
+	if currentTime.After(reqTime.Add(time.Duration(expires) * time.Second)) {
+		return ErrExpiredPresignRequest
+	}
+	result, err := s3signer.VerifyPresignedV4(utils.ClientAddressedRequest(r), cred.SecretAccessKey)
+	if err != nil {
+		log.Printf("Error while verifying presigned V4 Signature for request %s: %s", utils.RequestID(r), err)
+	}
+	if !result {
+		return ErrSignatureDoesNotMatch
+	}
+	return ErrNone
+}
+
+//ErrorResponse returns the S3 error response of the code
+func ErrorResponse(req *http.Request, errCode APIErrorCode) *http.Response {
+	apiErr, ok := apiErrors[errCode]
+	if !ok {
+		return utils.ResponseForbidden(req)
+	}
+	return utils.ResponseS3Error(req, apiErr.HTTPStatusCode, apiErr.Code, apiErr.Description)
+}
+
+//WithChunkVerification makes the signature of every chunk of the V4 streaming upload verified with each of
+//the credentials as the body is read, the other requests are returned untouched
+func WithChunkVerification(req *http.Request, creds []Keys) (*http.Request, APIErrorCode) {
+	authHeader, ok := req.Context().Value(httphandler.AuthHeader).(*utils.ParsedAuthorizationHeader)
+	if !ok || authHeader == nil || authHeader.Presigned || authHeader.Version != utils.SignV4Algorithm ||
+		req.Header.Get("X-Amz-Content-Sha256") != streamingPayload || req.Body == nil {
+		return req, ErrNone
+	}
+	secrets := make(map[string]struct{})
+	for _, cred := range creds {
+		secrets[cred.SecretAccessKey] = struct{}{}
+	}
+	clientReq := utils.ClientAddressedRequest(req)
+	verify := func(body io.ReadCloser) (io.ReadCloser, error) {
+		for secret := range secrets {
+			var err error
+			if body, err = s3signer.StreamingVerifyV4(clientReq, secret, body); err != nil {
+				return nil, err
+			}
+		}
+		return body, nil
+	}
+
+	verifiedReq := req.WithContext(req.Context())
+	if req.GetBody != nil {
+		verifiedReq.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return verify(body)
+		}
+	}
+	body, err := verify(req.Body)
+	if err != nil {
+		log.Printf("Failed to verify the chunks of request %s: %s", utils.RequestID(req), err)
+		return req, ErrInvalidChunkedUpload
+	}
+	verifiedReq.Body = body
+	return verifiedReq, ErrNone
+}
+
 // Keys user credentials
 type Keys struct {
 	AccessKeyID     string `json:"access-key" yaml:"AccessKey"`
@@ -97,7 +255,7 @@ type signAuthServiceRoundTripper struct {
 
 // RoundTrip implements http.RoundTripper interface
 func (srt signRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	authHeader, err := utils.ParseAuthorizationHeader(req.Header.Get("Authorization"))
+	authHeader, err := parseRequestAuthorization(req)
 	if err != nil {
 		if err == utils.ErrNoAuthHeader {
 			return srt.rt.RoundTrip(req)
@@ -105,8 +263,8 @@ func (srt signRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 		return &http.Response{StatusCode: http.StatusBadRequest, Request: req}, err
 	}
 	log.Debug("sign round tripper does sign match")
-	if DoesSignMatch(req, Keys{AccessKeyID: srt.keys.AccessKeyID, SecretAccessKey: srt.keys.SecretAccessKey}, srt.ignoredCanonicalizedHeaders) != ErrNone {
-		return &http.Response{StatusCode: http.StatusForbidden, Request: req}, err
+	if errCode := DoesSignMatch(req, Keys{AccessKeyID: srt.keys.AccessKeyID, SecretAccessKey: srt.keys.SecretAccessKey}, srt.ignoredCanonicalizedHeaders); errCode != ErrNone {
+		return ErrorResponse(req, errCode), nil
 	}
 
 	req, err = sign(req, authHeader, srt.host, srt.keys.AccessKeyID, srt.keys.SecretAccessKey, srt.ignoredCanonicalizedHeaders, srt.v4IgnoredHeaders)
@@ -120,7 +278,7 @@ func (srt signRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 func (srt signAuthServiceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	reqID := utils.RequestID(req)
 	log.Debugf("Singning req %s", utils.RequestID(req))
-	authHeader, err := utils.ParseAuthorizationHeader(req.Header.Get("Authorization"))
+	authHeader, err := parseRequestAuthorization(req)
 	if err != nil {
 		if err == utils.ErrNoAuthHeader {
 			return srt.rt.RoundTrip(req)
@@ -157,8 +315,17 @@ func (srt signAuthServiceRoundTripper) RoundTrip(req *http.Request) (*http.Respo
 	return srt.rt.RoundTrip(req)
 }
 
+//parseRequestAuthorization reads the authorization of the request from the header or from the query of v4 presigned URL
+func parseRequestAuthorization(req *http.Request) (utils.ParsedAuthorizationHeader, error) {
+	authHeader, err := utils.ParseAuthorizationHeader(req.Header.Get("Authorization"))
+	if err == utils.ErrNoAuthHeader {
+		return utils.ParsePresignedV4Query(req.URL.Query())
+	}
+	return authHeader, err
+}
+
 func isStreamingRequest(req *http.Request) (bool, int64, error) {
-	if req.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+	if req.Header.Get("X-Amz-Content-Sha256") != streamingPayload {
 		return false, 0, nil
 	}
 	if req.Header.Get("x-amz-decoded-content-length") == "" {
@@ -222,18 +389,26 @@ type forceSignRoundTripper struct {
 // RoundTrip implements http.RoundTripper interface
 func (srt forceSignRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	if srt.shouldBeSigned(req) {
+		stripPresignedQuery(req)
+		markVirtualHost(req, srt.host)
 		req = s3signer.SignV2(req, srt.keys.AccessKeyID, srt.keys.SecretAccessKey, srt.ignoredCanonicalizedHeaders)
 	}
 	return srt.rt.RoundTrip(req)
 }
 
 func sign(req *http.Request, authHeader utils.ParsedAuthorizationHeader, newHost, accessKey, secretKey string, ignoredHeaders, v4IgnoredHeaders map[string]bool) (*http.Request, error) {
-	req.Host = newHost
-	req.URL.Host = newHost
+	host := backendHost(req, newHost)
+	req.Host = host
+	req.URL.Host = host
 	switch authHeader.Version {
 	case utils.SignV2Algorithm:
+		markVirtualHost(req, newHost)
 		return s3signer.SignV2(req, accessKey, secretKey, noHeadersIgnored), nil
 	case utils.SignV4Algorithm:
+		if authHeader.Presigned {
+			stripPresignedQuery(req)
+			req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+		}
 		isStreamingRequest, dataLen, err := isStreamingRequest(req)
 		if isStreamingRequest {
 			if err != nil {
@@ -246,6 +421,21 @@ func sign(req *http.Request, authHeader utils.ParsedAuthorizationHeader, newHost
 	return req, nil
 }
 
+//backendHost keeps the bucket subdomain of requests rewritten to virtual-hosted-style
+func backendHost(req *http.Request, host string) string {
+	if strings.HasSuffix(req.URL.Host, "."+host) {
+		return req.URL.Host
+	}
+	return host
+}
+
+//markVirtualHost lets the V2 signer find the bucket in the host of a virtual-hosted-style request
+func markVirtualHost(req *http.Request, host string) {
+	if strings.HasSuffix(req.Host, "."+host) {
+		req.Header.Set(s3signer.CustomStorageHost, host)
+	}
+}
+
 func (srt forceSignRoundTripper) shouldBeSigned(request *http.Request) bool {
 	if len(srt.methods) == 0 || strings.Contains(srt.methods, request.Method) {
 		return true
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/allegro/akubra/internal/akubra/log"
@@ -22,25 +23,99 @@ type Backend struct {
 	http.RoundTripper
 	Endpoint url.URL
 	Name     string
+	// maintenanceOverride replaces the configured Maintenance flag once set at runtime
+	maintenanceOverride int32
+	// softMaintenance is set by the health prober, the runtime override takes precedence over it
+	softMaintenance int32
+}
+
+const (
+	maintenanceFromConfig int32 = iota
+	maintenanceOn
+	maintenanceOff
+)
+
+// IsInMaintenance tells if the backend is in maintenance mode, taking the runtime override into account
+func (b *Backend) IsInMaintenance() bool {
+	switch atomic.LoadInt32(&b.maintenanceOverride) {
+	case maintenanceOn:
+		return true
+	case maintenanceOff:
+		return false
+	}
+	return b.Maintenance || atomic.LoadInt32(&b.softMaintenance) == 1
+}
+
+// SetSoftMaintenance puts the backend into or takes it out of maintenance mode unless the mode is overridden
+func (b *Backend) SetSoftMaintenance(maintenance bool) {
+	var soft int32
+	if maintenance {
+		soft = 1
+	}
+	atomic.StoreInt32(&b.softMaintenance, soft)
+}
+
+// IsInSoftMaintenance tells if the backend was put into soft maintenance mode
+func (b *Backend) IsInSoftMaintenance() bool {
+	return atomic.LoadInt32(&b.softMaintenance) == 1
+}
+
+// SetMaintenance overrides the configured maintenance mode at runtime
+func (b *Backend) SetMaintenance(maintenance bool) {
+	override := maintenanceOff
+	if maintenance {
+		override = maintenanceOn
+	}
+	atomic.StoreInt32(&b.maintenanceOverride, override)
+}
+
+// ResetMaintenance drops the runtime override, so the configured maintenance mode applies again
+func (b *Backend) ResetMaintenance() {
+	atomic.StoreInt32(&b.maintenanceOverride, maintenanceFromConfig)
+}
+
+// IsMaintenanceOverridden tells if the maintenance mode was set at runtime
+func (b *Backend) IsMaintenanceOverridden() bool {
+	return atomic.LoadInt32(&b.maintenanceOverride) != maintenanceFromConfig
 }
 
 // RoundTrip satisfies http.RoundTripper interface
 func (b *Backend) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	defer b.collectMetrics(resp, err, time.Now())
+	since := time.Now()
+	method := req.Method
+	defer func() { b.collectMetrics(method, resp, err, since) }()
 	req.URL.Host = b.Endpoint.Host
 	req.URL.Scheme = b.Endpoint.Scheme
 
 	reqID := req.Context().Value(log.ContextreqIDKey)
 
-	if b.Maintenance {
+	if b.IsInMaintenance() {
 		log.Debugf("Request %s blocked %s/%s is in maintenance mode", reqID, req.URL.Host, req.URL.Path)
 		utils.SetRequestProcessingMetadata(req, "backendResponse", fmt.Sprintf("%s is in maintenance mode", req.URL.Host))
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
 		return nil, &types.BackendError{HostName: b.Endpoint.Host,
 			OrigErr: types.ErrorBackendMaintenance}
 	}
+	return b.roundTrip(req)
+}
+
+// Probe sends the health probe request to the storage, the maintenance mode doesn't block it
+func (b *Backend) Probe(req *http.Request) (*http.Response, error) {
+	req.URL.Host = b.Endpoint.Host
+	req.URL.Scheme = b.Endpoint.Scheme
+	return b.roundTrip(req)
+}
+
+func (b *Backend) roundTrip(req *http.Request) (resp *http.Response, err error) {
+	reqID := req.Context().Value(log.ContextreqIDKey)
 	if b.BucketPrefix != "" {
 		req = b.addPrefix(req)
 	}
+	if b.AddressingStyle == config.VirtualHostedStyle {
+		req = utils.ToVirtualHostedStyle(req, b.Endpoint.Host)
+	}
 	log.Debugf("Request backend %s, %s, %s", req.URL.Host, req.URL.Path, reqID)
 	resp, oerror := b.RoundTripper.RoundTrip(req)
 	log.Debugf("Response error %s", oerror)
@@ -66,7 +141,7 @@ func (b Backend) addPrefix(req *http.Request) *http.Request {
 	return req
 }
 
-func (b *Backend) collectMetrics(resp *http.Response, err error, since time.Time) {
+func (b *Backend) collectMetrics(method string, resp *http.Response, err error, since time.Time) {
 	metrics.UpdateSince("reqs.backend."+b.Name+".all", since)
 	if err != nil {
 		metrics.UpdateSince("reqs.backend."+b.Name+".err", since)
@@ -74,9 +149,10 @@ func (b *Backend) collectMetrics(resp *http.Response, err error, since time.Time
 	if resp != nil {
 		statusName := fmt.Sprintf("reqs.backend."+b.Name+".status_%d", resp.StatusCode)
 		metrics.UpdateSince(statusName, since)
-		methodName := fmt.Sprintf("reqs.backend."+b.Name+".method_%s", resp.Request.Method)
-		metrics.UpdateSince(methodName, since)
 	}
+	methodName := fmt.Sprintf("reqs.backend."+b.Name+".method_%s", method)
+	metrics.UpdateSince(methodName, since)
+	metrics.ObserveBackendRequest(b.Name, method, resp, err, since)
 }
 
 // Response helps handle responses
@@ -72,3 +72,77 @@ func TestBackendShouldWrapErrorWithBackendError(t *testing.T) {
 	require.True(t, ok)
 	require.Equal(t, host, berr.Backend())
 }
+
+func TestBackendShouldRewriteRequestToVirtualHostedStyle(t *testing.T) {
+	host := "someremote.backend:8080"
+	netURL, err := url.Parse(fmt.Sprintf("http://%s", host))
+	require.NoError(t, err)
+
+	roundtripper := func(req *http.Request) (*http.Response, error) {
+		return &http.Response{Request: req}, nil
+	}
+	storage := config.Storage{Backend: types.YAMLUrl{URL: netURL}, Type: "passthrough", BucketPrefix: "pre-", AddressingStyle: config.VirtualHostedStyle}
+	b := &Backend{Endpoint: *netURL, RoundTripper: &testRt{rt: roundtripper}, Storage: storage}
+
+	r, err := http.NewRequest("GET", "http://localhost:8080/bucket/some/key", nil)
+	require.NoError(t, err)
+
+	resp, err := b.RoundTrip(r)
+	require.NoError(t, err)
+	require.Equal(t, "pre-bucket."+host, resp.Request.URL.Host)
+	require.Equal(t, "pre-bucket."+host, resp.Request.Host)
+	require.Equal(t, "/some/key", resp.Request.URL.Path)
+}
+
+func TestBackendShouldRespectMaintenanceOverride(t *testing.T) {
+	netURL, err := url.Parse("http://someremote.backend:8080")
+	require.NoError(t, err)
+	calls := 0
+	roundtripper := func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{Request: req, StatusCode: http.StatusOK}, nil
+	}
+	b := &Backend{Endpoint: *netURL, RoundTripper: &testRt{rt: roundtripper}, Storage: config.Storage{Maintenance: true}}
+	require.True(t, b.IsInMaintenance())
+	require.False(t, b.IsMaintenanceOverridden())
+
+	b.SetMaintenance(false)
+	r, err := http.NewRequest("GET", "http://localhost:8080/bucket/key", nil)
+	require.NoError(t, err)
+	_, err = b.RoundTrip(r)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+	require.True(t, b.IsMaintenanceOverridden())
+
+	b.ResetMaintenance()
+	r, err = http.NewRequest("GET", "http://localhost:8080/bucket/key", nil)
+	require.NoError(t, err)
+	_, err = b.RoundTrip(r)
+	require.Error(t, err)
+	require.Equal(t, types.ErrorBackendMaintenance, err.(*types.BackendError).OrigErr)
+	require.Equal(t, 1, calls)
+}
+
+func TestBackendShouldProbeStorageInSoftMaintenance(t *testing.T) {
+	netURL, err := url.Parse("http://someremote.backend:8080")
+	require.NoError(t, err)
+	probedHost := ""
+	roundtripper := func(req *http.Request) (*http.Response, error) {
+		probedHost = req.URL.Host
+		return &http.Response{Request: req, StatusCode: http.StatusOK}, nil
+	}
+	b := &Backend{Endpoint: *netURL, RoundTripper: &testRt{rt: roundtripper}}
+	b.SetSoftMaintenance(true)
+	require.True(t, b.IsInMaintenance())
+
+	r, err := http.NewRequest(http.MethodHead, "http://localhost:8080/canary", nil)
+	require.NoError(t, err)
+	_, err = b.RoundTrip(r)
+	require.Error(t, err)
+	require.Equal(t, "", probedHost)
+
+	resp, err := b.Probe(r)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "someremote.backend:8080", probedHost)
+}
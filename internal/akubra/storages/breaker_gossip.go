@@ -0,0 +1,121 @@
+package storages
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/httphandler/config"
+	"github.com/allegro/akubra/internal/akubra/log"
+)
+
+const (
+	gossipMessageMaxSize = 1024
+	// gossipMaxClockSkew tolerates the breakers opened "in the future" by the peers with clocks ahead
+	gossipMaxClockSkew = time.Minute
+)
+
+// BreakerTripper opens the breakers of the storages found broken by other instances
+type BreakerTripper interface {
+	TripBreaker(name string, openedAt time.Time, source string) error
+}
+
+type breakerMessage struct {
+	Storage   string    `json:"Storage"`
+	OpenedAt  time.Time `json:"OpenedAt"`
+	Signature string    `json:"Signature"`
+}
+
+// BreakerGossip exchanges the opened breakers with the peers over UDP
+type BreakerGossip struct {
+	conn    net.PacketConn
+	peers   []string
+	secret  []byte
+	tripper BreakerTripper
+}
+
+// NewBreakerGossip listens for the breakers opened by the peers
+func NewBreakerGossip(conf config.BreakerGossip, tripper BreakerTripper) (*BreakerGossip, error) {
+	if conf.Secret == "" {
+		return nil, errors.New("breaker gossip requires a secret")
+	}
+	conn, err := net.ListenPacket("udp", conf.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for breaker gossip on %s: %s", conf.Listen, err)
+	}
+	return &BreakerGossip{conn: conn, peers: conf.Peers, secret: []byte(conf.Secret), tripper: tripper}, nil
+}
+
+// Addr returns the address the gossip listens on
+func (gossip *BreakerGossip) Addr() net.Addr {
+	return gossip.conn.LocalAddr()
+}
+
+// Publish sends the opened breaker to the peers
+func (gossip *BreakerGossip) Publish(storage string, openedAt time.Time) {
+	message := breakerMessage{Storage: storage, OpenedAt: openedAt, Signature: gossip.sign(storage, openedAt)}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Breaker gossip: cannot encode message: %s", err)
+		return
+	}
+	for _, peer := range gossip.peers {
+		peerAddr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			log.Printf("Breaker gossip: cannot resolve peer %s: %s", peer, err)
+			continue
+		}
+		if _, err := gossip.conn.WriteTo(payload, peerAddr); err != nil {
+			log.Printf("Breaker gossip: cannot send storage %q breaker to peer %s: %s", storage, peer, err)
+		}
+	}
+}
+
+// Serve trips the breakers opened by the peers until the gossip is closed
+func (gossip *BreakerGossip) Serve() {
+	buffer := make([]byte, gossipMessageMaxSize)
+	for {
+		size, source, err := gossip.conn.ReadFrom(buffer)
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			log.Printf("Breaker gossip: cannot read message: %s", err)
+			continue
+		}
+		message := breakerMessage{}
+		if err := json.Unmarshal(buffer[:size], &message); err != nil {
+			log.Debugf("Breaker gossip: malformed message from %s: %s", source, err)
+			continue
+		}
+		if !hmac.Equal([]byte(message.Signature), []byte(gossip.sign(message.Storage, message.OpenedAt))) {
+			log.Printf("Breaker gossip: invalid signature of message from %s", source)
+			continue
+		}
+		// the signature doesn't prevent replaying a captured message, so the stale ones are dropped
+		if age := time.Since(message.OpenedAt); age > inheritedBreakerMaxAge || age < -gossipMaxClockSkew {
+			log.Printf("Breaker gossip: dropped storage %q breaker message from %s opened at %s", message.Storage, source, message.OpenedAt)
+			continue
+		}
+		if err := gossip.tripper.TripBreaker(message.Storage, message.OpenedAt, source.String()); err != nil {
+			log.Debugf("Breaker gossip: cannot trip storage %q breaker: %s", message.Storage, err)
+		}
+	}
+}
+
+// Close stops the gossip
+func (gossip *BreakerGossip) Close() error {
+	return gossip.conn.Close()
+}
+
+func (gossip *BreakerGossip) sign(storage string, openedAt time.Time) string {
+	mac := hmac.New(sha256.New, gossip.secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%d", storage, openedAt.UnixNano())
+	return hex.EncodeToString(mac.Sum(nil))
+}
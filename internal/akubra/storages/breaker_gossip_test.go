@@ -0,0 +1,86 @@
+package storages
+
+import (
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/httphandler/config"
+	"github.com/stretchr/testify/require"
+)
+
+type trip struct {
+	storage  string
+	openedAt time.Time
+}
+
+type breakerTripperMock struct {
+	trips chan trip
+}
+
+func (tripper *breakerTripperMock) TripBreaker(name string, openedAt time.Time, source string) error {
+	tripper.trips <- trip{storage: name, openedAt: openedAt}
+	return nil
+}
+
+func newTestGossip(t *testing.T, secret string, peers ...string) (*BreakerGossip, *breakerTripperMock) {
+	tripper := &breakerTripperMock{trips: make(chan trip, 1)}
+	gossip, err := NewBreakerGossip(config.BreakerGossip{Listen: "127.0.0.1:0", Peers: peers, Secret: secret}, tripper)
+	require.NoError(t, err)
+	go gossip.Serve()
+	return gossip, tripper
+}
+
+func TestBreakerGossipShouldTripBreakersOpenedByPeers(t *testing.T) {
+	receiver, tripper := newTestGossip(t, "secret")
+	defer func() { _ = receiver.Close() }()
+	sender, _ := newTestGossip(t, "secret", receiver.Addr().String())
+	defer func() { _ = sender.Close() }()
+	openedAt := time.Now()
+
+	sender.Publish("storage1", openedAt)
+
+	select {
+	case received := <-tripper.trips:
+		require.Equal(t, "storage1", received.storage)
+		require.True(t, openedAt.Equal(received.openedAt))
+	case <-time.After(time.Second):
+		require.Fail(t, "breaker wasn't tripped")
+	}
+}
+
+func TestBreakerGossipShouldDropMessagesWithInvalidSignature(t *testing.T) {
+	receiver, tripper := newTestGossip(t, "secret")
+	defer func() { _ = receiver.Close() }()
+	sender, _ := newTestGossip(t, "other secret", receiver.Addr().String())
+	defer func() { _ = sender.Close() }()
+
+	sender.Publish("storage1", time.Now())
+
+	select {
+	case <-tripper.trips:
+		require.Fail(t, "breaker shouldn't be tripped by unsigned message")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestBreakerGossipShouldDropStaleMessages(t *testing.T) {
+	receiver, tripper := newTestGossip(t, "secret")
+	defer func() { _ = receiver.Close() }()
+	sender, _ := newTestGossip(t, "secret", receiver.Addr().String())
+	defer func() { _ = sender.Close() }()
+
+	sender.Publish("storage1", time.Now().Add(-inheritedBreakerMaxAge-time.Second))
+	sender.Publish("storage1", time.Now().Add(gossipMaxClockSkew+time.Minute))
+
+	select {
+	case <-tripper.trips:
+		require.Fail(t, "breaker shouldn't be tripped by stale message")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestBreakerGossipShouldRequireSecret(t *testing.T) {
+	_, err := NewBreakerGossip(config.BreakerGossip{Listen: "127.0.0.1:0"}, &breakerTripperMock{})
+
+	require.Error(t, err)
+}
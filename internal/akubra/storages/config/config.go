@@ -14,6 +14,16 @@ const (
 	Passthrough = "passthrough"
 )
 
+// AddressingStyle specifies how the bucket is addressed in requests sent to a storage
+type AddressingStyle string
+
+const (
+	//PathStyle puts the bucket name in the path, it's the default
+	PathStyle AddressingStyle = "path"
+	//VirtualHostedStyle puts the bucket name in the host as a subdomain of the storage host
+	VirtualHostedStyle AddressingStyle = "virtual-hosted"
+)
+
 // Storage defines backend
 type Storage struct {
 	Backend      types.YAMLUrl     `yaml:"Backend"`
@@ -21,14 +31,63 @@ type Storage struct {
 	Maintenance  bool              `yaml:"Maintenance"`
 	Properties   map[string]string `yaml:"Properties"`
 	BucketPrefix string            `yaml:"BucketPrefix"`
+	// AddressingStyle of requests sent to the storage, path style is used if empty
+	AddressingStyle AddressingStyle `yaml:"AddressingStyle"`
+	// HealthProbe checks the storage periodically, independently of the client traffic
+	HealthProbe HealthProbe `yaml:"HealthProbe"`
+}
+
+// HealthProbe configures active health probing of a storage
+type HealthProbe struct {
+	// Interval between probes, probing is disabled if not set
+	Interval metrics.Interval `yaml:"Interval"`
+	// Method of the probe request, HEAD is used if empty
+	Method string `yaml:"Method"`
+	// Path of the probe request, e.g. a canary object, "/" is used if empty
+	Path string `yaml:"Path"`
+	// Timeout of the probe request, Interval is used if not set
+	Timeout metrics.Interval `yaml:"Timeout"`
+	// MaintenanceAfter consecutive failed probes puts the storage into soft maintenance, disabled if not set
+	MaintenanceAfter int `yaml:"MaintenanceAfter"`
 }
 
 // StoragesMap is map of Backend
 type StoragesMap map[string]Storage
 
+// BalancingStrategy specifies how the storage serving a read is elected
+type BalancingStrategy string
+
+const (
+	//ResponseTimeBalancing elects the storage with the least time spent on calls, it's the default
+	ResponseTimeBalancing BalancingStrategy = "response-time"
+	//PowerOfTwoChoicesBalancing elects the storage with less requests in flight out of two random ones
+	PowerOfTwoChoicesBalancing BalancingStrategy = "power-of-two-choices"
+	//EWMABalancing elects the storage with the lowest moving average of latency
+	EWMABalancing BalancingStrategy = "ewma"
+	//WeightedRoundRobinBalancing elects the storages in turns proportional to their weights
+	WeightedRoundRobinBalancing BalancingStrategy = "weighted-round-robin"
+	//CostAwareBalancing elects the cheapest storage meeting the latency SLO
+	CostAwareBalancing BalancingStrategy = "cost-aware"
+)
+
 // Shard defines shard storages configuration
 type Shard struct {
 	Storages Storages `yaml:"Storages"`
+	// Balancing strategy electing the storage serving a read, ResponseTimeBalancing is used if empty
+	Balancing BalancingStrategy `yaml:"Balancing"`
+	// LatencySLO of the cost-aware balancing, more expensive storages are used only if cheaper ones exceed it
+	LatencySLO metrics.Interval `yaml:"LatencySLO"`
+	// HedgedReads sends a read to the next best storage too, if the first one is late with the response
+	HedgedReads HedgedReads `yaml:"HedgedReads"`
+}
+
+// HedgedReads configures hedged reads of a shard
+type HedgedReads struct {
+	Enabled bool `yaml:"Enabled"`
+	// Delay after which the hedged request is sent, it's also used until the storage has no call times tracked
+	Delay metrics.Interval `yaml:"Delay"`
+	// DelayPercentile if set, makes the delay the given percentile of the call times tracked for the storage
+	DelayPercentile float64 `yaml:"DelayPercentile"`
 }
 
 // ShardsMap is map of Cluster
@@ -49,4 +108,8 @@ type StorageBreakerProperties struct {
 	Priority                       int              `yaml:"Priority"`
 	MeterResolution                metrics.Interval `yaml:"MeterResolution"`
 	MeterRetention                 metrics.Interval `yaml:"MeterRetention"`
+	// Weight of the storage in the weighted round-robin balancing, 1 if not set
+	Weight int `yaml:"Weight"`
+	// EgressCost of reading from the storage, used by the cost-aware balancing
+	EgressCost float64 `yaml:"EgressCost"`
 }
@@ -0,0 +1,151 @@
+package storages
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+)
+
+const probeRequestID = "health-probe"
+
+// StorageHealth describes the results of the storage health probes
+type StorageHealth struct {
+	Healthy             bool      `json:"Healthy"`
+	ConsecutiveFailures int       `json:"ConsecutiveFailures"`
+	LastProbe           time.Time `json:"LastProbe"`
+	LastError           string    `json:"LastError,omitempty"`
+	SoftMaintenance     bool      `json:"SoftMaintenance"`
+}
+
+// healthProber probes the storages with HealthProbe configured, independently of the client traffic
+type healthProber struct {
+	storages *Storages
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mx       sync.Mutex
+	health   map[string]StorageHealth
+}
+
+func newHealthProber(storages *Storages) *healthProber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &healthProber{storages: storages, ctx: ctx, cancel: cancel, health: make(map[string]StorageHealth)}
+}
+
+// start runs a probing loop for every storage with the probe interval set
+func (prober *healthProber) start() {
+	for name, backend := range prober.storages.Backends {
+		if backend.HealthProbe.Interval.Duration <= 0 {
+			continue
+		}
+		log.Printf("Health prober: probing storage %q every %s", name, backend.HealthProbe.Interval.Duration)
+		prober.wg.Add(1)
+		go prober.run(name, backend)
+	}
+}
+
+func (prober *healthProber) run(name string, backend *StorageClient) {
+	defer prober.wg.Done()
+	ticker := time.NewTicker(backend.HealthProbe.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		prober.probe(name, backend)
+		select {
+		case <-prober.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe sends the probe request and feeds the breakers of the storage with its result
+func (prober *healthProber) probe(name string, backend *StorageClient) {
+	conf := backend.HealthProbe
+	timeout := conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = conf.Interval.Duration
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(prober.ctx, log.ContextreqIDKey, probeRequestID), timeout)
+	defer cancel()
+	method := conf.Method
+	if method == "" {
+		method = http.MethodHead
+	}
+	probeURL := backend.Endpoint
+	probeURL.Path = conf.Path
+	if probeURL.Path == "" {
+		probeURL.Path = "/"
+	}
+	since := time.Now()
+	req, err := http.NewRequestWithContext(ctx, method, probeURL.String(), nil)
+	var resp *http.Response
+	if err == nil {
+		resp, err = backend.Probe(req)
+	}
+	duration := time.Since(since)
+	if resp != nil && resp.Body != nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+	if prober.ctx.Err() != nil {
+		return
+	}
+	healthy := err == nil && resp != nil && resp.StatusCode < http.StatusInternalServerError
+	for _, measuredStorage := range prober.storages.measuredStorages(name) {
+		measuredStorage.RecordProbe(duration, healthy)
+	}
+	metrics.ObserveHealthProbe(name, resp, err, healthy, duration)
+	prober.update(name, backend, resp, err, healthy)
+}
+
+// update records the probe result and toggles the soft maintenance of the storage after sustained failures
+func (prober *healthProber) update(name string, backend *StorageClient, resp *http.Response, err error, healthy bool) {
+	prober.mx.Lock()
+	defer prober.mx.Unlock()
+	health := prober.health[name]
+	health.Healthy = healthy
+	health.LastProbe = time.Now()
+	health.LastError = ""
+	if healthy {
+		health.ConsecutiveFailures = 0
+	} else {
+		health.ConsecutiveFailures++
+		if err != nil {
+			health.LastError = err.Error()
+		} else {
+			health.LastError = resp.Status
+		}
+		log.Debugf("Health prober: storage %q probe failed %d times in a row: %s", name, health.ConsecutiveFailures, health.LastError)
+	}
+	maintenanceAfter := backend.HealthProbe.MaintenanceAfter
+	switch {
+	case !healthy && maintenanceAfter > 0 && health.ConsecutiveFailures >= maintenanceAfter && !backend.IsInSoftMaintenance():
+		backend.SetSoftMaintenance(true)
+		log.Printf("Health prober: storage %q put into soft maintenance after %d failed probes", name, health.ConsecutiveFailures)
+	case healthy && backend.IsInSoftMaintenance():
+		backend.SetSoftMaintenance(false)
+		log.Printf("Health prober: storage %q taken out of soft maintenance", name)
+	}
+	health.SoftMaintenance = backend.IsInSoftMaintenance()
+	prober.health[name] = health
+}
+
+// storageHealth returns the health of the storage, false if it wasn't probed yet
+func (prober *healthProber) storageHealth(name string) (StorageHealth, bool) {
+	prober.mx.Lock()
+	defer prober.mx.Unlock()
+	health, ok := prober.health[name]
+	return health, ok
+}
+
+// close stops probing and waits for the pending probes
+func (prober *healthProber) close() {
+	prober.cancel()
+	prober.wg.Wait()
+}
@@ -0,0 +1,106 @@
+package storages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newProbedTestStorages(t *testing.T, handler http.HandlerFunc, probe config.HealthProbe) (*Storages, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	endpoint, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	storages := newAdminTestStorages()
+	backend := storages.Backends["storage1"]
+	backend.Endpoint = *endpoint
+	backend.HealthProbe = probe
+	storages.prober = newHealthProber(storages)
+	return storages, server
+}
+
+func TestHealthProberShouldFeedBreakersAndToggleSoftMaintenance(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	probedPaths := make(chan string, 10)
+	storages, server := newProbedTestStorages(t, func(w http.ResponseWriter, r *http.Request) {
+		probedPaths <- r.Method + " " + r.URL.Path
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}, config.HealthProbe{Interval: metrics.Interval{Duration: time.Second}, Path: "/canary/object", MaintenanceAfter: 3})
+	defer server.Close()
+	backend := storages.Backends["storage1"]
+	measuredStorage := storages.measuredStorages("storage1")["shard1"]
+
+	for i := 0; i < 2; i++ {
+		storages.prober.probe("storage1", backend)
+	}
+	require.Equal(t, "HEAD /canary/object", <-probedPaths)
+	require.True(t, measuredStorage.IsOpen())
+	require.False(t, backend.IsInMaintenance())
+
+	storages.prober.probe("storage1", backend)
+	health, probed := storages.Health("storage1")
+	require.True(t, probed)
+	require.False(t, health.Healthy)
+	require.Equal(t, 3, health.ConsecutiveFailures)
+	require.Equal(t, "503 Service Unavailable", health.LastError)
+	require.True(t, health.SoftMaintenance)
+	require.True(t, backend.IsInMaintenance())
+
+	backend.SetMaintenance(false)
+	require.False(t, backend.IsInMaintenance(), "maintenance override should take precedence over soft maintenance")
+	backend.ResetMaintenance()
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	storages.prober.probe("storage1", backend)
+	health, _ = storages.Health("storage1")
+	require.True(t, health.Healthy)
+	require.Zero(t, health.ConsecutiveFailures)
+	require.False(t, health.SoftMaintenance)
+	require.False(t, backend.IsInMaintenance())
+
+	_, probed = storages.Health("storage2")
+	require.False(t, probed)
+}
+
+func TestHealthProberShouldProbeOnIntervalUntilClosed(t *testing.T) {
+	probes := int32(0)
+	storages, server := newProbedTestStorages(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}, config.HealthProbe{Interval: metrics.Interval{Duration: 10 * time.Millisecond}})
+	defer server.Close()
+
+	storages.StartHealthProbes()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&probes) >= 3 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, storages.Close())
+	probesAfterClose := atomic.LoadInt32(&probes)
+	time.Sleep(50 * time.Millisecond)
+
+	require.Equal(t, probesAfterClose, atomic.LoadInt32(&probes))
+	health, probed := storages.Health("storage1")
+	require.True(t, probed)
+	require.True(t, health.Healthy)
+}
+
+func TestAdminShouldExposeStorageHealth(t *testing.T) {
+	storages, server := newProbedTestStorages(t, func(w http.ResponseWriter, r *http.Request) {},
+		config.HealthProbe{Interval: metrics.Interval{Duration: time.Second}})
+	defer server.Close()
+	admin, err := NewAdmin("")
+	require.NoError(t, err)
+	admin.Attach(storages)
+
+	storages.prober.probe("storage1", storages.Backends["storage1"])
+
+	recorder, state := adminRequest(t, admin, http.MethodGet, "/storages/storage1", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NotNil(t, state.Health)
+	require.True(t, state.Health.Healthy)
+	_, state = adminRequest(t, admin, http.MethodGet, "/storages/storage2", "")
+	require.Nil(t, state.Health)
+}
@@ -0,0 +1,161 @@
+package storages
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/balancing"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/utils"
+)
+
+type hedgedResponse struct {
+	node   balancing.Node
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+}
+
+// cancelOnCloseBody cancels the request of the winning response once its body is consumed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnCloseBody) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
+func (response *hedgedResponse) isGood() bool {
+	return response.err == nil && response.resp != nil &&
+		response.resp.StatusCode < http.StatusInternalServerError &&
+		response.resp.StatusCode != http.StatusNotFound &&
+		response.resp.StatusCode != http.StatusForbidden
+}
+
+func (response *hedgedResponse) win() (*http.Response, error) {
+	if response.resp == nil || response.resp.Body == nil {
+		response.cancel()
+		return response.resp, response.err
+	}
+	response.resp.Body = &cancelOnCloseBody{ReadCloser: response.resp.Body, cancel: response.cancel}
+	return response.resp, response.err
+}
+
+func (response *hedgedResponse) discard() {
+	defer response.cancel()
+	if response.resp == nil || response.resp.Body == nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, response.resp.Body)
+	_ = response.resp.Body.Close()
+}
+
+// readFromNodes reads from the node, hedging the read with the next best node if the shard is configured to.
+// It returns the nodes which were asked for the response.
+func (shardClient *ShardClient) readFromNodes(req *http.Request, node *balancing.MeasuredStorage, skipNodes []balancing.Node) (*http.Response, []balancing.Node, error) {
+	delay, hedged := shardClient.balancer.HedgeDelay(node)
+	if !hedged {
+		nodeRequest, err := utils.ReplicateRequest(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := node.RoundTrip(nodeRequest)
+		return resp, []balancing.Node{node}, err
+	}
+	return shardClient.hedgedRead(req, node, delay, skipNodes)
+}
+
+// hedgedRead sends the read to the next best node if the first one doesn't respond within the delay,
+// the first good response wins and the other one is cancelled
+func (shardClient *ShardClient) hedgedRead(req *http.Request, node *balancing.MeasuredStorage, delay time.Duration, skipNodes []balancing.Node) (*http.Response, []balancing.Node, error) {
+	responses := make(chan *hedgedResponse, 2)
+	sent := make([]*hedgedResponse, 0, 2)
+	first, err := sendRead(req, node, responses)
+	if err != nil {
+		return nil, nil, err
+	}
+	sent = append(sent, first)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var fallback *hedgedResponse
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			hedge := shardClient.balancer.GetMostAvailable(append(askedNodes(sent), skipNodes...)...)
+			if hedge == nil {
+				continue
+			}
+			hedgeResponse, err := sendRead(req, hedge, responses)
+			if err != nil {
+				log.Debugf("Failed to hedge request %s: %s", utils.RequestID(req), err)
+				continue
+			}
+			log.Debugf("Request %s hedged with storage %s after %s", utils.RequestID(req), hedge.Name, delay)
+			sent = append(sent, hedgeResponse)
+			pending++
+		case response := <-responses:
+			pending--
+			if response.isGood() || (pending == 0 && fallback == nil) {
+				discardOthers(response, fallback, sent, responses, pending)
+				resp, err := response.win()
+				return resp, askedNodes(sent), err
+			}
+			if fallback == nil {
+				fallback = response
+				continue
+			}
+			response.discard()
+		}
+	}
+	resp, err := fallback.win()
+	return resp, askedNodes(sent), err
+}
+
+func sendRead(req *http.Request, node *balancing.MeasuredStorage, responses chan<- *hedgedResponse) (*hedgedResponse, error) {
+	nodeRequest, err := utils.ReplicateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(nodeRequest.Context())
+	response := &hedgedResponse{node: node, cancel: cancel}
+	go func() {
+		resp, err := node.RoundTrip(nodeRequest.WithContext(ctx))
+		response.resp, response.err = resp, err
+		responses <- response
+	}()
+	return response, nil
+}
+
+// discardOthers cancels the requests which lost the race and drains their responses
+func discardOthers(winner, fallback *hedgedResponse, sent []*hedgedResponse, responses <-chan *hedgedResponse, pending int) {
+	for _, response := range sent {
+		if response != winner && response != fallback {
+			response.cancel()
+		}
+	}
+	if fallback != nil && fallback != winner {
+		fallback.discard()
+	}
+	if pending == 0 {
+		return
+	}
+	go func() {
+		for ; pending > 0; pending-- {
+			(<-responses).discard()
+		}
+	}()
+}
+
+func askedNodes(sent []*hedgedResponse) []balancing.Node {
+	nodes := make([]balancing.Node, 0, len(sent))
+	for _, response := range sent {
+		nodes = append(nodes, response.node)
+	}
+	return nodes
+}
@@ -0,0 +1,106 @@
+package storages
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/balancing"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hedgedStorage struct {
+	name      string
+	slow      bool
+	calls     int32
+	cancelled chan struct{}
+}
+
+func (storage *hedgedStorage) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&storage.calls, 1)
+	if storage.slow {
+		select {
+		case <-req.Context().Done():
+			close(storage.cancelled)
+			return nil, req.Context().Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(storage.name)),
+		Request:    req,
+	}, nil
+}
+
+func newHedgedShard(hedging config.HedgedReads, storages ...*hedgedStorage) *ShardClient {
+	clients := make(map[string]*StorageClient)
+	shardStorages := config.Storages{}
+	for _, storage := range storages {
+		clients[storage.name] = &StorageClient{Name: storage.name, RoundTripper: storage}
+		shardStorages = append(shardStorages, config.StorageBreakerProperties{
+			Name:                           storage.name,
+			BreakerProbeSize:               10,
+			BreakerErrorRate:               0.5,
+			BreakerCallTimeLimit:           metrics.Interval{Duration: 10 * time.Second},
+			BreakerCallTimeLimitPercentile: 0.9,
+			BreakerBasicCutOutDuration:     metrics.Interval{Duration: time.Second},
+			BreakerMaxCutOutDuration:       metrics.Interval{Duration: time.Minute},
+			MeterResolution:                metrics.Interval{Duration: time.Second},
+			MeterRetention:                 metrics.Interval{Duration: time.Minute},
+		})
+	}
+	balancer := balancing.NewShardBalancerPrioritySet(config.Shard{Storages: shardStorages, HedgedReads: hedging},
+		convertToRoundTrippersMap(clients))
+	return &ShardClient{name: "shard", balancer: balancer}
+}
+
+func newHedgedReadRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://akubra/bucket/object", nil)
+	req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+	return req
+}
+
+func TestShouldHedgeReadIfStorageDoesNotRespondWithinDelay(t *testing.T) {
+	slow := &hedgedStorage{name: "slow", slow: true, cancelled: make(chan struct{})}
+	fast := &hedgedStorage{name: "fast"}
+	shard := newHedgedShard(config.HedgedReads{Enabled: true, Delay: metrics.Interval{Duration: 10 * time.Millisecond}}, slow, fast)
+
+	resp, err := shard.RoundTrip(newHedgedReadRequest())
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, "fast", string(body))
+	select {
+	case <-slow.cancelled:
+	case <-time.After(time.Second):
+		assert.Fail(t, "request of the storage which lost the race should be cancelled")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slow.calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fast.calls))
+}
+
+func TestShouldNotHedgeReadIfStorageRespondsWithinDelay(t *testing.T) {
+	first := &hedgedStorage{name: "first"}
+	second := &hedgedStorage{name: "second"}
+	shard := newHedgedShard(config.HedgedReads{Enabled: true, Delay: metrics.Interval{Duration: time.Second}}, first, second)
+
+	resp, err := shard.RoundTrip(newHedgedReadRequest())
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "first", string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&first.calls))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&second.calls))
+}
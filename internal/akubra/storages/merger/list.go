@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"sort"
@@ -96,26 +97,27 @@ func (oc *objectsContainer) first(limit int) []fmt.Stringer {
 }
 
 func extractListResults(resp *http.Response) s3datatypes.ListBucketResult {
-	lbr := s3datatypes.ListBucketResult{}
 	if resp.Body == nil {
-		return lbr
-	}
-
-	buf := &bytes.Buffer{}
-	if _, rerr := buf.ReadFrom(resp.Body); rerr != nil {
-		log.Debugf("Problem reading ObjectStore response body, %s", rerr)
-		return lbr
+		return s3datatypes.ListBucketResult{}
 	}
-
-	bodyBytes := buf.Bytes()
-	err := xml.Unmarshal(bodyBytes, &lbr)
+	lbr, err := ParseBucketListResult(resp.Body)
 	if err != nil {
 		log.Debugf("ListBucketResult unmarshalling problem %s", err)
 	}
-
 	return lbr
 }
 
+// ParseBucketListResult reads the bucket listing from the body of a ListObjects response
+func ParseBucketListResult(body io.Reader) (s3datatypes.ListBucketResult, error) {
+	lbr := s3datatypes.ListBucketResult{}
+	buf := &bytes.Buffer{}
+	if _, err := buf.ReadFrom(body); err != nil {
+		return lbr, fmt.Errorf("problem reading ObjectStore response body, %s", err)
+	}
+	err := xml.Unmarshal(buf.Bytes(), &lbr)
+	return lbr, err
+}
+
 func createResultSet(keys objectsContainer, prefixes objectsContainer, maxKeys int, listBucketResult s3datatypes.ListBucketResult) s3datatypes.ListBucketResult {
 	listBucketResult.CommonPrefixes = listBucketResult.CommonPrefixes.FromStringer(prefixes.first(maxKeys))
 	keysCount := maxKeys - len(listBucketResult.CommonPrefixes)
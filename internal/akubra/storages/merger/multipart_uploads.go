@@ -0,0 +1,193 @@
+package merger
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/storages/backend"
+	"github.com/allegro/akubra/internal/akubra/storages/merger/s3datatypes"
+)
+
+// MergeListMultipartUploadsResponses unifies ListMultipartUploads responses from multiple backends
+func MergeListMultipartUploadsResponses(successes []backend.Response) (resp *http.Response, err error) {
+	if len(successes) == 0 {
+		err = fmt.Errorf("No successful responses")
+		return
+	}
+	uploads := objectsContainer{
+		list: make([]fmt.Stringer, 0),
+		set:  make(map[string]struct{}),
+	}
+	prefixes := objectsContainer{
+		list: make([]fmt.Stringer, 0),
+		set:  make(map[string]struct{}),
+	}
+	var listUploadsResult s3datatypes.ListMultipartUploadsResult
+	truncatedAtKey := ""
+	for _, tuple := range successes {
+		resp = tuple.Response
+		listUploadsResult = extractListMultipartUploadsResults(resp)
+		uploads.append(listUploadsResult.Uploads.ToStringer()...)
+		prefixes.append(listUploadsResult.CommonPrefixes.ToStringer()...)
+		if listUploadsResult.IsTruncated && (truncatedAtKey == "" || listUploadsResult.NextKeyMarker < truncatedAtKey) {
+			truncatedAtKey = listUploadsResult.NextKeyMarker
+		}
+		discardErr := tuple.DiscardBody()
+		if discardErr != nil {
+			log.Debug("Response discard error in MergeListMultipartUploadsResponses %s", discardErr)
+		}
+	}
+
+	req := successes[0].Request
+	reqQuery := req.URL.Query()
+	maxUploadsQuery := reqQuery.Get("max-uploads")
+	maxUploads, err := strconv.Atoi(maxUploadsQuery)
+	if err != nil {
+		maxUploads = 1000
+	}
+
+	listUploadsResult = createMultipartUploadsResultSet(uploads, prefixes, maxUploads, truncatedAtKey, listUploadsResult)
+
+	bodyBytes, err := xml.Marshal(listUploadsResult)
+	if err != nil {
+		log.Debug("Problem marshalling ObjectStore response body, %s", err)
+		return nil, err
+	}
+	buf := bytes.NewBuffer(bodyBytes)
+	resp.Body = ioutil.NopCloser(buf)
+	resp.ContentLength = int64(buf.Len())
+	resp.Header = http.Header{}
+	resp.Header.Set("content-length", fmt.Sprintf("%d", buf.Len()))
+	resp.Header.Set("content-type", "application/xml")
+	return resp, nil
+}
+
+// MergeFanOutListMultipartUploadsResponses unifies ListMultipartUploads responses of the storages the uploads are
+// replicated to. The storage uploadIds of the same upload are replaced with the client facing uploadId made by
+// fanOutUploadID, so the upload is listed once. The uploads of a key are matched across the storages in the
+// order of their initiation, an upload is matched with at most one upload of every storage
+func MergeFanOutListMultipartUploadsResponses(successes []backend.Response, fanOutUploadID func(backendUploadIDs url.Values) string) (*http.Response, error) {
+	results := make([]s3datatypes.ListMultipartUploadsResult, len(successes))
+	uploadsByKey := make(map[string][]backendUpload)
+	for idx := range successes {
+		results[idx] = extractListMultipartUploadsResults(successes[idx].Response)
+		backendName := ""
+		if successes[idx].Backend != nil {
+			backendName = successes[idx].Backend.Name
+		}
+		for uploadIdx := range results[idx].Uploads {
+			upload := &results[idx].Uploads[uploadIdx]
+			uploadsByKey[upload.Key] = append(uploadsByKey[upload.Key], backendUpload{backend: backendName, upload: upload})
+		}
+	}
+	for _, uploads := range uploadsByKey {
+		sort.SliceStable(uploads, func(i, j int) bool { return uploads[i].upload.Initiated.Before(uploads[j].upload.Initiated) })
+		group := make([]backendUpload, 0, len(uploads))
+		for _, upload := range uploads {
+			if containsBackend(group, upload.backend) {
+				unifyUploads(group, fanOutUploadID)
+				group = group[:0]
+			}
+			group = append(group, upload)
+		}
+		unifyUploads(group, fanOutUploadID)
+	}
+	for idx := range successes {
+		bodyBytes, err := xml.Marshal(results[idx])
+		if err != nil {
+			return nil, err
+		}
+		successes[idx].Response.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+	}
+	return MergeListMultipartUploadsResponses(successes)
+}
+
+type backendUpload struct {
+	backend string
+	upload  *s3datatypes.ObjectMultipartInfo
+}
+
+func containsBackend(uploads []backendUpload, backendName string) bool {
+	for _, upload := range uploads {
+		if upload.backend == backendName {
+			return true
+		}
+	}
+	return false
+}
+
+// unifyUploads gives the uploads of the storages the same uploadId and initiation time, so they are merged
+func unifyUploads(uploads []backendUpload, fanOutUploadID func(backendUploadIDs url.Values) string) {
+	if len(uploads) == 0 {
+		return
+	}
+	backendUploadIDs := url.Values{}
+	for _, upload := range uploads {
+		backendUploadIDs.Set(upload.backend, upload.upload.UploadID)
+	}
+	uploadID := fanOutUploadID(backendUploadIDs)
+	initiated := uploads[0].upload.Initiated
+	for _, upload := range uploads {
+		upload.upload.UploadID = uploadID
+		upload.upload.Initiated = initiated
+	}
+}
+
+func extractListMultipartUploadsResults(resp *http.Response) s3datatypes.ListMultipartUploadsResult {
+	lmur := s3datatypes.ListMultipartUploadsResult{}
+	if resp.Body == nil {
+		return lmur
+	}
+
+	buf := &bytes.Buffer{}
+	if _, rerr := buf.ReadFrom(resp.Body); rerr != nil {
+		log.Debugf("Problem reading ObjectStore response body, %s", rerr)
+		return lmur
+	}
+
+	bodyBytes := buf.Bytes()
+	err := xml.Unmarshal(bodyBytes, &lmur)
+	if err != nil {
+		log.Debugf("ListMultipartUploadsResult unmarshalling problem %s", err)
+	}
+
+	return lmur
+}
+
+// createMultipartUploadsResultSet limits the uploads to max-uploads entries. If any of the backends truncated
+// its listing, the uploads of the keys after its next key marker are left for the next page, otherwise they
+// would be skipped by the client
+func createMultipartUploadsResultSet(uploads objectsContainer, prefixes objectsContainer, maxUploads int, truncatedAtKey string, listUploadsResult s3datatypes.ListMultipartUploadsResult) s3datatypes.ListMultipartUploadsResult {
+	listUploadsResult.CommonPrefixes = listUploadsResult.CommonPrefixes.FromStringer(prefixes.first(maxUploads))
+	uploadsCount := maxUploads - len(listUploadsResult.CommonPrefixes)
+	listUploadsResult.Uploads = listUploadsResult.Uploads.FromStringer(uploads.first(uploadsCount))
+	listUploadsResult.IsTruncated = truncatedAtKey != "" || uploads.Len()+prefixes.Len() > maxUploads
+	if truncatedAtKey != "" {
+		for idx, upload := range listUploadsResult.Uploads {
+			if upload.Key > truncatedAtKey {
+				listUploadsResult.Uploads = listUploadsResult.Uploads[:idx]
+				break
+			}
+		}
+	}
+	listUploadsResult.MaxUploads = int64(maxUploads)
+	listUploadsResult.NextKeyMarker = ""
+	listUploadsResult.NextUploadIDMarker = ""
+	if listUploadsResult.IsTruncated {
+		if len(listUploadsResult.Uploads) > 0 {
+			lastUpload := listUploadsResult.Uploads[len(listUploadsResult.Uploads)-1]
+			listUploadsResult.NextKeyMarker = lastUpload.Key
+			listUploadsResult.NextUploadIDMarker = lastUpload.UploadID
+		} else if len(listUploadsResult.CommonPrefixes) > 0 {
+			listUploadsResult.NextKeyMarker = listUploadsResult.CommonPrefixes[len(listUploadsResult.CommonPrefixes)-1].Prefix
+		}
+	}
+	return listUploadsResult
+}
@@ -0,0 +1,127 @@
+package merger
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/storages/backend"
+	"github.com/allegro/akubra/internal/akubra/storages/merger/s3datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listMultipartUploadsResponse(t *testing.T, maxUploads string, result s3datatypes.ListMultipartUploadsResult) backend.Response {
+	request, err := http.NewRequest(http.MethodGet, "http://localhost/bucket?uploads&max-uploads="+maxUploads, nil)
+	require.NoError(t, err)
+	body, err := xml.Marshal(result)
+	require.NoError(t, err)
+	return backend.Response{
+		Request:  request,
+		Response: &http.Response{Request: request, StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(body))},
+	}
+}
+
+func upload(key, uploadID string, initiated int64) s3datatypes.ObjectMultipartInfo {
+	return s3datatypes.ObjectMultipartInfo{Key: key, UploadID: uploadID, Initiated: time.Unix(initiated, 0).UTC()}
+}
+
+func readListMultipartUploadsResult(t *testing.T, response *http.Response) s3datatypes.ListMultipartUploadsResult {
+	result := s3datatypes.ListMultipartUploadsResult{}
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, xml.Unmarshal(body, &result))
+	return result
+}
+
+func TestShouldMergeMultipartUploadsOfAllBackendsOrderedByKeyAndInitiation(t *testing.T) {
+	first := listMultipartUploadsResponse(t, "", s3datatypes.ListMultipartUploadsResult{
+		Bucket:  "bucket",
+		Uploads: s3datatypes.ObjectMultipartInfos{upload("b", "1-b", 10), upload("a", "1-a", 30)},
+	})
+	second := listMultipartUploadsResponse(t, "", s3datatypes.ListMultipartUploadsResult{
+		Bucket:         "bucket",
+		Uploads:        s3datatypes.ObjectMultipartInfos{upload("a", "2-a", 20), upload("b", "1-b", 10)},
+		CommonPrefixes: s3datatypes.CommonPrefixes{{Prefix: "dir/"}},
+	})
+
+	response, err := MergeListMultipartUploadsResponses([]backend.Response{first, second})
+	require.NoError(t, err)
+	result := readListMultipartUploadsResult(t, response)
+
+	assert.Equal(t, "bucket", result.Bucket)
+	assert.Equal(t, s3datatypes.ObjectMultipartInfos{upload("a", "2-a", 20), upload("a", "1-a", 30), upload("b", "1-b", 10)}, result.Uploads)
+	assert.Equal(t, s3datatypes.CommonPrefixes{{Prefix: "dir/"}}, result.CommonPrefixes)
+	assert.False(t, result.IsTruncated)
+	assert.Equal(t, int64(1000), result.MaxUploads)
+	assert.Equal(t, "application/xml", response.Header.Get("content-type"))
+}
+
+func TestShouldLimitMergedMultipartUploadsToMaxUploads(t *testing.T) {
+	first := listMultipartUploadsResponse(t, "2", s3datatypes.ListMultipartUploadsResult{
+		Uploads: s3datatypes.ObjectMultipartInfos{upload("a", "1-a", 10), upload("c", "1-c", 10)},
+	})
+	second := listMultipartUploadsResponse(t, "2", s3datatypes.ListMultipartUploadsResult{
+		Uploads: s3datatypes.ObjectMultipartInfos{upload("b", "2-b", 10)},
+	})
+
+	response, err := MergeListMultipartUploadsResponses([]backend.Response{first, second})
+	require.NoError(t, err)
+	result := readListMultipartUploadsResult(t, response)
+
+	assert.Equal(t, s3datatypes.ObjectMultipartInfos{upload("a", "1-a", 10), upload("b", "2-b", 10)}, result.Uploads)
+	assert.True(t, result.IsTruncated)
+	assert.Equal(t, "b", result.NextKeyMarker)
+	assert.Equal(t, "2-b", result.NextUploadIDMarker)
+}
+
+func TestShouldNotListMultipartUploadsPastTheTruncationOfAnyBackend(t *testing.T) {
+	first := listMultipartUploadsResponse(t, "", s3datatypes.ListMultipartUploadsResult{
+		Uploads:            s3datatypes.ObjectMultipartInfos{upload("a", "1-a", 10), upload("b", "1-b", 10)},
+		IsTruncated:        true,
+		NextKeyMarker:      "b",
+		NextUploadIDMarker: "1-b",
+	})
+	second := listMultipartUploadsResponse(t, "", s3datatypes.ListMultipartUploadsResult{
+		Uploads: s3datatypes.ObjectMultipartInfos{upload("c", "2-c", 10)},
+	})
+
+	response, err := MergeListMultipartUploadsResponses([]backend.Response{first, second})
+	require.NoError(t, err)
+	result := readListMultipartUploadsResult(t, response)
+
+	assert.Equal(t, s3datatypes.ObjectMultipartInfos{upload("a", "1-a", 10), upload("b", "1-b", 10)}, result.Uploads)
+	assert.True(t, result.IsTruncated)
+	assert.Equal(t, "b", result.NextKeyMarker)
+	assert.Equal(t, "1-b", result.NextUploadIDMarker)
+}
+
+func TestShouldListFanOutUploadsOnceWithFanOutUploadIDs(t *testing.T) {
+	first := listMultipartUploadsResponse(t, "", s3datatypes.ListMultipartUploadsResult{
+		Bucket:  "bucket",
+		Uploads: s3datatypes.ObjectMultipartInfos{upload("a", "1-a", 10), upload("a", "1-a2", 50), upload("b", "1-b", 20)},
+	})
+	first.Backend = &backend.Backend{Name: "storage1"}
+	second := listMultipartUploadsResponse(t, "", s3datatypes.ListMultipartUploadsResult{
+		Bucket:  "bucket",
+		Uploads: s3datatypes.ObjectMultipartInfos{upload("a", "2-a", 11), upload("a", "2-a2", 51)},
+	})
+	second.Backend = &backend.Backend{Name: "storage2"}
+	fanOutUploadID := func(backendUploadIDs url.Values) string {
+		return "fanout." + backendUploadIDs.Encode()
+	}
+
+	response, err := MergeFanOutListMultipartUploadsResponses([]backend.Response{first, second}, fanOutUploadID)
+	require.NoError(t, err)
+	result := readListMultipartUploadsResult(t, response)
+
+	assert.Equal(t, s3datatypes.ObjectMultipartInfos{
+		upload("a", "fanout.storage1=1-a&storage2=2-a", 10),
+		upload("a", "fanout.storage1=1-a2&storage2=2-a2", 50),
+		upload("b", "fanout.storage1=1-b", 20),
+	}, result.Uploads)
+}
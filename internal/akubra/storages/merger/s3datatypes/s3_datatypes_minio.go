@@ -302,11 +302,11 @@ type ListMultipartUploadsResult struct {
 	EncodingType       string
 	MaxUploads         int64
 	IsTruncated        bool
-	Uploads            []ObjectMultipartInfo `xml:"Upload"`
+	Uploads            ObjectMultipartInfos `xml:"Upload"`
 	Prefix             string
 	Delimiter          string
 	// A response can contain CommonPrefixes only if you specify a delimiter.
-	CommonPrefixes []CommonPrefix
+	CommonPrefixes CommonPrefixes
 }
 
 // ObjectMultipartInfo container for multipart object metadata.
@@ -330,5 +330,33 @@ type ObjectMultipartInfo struct {
 	UploadID string `xml:"UploadId"`
 
 	// Error
-	Err error
+	Err error `xml:"-"`
+}
+
+// String orders the uploads by key and then by initiation time
+func (omi ObjectMultipartInfo) String() string {
+	return omi.Key + "\x00" + omi.Initiated.UTC().Format("20060102150405.000000000") + "\x00" + omi.UploadID
+}
+
+// ObjectMultipartInfos is slice of ObjectMultipartInfo
+type ObjectMultipartInfos []ObjectMultipartInfo
+
+// ToStringer returns slice of stringers
+func (omis ObjectMultipartInfos) ToStringer() []fmt.Stringer {
+	stringers := make([]fmt.Stringer, 0, len(omis))
+	for _, item := range omis {
+		stringer := fmt.Stringer(item)
+		stringers = append(stringers, stringer)
+	}
+	return stringers
+}
+
+// FromStringer returns asserted stringer slice to ObjectMultipartInfos
+func (omis ObjectMultipartInfos) FromStringer(stringers []fmt.Stringer) ObjectMultipartInfos {
+	newOmis := make(ObjectMultipartInfos, 0, len(stringers))
+	for _, stringer := range stringers {
+		item := stringer.(ObjectMultipartInfo)
+		newOmis = append(newOmis, item)
+	}
+	return newOmis
 }
@@ -0,0 +1,342 @@
+package storages
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/storages/backend"
+	"github.com/allegro/akubra/internal/akubra/storages/merger"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+)
+
+const (
+	// MultipartFanOut is a constant used to put/get policy multipart fan-out flag to/from request's context
+	MultipartFanOut = log.ContextKey("MultipartFanOut")
+	// fanOutUploadIDPrefix marks the client facing uploadIds of the uploads replicated to all storages
+	fanOutUploadIDPrefix = "fanout."
+)
+
+// ErrUnknownFanOutUpload is returned if the uploadId doesn't map to any storage of the shard
+var ErrUnknownFanOutUpload = errors.New("multipart upload not initiated on any storage of the shard")
+
+// MultipartFanOutClient replicates multipart uploads to all storages of the shard. The client facing uploadId
+// maps to the uploadIds of every storage which initiated the upload, the mapping is encoded in the uploadId
+// itself, so the upload can be continued by any akubra instance. Requests are completed on all storages
+// before the response is returned, so the parts are in place on every storage when the upload is completed
+type MultipartFanOutClient struct {
+	backends []*backend.Backend
+}
+
+// newMultipartFanOutClient creates MultipartFanOutClient
+func newMultipartFanOutClient(backends []*backend.Backend) client {
+	return &MultipartFanOutClient{backends: backends}
+}
+
+// Cancel Client interface
+func (fanOutClient *MultipartFanOutClient) Cancel() error { return nil }
+
+// Do sends the multipart request to the storages of the upload, successful responses are emitted first
+func (fanOutClient *MultipartFanOutClient) Do(request *http.Request) <-chan BackendResponse {
+	responsesChan := make(chan BackendResponse, len(fanOutClient.backends))
+	go func() {
+		defer close(responsesChan)
+		var responses []BackendResponse
+		switch {
+		case isListMultipartUploadsRequest(request):
+			responses = fanOutClient.listUploads(request)
+		case utils.IsInitiateMultiPartUploadRequest(request):
+			responses = fanOutClient.initiate(request)
+		default:
+			responses = fanOutClient.continueUpload(request)
+		}
+		sort.SliceStable(responses, func(i, j int) bool {
+			return responses[i].IsSuccessful() && !responses[j].IsSuccessful()
+		})
+		for _, response := range responses {
+			responsesChan <- response
+		}
+	}()
+	return responsesChan
+}
+
+func (fanOutClient *MultipartFanOutClient) initiate(request *http.Request) []BackendResponse {
+	activeBackends := make([]*backend.Backend, 0, len(fanOutClient.backends))
+	for _, storage := range fanOutClient.backends {
+		if storage.IsInMaintenance() {
+			log.Printf("Multipart fan-out: storage %s in maintenance skipped on initiate of %s, reqID %s",
+				storage.Name, request.URL.Path, utils.RequestID(request))
+			continue
+		}
+		activeBackends = append(activeBackends, storage)
+	}
+	if len(activeBackends) == 0 {
+		return []BackendResponse{{Request: request, Error: ErrImpossibleMultipart}}
+	}
+	responses := fanOutClient.callBackends(request, activeBackends, nil)
+
+	backendUploadIDs := url.Values{}
+	for idx := range responses {
+		if !responses[idx].IsSuccessful() {
+			continue
+		}
+		uploadID, err := utils.ExtractMultiPartUploadIDFrom(responses[idx].Response)
+		if err != nil {
+			responses[idx].Error = fmt.Errorf("no uploadId in response of storage %s: %s", responses[idx].Backend.Name, err)
+			continue
+		}
+		backendUploadIDs.Set(responses[idx].Backend.Name, uploadID)
+	}
+	fanOutUploadID := encodeFanOutUploadID(backendUploadIDs)
+	for idx := range responses {
+		if responses[idx].IsSuccessful() {
+			replaceUploadID(responses[idx].Response, backendUploadIDs.Get(responses[idx].Backend.Name), fanOutUploadID)
+		}
+	}
+	return responses
+}
+
+func (fanOutClient *MultipartFanOutClient) continueUpload(request *http.Request) []BackendResponse {
+	fanOutUploadID := request.URL.Query().Get("uploadId")
+	backendUploadIDs, err := decodeFanOutUploadID(fanOutUploadID)
+	if err != nil {
+		return []BackendResponse{{Request: request, Error: err}}
+	}
+	uploadBackends := make([]*backend.Backend, 0, len(backendUploadIDs))
+	for _, storage := range fanOutClient.backends {
+		if backendUploadIDs.Get(storage.Name) != "" {
+			uploadBackends = append(uploadBackends, storage)
+		}
+	}
+	if len(uploadBackends) == 0 {
+		return []BackendResponse{{Request: request, Error: ErrUnknownFanOutUpload}}
+	}
+	if request.Method == http.MethodGet {
+		return fanOutClient.readUpload(request, uploadBackends, backendUploadIDs, fanOutUploadID)
+	}
+
+	responses := fanOutClient.callBackends(request, uploadBackends, backendUploadIDs)
+	if request.Method == http.MethodPost {
+		markCompletedUpload(request, responses)
+	}
+	return responses
+}
+
+// listUploads lists the uploads on all storages and merges the listings into one response, the storage uploadIds
+// are replaced with the fan-out ones, so every upload is listed once and can be continued from the listing
+func (fanOutClient *MultipartFanOutClient) listUploads(request *http.Request) []BackendResponse {
+	markerUploadIDs := url.Values{}
+	if marker := request.URL.Query().Get("upload-id-marker"); isFanOutUploadID(marker) {
+		decoded, err := decodeFanOutUploadID(marker)
+		if err != nil {
+			return []BackendResponse{{Request: request, Error: err}}
+		}
+		markerUploadIDs = decoded
+	}
+	responses := make([]BackendResponse, len(fanOutClient.backends))
+	wg := sync.WaitGroup{}
+	for idx := range fanOutClient.backends {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			responses[idx] = callListingBackend(request, fanOutClient.backends[idx], markerUploadIDs)
+		}(idx)
+	}
+	wg.Wait()
+
+	successes := make([]BackendResponse, 0, len(responses))
+	for _, response := range responses {
+		if response.IsSuccessful() {
+			successes = append(successes, response)
+			continue
+		}
+		log.Printf("Multipart fan-out: storage %s failed to list uploads of %s, reqID %s: %s",
+			response.Backend.Name, request.URL.Path, utils.RequestID(request), responseFailure(response))
+		if err := response.DiscardBody(); err != nil {
+			log.Debugf("Multipart fan-out: cannot discard response body: %s", err)
+		}
+	}
+	if len(successes) == 0 {
+		return responses
+	}
+	merged, err := merger.MergeFanOutListMultipartUploadsResponses(successes, encodeFanOutUploadID)
+	return []BackendResponse{{Request: successes[0].Request, Response: merged, Error: err}}
+}
+
+// callListingBackend lists the uploads on the storage, the fan-out upload-id-marker is replaced with the uploadId
+// of the storage, or dropped if the upload wasn't initiated on it
+func callListingBackend(request *http.Request, storage *backend.Backend, markerUploadIDs url.Values) BackendResponse {
+	replicatedRequest, err := utils.ReplicateRequest(request)
+	if err != nil {
+		return BackendResponse{Request: request, Error: fmt.Errorf("failed to replicate request: %s", err), Backend: storage}
+	}
+	if len(markerUploadIDs) > 0 {
+		query := replicatedRequest.URL.Query()
+		query.Del("upload-id-marker")
+		if backendUploadID := markerUploadIDs.Get(storage.Name); backendUploadID != "" {
+			query.Set("upload-id-marker", backendUploadID)
+		}
+		replicatedRequest.URL.RawQuery = query.Encode()
+	}
+	response, err := storage.RoundTrip(replicatedRequest)
+	return BackendResponse{Request: replicatedRequest, Response: response, Error: err, Backend: storage}
+}
+
+// readUpload asks the storages one by one, as any of them can list the parts of the upload
+func (fanOutClient *MultipartFanOutClient) readUpload(request *http.Request, uploadBackends []*backend.Backend, backendUploadIDs url.Values, fanOutUploadID string) []BackendResponse {
+	responses := make([]BackendResponse, 0, len(uploadBackends))
+	for _, storage := range uploadBackends {
+		response := callUploadBackend(request, storage, backendUploadIDs.Get(storage.Name))
+		responses = append(responses, response)
+		if response.IsSuccessful() {
+			replaceUploadID(response.Response, backendUploadIDs.Get(storage.Name), fanOutUploadID)
+			break
+		}
+	}
+	return responses
+}
+
+func (fanOutClient *MultipartFanOutClient) callBackends(request *http.Request, storages []*backend.Backend, backendUploadIDs url.Values) []BackendResponse {
+	responses := make([]BackendResponse, len(storages))
+	wg := sync.WaitGroup{}
+	for idx := range storages {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			responses[idx] = callUploadBackend(request, storages[idx], backendUploadIDs.Get(storages[idx].Name))
+		}(idx)
+	}
+	wg.Wait()
+
+	allBackendsSuccess := true
+	for _, response := range responses {
+		if !response.IsSuccessful() {
+			allBackendsSuccess = false
+			log.Printf("Multipart fan-out: storage %s failed on %s %s, reqID %s: %s",
+				response.Backend.Name, request.Method, request.URL.Path, utils.RequestID(request), responseFailure(response))
+		}
+	}
+	noErrors, ok := request.Context().Value(watchdog.NoErrorsDuringRequest).(*bool)
+	if ok && noErrors != nil {
+		*noErrors = *noErrors && allBackendsSuccess
+	}
+	return responses
+}
+
+func callUploadBackend(request *http.Request, storage *backend.Backend, backendUploadID string) BackendResponse {
+	replicatedRequest, err := utils.ReplicateRequest(request)
+	if err != nil {
+		return BackendResponse{Request: request, Error: fmt.Errorf("failed to replicate request: %s", err), Backend: storage}
+	}
+	if backendUploadID != "" {
+		query := replicatedRequest.URL.Query()
+		query.Set("uploadId", backendUploadID)
+		replicatedRequest.URL.RawQuery = query.Encode()
+	}
+	response, err := storage.RoundTrip(replicatedRequest)
+	return BackendResponse{Request: replicatedRequest, Response: response, Error: err, Backend: storage}
+}
+
+// markCompletedUpload sets the multipart flag, so the watchdog syncs the object to the storages that failed
+func markCompletedUpload(request *http.Request, responses []BackendResponse) {
+	for _, response := range responses {
+		if response.IsSuccessful() && isCompleteUploadResponseSuccessful(response.Response) {
+			multipartUploadID, ok := request.Context().Value(watchdog.MultiPartUpload).(*bool)
+			if ok && multipartUploadID != nil {
+				*multipartUploadID = true
+			}
+			return
+		}
+	}
+}
+
+func responseFailure(response BackendResponse) string {
+	if response.Error != nil {
+		return response.Error.Error()
+	}
+	if response.Response != nil {
+		return response.Response.Status
+	}
+	return "no response"
+}
+
+func replaceUploadID(response *http.Response, backendUploadID, fanOutUploadID string) {
+	if response == nil || response.Body == nil || backendUploadID == "" {
+		return
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		log.Printf("Multipart fan-out: failed to read response body of %s: %s", response.Request.URL.Path, err)
+	}
+	body = bytes.Replace(body, uploadIDElement(backendUploadID), uploadIDElement(fanOutUploadID), -1)
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	response.ContentLength = int64(len(body))
+	response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+func uploadIDElement(uploadID string) []byte {
+	element := &bytes.Buffer{}
+	element.WriteString("<UploadId>")
+	_ = xml.EscapeText(element, []byte(uploadID))
+	element.WriteString("</UploadId>")
+	return element.Bytes()
+}
+
+func encodeFanOutUploadID(backendUploadIDs url.Values) string {
+	return fanOutUploadIDPrefix + base64.RawURLEncoding.EncodeToString([]byte(backendUploadIDs.Encode()))
+}
+
+func decodeFanOutUploadID(uploadID string) (url.Values, error) {
+	if !isFanOutUploadID(uploadID) {
+		return nil, fmt.Errorf("uploadId %q is not a fan-out uploadId", uploadID)
+	}
+	encoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(uploadID, fanOutUploadIDPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("malformed fan-out uploadId %q: %s", uploadID, err)
+	}
+	return url.ParseQuery(string(encoded))
+}
+
+func isFanOutUploadID(uploadID string) bool {
+	return strings.HasPrefix(uploadID, fanOutUploadIDPrefix)
+}
+
+// isMultipartFanOutRequest tells if the request belongs to an upload replicated to all storages, or lists
+// the uploads of a policy replicating them
+func isMultipartFanOutRequest(request *http.Request) bool {
+	if isFanOutUploadID(request.URL.Query().Get("uploadId")) {
+		return true
+	}
+	fanOut, ok := request.Context().Value(MultipartFanOut).(bool)
+	return ok && fanOut && (utils.IsInitiateMultiPartUploadRequest(request) || isListMultipartUploadsRequest(request))
+}
+
+// multipartRecordID returns the consistency record id of the upload, fan-out uploadIds are
+// hashed as they are longer than the record ids
+func multipartRecordID(uploadID string) string {
+	if !isFanOutUploadID(uploadID) {
+		return uploadID
+	}
+	hash := md5.Sum([]byte(uploadID))
+	return hex.EncodeToString(hash[:])
+}
+
+// isListMultipartUploadsRequest tells if the request lists the multipart uploads of the bucket
+func isListMultipartUploadsRequest(request *http.Request) bool {
+	_, has := request.URL.Query()["uploads"]
+	return has && request.Method == http.MethodGet && utils.IsBucketPath(request.URL.Path)
+}
@@ -0,0 +1,211 @@
+package storages
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/storages/backend"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fanOutStorage struct {
+	mx          sync.Mutex
+	name        string
+	fail        bool
+	uploadIDs   []string
+	partNumbers []string
+	listMarkers []string
+}
+
+func (storage *fanOutStorage) RoundTrip(req *http.Request) (*http.Response, error) {
+	storage.mx.Lock()
+	defer storage.mx.Unlock()
+	if storage.fail {
+		return nil, fmt.Errorf("%s unavailable", storage.name)
+	}
+	query := req.URL.Query()
+	body := ""
+	switch {
+	case utils.IsInitiateMultiPartUploadRequest(req):
+		body = fmt.Sprintf(`<InitiateMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bucket</Bucket><Key>key</Key><UploadId>%s-upload</UploadId></InitiateMultipartUploadResult>`, storage.name)
+	case isListMultipartUploadsRequest(req):
+		storage.listMarkers = append(storage.listMarkers, query.Get("upload-id-marker"))
+		body = fmt.Sprintf(`<ListMultipartUploadsResult><Bucket>bucket</Bucket><Upload><Key>key</Key><UploadId>%s-upload</UploadId><Initiated>2020-01-01T00:00:00Z</Initiated></Upload></ListMultipartUploadsResult>`, storage.name)
+	case req.Method == http.MethodGet:
+		storage.uploadIDs = append(storage.uploadIDs, query.Get("uploadId"))
+		body = fmt.Sprintf(`<ListPartsResult><Bucket>bucket</Bucket><Key>key</Key><UploadId>%s</UploadId></ListPartsResult>`, query.Get("uploadId"))
+	case req.Method == http.MethodPost:
+		storage.uploadIDs = append(storage.uploadIDs, query.Get("uploadId"))
+		body = `<CompleteMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bucket</Bucket><Key>key</Key><ETag>"etag-2"</ETag></CompleteMultipartUploadResult>`
+	default:
+		storage.uploadIDs = append(storage.uploadIDs, query.Get("uploadId"))
+		storage.partNumbers = append(storage.partNumbers, query.Get("partNumber"))
+		partBody, _ := ioutil.ReadAll(req.Body)
+		body = string(partBody)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func newFanOutDispatcher(storages ...*fanOutStorage) *RequestDispatcher {
+	backends := make([]*backend.Backend, 0, len(storages))
+	for _, storage := range storages {
+		endpoint, _ := url.Parse(fmt.Sprintf("http://%s:8080", storage.name))
+		backends = append(backends, &StorageClient{Name: storage.name, Endpoint: *endpoint, RoundTripper: storage})
+	}
+	return NewRequestDispatcher(backends)
+}
+
+func newFanOutRequest(t *testing.T, method, rawURL, body string) (*http.Request, *bool, *bool) {
+	request, err := http.NewRequest(method, rawURL, strings.NewReader(body))
+	require.NoError(t, err)
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(body)), nil
+	}
+	noErrors := true
+	completed := false
+	ctx := context.WithValue(request.Context(), log.ContextreqIDKey, "reqID")
+	ctx = context.WithValue(ctx, MultipartFanOut, true)
+	ctx = context.WithValue(ctx, watchdog.NoErrorsDuringRequest, &noErrors)
+	ctx = context.WithValue(ctx, watchdog.MultiPartUpload, &completed)
+	return request.WithContext(ctx), &noErrors, &completed
+}
+
+func initiateFanOutUpload(t *testing.T, dispatcher *RequestDispatcher) string {
+	request, _, _ := newFanOutRequest(t, http.MethodPost, "http://akubra/bucket/key?uploads", "")
+	response, err := dispatcher.Dispatch(request)
+	require.NoError(t, err)
+	uploadID, err := utils.ExtractMultiPartUploadIDFrom(response)
+	require.NoError(t, err)
+	return uploadID
+}
+
+func TestShouldInitiateMultipartUploadOnAllStoragesOfShard(t *testing.T) {
+	storage1, storage2 := &fanOutStorage{name: "storage1"}, &fanOutStorage{name: "storage2"}
+
+	uploadID := initiateFanOutUpload(t, newFanOutDispatcher(storage1, storage2))
+
+	assert.True(t, isFanOutUploadID(uploadID))
+	backendUploadIDs, err := decodeFanOutUploadID(uploadID)
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"storage1": {"storage1-upload"}, "storage2": {"storage2-upload"}}, backendUploadIDs)
+}
+
+func TestShouldSkipFailedStoragesOnMultipartUploadInitiation(t *testing.T) {
+	storage1, storage2 := &fanOutStorage{name: "storage1"}, &fanOutStorage{name: "storage2", fail: true}
+	request, noErrors, _ := newFanOutRequest(t, http.MethodPost, "http://akubra/bucket/key?uploads", "")
+
+	response, err := newFanOutDispatcher(storage1, storage2).Dispatch(request)
+	require.NoError(t, err)
+	uploadID, err := utils.ExtractMultiPartUploadIDFrom(response)
+	require.NoError(t, err)
+
+	backendUploadIDs, err := decodeFanOutUploadID(uploadID)
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"storage1": {"storage1-upload"}}, backendUploadIDs)
+	assert.False(t, *noErrors)
+}
+
+func TestShouldFanOutPartsAndCompletionWithStorageUploadIDs(t *testing.T) {
+	storage1, storage2 := &fanOutStorage{name: "storage1"}, &fanOutStorage{name: "storage2"}
+	dispatcher := newFanOutDispatcher(storage1, storage2)
+	uploadID := initiateFanOutUpload(t, dispatcher)
+
+	partRequest, noErrors, _ := newFanOutRequest(t, http.MethodPut, "http://akubra/bucket/key?partNumber=1&uploadId="+url.QueryEscape(uploadID), "part-1")
+	response, err := dispatcher.Dispatch(partRequest)
+	require.NoError(t, err)
+	partBody, _ := ioutil.ReadAll(response.Body)
+	assert.Equal(t, "part-1", string(partBody))
+	assert.True(t, *noErrors)
+
+	completeRequest, _, completed := newFanOutRequest(t, http.MethodPost, "http://akubra/bucket/key?uploadId="+url.QueryEscape(uploadID), "<CompleteMultipartUpload/>")
+	response, err = dispatcher.Dispatch(completeRequest)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.True(t, *completed)
+
+	assert.Equal(t, []string{"storage1-upload", "storage1-upload"}, storage1.uploadIDs)
+	assert.Equal(t, []string{"storage2-upload", "storage2-upload"}, storage2.uploadIDs)
+	assert.Equal(t, []string{"1"}, storage2.partNumbers)
+}
+
+func TestShouldSucceedOnPartUploadIfAnyStorageOfUploadSucceeded(t *testing.T) {
+	storage1, storage2 := &fanOutStorage{name: "storage1"}, &fanOutStorage{name: "storage2"}
+	dispatcher := newFanOutDispatcher(storage1, storage2)
+	uploadID := initiateFanOutUpload(t, dispatcher)
+	storage1.fail = true
+
+	partRequest, noErrors, _ := newFanOutRequest(t, http.MethodPut, "http://akubra/bucket/key?partNumber=1&uploadId="+url.QueryEscape(uploadID), "part-1")
+	response, err := dispatcher.Dispatch(partRequest)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.False(t, *noErrors)
+}
+
+func TestShouldListPartsOfFanOutUploadFromOneStorage(t *testing.T) {
+	storage1, storage2 := &fanOutStorage{name: "storage1"}, &fanOutStorage{name: "storage2"}
+	dispatcher := newFanOutDispatcher(storage1, storage2)
+	uploadID := initiateFanOutUpload(t, dispatcher)
+	shard := &ShardClient{name: "shard", requestDispatcher: dispatcher, balancer: nil}
+
+	listRequest, _, _ := newFanOutRequest(t, http.MethodGet, "http://akubra/bucket/key?uploadId="+url.QueryEscape(uploadID), "")
+	response, err := shard.RoundTrip(listRequest)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(response.Body)
+
+	assert.Contains(t, string(body), "<UploadId>"+uploadID+"</UploadId>")
+	assert.Len(t, storage1.uploadIDs, 1)
+	assert.Empty(t, storage2.uploadIDs)
+}
+
+func TestShouldListFanOutUploadOnceWithFanOutUploadID(t *testing.T) {
+	storage1, storage2 := &fanOutStorage{name: "storage1"}, &fanOutStorage{name: "storage2"}
+	dispatcher := newFanOutDispatcher(storage1, storage2)
+	uploadID := initiateFanOutUpload(t, dispatcher)
+
+	listRequest, _, _ := newFanOutRequest(t, http.MethodGet,
+		"http://akubra/bucket?uploads&key-marker=key&upload-id-marker="+url.QueryEscape(uploadID), "")
+	response, err := dispatcher.Dispatch(listRequest)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(response.Body)
+
+	assert.Equal(t, 1, strings.Count(string(body), "<Upload>"))
+	assert.Contains(t, string(body), "<UploadId>"+uploadID+"</UploadId>")
+	assert.Equal(t, []string{"storage1-upload"}, storage1.listMarkers)
+	assert.Equal(t, []string{"storage2-upload"}, storage2.listMarkers)
+}
+
+func TestShouldFailOnUploadIDNotInitiatedOnShardStorages(t *testing.T) {
+	dispatcher := newFanOutDispatcher(&fanOutStorage{name: "storage3"})
+	uploadID := encodeFanOutUploadID(url.Values{"storage1": {"storage1-upload"}})
+
+	partRequest, _, _ := newFanOutRequest(t, http.MethodPut, "http://akubra/bucket/key?partNumber=1&uploadId="+url.QueryEscape(uploadID), "part-1")
+	_, err := dispatcher.Dispatch(partRequest)
+
+	assert.Equal(t, ErrUnknownFanOutUpload, err)
+}
+
+func TestShouldShortenFanOutUploadIDsToConsistencyRecordIDs(t *testing.T) {
+	uploadID := encodeFanOutUploadID(url.Values{"storage1": {"storage1-upload"}, "storage2": {"storage2-upload"}})
+
+	assert.Len(t, multipartRecordID(uploadID), 32)
+	assert.Equal(t, multipartRecordID(uploadID), multipartRecordID(uploadID))
+	assert.Equal(t, "2~pinned-upload", multipartRecordID("2~pinned-upload"))
+}
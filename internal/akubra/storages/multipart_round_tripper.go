@@ -18,8 +18,8 @@ import (
 )
 
 // MultiPartRoundTripper handles the multipart upload. If multipart upload is detected, it delegates the request
-// to the backend selected using the active backends hash ring, otherwise the cluster round tripper is used
-// to handle the operation in standard fashion
+// to the backend selected using the backends hash ring, skipping the backends in maintenance at the time of the pick,
+// otherwise the cluster round tripper is used to handle the operation in standard fashion
 type MultiPartRoundTripper struct {
 	backendsRoundTrippers map[string]*backend.Backend
 	backendsRing          *hashring.HashRing
@@ -29,24 +29,20 @@ type MultiPartRoundTripper struct {
 // Cancel Client interface
 func (multiPartRoundTripper MultiPartRoundTripper) Cancel() error { return nil }
 
-// newMultiPartRoundTripper initializes multipart client
+// newMultiPartRoundTripper initializes multipart client, the maintenance mode is checked on every pick, as it may
+// be changed at runtime
 func newMultiPartRoundTripper(backends []*StorageClient) client {
 	multiPartRoundTripper := &MultiPartRoundTripper{}
 	var backendsEndpoints []string
-	var activeBackendsEndpoints []string
 
 	multiPartRoundTripper.backendsRoundTrippers = make(map[string]*StorageClient)
 
 	for _, backend := range backends {
-		if !backend.Maintenance {
-			multiPartRoundTripper.backendsRoundTrippers[backend.Endpoint.Host] = backend
-			activeBackendsEndpoints = append(activeBackendsEndpoints, backend.Endpoint.Host)
-		}
-
+		multiPartRoundTripper.backendsRoundTrippers[backend.Endpoint.Host] = backend
 		backendsEndpoints = append(backendsEndpoints, backend.Endpoint.Host)
 	}
 	multiPartRoundTripper.backendsEndpoints = backendsEndpoints
-	multiPartRoundTripper.backendsRing = hashring.New(activeBackendsEndpoints)
+	multiPartRoundTripper.backendsRing = hashring.New(backendsEndpoints)
 	return multiPartRoundTripper
 }
 
@@ -111,22 +107,29 @@ func (multiPartRoundTripper *MultiPartRoundTripper) Do(request *http.Request) <-
 	return backendResponseChannel
 }
 
+// pickBackend returns the first backend of the ring order of the object which is not in maintenance
 func (multiPartRoundTripper *MultiPartRoundTripper) pickBackend(objectPath string) (*backend.Backend, error) {
-	backendEndpoint, nodeFound := multiPartRoundTripper.backendsRing.GetNode(objectPath)
-	if !nodeFound {
+	backendsEndpoints, nodesFound := multiPartRoundTripper.backendsRing.GetNodes(objectPath, multiPartRoundTripper.backendsRing.Size())
+	if !nodesFound {
 		return nil, errors.New("can't find backend for upload in multi upload ring")
 	}
 
-	backend, backendFound := multiPartRoundTripper.backendsRoundTrippers[backendEndpoint]
-	if !backendFound {
-		return nil, errors.New("can't find backend for upload in backendsRoundTripper")
+	for _, backendEndpoint := range backendsEndpoints {
+		backend, backendFound := multiPartRoundTripper.backendsRoundTrippers[backendEndpoint]
+		if backendFound && !backend.IsInMaintenance() {
+			return backend, nil
+		}
 	}
-
-	return backend, nil
+	return nil, errors.New("can't find backend for upload in backendsRoundTripper")
 }
 
 func (multiPartRoundTripper *MultiPartRoundTripper) canHandleMultiUpload() bool {
-	return len(multiPartRoundTripper.backendsRoundTrippers) > 0
+	for _, backend := range multiPartRoundTripper.backendsRoundTrippers {
+		if !backend.IsInMaintenance() {
+			return true
+		}
+	}
+	return false
 }
 
 func isCompleteUploadResponseSuccessful(response *http.Response) bool {
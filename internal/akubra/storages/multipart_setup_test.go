@@ -1,6 +1,7 @@
 package storages
 
 import (
+	"fmt"
 	"github.com/allegro/akubra/internal/akubra/storages/config"
 	"net/http"
 	"net/url"
@@ -68,7 +69,32 @@ func TestShouldSetupMultiUploadRingAndMigrationEndpoints(testSuite *testing.T) {
 	multiPartRoundTripper := newMultiPartRoundTripper(backends)
 	mprt, ok := multiPartRoundTripper.(*MultiPartRoundTripper)
 	assert.True(testSuite, ok)
-	assert.Len(testSuite, mprt.backendsRoundTrippers, 2)
-	assert.Equal(testSuite, 2, mprt.backendsRing.Size())
+	assert.Len(testSuite, mprt.backendsRoundTrippers, 3)
+	assert.Equal(testSuite, 3, mprt.backendsRing.Size())
 	assert.Len(testSuite, mprt.backendsEndpoints, 3)
+	for idx := 0; idx < 100; idx++ {
+		picked, err := mprt.pickBackend(fmt.Sprintf("/bucket/object-%d", idx))
+		assert.NoError(testSuite, err)
+		assert.NotEqual(testSuite, maintenanceBackend, picked)
+	}
+}
+
+func TestShouldPickMultiUploadBackendByMaintenanceModeAtTheTimeOfThePick(testSuite *testing.T) {
+	firstURL, _ := url.Parse("http://first:1234")
+	secondURL, _ := url.Parse("http://second:1234")
+	first := &StorageClient{Endpoint: *firstURL, Name: "first"}
+	second := &StorageClient{Endpoint: *secondURL, Name: "second"}
+	mprt := newMultiPartRoundTripper([]*StorageClient{first, second}).(*MultiPartRoundTripper)
+
+	first.SetMaintenance(true)
+	for idx := 0; idx < 20; idx++ {
+		picked, err := mprt.pickBackend(fmt.Sprintf("/bucket/object-%d", idx))
+		assert.NoError(testSuite, err)
+		assert.Equal(testSuite, second, picked)
+	}
+
+	second.SetMaintenance(true)
+	assert.False(testSuite, mprt.canHandleMultiUpload())
+	first.ResetMaintenance()
+	assert.True(testSuite, mprt.canHandleMultiUpload())
 }
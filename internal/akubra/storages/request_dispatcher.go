@@ -3,10 +3,14 @@ package storages
 import (
 	"net/http"
 
+	"github.com/allegro/akubra/internal/akubra/log"
 	"github.com/allegro/akubra/internal/akubra/storages/backend"
 	"github.com/allegro/akubra/internal/akubra/utils"
 )
 
+// WriteQuorum is a constant used to put/get policy write quorum to/from request's context
+const WriteQuorum = log.ContextKey("WriteQuorum")
+
 type dispatcher interface {
 	Dispatch(request *http.Request) (*http.Response, error)
 }
@@ -35,8 +39,13 @@ func (rd *RequestDispatcher) Dispatch(request *http.Request) (*http.Response, er
 	cli := clientFactory(rd.Backends)
 
 	respChan := cli.Do(request)
-	pickerFactory := rd.pickResponsePickerFactory(request)
-	pickr := pickerFactory(respChan)
+	var pickr responsePicker
+	if quorum, ok := writeQuorum(request); ok {
+		pickr = newWriteQuorumPicker(respChan, quorum, len(rd.Backends))
+	} else {
+		pickerFactory := rd.pickResponsePickerFactory(request)
+		pickr = pickerFactory(respChan)
+	}
 
 	resp, err := pickr.Pick()
 	if err != nil {
@@ -55,6 +64,9 @@ type client interface {
 }
 
 var defaultReplicationClientFactory = func(request *http.Request) func([]*backend.Backend) client {
+	if isMultipartFanOutRequest(request) {
+		return newMultipartFanOutClient
+	}
 	if utils.IsMultiPartUploadRequest(request) {
 		return newMultiPartRoundTripper
 	}
@@ -70,3 +82,18 @@ var defaultResponsePickerFactory = func(request *http.Request) func(<-chan Backe
 	}
 	return newFirstSuccessfulResponsePicker
 }
+
+// writeQuorum returns the write quorum of the policy if the request is an object write subject to it
+func writeQuorum(request *http.Request) (int, bool) {
+	quorum, ok := request.Context().Value(WriteQuorum).(int)
+	if !ok || quorum <= 0 {
+		return 0, false
+	}
+	if request.Method != http.MethodPut && request.Method != http.MethodDelete {
+		return 0, false
+	}
+	if utils.IsBucketPath(request.URL.Path) || utils.IsMultiPartUploadRequest(request) {
+		return 0, false
+	}
+	return quorum, true
+}
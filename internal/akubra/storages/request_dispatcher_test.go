@@ -51,6 +51,7 @@ func TestRequestDispatcherPicks(t *testing.T) {
 		{"POST", "http://some.storage/bucket/object?uploads", multipartMultipartReplicator, firstSuccessfulResponsePicker},
 		{"POST", "http://some.storage/bucket/object?uploadId=ssssss", multipartMultipartReplicator, firstSuccessfulResponsePicker},
 		{"GET", "http://some.storage/bucket", matchReplicationClient, matchResponseMerger},
+		{"GET", "http://some.storage/bucket?uploads", matchReplicationClient, matchResponseMerger},
 		{"HEAD", "http://some.storage/bucket", matchReplicationClient, firstSuccessfulResponsePicker},
 		{"PUT", "http://some.storage/bucket", matchReplicationClient, allResponsesSuccessfulPicker},
 	}
@@ -95,6 +96,31 @@ func TestRequestDispatcherDispatch(t *testing.T) {
 
 }
 
+func TestRequestDispatcherShouldAwaitWriteQuorumOfObjectWrites(t *testing.T) {
+	testCases := []struct {
+		method string
+		url    string
+		quorum int
+		isSet  bool
+	}{
+		{"PUT", "http://some.storage/bucket/object", 2, true},
+		{"DELETE", "http://some.storage/bucket/object", 2, true},
+		{"PUT", "http://some.storage/bucket/object", 0, false},
+		{"GET", "http://some.storage/bucket/object", 2, false},
+		{"PUT", "http://some.storage/bucket", 2, false},
+		{"PUT", "http://some.storage/bucket/object?partNumber=1&uploadId=ssssss", 2, false},
+	}
+	for _, tc := range testCases {
+		request, _ := http.NewRequest(tc.method, tc.url, nil)
+		request = request.WithContext(context.WithValue(request.Context(), WriteQuorum, tc.quorum))
+		quorum, ok := writeQuorum(request)
+		require.Equal(t, tc.isSet, ok, "%s %s", tc.method, tc.url)
+		if ok {
+			require.Equal(t, tc.quorum, quorum)
+		}
+	}
+}
+
 func prepareTest(backends []*backend.Backend) (*RequestDispatcher, *replicationClientMock, *responsePickerMock) {
 	respPickerMock := &responsePickerMock{&mock.Mock{}}
 	responsePickerFactoryMock := responsePickFactoryMockFactory(respPickerMock)
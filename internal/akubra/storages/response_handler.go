@@ -40,6 +40,10 @@ func (rm *responseMerger) createResponse(firstResponse BackendResponse, successe
 	if reqQuery["versions"] != nil {
 		return merger.MergeVersionsResponses(successes)
 	}
+
+	if reqQuery["uploads"] != nil {
+		return merger.MergeListMultipartUploadsResponses(successes)
+	}
 	return merger.MergeBucketListResponses(successes)
 }
 
@@ -78,10 +82,6 @@ func (rm *responseMerger) merge(firstTuple BackendResponse, rtupleCh <-chan Back
 	return firstTuple
 }
 
-var unsupportedQueryParamNames = []string{
-	"uploads",
-}
-
 var partialSupportQueryParamNames = []string{"acl",
 	"accelerate",
 	"tags",
@@ -100,19 +100,7 @@ var partialSupportQueryParamNames = []string{"acl",
 }
 
 func (rm *responseMerger) isMergable(req *http.Request) bool {
-	path := req.URL.Path
-	method := req.Method
-	reqQuery := req.URL.Query()
-	unsupportedQuery := false
-	if reqQuery != nil {
-		for _, key := range unsupportedQueryParamNames {
-			if reqQuery[key] != nil {
-				unsupportedQuery = true
-				break
-			}
-		}
-	}
-	return !unsupportedQuery && (method == http.MethodGet) && utils.IsBucketPath(path)
+	return (req.Method == http.MethodGet) && utils.IsBucketPath(req.URL.Path)
 }
 
 func (rm *responseMerger) isPartiallyMergable(req *http.Request) bool {
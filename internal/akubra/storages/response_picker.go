@@ -1,9 +1,13 @@
 package storages
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/utils"
 )
 
 var emptyBackendResponse = BackendResponse{}
@@ -121,7 +125,7 @@ func newAllResponsesSuccessfulPicker(rch <-chan BackendResponse) responsePicker
 }
 
 func (drp *baseDeleteResponsePicker) collectFailureResponse(bresp BackendResponse) {
-	if bresp.Backend.Maintenance {
+	if bresp.Backend.IsInMaintenance() {
 		drp.softErrors = append(drp.softErrors, bresp)
 		return
 	}
@@ -135,7 +139,7 @@ func pullResponses(drp *baseDeleteResponsePicker, out chan<- BackendResponse) {
 		if success {
 			drp.collectSuccessResponse(bresp)
 		} else {
-			shouldSend = !drp.hasFailureResponse() && !bresp.Backend.Maintenance
+			shouldSend = !drp.hasFailureResponse() && !bresp.Backend.IsInMaintenance()
 			drp.collectFailureResponse(bresp)
 		}
 		if shouldSend {
@@ -148,3 +152,96 @@ func pullResponses(drp *baseDeleteResponsePicker, out chan<- BackendResponse) {
 	}
 	close(out)
 }
+
+// WriteQuorumPicker answers object writes once the quorum of storages succeeds, or fails as soon as
+// the quorum can't be reached anymore
+type WriteQuorumPicker struct {
+	BasePicker
+	quorum    int
+	backends  int
+	successes int
+}
+
+func newWriteQuorumPicker(rch <-chan BackendResponse, quorum, backends int) responsePicker {
+	if quorum > backends {
+		quorum = backends
+	}
+	return &WriteQuorumPicker{BasePicker: BasePicker{responsesChan: rch}, quorum: quorum, backends: backends}
+}
+
+// Pick returns a successful response once the quorum is reached, the remaining responses are discarded
+func (wqp *WriteQuorumPicker) Pick() (*http.Response, error) {
+	outChan := make(chan BackendResponse)
+	go wqp.pullResponses(outChan)
+	bresp := <-outChan
+	return bresp.Response, bresp.Error
+}
+
+func (wqp *WriteQuorumPicker) pullResponses(out chan<- BackendResponse) {
+	defer close(out)
+	for bresp := range wqp.responsesChan {
+		if bresp.IsSuccessful() {
+			wqp.successes++
+			wqp.collectSuccessResponse(bresp)
+			if !wqp.sent && wqp.successes >= wqp.quorum {
+				wqp.send(out, wqp.success)
+			}
+			continue
+		}
+		wqp.collectFailureResponse(bresp)
+		if !wqp.sent && len(wqp.errors) > wqp.backends-wqp.quorum {
+			wqp.send(out, wqp.quorumFailure(bresp.Request))
+		}
+	}
+	if !wqp.sent {
+		wqp.send(out, wqp.quorumFailure(nil))
+	}
+}
+
+// quorumFailure responds with the failure of the storages if all of them rejected the request as a client error,
+// otherwise with 503 ServiceUnavailable. The failures of the storages are logged only
+func (wqp *WriteQuorumPicker) quorumFailure(request *http.Request) BackendResponse {
+	if request == nil && len(wqp.errors) > 0 {
+		request = wqp.errors[len(wqp.errors)-1].Request
+	}
+	if request == nil {
+		request = wqp.success.Request
+	}
+	summary := &bytes.Buffer{}
+	fmt.Fprintf(summary, "write quorum of %d out of %d storages not reached, %d succeeded", wqp.quorum, wqp.backends, wqp.successes)
+	for _, failure := range wqp.errors {
+		name := "unknown"
+		if failure.Backend != nil {
+			name = failure.Backend.Name
+		}
+		fmt.Fprintf(summary, "; %s: %s", name, responseFailure(failure))
+	}
+	log.Printf("Write quorum not reached for request %s: %s", utils.RequestID(request), summary)
+	if wqp.allFailuresAreClientErrors() {
+		return wqp.failure
+	}
+	if wqp.hasFailureResponse() {
+		if err := wqp.failure.DiscardBody(); err != nil {
+			log.Debugf("Could not close tuple body: %s", err)
+		}
+	}
+	if request == nil {
+		return BackendResponse{Error: errors.New(summary.String())}
+	}
+	response := utils.ResponseS3Error(request, http.StatusServiceUnavailable, "ServiceUnavailable",
+		fmt.Sprintf("Write quorum of %d out of %d storages not reached", wqp.quorum, wqp.backends))
+	return BackendResponse{Response: response, Request: request}
+}
+
+func (wqp *WriteQuorumPicker) allFailuresAreClientErrors() bool {
+	if !wqp.hasFailureResponse() {
+		return false
+	}
+	for _, failure := range wqp.errors {
+		if failure.Error != nil || failure.Response == nil ||
+			failure.Response.StatusCode < http.StatusBadRequest || failure.Response.StatusCode >= http.StatusInternalServerError {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,9 @@ package storages
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -89,6 +91,77 @@ func TestDeleteResponsePickerAllGood(t *testing.T) {
 	require.True(t, resp.StatusCode < 400)
 }
 
+func TestWriteQuorumPicker(t *testing.T) {
+	for _, testCase := range []struct {
+		quorum     int
+		responses  []bool
+		successful bool
+	}{
+		{2, []bool{true, true, false}, true},
+		{2, []bool{false, true, true}, true},
+		{2, []bool{true, false, false}, false},
+		{3, []bool{true, true, false}, false},
+		{3, []bool{true, true, true}, true},
+		{5, []bool{true, true, true}, true},
+	} {
+		responsesChan := createChanOfResponses(testCase.responses...)
+		picker := newWriteQuorumPicker(responsesChan, testCase.quorum, len(testCase.responses))
+		resp, err := picker.Pick()
+		require.NoError(t, err)
+		require.Equal(t, testCase.successful, resp.StatusCode < 400, "quorum %d of %v", testCase.quorum, testCase.responses)
+	}
+}
+
+func TestWriteQuorumPickerShouldFailFastWithS3Error(t *testing.T) {
+	request, _ := http.NewRequest("PUT", "http://some.domain/bucket/key", nil)
+	responsesChan := make(chan BackendResponse)
+	go func() {
+		responsesChan <- BackendResponse{Error: fmt.Errorf("someerror"), Backend: &StorageClient{Name: "storage1"}, Request: request}
+		responsesChan <- quorumTestResponse(request, "storage2", http.StatusInternalServerError)
+	}()
+
+	resp, err := newWriteQuorumPicker(responsesChan, 2, 3).Pick()
+	close(responsesChan)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "application/xml", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "<Code>ServiceUnavailable</Code>")
+	require.NotContains(t, string(body), "storage1")
+	require.NotContains(t, string(body), "storage2")
+}
+
+func TestWriteQuorumPickerShouldPassClientErrorsOfAllStorages(t *testing.T) {
+	request, _ := http.NewRequest("PUT", "http://some.domain/bucket/key", nil)
+	responsesChan := make(chan BackendResponse, 3)
+	responsesChan <- quorumTestResponse(request, "storage1", http.StatusForbidden)
+	responsesChan <- quorumTestResponse(request, "storage2", http.StatusForbidden)
+	responsesChan <- quorumTestResponse(request, "storage3", http.StatusForbidden)
+	close(responsesChan)
+
+	resp, err := newWriteQuorumPicker(responsesChan, 2, 3).Pick()
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "<Code>SignatureDoesNotMatch</Code>", string(body))
+}
+
+func quorumTestResponse(request *http.Request, backend string, statusCode int) BackendResponse {
+	body := "<Code>SignatureDoesNotMatch</Code>"
+	if statusCode >= http.StatusInternalServerError {
+		body = "<Code>InternalError</Code>"
+	}
+	return BackendResponse{
+		Response: &http.Response{Request: request, StatusCode: statusCode, Body: ioutil.NopCloser(strings.NewReader(body))},
+		Backend:  &StorageClient{Name: backend},
+		Request:  request,
+	}
+}
+
 func createChanOfResponses(successful ...bool) chan BackendResponse {
 	backendResponses := []BackendResponse{}
 	request, _ := http.NewRequest("GET", "http://some.domain/bucket/object", nil)
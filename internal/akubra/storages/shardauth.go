@@ -36,9 +36,28 @@ func (shardAuth *ShardAuthenticator) Backends() []*StorageClient {
 // RoundTrip first ensures that client is authorized to access the shard and the delegates
 // the request to shard client
 func (shardAuth *ShardAuthenticator) RoundTrip(req *http.Request) (*http.Response, error) {
+	backendsCredentials, resp, err := shardAuth.authorize(req)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	req, errCode := auth.WithChunkVerification(req, backendsCredentials)
+	if errCode != auth.ErrNone {
+		return auth.ErrorResponse(req, errCode), nil
+	}
+	return shardAuth.shardClient.RoundTrip(req)
+}
+
+//Authorize checks the signature of the request against the credentials of every storage of the shard,
+//it returns a response only if the request can't be delegated to the shard
+func (shardAuth *ShardAuthenticator) Authorize(req *http.Request) (*http.Response, error) {
+	_, resp, err := shardAuth.authorize(req)
+	return resp, err
+}
+
+func (shardAuth *ShardAuthenticator) authorize(req *http.Request) ([]auth.Keys, *http.Response, error) {
 	authHeaderVal := req.Context().Value(httphandler.AuthHeader)
 	if authHeaderVal == nil {
-		return shardAuth.shardClient.RoundTrip(req)
+		return nil, nil, nil
 	}
 
 	authHeader := authHeaderVal.(*utils.ParsedAuthorizationHeader)
@@ -46,20 +65,15 @@ func (shardAuth *ShardAuthenticator) RoundTrip(req *http.Request) (*http.Respons
 
 	var backendsCredentials []auth.Keys
 	for idx := range backends {
-		switch backends[idx].Type {
-		case auth.Passthrough:
-			continue
-		case auth.S3FixedKey:
-			backendsCredentials = append(backendsCredentials, extractKeysFrom(backends[idx].Properties))
-		case auth.S3AuthService:
-			keys, err := fetchKeysFor(authHeader.AccessKey, backends[idx])
-			if err != nil {
-				utils.SetRequestProcessingMetadata(
-					req,
-					"auth",
-					fmt.Sprintf("no keys for %s on backend %s", authHeader.AccessKey, backends[idx].Name))
-				return nil, err
-			}
+		keys, checked, err := backendKeys(authHeader.AccessKey, backends[idx])
+		if err != nil {
+			utils.SetRequestProcessingMetadata(
+				req,
+				"auth",
+				fmt.Sprintf("no keys for %s on backend %s", authHeader.AccessKey, backends[idx].Name))
+			return nil, nil, err
+		}
+		if checked {
 			log.Debugf("Backend %s creds added", backends[idx].Name)
 			backendsCredentials = append(backendsCredentials, keys)
 		}
@@ -67,15 +81,41 @@ func (shardAuth *ShardAuthenticator) RoundTrip(req *http.Request) (*http.Respons
 
 	for idx := range backendsCredentials {
 
-		if auth.ErrNone != auth.DoesSignMatch(req, backendsCredentials[idx], shardAuth.ignoredCanonicalizedHeaders) {
+		if errCode := auth.DoesSignMatch(req, backendsCredentials[idx], shardAuth.ignoredCanonicalizedHeaders); errCode != auth.ErrNone {
 			log.Debugf("authorization check failed for req %s, signature mismatch on storage '%s' using access '%s'",
 				req.Context().Value(log.ContextreqIDKey).(string), backends[idx].Name, backendsCredentials[idx].AccessKeyID)
 
-			return utils.ResponseForbidden(req), nil
+			return nil, auth.ErrorResponse(req, errCode), nil
+		}
+	}
+	return backendsCredentials, nil, nil
+}
+
+//BackendsKeys returns the credentials the requests signed with the client access key are checked against
+//on the backends, the passthrough backends don't check the requests
+func BackendsKeys(clientAccessKey string, backends []*StorageClient) ([]auth.Keys, error) {
+	var backendsCredentials []auth.Keys
+	for _, backend := range backends {
+		keys, checked, err := backendKeys(clientAccessKey, backend)
+		if err != nil {
+			return nil, err
+		}
+		if checked {
+			backendsCredentials = append(backendsCredentials, keys)
 		}
 	}
+	return backendsCredentials, nil
+}
 
-	return shardAuth.shardClient.RoundTrip(req)
+func backendKeys(clientAccessKey string, backend *StorageClient) (keys auth.Keys, checked bool, err error) {
+	switch backend.Type {
+	case auth.S3FixedKey:
+		return extractKeysFrom(backend.Properties), true, nil
+	case auth.S3AuthService:
+		keys, err = fetchKeysFor(clientAccessKey, backend)
+		return keys, true, err
+	}
+	return auth.Keys{}, false, nil
 }
 
 func fetchKeysFor(clientAccessKey string, backend *StorageClient) (auth.Keys, error) {
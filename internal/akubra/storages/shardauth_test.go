@@ -1,17 +1,32 @@
 package storages
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
 	"github.com/allegro/akubra/external/miniotweak/s3signer"
 	"github.com/allegro/akubra/internal/akubra/httphandler"
+	httphandlerconfig "github.com/allegro/akubra/internal/akubra/httphandler/config"
 	"github.com/allegro/akubra/internal/akubra/log"
 	"github.com/allegro/akubra/internal/akubra/storages/auth"
 	"github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/allegro/akubra/internal/akubra/types"
 	"github.com/allegro/akubra/internal/akubra/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
-	"net/http"
-	"testing"
 )
 
 type shardClientMock struct {
@@ -60,6 +75,7 @@ func TestShouldReturnAccessDeniedWhenCredentialsDoNotMatch(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "SignatureDoesNotMatch", s3ErrorCode(t, resp))
 }
 
 func TestShouldValidateRequestCredentialsBasedOnBackendType(t *testing.T) {
@@ -92,6 +108,228 @@ func TestShouldValidateRequestCredentialsBasedOnBackendType(t *testing.T) {
 	assert.Equal(t, &expectedResp, resp)
 }
 
+func TestShouldVerifyV4RequestsWithClockSkewAndUnsignedPayload(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		signedHeaders map[string]string
+		tamper        func(req *http.Request)
+		expectedError string
+	}{
+		{"valid request", nil, func(req *http.Request) {}, ""},
+		{"unsigned payload", map[string]string{"X-Amz-Content-Sha256": "UNSIGNED-PAYLOAD"}, func(req *http.Request) {}, ""},
+		{"skewed request", nil, func(req *http.Request) {
+			req.Header.Set("X-Amz-Date", time.Now().UTC().Add(-time.Hour).Format("20060102T150405Z"))
+		}, "RequestTimeTooSkewed"},
+		{"missing date", nil, func(req *http.Request) { req.Header.Del("X-Amz-Date") }, "AccessDenied"},
+	} {
+		req, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/bucket/obj", strings.NewReader("content"))
+		for name, value := range testCase.signedHeaders {
+			req.Header.Set(name, value)
+		}
+		req = s3signer.SignV4(req, "access", "secret", "", "us-east-1", "s3")
+		testCase.tamper(req)
+		req = withParsedAuth(t, req)
+
+		resp, err := fixedKeyShardAuthenticator(req, "access", "secret").RoundTrip(req)
+
+		assert.NoError(t, err, testCase.name)
+		if testCase.expectedError == "" {
+			assert.Equal(t, http.StatusOK, resp.StatusCode, testCase.name)
+			continue
+		}
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, testCase.name)
+		assert.Equal(t, testCase.expectedError, s3ErrorCode(t, resp), testCase.name)
+	}
+}
+
+func TestShouldVerifyV4RequestsDatedByDateHeader(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		date          time.Time
+		signedHeaders string
+		expectedError string
+	}{
+		{"valid request", time.Now(), "date;host;x-amz-content-sha256", ""},
+		{"skewed request", time.Now().Add(-time.Hour), "date;host;x-amz-content-sha256", "RequestTimeTooSkewed"},
+		{"unsigned date", time.Now(), "host;x-amz-content-sha256", "SignatureDoesNotMatch"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/obj", nil)
+		signV4WithDateHeader(req, "access", "secret", testCase.date, testCase.signedHeaders)
+		req = withParsedAuth(t, req)
+
+		resp, err := fixedKeyShardAuthenticator(req, "access", "secret").RoundTrip(req)
+
+		assert.NoError(t, err, testCase.name)
+		if testCase.expectedError == "" {
+			assert.Equal(t, http.StatusOK, resp.StatusCode, testCase.name)
+			continue
+		}
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, testCase.name)
+		assert.Equal(t, testCase.expectedError, s3ErrorCode(t, resp), testCase.name)
+	}
+}
+
+// signV4WithDateHeader signs the request dated by the Date header instead of X-Amz-Date, as s3signer always
+// sets the latter
+func signV4WithDateHeader(req *http.Request, access, secret string, date time.Time, signedHeaders string) {
+	date = date.UTC().Truncate(time.Second)
+	req.Header.Set("Date", date.Format(http.TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	canonicalHeaders := ""
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+		}
+		canonicalHeaders += name + ":" + value + "\n"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonicalHeaders, signedHeaders, "UNSIGNED-PAYLOAD"}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date.Format("20060102") + "/us-east-1/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + date.Format("20060102T150405Z") + "\n" + scope + "\n" +
+		hex.EncodeToString(canonicalRequestHash[:])
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+access+"/"+scope+", SignedHeaders="+signedHeaders+
+		", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func TestShouldVerifyPresignedV4Requests(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/obj", nil)
+	req = withParsedAuth(t, s3signer.PreSignV4(req, "access", "secret", "", "us-east-1", "s3", 60))
+
+	resp, err := fixedKeyShardAuthenticator(req, "access", "secret").RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = fixedKeyShardAuthenticator(req, "access", "other-secret").RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "SignatureDoesNotMatch", s3ErrorCode(t, resp))
+
+	expiredReq, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/obj", nil)
+	expiredReq = s3signer.PreSignV4(expiredReq, "access", "secret", "", "us-east-1", "s3", 60)
+	query := expiredReq.URL.Query()
+	query.Set("X-Amz-Date", time.Now().UTC().Add(-time.Hour).Format("20060102T150405Z"))
+	expiredReq.URL.RawQuery = query.Encode()
+	expiredReq = withParsedAuth(t, expiredReq)
+
+	resp, err = fixedKeyShardAuthenticator(expiredReq, "access", "secret").RoundTrip(expiredReq)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "AccessDenied", s3ErrorCode(t, resp))
+}
+
+func TestShouldVerifyChunksOfStreamingV4Upload(t *testing.T) {
+	dataLen := int64(100 * 1024)
+	signedReq, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/bucket/obj", bytes.NewReader(bytes.Repeat([]byte("a"), int(dataLen))))
+	signedReq = s3signer.StreamingSignV4(signedReq, "access", "secret", "", "us-east-1", "s3", dataLen, time.Now().UTC(), false)
+	signedPayload, err := ioutil.ReadAll(signedReq.Body)
+	require.NoError(t, err)
+
+	for _, testCase := range []struct {
+		name          string
+		payload       []byte
+		expectedError error
+	}{
+		{"signed chunks", signedPayload, nil},
+		{"tampered chunk", bytes.Replace(signedPayload, []byte("aaaa"), []byte("aaab"), 1), s3signer.ErrChunkSignatureMismatch},
+	} {
+		payload := testCase.payload
+		req := withParsedAuth(t, signedReq.Clone(context.Background()))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(payload)), nil
+		}
+		req.Body, _ = req.GetBody()
+
+		var readPayload []byte
+		var readErr error
+		shardMock := shardClientMock{Mock: &mock.Mock{}}
+		shardMock.On("Backends").Return([]*StorageClient{{Storage: config.Storage{
+			Type:       auth.S3FixedKey,
+			Properties: map[string]string{"AccessKey": "access", "Secret": "secret"}}}})
+		shardMock.On("RoundTrip", mock.Anything).Return(&http.Response{StatusCode: http.StatusOK}, nil).Run(func(args mock.Arguments) {
+			body, err := args.Get(0).(*http.Request).GetBody()
+			require.NoError(t, err)
+			readPayload, readErr = ioutil.ReadAll(body)
+		})
+
+		resp, err := NewShardAuthenticator(&shardMock, nil).RoundTrip(req)
+
+		assert.NoError(t, err, testCase.name)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, testCase.name)
+		assert.Equal(t, testCase.expectedError, readErr, testCase.name)
+		if testCase.expectedError == nil {
+			assert.Equal(t, signedPayload, readPayload, testCase.name)
+		}
+	}
+}
+
+func TestShouldAuthorizeURLPresignedWithKeysOfTheShard(t *testing.T) {
+	fixedKeyBackend := &StorageClient{Storage: config.Storage{
+		Type:       auth.S3FixedKey,
+		Properties: map[string]string{"AccessKey": "access", "Secret": "secret"}}}
+	passthroughBackend := &StorageClient{Storage: config.Storage{Type: auth.Passthrough}}
+	shardMock := shardClientMock{Mock: &mock.Mock{}}
+	shardMock.On("RoundTrip", mock.Anything).Return(&http.Response{StatusCode: http.StatusOK}, nil)
+	shardMock.On("Backends").Return([]*StorageClient{fixedKeyBackend, passthroughBackend, fixedKeyBackend})
+	shardAuthenticator := NewShardAuthenticator(&shardMock, nil).(*ShardAuthenticator)
+	presignHandler := auth.NewPresignHandler(httphandlerconfig.Presign{},
+		func(domain, method, path, accessKey string) ([]auth.Keys, error) {
+			return BackendsKeys(accessKey, shardMock.Backends())
+		})
+
+	presignReq := httptest.NewRequest(http.MethodGet, "http://localhost:8071/presign?domain=akubra.local&bucket=bucket&key=obj", nil)
+	presignReq = s3signer.SignV4(presignReq, "access", "secret", "", "us-east-1", "s3")
+	recorder := httptest.NewRecorder()
+	presignHandler.ServeHTTP(recorder, presignReq)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var presigned auth.PresignedURL
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&presigned))
+
+	req, err := http.NewRequest(presigned.Method, presigned.URL, nil)
+	require.NoError(t, err)
+	resp, err := shardAuthenticator.Authorize(withParsedAuth(t, req))
+
+	assert.NoError(t, err)
+	assert.Nil(t, resp, "presigned URL should be authorized by the shard")
+}
+
+func withParsedAuth(t *testing.T, req *http.Request) *http.Request {
+	authHeader, err := utils.ParseAuthorizationHeader(req.Header.Get("Authorization"))
+	if err == utils.ErrNoAuthHeader {
+		authHeader, err = utils.ParsePresignedV4Query(req.URL.Query())
+	}
+	require.NoError(t, err)
+	req = req.WithContext(context.WithValue(context.Background(), httphandler.AuthHeader, &authHeader))
+	return req.WithContext(context.WithValue(req.Context(), log.ContextreqIDKey, "123"))
+}
+
+func fixedKeyShardAuthenticator(req *http.Request, access, secret string) NamedShardClient {
+	fixedKeyBackend := StorageClient{Storage: config.Storage{
+		Type:       auth.S3FixedKey,
+		Properties: map[string]string{"AccessKey": access, "Secret": secret}}}
+	shardMock := shardClientMock{Mock: &mock.Mock{}}
+	shardMock.On("RoundTrip", mock.Anything).Return(&http.Response{Request: req, StatusCode: http.StatusOK}, nil)
+	shardMock.On("Backends").Return([]*StorageClient{&fixedKeyBackend})
+	return NewShardAuthenticator(&shardMock, nil)
+}
+
+func s3ErrorCode(t *testing.T, resp *http.Response) string {
+	var errorResponse types.ErrorResponse
+	require.NoError(t, xml.NewDecoder(resp.Body).Decode(&errorResponse))
+	assert.Equal(t, "application/xml", resp.Header.Get("Content-Type"))
+	return errorResponse.Code
+}
+
 func (mock *shardClientMock) Name() string {
 	return mock.Called().String(0)
 }
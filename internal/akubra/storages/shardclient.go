@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/allegro/akubra/internal/akubra/watchdog/config"
 
 	"github.com/allegro/akubra/internal/akubra/balancing"
 	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
 	"github.com/allegro/akubra/internal/akubra/utils"
 	"github.com/allegro/akubra/internal/akubra/watchdog"
 	set "github.com/deckarep/golang-set"
@@ -33,9 +35,24 @@ type ShardClient struct {
 
 // RoundTrip implements http.RoundTripper interface
 func (shardClient *ShardClient) RoundTrip(request *http.Request) (*http.Response, error) {
+	since := time.Now()
+	resp, err := shardClient.roundTrip(request)
+	region, _ := request.Context().Value(metrics.Region).(string)
+	metrics.ObserveShardRequest(shardClient.name, region, request.Method, resp, err, since)
+	return resp, err
+}
+
+func (shardClient *ShardClient) roundTrip(request *http.Request) (*http.Response, error) {
 	reqID, _ := request.Context().Value(log.ContextreqIDKey).(string)
 	log.Debugf("Shard: Got request id %s", reqID)
-	if shardClient.balancer != nil && (request.Method == http.MethodGet || request.Method == http.MethodHead || request.Method == http.MethodOptions) {
+	if isVerifiedReadRequest(request) {
+		if storages := shardClient.verifiedStorages(); len(storages) > 0 {
+			log.Debugf("Request %s processed by verified read", reqID)
+			return shardClient.verifiedRead(request, storages)
+		}
+	}
+	isReadRequest := request.Method == http.MethodGet || request.Method == http.MethodHead || request.Method == http.MethodOptions
+	if shardClient.balancer != nil && isReadRequest && !isMultipartFanOutRequest(request) {
 		resp, err := shardClient.balancerRoundTrip(request)
 		log.Debugf("Request %s, processed by balancer error %s", reqID, err)
 		return resp, err
@@ -54,15 +71,13 @@ func (shardClient *ShardClient) balancerRoundTrip(req *http.Request) (resp *http
 		if node == nil {
 			return nil, fmt.Errorf("all balancer nodes are unavailable")
 		}
-		var nodeRequest *http.Request
-		nodeRequest, err = utils.ReplicateRequest(req)
-		if err != nil {
+		var askedNodes []balancing.Node
+		resp, askedNodes, err = shardClient.readFromNodes(req, node, notFoundNodes)
+		if askedNodes == nil {
 			return nil, err
 		}
-
-		resp, err = node.RoundTrip(nodeRequest)
 		if (resp == nil && err != balancing.ErrNoActiveNodes) || http.StatusNotFound == resp.StatusCode || http.StatusForbidden == resp.StatusCode {
-			notFoundNodes = append(notFoundNodes, node)
+			notFoundNodes = append(notFoundNodes, askedNodes...)
 			continue
 		}
 		if len(notFoundNodes) > 0 {
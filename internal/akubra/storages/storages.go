@@ -30,6 +30,30 @@ type Storages struct {
 	Backends     map[string]*StorageClient
 	watchdog     watchdog.ConsistencyWatchdog
 	shardFactory *shardFactory
+	prober       *healthProber
+}
+
+// StartHealthProbes starts probing the storages with HealthProbe configured
+func (st *Storages) StartHealthProbes() {
+	if st.prober != nil {
+		st.prober.start()
+	}
+}
+
+// Health returns the result of the storage health probes, false if the storage isn't probed
+func (st *Storages) Health(name string) (StorageHealth, bool) {
+	if st.prober == nil {
+		return StorageHealth{}, false
+	}
+	return st.prober.storageHealth(name)
+}
+
+// Close stops the health probes
+func (st *Storages) Close() error {
+	if st.prober != nil {
+		st.prober.close()
+	}
+	return nil
 }
 
 // GetShard gets cluster by name or nil if cluster with given name was not found
@@ -110,20 +134,22 @@ func (factory *Factory) InitStorages(clustersConf config.ShardsMap, storagesMap
 
 	for name, clusterConf := range clustersConf {
 		cluster, err := factory.shardFactory.newShard(name, storageNames(clusterConf), storageClients)
-		cluster.balancer = balancing.NewBalancerPrioritySet(clusterConf.Storages, convertToRoundTrippersMap(storageClients))
+		cluster.balancer = balancing.NewShardBalancerPrioritySet(clusterConf, convertToRoundTrippersMap(storageClients))
 		if err != nil {
 			return nil, err
 		}
 		shards[name] = cluster
 	}
 
-	return &Storages{
+	storages := &Storages{
 		clustersConf: clustersConf,
 		storagesMap:  storagesMap,
 		ShardClients: shards,
 		Backends:     storageClients,
 		shardFactory: factory.shardFactory,
-	}, nil
+	}
+	storages.prober = newHealthProber(storages)
+	return storages, nil
 }
 
 func convertToRoundTrippersMap(backends map[string]*StorageClient) map[string]http.RoundTripper {
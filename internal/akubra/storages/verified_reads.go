@@ -0,0 +1,205 @@
+package storages
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/allegro/akubra/internal/akubra/balancing"
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/storages/auth"
+	"github.com/allegro/akubra/internal/akubra/storages/backend"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+)
+
+// VerifyReads is a constant used to put/get policy verify reads flag to/from request's context
+const VerifyReads = log.ContextKey("VerifyReads")
+
+// noObjectVersion is the version of responses without the object version header, it's older than any version
+const noObjectVersion = int64(-1)
+
+type verifiedResponse struct {
+	storage string
+	rt      http.RoundTripper
+	method  string
+	resp    *http.Response
+	err     error
+	cancel  context.CancelFunc
+	version int64
+}
+
+func (response *verifiedResponse) isSuccessful() bool {
+	return backend.IsSuccessful(response.resp, response.err)
+}
+
+func (response *verifiedResponse) isNotFound() bool {
+	return response.err == nil && response.resp != nil && response.resp.StatusCode == http.StatusNotFound
+}
+
+func (response *verifiedResponse) etag() string {
+	return response.resp.Header.Get("ETag")
+}
+
+// isVerifiedReadRequest tells if the object read should be compared across all storages of the shard
+func isVerifiedReadRequest(request *http.Request) bool {
+	verify, ok := request.Context().Value(VerifyReads).(bool)
+	if !ok || !verify {
+		return false
+	}
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return false
+	}
+	return utils.IsObjectPath(request.URL.Path) && !utils.IsMultiPartUploadRequest(request)
+}
+
+// verifiedRead sends HEAD of the object to all storages of the shard and answers with the newest object version,
+// a GET is sent only to the storage holding it, so the object is downloaded once. The storages passing the client
+// signature through get the read with its original method, as the signature covers the method. If the storages
+// disagree on the object, a read repair is requested
+func (shardClient *ShardClient) verifiedRead(req *http.Request, storages []namedRoundTripper) (*http.Response, error) {
+	responses := shardClient.headAllStorages(req, storages)
+	picked := pickNewestResponse(responses)
+	if picked.isSuccessful() && objectDiverged(responses, picked) {
+		log.Printf("Verified read: storages diverge on %s, picked %s with version %d, reqID %s",
+			req.URL.Path, picked.storage, picked.version, utils.RequestID(req))
+		utils.PutResponseHeaderToContext(req.Context(), watchdog.ReadRepairObjectVersion, picked.resp, shardClient.watchdogVersionHeaderName)
+	}
+	for _, response := range responses {
+		if response != picked {
+			discardVerifiedResponse(response)
+		}
+	}
+	if picked.method == req.Method || picked.resp == nil {
+		return winVerifiedResponse(picked)
+	}
+	discardVerifiedResponse(picked)
+	return winVerifiedResponse(readFromStorage(req, picked.storage, picked.rt))
+}
+
+// headAllStorages sends HEAD of the read object to the storages in parallel
+func (shardClient *ShardClient) headAllStorages(req *http.Request, storages []namedRoundTripper) []*verifiedResponse {
+	_, signed := req.Context().Value(httphandler.AuthHeader).(*utils.ParsedAuthorizationHeader)
+	responses := make([]*verifiedResponse, len(storages))
+	wg := sync.WaitGroup{}
+	for idx, storage := range storages {
+		wg.Add(1)
+		go func(idx int, storage namedRoundTripper) {
+			defer wg.Done()
+			headRequest := req.WithContext(req.Context())
+			if !signed || !storage.forwardsSignature {
+				headRequest.Method = http.MethodHead
+			}
+			response := readFromStorage(headRequest, storage.name, storage.rt)
+			responses[idx] = response
+			if response.isSuccessful() && shardClient.watchdogVersionHeaderName != "" {
+				if version, err := strconv.ParseInt(response.resp.Header.Get(shardClient.watchdogVersionHeaderName), 10, 64); err == nil {
+					response.version = version
+				}
+			}
+		}(idx, storage)
+	}
+	wg.Wait()
+	return responses
+}
+
+// readFromStorage sends the read to the storage, the response is cancelled with the cancel func of the response
+func readFromStorage(req *http.Request, name string, storage http.RoundTripper) *verifiedResponse {
+	ctx, cancel := context.WithCancel(req.Context())
+	response := &verifiedResponse{storage: name, rt: storage, method: req.Method, cancel: cancel, version: noObjectVersion}
+	storageRequest, err := utils.ReplicateRequest(req.WithContext(ctx))
+	if err != nil {
+		response.err = err
+		return response
+	}
+	response.resp, response.err = storage.RoundTrip(storageRequest)
+	return response
+}
+
+type namedRoundTripper struct {
+	name              string
+	rt                http.RoundTripper
+	forwardsSignature bool
+}
+
+// verifiedStorages returns the storages of the shard, through their balancer members if the shard is balanced,
+// the storages with open breakers are skipped
+func (shardClient *ShardClient) verifiedStorages() []namedRoundTripper {
+	measuredStorages := make(map[string]*balancing.MeasuredStorage)
+	if shardClient.balancer != nil {
+		for _, measuredStorage := range shardClient.balancer.MeasuredStorages() {
+			measuredStorages[measuredStorage.Name] = measuredStorage
+		}
+	}
+	storages := make([]namedRoundTripper, 0, len(shardClient.backends))
+	for _, storage := range shardClient.backends {
+		forwardsSignature := storage.Type == auth.Passthrough
+		measuredStorage, balanced := measuredStorages[storage.Name]
+		if !balanced {
+			storages = append(storages, namedRoundTripper{name: storage.Name, rt: storage, forwardsSignature: forwardsSignature})
+			continue
+		}
+		if measuredStorage.IsOpen() {
+			continue
+		}
+		storages = append(storages, namedRoundTripper{name: storage.Name, rt: measuredStorage, forwardsSignature: forwardsSignature})
+	}
+	return storages
+}
+
+// pickNewestResponse picks the successful response with the highest object version, the first one on ties.
+// Without successful responses "not found" is preferred over failures
+func pickNewestResponse(responses []*verifiedResponse) *verifiedResponse {
+	var picked *verifiedResponse
+	for _, response := range responses {
+		if response.isSuccessful() && (picked == nil || response.version > picked.version) {
+			picked = response
+		}
+	}
+	if picked != nil {
+		return picked
+	}
+	for _, response := range responses {
+		if response.isNotFound() {
+			return response
+		}
+	}
+	for _, response := range responses {
+		if response.resp != nil {
+			return response
+		}
+	}
+	return responses[0]
+}
+
+// objectDiverged tells if any storage is missing the object or has other version or ETag of it than the picked one,
+// failed storages tell nothing about the object
+func objectDiverged(responses []*verifiedResponse, picked *verifiedResponse) bool {
+	for _, response := range responses {
+		if response.isNotFound() {
+			return true
+		}
+		if response.isSuccessful() && (response.version != picked.version || response.etag() != picked.etag()) {
+			return true
+		}
+	}
+	return false
+}
+
+func winVerifiedResponse(response *verifiedResponse) (*http.Response, error) {
+	if response.resp == nil || response.resp.Body == nil {
+		response.cancel()
+		return response.resp, response.err
+	}
+	response.resp.Body = &cancelOnCloseBody{ReadCloser: response.resp.Body, cancel: response.cancel}
+	return response.resp, response.err
+}
+
+func discardVerifiedResponse(response *verifiedResponse) {
+	defer response.cancel()
+	if response.resp != nil && response.resp.Body != nil {
+		_ = response.resp.Body.Close()
+	}
+}
@@ -0,0 +1,180 @@
+package storages
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/balancing"
+	"github.com/allegro/akubra/internal/akubra/httphandler"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/storages/auth"
+	"github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/allegro/akubra/internal/akubra/utils"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	"github.com/stretchr/testify/require"
+)
+
+const testVersionHeader = "x-amz-meta-version"
+
+type versionedStorage struct {
+	name    string
+	status  int
+	version string
+	etag    string
+	calls   int32
+	gets    int32
+}
+
+func (storage *versionedStorage) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&storage.calls, 1)
+	if req.Method == http.MethodGet {
+		atomic.AddInt32(&storage.gets, 1)
+	}
+	header := http.Header{}
+	if storage.version != "" {
+		header.Set(testVersionHeader, storage.version)
+	}
+	if storage.etag != "" {
+		header.Set("ETag", storage.etag)
+	}
+	return &http.Response{
+		StatusCode: storage.status,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(storage.name)),
+		Request:    req,
+	}, nil
+}
+
+func newVerifiedShard(storages ...*versionedStorage) *ShardClient {
+	backends := make([]*StorageClient, 0, len(storages))
+	for _, storage := range storages {
+		backends = append(backends, &StorageClient{Name: storage.name, RoundTripper: storage})
+	}
+	return &ShardClient{name: "shard", backends: backends, watchdogVersionHeaderName: testVersionHeader}
+}
+
+func newVerifiedReadRequest(method string) (*http.Request, *string) {
+	readRepairVersion := ""
+	req := httptest.NewRequest(method, "http://akubra/bucket/object", nil)
+	req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+	ctx := context.WithValue(req.Context(), VerifyReads, true)
+	ctx = context.WithValue(ctx, watchdog.ReadRepairObjectVersion, &readRepairVersion)
+	return req.WithContext(ctx), &readRepairVersion
+}
+
+func readVerifiedBody(t *testing.T, resp *http.Response) string {
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
+
+func TestVerifiedReadShouldPickNewestVersionAndRequestReadRepair(t *testing.T) {
+	stale := &versionedStorage{name: "stale", status: http.StatusOK, version: "3", etag: `"a"`}
+	newest := &versionedStorage{name: "newest", status: http.StatusOK, version: "5", etag: `"b"`}
+	failing := &versionedStorage{name: "failing", status: http.StatusInternalServerError}
+	shard := newVerifiedShard(stale, newest, failing)
+	req, readRepairVersion := newVerifiedReadRequest(http.MethodGet)
+
+	resp, err := shard.RoundTrip(req)
+
+	require.NoError(t, err)
+	require.Equal(t, "newest", readVerifiedBody(t, resp))
+	require.Equal(t, "5", *readRepairVersion)
+	require.Equal(t, []int32{1, 2, 1}, []int32{stale.calls, newest.calls, failing.calls}, "all storages should be asked for HEAD")
+	require.Equal(t, []int32{0, 1, 0}, []int32{stale.gets, newest.gets, failing.gets}, "object should be downloaded from the picked storage only")
+}
+
+func TestVerifiedReadShouldKeepMethodOfSignedReadsToPassthroughStorages(t *testing.T) {
+	stale := &versionedStorage{name: "stale", status: http.StatusOK, version: "3"}
+	newest := &versionedStorage{name: "newest", status: http.StatusOK, version: "5"}
+	shard := newVerifiedShard(stale, newest)
+	for _, backend := range shard.backends {
+		backend.Type = auth.Passthrough
+	}
+	req, _ := newVerifiedReadRequest(http.MethodGet)
+	req = req.WithContext(context.WithValue(req.Context(), httphandler.AuthHeader, &utils.ParsedAuthorizationHeader{AccessKey: "access"}))
+
+	resp, err := shard.RoundTrip(req)
+
+	require.NoError(t, err)
+	require.Equal(t, "newest", readVerifiedBody(t, resp))
+	require.Equal(t, []int32{1, 1}, []int32{stale.gets, newest.gets}, "signature of passthrough storages covers the method")
+}
+
+func TestVerifiedReadShouldRequestReadRepairIfETagsOrPresenceDiverge(t *testing.T) {
+	for _, testCase := range []struct {
+		caseName string
+		second   *versionedStorage
+		repair   bool
+	}{
+		{"same object", &versionedStorage{name: "second", status: http.StatusOK, version: "5", etag: `"a"`}, false},
+		{"other etag", &versionedStorage{name: "second", status: http.StatusOK, version: "5", etag: `"b"`}, true},
+		{"missing object", &versionedStorage{name: "second", status: http.StatusNotFound}, true},
+		{"failed storage", &versionedStorage{name: "second", status: http.StatusServiceUnavailable}, false},
+	} {
+		shard := newVerifiedShard(&versionedStorage{name: "first", status: http.StatusOK, version: "5", etag: `"a"`}, testCase.second)
+		req, readRepairVersion := newVerifiedReadRequest(http.MethodHead)
+
+		resp, err := shard.RoundTrip(req)
+
+		require.NoError(t, err, testCase.caseName)
+		require.Equal(t, "first", readVerifiedBody(t, resp), testCase.caseName)
+		require.Equal(t, testCase.repair, *readRepairVersion == "5", testCase.caseName)
+	}
+}
+
+func TestVerifiedReadShouldAnswerNotFoundIfNoStorageHasObject(t *testing.T) {
+	shard := newVerifiedShard(
+		&versionedStorage{name: "failing", status: http.StatusServiceUnavailable},
+		&versionedStorage{name: "empty", status: http.StatusNotFound},
+	)
+	req, readRepairVersion := newVerifiedReadRequest(http.MethodGet)
+
+	resp, err := shard.RoundTrip(req)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, "", *readRepairVersion)
+}
+
+func TestVerifiedReadShouldSkipStoragesWithOpenBreakers(t *testing.T) {
+	open := &versionedStorage{name: "open", status: http.StatusOK, version: "9"}
+	closed := &versionedStorage{name: "closed", status: http.StatusOK, version: "1"}
+	shard := newVerifiedShard(open, closed)
+	shardStorages := config.Storages{}
+	for _, name := range []string{"open", "closed"} {
+		shardStorages = append(shardStorages, config.StorageBreakerProperties{
+			Name:                           name,
+			BreakerProbeSize:               10,
+			BreakerErrorRate:               0.1,
+			BreakerCallTimeLimit:           metrics.Interval{Duration: time.Second},
+			BreakerCallTimeLimitPercentile: 0.9,
+			BreakerBasicCutOutDuration:     metrics.Interval{Duration: time.Second},
+			BreakerMaxCutOutDuration:       metrics.Interval{Duration: time.Minute},
+			MeterResolution:                metrics.Interval{Duration: time.Second},
+			MeterRetention:                 metrics.Interval{Duration: time.Minute},
+		})
+	}
+	clients := map[string]*StorageClient{"open": shard.backends[0], "closed": shard.backends[1]}
+	shard.balancer = balancing.NewBalancerPrioritySet(shardStorages, convertToRoundTrippersMap(clients))
+	for _, measuredStorage := range shard.balancer.MeasuredStorages() {
+		if measuredStorage.Name == "open" {
+			measuredStorage.ForceBreaker(balancing.BreakerForcedOpen)
+		}
+	}
+	req, _ := newVerifiedReadRequest(http.MethodGet)
+
+	resp, err := shard.RoundTrip(req)
+
+	require.NoError(t, err)
+	require.Equal(t, "closed", readVerifiedBody(t, resp))
+	require.Zero(t, atomic.LoadInt32(&open.calls))
+}
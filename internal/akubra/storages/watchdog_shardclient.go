@@ -1,9 +1,13 @@
 package storages
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metadata"
+	"github.com/allegro/akubra/internal/akubra/metrics"
 	"github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/allegro/akubra/internal/akubra/utils"
 	"github.com/allegro/akubra/internal/akubra/watchdog"
@@ -12,6 +16,9 @@ import (
 	"time"
 )
 
+//SkipConsistencyRecord marks multi-object deletes which only clean up after the objects and are not recorded
+const SkipConsistencyRecord = log.ContextKey("SkipConsistencyRecord")
+
 //ConsistencyShardClient is a shard that guarantees consistency based on the defined provided consistency level
 type ConsistencyShardClient struct {
 	watchdog          watchdog.ConsistencyWatchdog
@@ -28,6 +35,7 @@ type consistencyRequest struct {
 	isInitiateMultipartUploadRequest bool
 	consistencyLevel                 config.ConsistencyLevel
 	isReadRepairOn                   bool
+	multiDeleteMarkers               []*watchdog.DeleteMarker
 }
 
 //Name returns the name of the shard
@@ -55,7 +63,11 @@ func (consistencyShard *ConsistencyShardClient) RoundTrip(req *http.Request) (*h
 		isMultiPartUploadRequest:         utils.IsMultiPartUploadRequest(req),
 		isInitiateMultipartUploadRequest: utils.IsInitiateMultiPartUploadRequest(req),
 	}
-	consistencyRequest, err = consistencyShard.ensureConsistency(consistencyRequest)
+	if utils.IsMultiDeleteRequest(req) {
+		consistencyRequest, err = consistencyShard.logMultiDelete(consistencyRequest)
+	} else {
+		consistencyRequest, err = consistencyShard.ensureConsistency(consistencyRequest)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +78,9 @@ func (consistencyShard *ConsistencyShardClient) RoundTrip(req *http.Request) (*h
 	}
 	go consistencyShard.awaitCompletion(consistencyRequest)
 
+	if utils.IsAbortMultiPartUploadRequest(req) {
+		consistencyShard.clearAbortedMultipart(consistencyRequest, resp)
+	}
 	if consistencyRequest.isInitiateMultipartUploadRequest {
 		return consistencyShard.logIfInitMultiPart(consistencyRequest, resp)
 	}
@@ -82,6 +97,9 @@ func extractRegionPropsFrom(request *http.Request) (config.ConsistencyLevel, boo
 		return "", false, errors.New("couldn't determine consistency level of region")
 
 	}
+	if bucketMetaData := metadata.FromContext(request.Context()); bucketMetaData != nil && bucketMetaData.ConsistencyLevel != "" {
+		consistencyLevel = bucketMetaData.ConsistencyLevel
+	}
 	readRepairProp := request.Context().Value(watchdog.ReadRepair)
 	if readRepairProp == nil {
 		return "", false, errors.New("'ReadRepair' not present in request's context")
@@ -101,7 +119,7 @@ func (consistencyShard *ConsistencyShardClient) shouldLogRequest(consistencyRequ
 		return false
 	}
 	isObjectPath := utils.IsObjectPath(consistencyRequest.URL.Path)
-	if http.MethodDelete == consistencyRequest.Request.Method && isObjectPath {
+	if http.MethodDelete == consistencyRequest.Request.Method && isObjectPath && !consistencyRequest.isMultiPartUploadRequest {
 		return true
 	}
 	isPutOrInitMultiPart := (http.MethodPut == consistencyRequest.Request.Method && !consistencyRequest.isMultiPartUploadRequest) ||
@@ -117,7 +135,7 @@ func (consistencyShard *ConsistencyShardClient) logRequest(consistencyRequest *c
 			return nil, err
 		}
 	} else {
-		deleteMarker, err := consistencyShard.watchdog.Insert(consistencyRequest.ConsistencyRecord)
+		deleteMarker, err := consistencyShard.insert(consistencyRequest.ConsistencyRecord, consistencyRequest.consistencyLevel)
 		if err != nil {
 			return consistencyRequest, err
 		}
@@ -134,14 +152,71 @@ func (consistencyShard *ConsistencyShardClient) logMultipart(consistencyRequest
 	if err != nil {
 		return fmt.Errorf("failed on extracting multipart upload ID from response: %s", err)
 	}
-	consistencyRequest.ConsistencyRecord.RequestID = multiPartUploadID
-	_, err = consistencyShard.watchdog.Insert(consistencyRequest.ConsistencyRecord)
+	consistencyRequest.ConsistencyRecord.RequestID = multipartRecordID(multiPartUploadID)
+	_, err = consistencyShard.insert(consistencyRequest.ConsistencyRecord, consistencyRequest.consistencyLevel)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+//logMultiDelete records the deletion of every object listed in a multi-object delete request
+func (consistencyShard *ConsistencyShardClient) logMultiDelete(consistencyRequest *consistencyRequest) (*consistencyRequest, error) {
+	if consistencyShard.watchdog == nil || consistencyRequest.consistencyLevel == config.None {
+		return consistencyRequest, nil
+	}
+	if skip, _ := consistencyRequest.Context().Value(SkipConsistencyRecord).(bool); skip {
+		return consistencyRequest, nil
+	}
+	deleteRequest, err := utils.ReadDeleteObjectsRequest(consistencyRequest.Request)
+	if err != nil {
+		if config.Strong == consistencyRequest.consistencyLevel {
+			return nil, err
+		}
+		return consistencyRequest, nil
+	}
+	bucket := utils.ExtractBucketFrom(consistencyRequest.URL.Path)
+	for _, object := range deleteRequest.Objects {
+		objectRequest := consistencyRequest.Request.Clone(consistencyRequest.Context())
+		objectRequest.Method = http.MethodDelete
+		objectRequest.URL.Path = fmt.Sprintf("/%s/%s", bucket, object.Key)
+		objectRequest.URL.RawPath = ""
+		objectRequest.URL.RawQuery = ""
+		deleteMarker, err := consistencyShard.logObjectDelete(objectRequest, consistencyRequest.consistencyLevel)
+		if err != nil {
+			if config.Strong == consistencyRequest.consistencyLevel {
+				return nil, err
+			}
+			continue
+		}
+		if deleteMarker != nil {
+			consistencyRequest.multiDeleteMarkers = append(consistencyRequest.multiDeleteMarkers, deleteMarker)
+		}
+	}
+	return consistencyRequest, nil
+}
+
+func (consistencyShard *ConsistencyShardClient) logObjectDelete(objectRequest *http.Request, consistencyLevel config.ConsistencyLevel) (*watchdog.DeleteMarker, error) {
+	record, err := consistencyShard.recordFactory.CreateRecordFor(objectRequest)
+	if err != nil {
+		return nil, err
+	}
+	record.RequestID = multiDeleteRecordID(record.RequestID, objectRequest.URL.Path)
+	return consistencyShard.insert(record, consistencyLevel)
+}
+
+func (consistencyShard *ConsistencyShardClient) insert(record *watchdog.ConsistencyRecord, consistencyLevel config.ConsistencyLevel) (*watchdog.DeleteMarker, error) {
+	deleteMarker, err := consistencyShard.watchdog.Insert(record)
+	metrics.ObserveWatchdogRecord(string(consistencyLevel), err)
+	return deleteMarker, err
+}
+
+// multiDeleteRecordID derives an unique record id for each object of a multi-object delete
+func multiDeleteRecordID(requestID, objectPath string) string {
+	hash := md5.Sum([]byte(requestID + objectPath))
+	return hex.EncodeToString(hash[:])
+}
+
 func (consistencyShard *ConsistencyShardClient) ensureConsistency(consistencyRequest *consistencyRequest) (*consistencyRequest, error) {
 	if !consistencyShard.shouldLogRequest(consistencyRequest) {
 		return consistencyRequest, nil
@@ -176,11 +251,30 @@ func (consistencyShard *ConsistencyShardClient) logIfInitMultiPart(consistencyRe
 	return response, nil
 }
 
+//clearAbortedMultipart removes the record inserted on the upload initiation, so the object which was never
+//uploaded is not synchronized by the watchdog
+func (consistencyShard *ConsistencyShardClient) clearAbortedMultipart(consistencyRequest *consistencyRequest, resp *http.Response) {
+	if consistencyShard.watchdog == nil || consistencyRequest.consistencyLevel == config.None {
+		return
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return
+	}
+	uploadID := consistencyRequest.URL.Query().Get("uploadId")
+	err := consistencyShard.watchdog.DeleteRecord(multipartRecordID(uploadID))
+	if err != nil {
+		log.Printf("Failed to delete record of aborted multipart upload %s, reqId = %s, error: %s",
+			uploadID, consistencyRequest.Context().Value(log.ContextreqIDKey), err)
+		return
+	}
+	log.Debugf("Deleted record of aborted multipart upload %s", uploadID)
+}
+
 func (consistencyShard *ConsistencyShardClient) updateExecutionDelay(request *http.Request) {
 	reqQuery := request.URL.Query()
 	uploadID := reqQuery["uploadId"]
 	delta := &watchdog.ExecutionDelay{
-		RequestID: uploadID[0],
+		RequestID: multipartRecordID(uploadID[0]),
 		Delay:     time.Minute * 5,
 	}
 	err := consistencyShard.watchdog.UpdateExecutionDelay(delta)
@@ -212,7 +306,7 @@ func (consistencyShard *ConsistencyShardClient) performReadRepair(consistencyReq
 		return
 	}
 	record.ObjectVersion = int(objectVersion)
-	_, err = consistencyShard.watchdog.Insert(record)
+	_, err = consistencyShard.insert(record, consistencyRequest.consistencyLevel)
 	if err != nil {
 		log.Debugf("Failed to perform read repair for object %s in domain %s: %s", record.ObjectID, record.Domain, err)
 	}
@@ -241,6 +335,14 @@ func (consistencyShard *ConsistencyShardClient) awaitCompletion(consistencyReque
 			log.Printf("Failed to delete records older than record for request %s: %s", reqID, err)
 		}
 	}
+	if errorsFlagCastOk && noErrorsDuringRequestProcessing != nil && *noErrorsDuringRequestProcessing {
+		for _, deleteMarker := range consistencyRequest.multiDeleteMarkers {
+			err := consistencyShard.watchdog.Delete(deleteMarker)
+			if err != nil {
+				log.Printf("Failed to delete records older than record for request %s: %s", reqID, err)
+			}
+		}
+	}
 }
 
 func wasReplicationSuccessful(request *consistencyRequest, noErrorsDuringRequestProcessing *bool, castOk bool) bool {
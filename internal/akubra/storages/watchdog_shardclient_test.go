@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/allegro/akubra/internal/akubra/metadata"
 	"github.com/allegro/akubra/internal/akubra/regions/config"
 	"github.com/allegro/akubra/internal/akubra/watchdog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/tools/go/ssa/interp/testdata/src/errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"testing"
 )
 
@@ -178,6 +181,140 @@ func TestReadRepair(t *testing.T) {
 	}
 }
 
+func TestAbortedMultipartUploadRecordRemoval(t *testing.T) {
+	for _, testCase := range []struct {
+		consistencyLevel   config.ConsistencyLevel
+		statusCode         int
+		shouldDeleteRecord bool
+	}{
+		{consistencyLevel: config.Strong, statusCode: http.StatusNoContent, shouldDeleteRecord: true},
+		{consistencyLevel: config.Weak, statusCode: http.StatusNoContent, shouldDeleteRecord: true},
+		{consistencyLevel: config.Strong, statusCode: http.StatusNotFound, shouldDeleteRecord: false},
+		{consistencyLevel: config.None, statusCode: http.StatusNoContent, shouldDeleteRecord: false},
+	} {
+		shardMock := &ShardClientMock{&mock.Mock{}}
+		factoryMock := &ConsistencyRecordFactoryMock{&mock.Mock{}}
+		watchdogMock := &WatchdogMock{&mock.Mock{}}
+
+		consistentShard := ConsistencyShardClient{
+			watchdog:          watchdogMock,
+			shard:             shardMock,
+			recordFactory:     factoryMock,
+			versionHeaderName: "x-watchdog-version",
+		}
+
+		request, err := http.NewRequest(http.MethodDelete, "http://localhost:8080/bucket/object?uploadId=upload-id", nil)
+		assert.Nil(t, err)
+		request = request.WithContext(context.WithValue(request.Context(), watchdog.ConsistencyLevel, testCase.consistencyLevel))
+		request = request.WithContext(context.WithValue(request.Context(), watchdog.ReadRepair, false))
+
+		response := &http.Response{Request: request, StatusCode: testCase.statusCode}
+		shardMock.On("RoundTrip", request).Return(response, nil)
+		watchdogMock.On("DeleteRecord", "upload-id").Return(nil)
+
+		resp, err := consistentShard.RoundTrip(request)
+		assert.Nil(t, err)
+		assert.Equal(t, response, resp)
+
+		factoryMock.AssertNotCalled(t, "CreateRecordFor", request)
+		if testCase.shouldDeleteRecord {
+			watchdogMock.AssertCalled(t, "DeleteRecord", "upload-id")
+		} else {
+			watchdogMock.AssertNotCalled(t, "DeleteRecord", "upload-id")
+		}
+	}
+}
+
+func TestMultiObjectDeleteRecordsEveryDeletedObject(t *testing.T) {
+	shardMock := &ShardClientMock{&mock.Mock{}}
+	factoryMock := &ConsistencyRecordFactoryMock{&mock.Mock{}}
+	watchdogMock := &WatchdogMock{&mock.Mock{}}
+
+	consistentShard := ConsistencyShardClient{
+		watchdog:          watchdogMock,
+		shard:             shardMock,
+		recordFactory:     factoryMock,
+		versionHeaderName: "x-watchdog-version",
+	}
+
+	body := []byte("<Delete><Object><Key>first</Key></Object><Object><Key>second</Key></Object></Delete>")
+	request, err := http.NewRequest(http.MethodPost, "http://localhost:8080/bucket?delete", bytes.NewReader(body))
+	assert.Nil(t, err)
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	noErrors := true
+	ctx, cancel := context.WithCancel(context.WithValue(request.Context(), watchdog.NoErrorsDuringRequest, &noErrors))
+	request = request.WithContext(ctx)
+	request = request.WithContext(context.WithValue(request.Context(), watchdog.ConsistencyLevel, config.Strong))
+	request = request.WithContext(context.WithValue(request.Context(), watchdog.ReadRepair, false))
+
+	deletedMarkers := sync.WaitGroup{}
+	deletedMarkers.Add(2)
+	records := make(map[string]*watchdog.ConsistencyRecord)
+	markers := make(map[string]*watchdog.DeleteMarker)
+	for _, key := range []string{"first", "second"} {
+		objectPath := "/bucket/" + key
+		records[key] = &watchdog.ConsistencyRecord{RequestID: "req-id", ObjectID: "bucket/" + key}
+		markers[key] = &watchdog.DeleteMarker{}
+		factoryMock.On("CreateRecordFor", mock.MatchedBy(func(req *http.Request) bool {
+			return req.Method == http.MethodDelete && req.URL.Path == objectPath
+		})).Return(records[key], nil)
+		watchdogMock.On("Insert", records[key]).Return(markers[key], nil)
+		watchdogMock.On("Delete", markers[key]).Return(nil).Run(func(mock.Arguments) { deletedMarkers.Done() })
+	}
+	response := &http.Response{Request: request, StatusCode: http.StatusOK}
+	shardMock.On("RoundTrip", request).Return(response, nil)
+
+	resp, err := consistentShard.RoundTrip(request)
+	cancel()
+
+	assert.Nil(t, err)
+	assert.Equal(t, response, resp)
+	assert.Equal(t, multiDeleteRecordID("req-id", "/bucket/first"), records["first"].RequestID)
+	assert.Equal(t, multiDeleteRecordID("req-id", "/bucket/second"), records["second"].RequestID)
+	assert.NotEqual(t, records["first"].RequestID, records["second"].RequestID)
+	deletedMarkers.Wait()
+	watchdogMock.AssertCalled(t, "Delete", markers["first"])
+	watchdogMock.AssertCalled(t, "Delete", markers["second"])
+}
+
+func TestMultiObjectDeleteCleanupIsNotRecorded(t *testing.T) {
+	shardMock := &ShardClientMock{&mock.Mock{}}
+	factoryMock := &ConsistencyRecordFactoryMock{&mock.Mock{}}
+	watchdogMock := &WatchdogMock{&mock.Mock{}}
+
+	consistentShard := ConsistencyShardClient{
+		watchdog:          watchdogMock,
+		shard:             shardMock,
+		recordFactory:     factoryMock,
+		versionHeaderName: "x-watchdog-version",
+	}
+
+	body := []byte("<Delete><Object><Key>first</Key></Object></Delete>")
+	request, err := http.NewRequest(http.MethodPost, "http://localhost:8080/bucket?delete", bytes.NewReader(body))
+	assert.Nil(t, err)
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	noErrors := true
+	ctx, cancel := context.WithCancel(context.WithValue(request.Context(), watchdog.NoErrorsDuringRequest, &noErrors))
+	defer cancel()
+	ctx = context.WithValue(ctx, watchdog.ConsistencyLevel, config.Strong)
+	ctx = context.WithValue(ctx, watchdog.ReadRepair, false)
+	request = request.WithContext(context.WithValue(ctx, SkipConsistencyRecord, true))
+
+	response := &http.Response{Request: request, StatusCode: http.StatusOK}
+	shardMock.On("RoundTrip", request).Return(response, nil)
+
+	resp, err := consistentShard.RoundTrip(request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, response, resp)
+	factoryMock.AssertNotCalled(t, "CreateRecordFor", mock.Anything)
+	watchdogMock.AssertNotCalled(t, "Insert", mock.Anything)
+}
+
 func TestConsistencyLevels(t *testing.T) {
 	versionHeaderName := "x-watchdog-version"
 	for _, testCase := range []struct {
@@ -233,6 +370,49 @@ func TestConsistencyLevels(t *testing.T) {
 	}
 }
 
+func TestBucketConsistencyLevelShouldOverrideThePolicyOne(t *testing.T) {
+	for _, testCase := range []struct {
+		policyLevel      config.ConsistencyLevel
+		bucketLevel      config.ConsistencyLevel
+		shouldBeRecorded bool
+	}{
+		{policyLevel: config.None, bucketLevel: config.Strong, shouldBeRecorded: true},
+		{policyLevel: config.Strong, bucketLevel: config.None, shouldBeRecorded: false},
+		{policyLevel: config.Strong, bucketLevel: "", shouldBeRecorded: true},
+	} {
+		shardMock := &ShardClientMock{&mock.Mock{}}
+		factoryMock := &ConsistencyRecordFactoryMock{&mock.Mock{}}
+		watchdogMock := &WatchdogMock{&mock.Mock{}}
+		consistentShard := ConsistencyShardClient{
+			watchdog:          watchdogMock,
+			shard:             shardMock,
+			recordFactory:     factoryMock,
+			versionHeaderName: "x-watchdog-version",
+		}
+
+		request, err := http.NewRequest(http.MethodPut, "http://localhost:8080/bucket/object", nil)
+		assert.Nil(t, err)
+		ctx := context.WithValue(request.Context(), watchdog.ConsistencyLevel, testCase.policyLevel)
+		ctx = context.WithValue(ctx, watchdog.ReadRepair, false)
+		ctx = context.WithValue(ctx, metadata.BucketMetaDataContextKey, &metadata.BucketMetaData{Name: "bucket", ConsistencyLevel: testCase.bucketLevel})
+		request = request.WithContext(ctx)
+
+		consistencyRecord := &watchdog.ConsistencyRecord{}
+		factoryMock.On("CreateRecordFor", request).Return(consistencyRecord, nil)
+		watchdogMock.On("Insert", consistencyRecord).Return(nil, errors.New("error"))
+		shardMock.On("RoundTrip", request).Return(&http.Response{Request: request, StatusCode: http.StatusOK}, nil)
+
+		_, err = consistentShard.RoundTrip(request)
+
+		if testCase.shouldBeRecorded {
+			watchdogMock.AssertCalled(t, "Insert", consistencyRecord)
+		} else {
+			watchdogMock.AssertNotCalled(t, "Insert", consistencyRecord)
+		}
+		assert.Equal(t, testCase.bucketLevel == config.Strong || (testCase.bucketLevel == "" && testCase.policyLevel == config.Strong), err != nil)
+	}
+}
+
 func (shardMock *ShardClientMock) RoundTrip(req *http.Request) (resp *http.Response, rerr error) {
 	args := shardMock.Called(req)
 	r := args.Get(0)
@@ -280,6 +460,11 @@ func (wm *WatchdogMock) SupplyRecordWithVersion(record *watchdog.ConsistencyReco
 	return args.Error(0)
 }
 
+func (wm *WatchdogMock) DeleteRecord(requestID string) error {
+	args := wm.Called(requestID)
+	return args.Error(0)
+}
+
 type ConsistencyRecordFactoryMock struct {
 	*mock.Mock
 }
@@ -43,6 +43,15 @@ func (m *Matcher) RoundTrip(request *http.Request) (response *http.Response, err
 	return
 }
 
+// CloseIdleConnections closes idle connections of all the transports
+func (m *Matcher) CloseIdleConnections() {
+	for _, roundTripper := range m.RoundTrippers {
+		if transport, ok := roundTripper.(*http.Transport); ok {
+			transport.CloseIdleConnections()
+		}
+	}
+}
+
 // SelectTransportRoundTripper for selecting RoundTripper by request object from transports matcher
 func (m *Matcher) SelectTransportRoundTripper(request *http.Request) (selectedRoundTripper http.RoundTripper, err error) {
 	selectedTransport, err := m.SelectTransportDefinition(request.Method, request.URL.Path, request.URL.RawQuery, log.DefaultLogger)
@@ -19,3 +19,62 @@ type InitiateMultipartUploadResult struct {
 	Key      string
 	UploadID string `xml:"UploadId"`
 }
+
+//CopyObjectResult contains information about an object copied by the server
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult" json:"-"`
+	LastModified string
+	ETag         string
+}
+
+//CopyPartResult contains information about a part copied by the server
+type CopyPartResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyPartResult" json:"-"`
+	LastModified string
+	ETag         string
+}
+
+//DeleteObjectsRequest lists the objects to delete in a multi-object delete request
+type DeleteObjectsRequest struct {
+	XMLName xml.Name           `xml:"Delete" json:"-"`
+	Quiet   bool               `xml:",omitempty"`
+	Objects []ObjectIdentifier `xml:"Object"`
+}
+
+//ObjectIdentifier identifies an object (or its version) in a multi-object delete request
+type ObjectIdentifier struct {
+	Key       string
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+//DeleteResult contains the outcome of a multi-object delete for every object
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult" json:"-"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+//DeletedObject describes an object deleted by a multi-object delete
+type DeletedObject struct {
+	Key                   string
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:",omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+//DeleteError describes an object that a multi-object delete failed to delete
+type DeleteError struct {
+	Key       string
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string
+	Message   string
+}
+
+//ErrorResponse is the body of the S3 error response
+type ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error" json:"-"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+)
+
+// ClientAddressingKey is the context key under which the original addressing of a virtual-hosted-style request is kept
+const ClientAddressingKey = log.ContextKey("ClientAddressing")
+
+// ClientAddressing holds the host and the path of a virtual-hosted-style request as sent by the client
+type ClientAddressing struct {
+	Host    string
+	Path    string
+	RawPath string
+}
+
+// ToPathStyle rewrites a virtual-hosted-style request addressed to '<bucket>.<host>' into
+// a path-style request addressed to host, the original addressing is kept in the context
+func ToPathStyle(req *http.Request, bucket, host string) *http.Request {
+	addressing := &ClientAddressing{Host: req.Host, Path: req.URL.Path, RawPath: req.URL.RawPath}
+	req = req.WithContext(context.WithValue(req.Context(), ClientAddressingKey, addressing))
+	pathStyleURL := *req.URL
+	req.URL = &pathStyleURL
+	req.Host = host
+	req.URL.Path = prependBucket(bucket, req.URL.Path)
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = prependBucket(bucket, req.URL.RawPath)
+	}
+	return req
+}
+
+// ClientAddressedRequest returns a shallow copy of the request addressed the way the client sent it,
+// path-style requests are returned as they are
+func ClientAddressedRequest(req *http.Request) *http.Request {
+	addressing, ok := req.Context().Value(ClientAddressingKey).(*ClientAddressing)
+	if !ok || addressing == nil {
+		return req
+	}
+	clientReq := new(http.Request)
+	*clientReq = *req
+	clientReq.URL = new(url.URL)
+	*clientReq.URL = *req.URL
+	clientReq.Host = addressing.Host
+	clientReq.URL.Path = addressing.Path
+	clientReq.URL.RawPath = addressing.RawPath
+	return clientReq
+}
+
+// ToVirtualHostedStyle moves the bucket name from the path of the request to the '<bucket>.<host>' host,
+// requests which are not addressed to a bucket are left untouched
+func ToVirtualHostedStyle(req *http.Request, host string) *http.Request {
+	bucket := ExtractBucketFrom(req.URL.Path)
+	if bucket == "" {
+		return req
+	}
+	bucketHost := bucket + "." + host
+	req.URL.Host = bucketHost
+	req.Host = bucketHost
+	req.URL.Path = stripBucket(bucket, req.URL.Path)
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = stripBucket(bucket, req.URL.RawPath)
+	}
+	return req
+}
+
+func prependBucket(bucket, path string) string {
+	if path == "" || path == "/" {
+		return "/" + bucket
+	}
+	return "/" + bucket + "/" + strings.TrimPrefix(path, "/")
+}
+
+func stripBucket(bucket, path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "/"), bucket)
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRewriteVirtualHostedStyleRequestToPathStyle(t *testing.T) {
+	for _, testCase := range []struct {
+		url          string
+		expectedPath string
+	}{
+		{"http://bucket.akubra.local/some/key", "/bucket/some/key"},
+		{"http://bucket.akubra.local/", "/bucket"},
+		{"http://bucket.akubra.local", "/bucket"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, testCase.url, nil)
+
+		normalized := ToPathStyle(req, "bucket", "akubra.local")
+
+		assert.Equal(t, "akubra.local", normalized.Host)
+		assert.Equal(t, testCase.expectedPath, normalized.URL.Path)
+		clientReq := ClientAddressedRequest(normalized)
+		assert.Equal(t, "bucket.akubra.local", clientReq.Host)
+		assert.Equal(t, req.URL.Path, clientReq.URL.Path)
+	}
+}
+
+func TestClientAddressedRequestShouldReturnPathStyleRequestUntouched(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://akubra.local/bucket/key", nil)
+
+	assert.Equal(t, req, ClientAddressedRequest(req))
+}
+
+func TestShouldRewritePathStyleRequestToVirtualHostedStyle(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://akubra.local/bucket/some/key", nil)
+
+	rewritten := ToVirtualHostedStyle(req, "storage.local:9000")
+
+	assert.Equal(t, "bucket.storage.local:9000", rewritten.Host)
+	assert.Equal(t, "bucket.storage.local:9000", rewritten.URL.Host)
+	assert.Equal(t, "/some/key", rewritten.URL.Path)
+
+	bucketReq, _ := http.NewRequest(http.MethodGet, "http://akubra.local/bucket", nil)
+	assert.Equal(t, "/", ToVirtualHostedStyle(bucketReq, "storage.local").URL.Path)
+
+	listBucketsReq, _ := http.NewRequest(http.MethodGet, "http://akubra.local/", nil)
+	assert.Equal(t, "akubra.local", ToVirtualHostedStyle(listBucketsReq, "storage.local").URL.Host)
+}
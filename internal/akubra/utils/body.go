@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+)
+
+const (
+	//DefaultBodyMemoryLimit is the number of body bytes kept in memory before spilling to disk
+	DefaultBodyMemoryLimit = 4 * 1024 * 1024
+	bodyChunkSize          = 32 * 1024
+	bodySpillFilePattern   = "akubra-body-"
+)
+
+//ErrBodyReleased is returned when a reader is requested from a body that has been already released
+var ErrBodyReleased = errors.New("request body already released")
+
+//ReplayableBody streams the request body to any number of independent readers.
+//The source is read only once, on demand of the fastest reader, so the body is
+//never read ahead of its consumers. The first memoryLimit bytes are kept in memory,
+//the rest is spilled to a temporary file, which makes replays (regression calls,
+//retries) possible without keeping the whole body in memory.
+type ReplayableBody struct {
+	source      io.ReadCloser
+	memoryLimit int64
+	spillDir    string
+
+	fillMx sync.Mutex
+	chunk  []byte
+
+	mx       sync.Mutex
+	memory   []byte
+	spill    *os.File
+	size     int64
+	err      error
+	readers  int
+	released bool
+}
+
+//NewReplayableBody wraps source, memoryLimit <= 0 means DefaultBodyMemoryLimit,
+//empty spillDir means the default directory for temporary files
+func NewReplayableBody(source io.ReadCloser, memoryLimit int64, spillDir string) *ReplayableBody {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultBodyMemoryLimit
+	}
+	return &ReplayableBody{
+		source:      source,
+		memoryLimit: memoryLimit,
+		spillDir:    spillDir,
+	}
+}
+
+//NewReader returns a reader which reads the body from the beginning. It can be used as http.Request.GetBody
+func (rb *ReplayableBody) NewReader() (io.ReadCloser, error) {
+	rb.mx.Lock()
+	defer rb.mx.Unlock()
+	if rb.released && rb.readers == 0 {
+		return nil, ErrBodyReleased
+	}
+	rb.readers++
+	return &replayReader{body: rb}, nil
+}
+
+//Release marks the body as no longer needed by its owner. Resources are freed
+//as soon as all the readers are closed
+func (rb *ReplayableBody) Release() {
+	rb.mx.Lock()
+	defer rb.mx.Unlock()
+	rb.released = true
+	if rb.readers == 0 {
+		rb.cleanup()
+	}
+}
+
+func (rb *ReplayableBody) closeReader() {
+	rb.mx.Lock()
+	defer rb.mx.Unlock()
+	rb.readers--
+	if rb.released && rb.readers == 0 {
+		rb.cleanup()
+	}
+}
+
+//cleanup has to be called with mx held, the source is closed if it was not read to the end
+func (rb *ReplayableBody) cleanup() {
+	if rb.err == nil {
+		rb.err = ErrBodyReleased
+		if closeErr := rb.source.Close(); closeErr != nil {
+			log.Debugf("Cannot close request body source: %s", closeErr)
+		}
+	}
+	rb.memory = nil
+	if rb.spill == nil {
+		return
+	}
+	if err := rb.spill.Close(); err != nil {
+		log.Printf("Cannot close body spill file %s: %s", rb.spill.Name(), err)
+	}
+	rb.spill = nil
+}
+
+//readAt copies the already buffered bytes starting at offset, it returns (0, nil) if
+//there is nothing buffered at offset yet and the source is not exhausted
+func (rb *ReplayableBody) readAt(p []byte, offset int64) (int, error) {
+	rb.mx.Lock()
+	if offset >= rb.size {
+		err := rb.err
+		rb.mx.Unlock()
+		return 0, err
+	}
+	available := rb.size - offset
+	if int64(len(p)) > available {
+		p = p[:available]
+	}
+	if offset < int64(len(rb.memory)) {
+		n := copy(p, rb.memory[offset:])
+		rb.mx.Unlock()
+		return n, nil
+	}
+	spill := rb.spill
+	rb.mx.Unlock()
+	if spill == nil {
+		return 0, ErrBodyReleased
+	}
+	n, err := spill.ReadAt(p, offset-rb.memoryLimit)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+//fill reads the next chunk from the source unless some other reader already did it
+func (rb *ReplayableBody) fill(offset int64) {
+	rb.fillMx.Lock()
+	defer rb.fillMx.Unlock()
+	rb.mx.Lock()
+	done := offset < rb.size || rb.err != nil
+	rb.mx.Unlock()
+	if done {
+		return
+	}
+	if rb.chunk == nil {
+		rb.chunk = make([]byte, bodyChunkSize)
+	}
+	n, readErr := rb.source.Read(rb.chunk)
+	appendErr := rb.append(rb.chunk[:n])
+
+	rb.mx.Lock()
+	defer rb.mx.Unlock()
+	switch {
+	case appendErr != nil:
+		rb.err = appendErr
+	case readErr != nil:
+		rb.err = readErr
+	}
+	if rb.err != nil {
+		if closeErr := rb.source.Close(); closeErr != nil {
+			log.Debugf("Cannot close request body source: %s", closeErr)
+		}
+	}
+}
+
+//append has to be called with fillMx held
+func (rb *ReplayableBody) append(data []byte) error {
+	rb.mx.Lock()
+	defer rb.mx.Unlock()
+	if rb.released && rb.readers == 0 {
+		return ErrBodyReleased
+	}
+	if inMemory := rb.memoryLimit - int64(len(rb.memory)); inMemory > 0 {
+		if int64(len(data)) < inMemory {
+			inMemory = int64(len(data))
+		}
+		rb.memory = append(rb.memory, data[:inMemory]...)
+		rb.size += inMemory
+		data = data[inMemory:]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if rb.spill == nil {
+		spill, err := createSpillFile(rb.spillDir)
+		if err != nil {
+			return err
+		}
+		rb.spill = spill
+	}
+	n, err := rb.spill.WriteAt(data, rb.size-rb.memoryLimit)
+	rb.size += int64(n)
+	return err
+}
+
+//createSpillFile creates a temporary file and unlinks it right away, so the disk
+//space is reclaimed as soon as the descriptor is closed, even if a reader leaks
+func createSpillFile(dir string) (*os.File, error) {
+	spill, err := ioutil.TempFile(dir, bodySpillFilePattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(spill.Name()); err != nil {
+		log.Debugf("Cannot unlink body spill file %s: %s", spill.Name(), err)
+	}
+	return spill, nil
+}
+
+type replayReader struct {
+	body   *ReplayableBody
+	offset int64
+	once   sync.Once
+}
+
+//Read implements io.Reader
+func (rr *replayReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		n, err := rr.body.readAt(p, rr.offset)
+		rr.offset += int64(n)
+		if n > 0 || err != nil {
+			return n, err
+		}
+		rr.body.fill(rr.offset)
+	}
+}
+
+//Close implements io.Closer
+func (rr *replayReader) Close() error {
+	rr.once.Do(rr.body.closeReader)
+	return nil
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingReadCloser struct {
+	io.Reader
+	bytesRead int
+	closed    bool
+}
+
+func (crc *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := crc.Reader.Read(p)
+	crc.bytesRead += n
+	return n, err
+}
+
+func (crc *countingReadCloser) Close() error {
+	crc.closed = true
+	return nil
+}
+
+func randomPayload(size int) []byte {
+	payload := make([]byte, size)
+	_, _ = rand.Read(payload)
+	return payload
+}
+
+func TestReplayableBodyShouldServeTheSameBytesToConcurrentReaders(t *testing.T) {
+	payload := randomPayload(3*bodyChunkSize + 17)
+	source := &countingReadCloser{Reader: bytes.NewReader(payload)}
+	body := NewReplayableBody(source, bodyChunkSize, "")
+
+	readersCount := 5
+	results := make([][]byte, readersCount)
+	wg := sync.WaitGroup{}
+	for i := 0; i < readersCount; i++ {
+		reader, err := body.NewReader()
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func(idx int, reader io.ReadCloser) {
+			defer wg.Done()
+			defer reader.Close()
+			results[idx], _ = ioutil.ReadAll(reader)
+		}(i, reader)
+	}
+	wg.Wait()
+	body.Release()
+
+	for _, result := range results {
+		assert.Equal(t, payload, result)
+	}
+	assert.Equal(t, len(payload), source.bytesRead)
+	assert.True(t, source.closed)
+}
+
+func TestReplayableBodyShouldReplayBodySpilledToDisk(t *testing.T) {
+	payload := randomPayload(10 * bodyChunkSize)
+	body := NewReplayableBody(ioutil.NopCloser(bytes.NewReader(payload)), bodyChunkSize, t.TempDir())
+
+	first, err := body.NewReader()
+	assert.NoError(t, err)
+	firstRead, err := ioutil.ReadAll(first)
+	assert.NoError(t, err)
+	assert.NoError(t, first.Close())
+	assert.Len(t, body.memory, bodyChunkSize)
+	assert.NotNil(t, body.spill)
+
+	replay, err := body.NewReader()
+	assert.NoError(t, err)
+	replayed, err := ioutil.ReadAll(replay)
+	assert.NoError(t, err)
+
+	assert.Equal(t, payload, firstRead)
+	assert.Equal(t, payload, replayed)
+
+	body.Release()
+	assert.NotNil(t, body.spill)
+	assert.NoError(t, replay.Close())
+	assert.Nil(t, body.spill)
+}
+
+func TestReplayableBodyShouldNotReadSourceAheadOfReaders(t *testing.T) {
+	payload := randomPayload(4 * bodyChunkSize)
+	source := &countingReadCloser{Reader: bytes.NewReader(payload)}
+	body := NewReplayableBody(source, 0, "")
+
+	reader, err := body.NewReader()
+	assert.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(reader, buf)
+	assert.NoError(t, err)
+
+	assert.Equal(t, payload[:10], buf)
+	assert.Equal(t, bodyChunkSize, source.bytesRead)
+}
+
+func TestReplayableBodyShouldPropagateSourceError(t *testing.T) {
+	sourceErr := errors.New("connection reset")
+	source := ioutil.NopCloser(io.MultiReader(bytes.NewReader([]byte("partial")), &failingReader{err: sourceErr}))
+	body := NewReplayableBody(source, 0, "")
+
+	for i := 0; i < 2; i++ {
+		reader, err := body.NewReader()
+		assert.NoError(t, err)
+		read, err := ioutil.ReadAll(reader)
+		assert.Equal(t, sourceErr, err)
+		assert.Equal(t, []byte("partial"), read)
+	}
+}
+
+func TestReplayableBodyShouldRefuseNewReadersAfterRelease(t *testing.T) {
+	body := NewReplayableBody(ioutil.NopCloser(bytes.NewReader([]byte("body"))), 0, "")
+	body.Release()
+
+	reader, err := body.NewReader()
+
+	assert.Nil(t, reader)
+	assert.Equal(t, ErrBodyReleased, err)
+}
+
+func TestReplayableBodyShouldCloseUnreadSourceOnceAllReadersAreClosed(t *testing.T) {
+	source := &countingReadCloser{Reader: bytes.NewReader(randomPayload(2 * bodyChunkSize))}
+	body := NewReplayableBody(source, 0, "")
+	reader, err := body.NewReader()
+	assert.NoError(t, err)
+	_, err = reader.Read(make([]byte, 10))
+	assert.NoError(t, err)
+
+	body.Release()
+	assert.False(t, source.closed)
+	assert.NoError(t, reader.Close())
+
+	assert.True(t, source.closed)
+}
+
+type failingReader struct {
+	err error
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	return 0, fr.err
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/allegro/akubra/internal/akubra/log"
@@ -26,13 +27,19 @@ const (
 	RegexV2Algorithm = "AWS +(?P<access_key>[a-zA-Z0-9_-]+):(?P<Signature>(?:[A-Za-z0-9+/]{4})*(?:[A-Za-z0-9+/]{2}==|[A-Za-z0-9+/]{3}=)?)"
 	//RegexV4Algorithm is a regexp for parsing v4 auth headers
 	RegexV4Algorithm = "AWS4-HMAC-SHA256 +Credential=(?P<access_key>.+)/[0-9]+/(?P<region>[a-zA-Z0-9-]*)/(?P<service>[a-zA-Z0-9_-]+)/aws4_request,( +)?SignedHeaders=(?P<signed_headers>[a-z0-9-;.]+),( +)?Signature=(?P<signature>[a-z0-9]+)"
+	//RegexV4Credential is a regexp for parsing the X-Amz-Credential query parameter of v4 presigned URLs
+	RegexV4Credential = "^(?P<access_key>.+)/[0-9]+/(?P<region>[a-zA-Z0-9-]*)/(?P<service>[a-zA-Z0-9_-]+)/aws4_request$"
 )
 
 //ErrNoAuthHeader indicates that no authorization header was found in the request
 var ErrNoAuthHeader = fmt.Errorf("cannot find correct authorization header")
 
+//ErrIncorrectPresignedURL indicates that the query of v4 presigned URL is malformed
+var ErrIncorrectPresignedURL = fmt.Errorf("incorrect presigned URL query")
+
 var reV2 = regexp.MustCompile(RegexV2Algorithm)
 var reV4 = regexp.MustCompile(RegexV4Algorithm)
+var reV4Credential = regexp.MustCompile(RegexV4Credential)
 
 //ParsedAuthorizationHeader holds the parsed "Authorization" header content
 type ParsedAuthorizationHeader struct {
@@ -42,6 +49,8 @@ type ParsedAuthorizationHeader struct {
 	SignedHeaders string
 	Region        string
 	Service       string
+	//Presigned tells that the request is authorized by the query of v4 presigned URL
+	Presigned bool
 }
 
 // BackendError interface helps logging inconsistencies
@@ -77,6 +86,21 @@ func ParseAuthorizationHeader(authorizationHeader string) (authHeader ParsedAuth
 	return ParsedAuthorizationHeader{}, ErrNoAuthHeader
 }
 
+// ParsePresignedV4Query - extract S3 v4 presigned URL authorization details, ErrNoAuthHeader is returned
+// if the query is not presigned
+func ParsePresignedV4Query(query url.Values) (authHeader ParsedAuthorizationHeader, err error) {
+	algorithm := query.Get("X-Amz-Algorithm")
+	if algorithm == "" {
+		return ParsedAuthorizationHeader{}, ErrNoAuthHeader
+	}
+	match := reV4Credential.FindStringSubmatch(query.Get("X-Amz-Credential"))
+	if algorithm != SignV4Algorithm || match == nil || query.Get("X-Amz-SignedHeaders") == "" || query.Get("X-Amz-Signature") == "" {
+		return ParsedAuthorizationHeader{}, ErrIncorrectPresignedURL
+	}
+	return ParsedAuthorizationHeader{AccessKey: match[1], Signature: query.Get("X-Amz-Signature"), Region: match[2],
+		SignedHeaders: query.Get("X-Amz-SignedHeaders"), Version: SignV4Algorithm, Service: match[3], Presigned: true}, nil
+}
+
 // ExtractAccessKey extracts s3 auth key from header
 func ExtractAccessKey(req *http.Request) string {
 	if req.Header == nil {
@@ -96,6 +120,18 @@ func ExtractAccessKey(req *http.Request) string {
 	return parsedAuthHeader.AccessKey
 }
 
+// RequestAccessKey extracts s3 auth key from header or from the presigned URL query
+func RequestAccessKey(req *http.Request) string {
+	if accessKey := ExtractAccessKey(req); accessKey != "" {
+		return accessKey
+	}
+	query := req.URL.Query()
+	if credential := query.Get("X-Amz-Credential"); credential != "" {
+		return strings.SplitN(credential, "/", 2)[0]
+	}
+	return query.Get("AWSAccessKeyId")
+}
+
 // ExtractBucketAndKey extract object's bucket and key from request URL
 func ExtractBucketAndKey(requestPath string) (string, string) {
 	trimmedPath := strings.Trim(requestPath, "/")
@@ -145,11 +181,23 @@ func IsMultiPartUploadRequest(request *http.Request) bool {
 	return IsInitiateMultiPartUploadRequest(request) || containsUploadID(request)
 }
 
-//IsInitiateMultiPartUploadRequest checks if a request is an initiate multipart upload request
+//IsInitiateMultiPartUploadRequest checks if a request is an initiate multipart upload request,
+//'uploads' on a bucket path lists the multipart uploads in progress
 func IsInitiateMultiPartUploadRequest(request *http.Request) bool {
 	reqQuery := request.URL.Query()
 	_, has := reqQuery["uploads"]
-	return has
+	return has && IsObjectPath(request.URL.Path)
+}
+
+//IsAbortMultiPartUploadRequest checks if a request is an abort multipart upload request
+func IsAbortMultiPartUploadRequest(request *http.Request) bool {
+	return request.Method == http.MethodDelete && containsUploadID(request)
+}
+
+//IsMultiDeleteRequest checks if a request is a multi-object delete request
+func IsMultiDeleteRequest(request *http.Request) bool {
+	_, has := request.URL.Query()["delete"]
+	return has && request.Method == http.MethodPost && IsBucketPath(request.URL.Path)
 }
 
 func containsUploadID(request *http.Request) bool {
@@ -181,6 +229,23 @@ func ExtractMultiPartUploadIDFrom(response *http.Response) (string, error) {
 	return initiateMultipartUploadResult.UploadID, nil
 }
 
+//ReadDeleteObjectsRequest parses the body of a multi-object delete request, the body stays replayable
+func ReadDeleteObjectsRequest(request *http.Request) (*types.DeleteObjectsRequest, error) {
+	if request.GetBody == nil {
+		return nil, errors.New("multi-object delete request body is not replayable")
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	deleteRequest := &types.DeleteObjectsRequest{}
+	err = xml.NewDecoder(body).Decode(deleteRequest)
+	return deleteRequest, err
+}
+
 //ReplicateRequest makes a copy of the provided request
 func ReplicateRequest(request *http.Request) (*http.Request, error) {
 	replicatedRequest := new(http.Request)
@@ -208,23 +273,6 @@ func ReplicateRequest(request *http.Request) (*http.Request, error) {
 	return replicatedRequest.WithContext(request.Context()), nil
 }
 
-//ReadRequestBody returns the bytes of the request body or nil of body is not present
-func ReadRequestBody(request *http.Request) ([]byte, error) {
-	if request.Body == nil {
-		return nil, nil
-	}
-	bodyBytes, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		_ = request.Body.Close()
-		return nil, err
-	}
-	err = request.Body.Close()
-	if err != nil {
-		return nil, err
-	}
-	return bodyBytes, nil
-}
-
 //PutResponseHeaderToContext extracts the header value from the response and puts it into the context under the specified key
 func PutResponseHeaderToContext(context context.Context, contextValueName log.ContextKey, resp *http.Response, headerName string) {
 	ctxValue := context.Value(contextValueName).(*string)
@@ -237,13 +285,28 @@ func PutResponseHeaderToContext(context context.Context, contextValueName log.Co
 	}
 }
 
+// ResponseForbidden returns the S3 AccessDenied error response
 func ResponseForbidden(req *http.Request) *http.Response {
+	return ResponseS3Error(req, http.StatusForbidden, "AccessDenied", "Access Denied")
+}
+
+// ResponseS3Error returns the response with S3 error body of the given code
+func ResponseS3Error(req *http.Request, statusCode int, code, message string) *http.Response {
+	errorResponse := types.ErrorResponse{Code: code, Message: message, Resource: req.URL.Path, RequestID: RequestID(req)}
+	bodyBytes, _ := xml.Marshal(errorResponse)
+	bodyBytes = append([]byte(xml.Header), bodyBytes...)
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
 	return &http.Response{
-		Status:     "403 Forbidden",
-		StatusCode: http.StatusForbidden,
-		Proto:      req.Proto,
-		ProtoMajor: req.ProtoMajor,
-		ProtoMinor: req.ProtoMinor,
-		Request:    req,
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(bodyBytes)),
+		ContentLength: int64(len(bodyBytes)),
+		Request:       req,
 	}
 }
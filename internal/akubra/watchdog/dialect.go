@@ -0,0 +1,175 @@
+package watchdog
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/watchdog/config"
+	"github.com/jinzhu/gorm"
+	// database dialects and drivers
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Dialect hides the differences between the databases the consistency records can be kept in
+type Dialect interface {
+	// Name is the name of gorm dialect
+	Name() string
+	// ConnStringFormat is the connection string with ':param:' placeholders for ConnStringParams
+	ConnStringFormat() string
+	// ConnStringParams are the names of the watchdog props filled into ConnStringFormat
+	ConnStringParams() []string
+	// ReturnsInsertedVersion tells if the object version generated by the database is returned by insert
+	ReturnsInsertedVersion() bool
+	// ObjectVersion returns the current time in microseconds which is used as object version
+	ObjectVersion(db *gorm.DB) (int, error)
+	// ExecutionDelay converts the delay to the value of the execution_delay column
+	ExecutionDelay(delay time.Duration) interface{}
+	// DueRecordsCondition selects the records which execution delay has passed
+	DueRecordsCondition() string
+	// LockingClause locks the selected records for a single feeder, empty if the records are not locked
+	LockingClause() string
+	// DelayNextExecutionQuery postpones the next execution of the record (by request_id) by the delay
+	DelayNextExecutionQuery(delay time.Duration) string
+}
+
+var (
+	// PostgresDialect keeps the records in PostgreSQL, schema in db-migrations/migration.sql
+	PostgresDialect Dialect = postgresDialect{}
+	// MySQLDialect keeps the records in MySQL 8, schema in db-migrations/mysql8.sql
+	MySQLDialect Dialect = mysqlDialect{}
+	// SQLiteDialect keeps the records in a SQLite file, schema in db-migrations/sqlite.sql
+	SQLiteDialect Dialect = sqliteDialect{now: time.Now}
+
+	dialects = map[string]Dialect{
+		PostgresDialect.Name(): PostgresDialect,
+		MySQLDialect.Name():    MySQLDialect,
+		SQLiteDialect.Name():   SQLiteDialect,
+	}
+)
+
+// DialectByName returns the dialect of the given gorm dialect name, postgres is the default
+func DialectByName(name string) (Dialect, error) {
+	if name == "" {
+		return PostgresDialect, nil
+	}
+	dialect, ok := dialects[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported watchdog dialect '%s'", name)
+	}
+	return dialect, nil
+}
+
+// DialectOf returns the dialect of the configured watchdog
+func DialectOf(watchdogConfig *config.WatchdogConfig) (Dialect, error) {
+	if strings.ToLower(watchdogConfig.Type) == "sqlite" {
+		return SQLiteDialect, nil
+	}
+	return DialectByName(watchdogConfig.Props["dialect"])
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) ConnStringFormat() string { return PostgresConnStringFormat }
+
+func (postgresDialect) ConnStringParams() []string { return PostgresConnStringParams }
+
+func (postgresDialect) ReturnsInsertedVersion() bool { return true }
+
+func (postgresDialect) ObjectVersion(db *gorm.DB) (int, error) {
+	return selectObjectVersion(db, "SELECT CAST(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP at time zone 'utc') * 10^6 AS BIGINT)")
+}
+
+func (postgresDialect) ExecutionDelay(delay time.Duration) interface{} { return delay.String() }
+
+func (postgresDialect) DueRecordsCondition() string {
+	return "updated_at + execution_delay < NOW() AT TIME ZONE 'UTC'"
+}
+
+func (postgresDialect) LockingClause() string { return "FOR UPDATE SKIP LOCKED" }
+
+func (postgresDialect) DelayNextExecutionQuery(delay time.Duration) string {
+	return fmt.Sprintf("UPDATE consistency_record SET execution_delay = NOW() - updated_at + INTERVAL '%d seconds' WHERE request_id = ?", int64(delay.Seconds()))
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) ConnStringFormat() string {
+	return ":user::password:@tcp(:host:::port:)/:dbname:?parseTime=true&loc=UTC&time_zone=%27%2B00%3A00%27&timeout=:conntimeout:s"
+}
+
+func (mysqlDialect) ConnStringParams() []string { return PostgresConnStringParams }
+
+func (mysqlDialect) ReturnsInsertedVersion() bool { return false }
+
+func (mysqlDialect) ObjectVersion(db *gorm.DB) (int, error) {
+	return selectObjectVersion(db, "SELECT CAST(UNIX_TIMESTAMP(NOW(6)) * 1000000 AS SIGNED)")
+}
+
+func (mysqlDialect) ExecutionDelay(delay time.Duration) interface{} { return int64(delay.Seconds()) }
+
+func (mysqlDialect) DueRecordsCondition() string {
+	return "updated_at + INTERVAL execution_delay SECOND < NOW(6)"
+}
+
+func (mysqlDialect) LockingClause() string { return "FOR UPDATE SKIP LOCKED" }
+
+func (mysqlDialect) DelayNextExecutionQuery(delay time.Duration) string {
+	return fmt.Sprintf("UPDATE consistency_record SET execution_delay = TIMESTAMPDIFF(SECOND, updated_at, NOW(6)) + %d WHERE request_id = ?", int64(delay.Seconds()))
+}
+
+// sqliteDialect takes the object versions from the local clock, as SQLite has only millisecond precision timestamps.
+// Records are not locked, since a SQLite file is meant to be consumed by a single feeder, and holding a write
+// transaction while the records are processed would block akubra
+type sqliteDialect struct {
+	now func() time.Time
+}
+
+func (sqliteDialect) Name() string { return "sqlite3" }
+
+func (sqliteDialect) ConnStringFormat() string {
+	return "file::path:?_busy_timeout=5000&_journal_mode=WAL"
+}
+
+func (sqliteDialect) ConnStringParams() []string { return []string{"path"} }
+
+func (sqliteDialect) ReturnsInsertedVersion() bool { return false }
+
+func (dialect sqliteDialect) ObjectVersion(db *gorm.DB) (int, error) {
+	return int(dialect.now().UnixNano() / int64(time.Microsecond)), nil
+}
+
+func (sqliteDialect) ExecutionDelay(delay time.Duration) interface{} { return int64(delay.Seconds()) }
+
+func (sqliteDialect) DueRecordsCondition() string {
+	return "CAST(strftime('%s', updated_at) AS INTEGER) + execution_delay < CAST(strftime('%s', 'now') AS INTEGER)"
+}
+
+func (sqliteDialect) LockingClause() string { return "" }
+
+func (sqliteDialect) DelayNextExecutionQuery(delay time.Duration) string {
+	return fmt.Sprintf("UPDATE consistency_record SET execution_delay = CAST(strftime('%%s', 'now') AS INTEGER) - CAST(strftime('%%s', updated_at) AS INTEGER) + %d WHERE request_id = ?", int64(delay.Seconds()))
+}
+
+func selectObjectVersion(db *gorm.DB, query string) (int, error) {
+	rows, err := db.Raw(query).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		return 0, sql.ErrNoRows
+	}
+	var objectVersion int
+	err = rows.Scan(&objectVersion)
+	return objectVersion, err
+}
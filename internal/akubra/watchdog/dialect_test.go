@@ -0,0 +1,72 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/allegro/akubra/internal/akubra/watchdog/config"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldResolveDialectOfWatchdogConfig(t *testing.T) {
+	for _, testCase := range []struct {
+		config  config.WatchdogConfig
+		dialect Dialect
+	}{
+		{config: config.WatchdogConfig{Type: "sql", Props: map[string]string{}}, dialect: PostgresDialect},
+		{config: config.WatchdogConfig{Type: "sql", Props: map[string]string{"dialect": "postgres"}}, dialect: PostgresDialect},
+		{config: config.WatchdogConfig{Type: "sql", Props: map[string]string{"dialect": "MySQL"}}, dialect: MySQLDialect},
+		{config: config.WatchdogConfig{Type: "sql", Props: map[string]string{"dialect": "sqlite3"}}, dialect: SQLiteDialect},
+		{config: config.WatchdogConfig{Type: "sqlite", Props: map[string]string{"path": "watchdog.db"}}, dialect: SQLiteDialect},
+	} {
+		dialect, err := DialectOf(&testCase.config)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.dialect.Name(), dialect.Name())
+	}
+
+	dialect, err := DialectOf(&config.WatchdogConfig{Type: "sql", Props: map[string]string{"dialect": "oracle"}})
+	assert.Nil(t, dialect)
+	assert.Error(t, err)
+}
+
+func TestShouldInsertRecordWithVersionSelectedFromMySQL(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	gormDB, err := gorm.Open("mysql", db)
+	assert.NoError(t, err)
+	watchdog := SQLWatchdog{dbConn: gormDB, versionHeaderName: "x-version-header", dialect: MySQLDialect}
+
+	expectedObjectVersion := 123
+	record := ConsistencyRecord{
+		RequestID:      "1",
+		ObjectID:       "bucket/key",
+		AccessKey:      "access",
+		ExecutionDelay: fiveMinutes,
+		Domain:         "local.qxlint",
+		Method:         PUT,
+	}
+
+	dbMock.
+		ExpectQuery(`SELECT CAST\(UNIX_TIMESTAMP\(NOW\(6\)\) \* 1000000 AS SIGNED\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow(expectedObjectVersion)).
+		RowsWillBeClosed()
+	dbMock.
+		ExpectExec(`INSERT INTO consistency_record \(object_version, request_id, object_id, domain, access_key, execution_delay, method\) VALUES \(\?, \?, \?, \?, \?, \?, \?\)$`).
+		WithArgs(expectedObjectVersion, record.RequestID, record.ObjectID, record.Domain, record.AccessKey, int64(300), record.Method).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.
+		ExpectExec(`UPDATE consistency_record SET execution_delay = \? WHERE request_id = \?`).
+		WithArgs(int64(3600), record.RequestID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	deleteMarker, err := watchdog.Insert(&record)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedObjectVersion, deleteMarker.objectVersion)
+	assert.Equal(t, expectedObjectVersion, record.ObjectVersion)
+
+	assert.NoError(t, watchdog.UpdateExecutionDelay(&ExecutionDelay{RequestID: record.RequestID, Delay: time.Hour}))
+	assert.Nil(t, dbMock.ExpectationsWereMet())
+}
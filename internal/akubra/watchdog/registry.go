@@ -0,0 +1,40 @@
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/allegro/akubra/internal/akubra/watchdog/config"
+)
+
+const (
+	// PostgresConnStringFormat is the connection string format of the "sql" watchdog with postgres dialect
+	PostgresConnStringFormat = "sslmode=disable dbname=:dbname: user=:user: password=:password: host=:host: port=:port: connect_timeout=:conntimeout:"
+)
+
+// PostgresConnStringParams are the names of the PostgresConnStringFormat params
+var PostgresConnStringParams = []string{"user", "password", "dbname", "host", "port", "conntimeout"}
+
+var watchdogFactories = map[string]ConsistencyWatchdogFactory{}
+
+func init() {
+	RegisterWatchdogFactory("sql", CreateSQLWatchdogFactory(nil))
+	RegisterWatchdogFactory("sqlite", &SQLiteWatchdogFactory{})
+}
+
+// RegisterWatchdogFactory makes the watchdog implementation available under the given Type
+func RegisterWatchdogFactory(watchdogType string, factory ConsistencyWatchdogFactory) {
+	watchdogFactories[strings.ToLower(watchdogType)] = factory
+}
+
+// CreateWatchdog creates the watchdog of the configured Type, no watchdog is created if Type is empty
+func CreateWatchdog(watchdogConfig *config.WatchdogConfig) (ConsistencyWatchdog, error) {
+	if watchdogConfig.Type == "" {
+		return nil, nil
+	}
+	factory, ok := watchdogFactories[strings.ToLower(watchdogConfig.Type)]
+	if !ok {
+		return nil, fmt.Errorf("watchdog of type '%s' is not supported", watchdogConfig.Type)
+	}
+	return factory.CreateWatchdogInstance(watchdogConfig)
+}
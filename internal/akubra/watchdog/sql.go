@@ -16,11 +16,12 @@ import (
 
 const (
 	insertNew                        = "INSERT INTO consistency_record (request_id, object_id, domain, access_key, execution_delay, method) VALUES (?, ?, ?, ?, ?, ?) RETURNING object_version"
-	insertNewWithObjectVersion       = "INSERT INTO consistency_record (object_version, request_id, object_id, domain, access_key, execution_delay, method) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING object_version"
-	selectNow                        = "SELECT CAST(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP at time zone 'utc') * 10^6 AS BIGINT)"
+	insertNewWithObjectVersion       = "INSERT INTO consistency_record (object_version, request_id, object_id, domain, access_key, execution_delay, method) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	returningObjectVersion           = " RETURNING object_version"
 	deleteMarkersInsertedEalier      = "DELETE FROM consistency_record WHERE domain = ? AND object_id = ? AND object_version <= ?"
+	deleteRecordByReqID              = "DELETE FROM consistency_record WHERE request_id = ?"
 	updateRecordExecutionTimeByReqID = "UPDATE consistency_record " +
-		"SET execution_delay = ?" +
+		"SET execution_delay = ? " +
 		"WHERE request_id = ?"
 	//Reader turns on reader config generation
 	Reader = true
@@ -37,6 +38,7 @@ type SQLWatchdogFactory struct {
 type SQLWatchdog struct {
 	dbConn            *gorm.DB
 	versionHeaderName string
+	dialect           Dialect
 }
 
 // ErrDataBase indicates a database errors
@@ -69,6 +71,7 @@ var sqlParams = map[string]string{
 	"port":            "port",
 	"conntimeout":     "conntimeout",
 	"connmaxlifetime": "connmaxlifetime",
+	"path":            "path",
 }
 
 var configurableSQLParams = map[string]string{
@@ -76,8 +79,9 @@ var configurableSQLParams = map[string]string{
 	"%sidleconns": "maxidleconns",
 }
 
-// CreateSQLWatchdogFactory creates instances of SQLWatchdogFactory
-func CreateSQLWatchdogFactory(dbClientFactory *database.GORMDBClientFactory) ConsistencyWatchdogFactory {
+// CreateSQLWatchdogFactory creates instances of SQLWatchdogFactory, if dbClientFactory is nil
+// the connection is made according to the configured dialect
+func CreateSQLWatchdogFactory(dbClientFactory database.DBClientFactory) ConsistencyWatchdogFactory {
 	return &SQLWatchdogFactory{dbClientFactory: dbClientFactory}
 }
 
@@ -96,21 +100,45 @@ func (factory *SQLWatchdogFactory) CreateWatchdogInstance(config *config.Watchdo
 	if strings.ToLower(config.Type) != "sql" {
 		return nil, fmt.Errorf("SQLWatchdogFactory can't instantiate watchdog of type '%s'", config.Type)
 	}
+	dialect, err := DialectOf(config)
+	if err != nil {
+		return nil, err
+	}
+	dbClientFactory := factory.dbClientFactory
+	if dbClientFactory == nil {
+		dbClientFactory = database.NewDBClientFactory(dialect.Name(), dialect.ConnStringFormat(), dialect.ConnStringParams())
+	}
 	dbConfig := CreateWatchdogSQLClientProps(config, Writer)
-	db, err := factory.dbClientFactory.CreateConnection(dbConfig)
+	db, err := dbClientFactory.CreateConnection(dbConfig)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("SQLWatchdog watcher setup successful")
+	log.Printf("SQLWatchdog watcher setup successful, dialect %s", dialect.Name())
+
+	return &SQLWatchdog{dbConn: db, versionHeaderName: config.ObjectVersionHeaderName, dialect: dialect}, nil
+}
+
+// DB returns the database of the watchdog
+func (watchdog *SQLWatchdog) DB() *gorm.DB {
+	return watchdog.dbConn
+}
 
-	return &SQLWatchdog{dbConn: db, versionHeaderName: config.ObjectVersionHeaderName}, nil
+// Close closes the database connections of the watchdog
+func (watchdog *SQLWatchdog) Close() error {
+	return watchdog.dbConn.Close()
 }
 
 // Insert inserts to SQL db
 func (watchdog *SQLWatchdog) Insert(record *ConsistencyRecord) (*DeleteMarker, error) {
 	log.Debugf("[watchdog] INSERT reqID %s, objID %s, domain %s ", record.RequestID, record.ObjectID, record.Domain)
 
+	dialect := watchdog.sqlDialect()
+	if !dialect.ReturnsInsertedVersion() {
+		return watchdog.insertWithObjectVersion(record)
+	}
+
 	queryStartTime := time.Now()
+	executionDelay := dialect.ExecutionDelay(record.ExecutionDelay)
 
 	var rows *sql.Rows
 	var err error
@@ -118,14 +146,14 @@ func (watchdog *SQLWatchdog) Insert(record *ConsistencyRecord) (*DeleteMarker, e
 
 		rows, err = watchdog.
 			dbConn.
-			Raw(insertNewWithObjectVersion, record.ObjectVersion, record.RequestID, record.ObjectID, record.Domain, record.AccessKey, record.ExecutionDelay.String(), record.Method).
+			Raw(insertNewWithObjectVersion+returningObjectVersion, record.ObjectVersion, record.RequestID, record.ObjectID, record.Domain, record.AccessKey, executionDelay, record.Method).
 			Rows()
 
 	} else {
 
 		rows, err = watchdog.
 			dbConn.
-			Raw(insertNew, record.RequestID, record.ObjectID, record.Domain, record.AccessKey, record.ExecutionDelay.String(), record.Method).
+			Raw(insertNew, record.RequestID, record.ObjectID, record.Domain, record.AccessKey, executionDelay, record.Method).
 			Rows()
 
 	}
@@ -158,6 +186,37 @@ func (watchdog *SQLWatchdog) Insert(record *ConsistencyRecord) (*DeleteMarker, e
 	}, nil
 }
 
+// insertWithObjectVersion inserts the record for the dialects that can't return the generated object version,
+// the version is taken up front unless the record has one already
+func (watchdog *SQLWatchdog) insertWithObjectVersion(record *ConsistencyRecord) (*DeleteMarker, error) {
+	if record.ObjectVersion <= 0 {
+		if err := watchdog.SupplyRecordWithVersion(record); err != nil {
+			return nil, ErrDataBase
+		}
+	}
+
+	queryStartTime := time.Now()
+	executionDelay := watchdog.sqlDialect().ExecutionDelay(record.ExecutionDelay)
+	err := watchdog.
+		dbConn.
+		Exec(insertNewWithObjectVersion, record.ObjectVersion, record.RequestID, record.ObjectID, record.Domain, record.AccessKey, executionDelay, record.Method).
+		Error
+
+	if err != nil {
+		metrics.UpdateSince("watchdog.insert.err", queryStartTime)
+		log.Debugf("[watchdog] INSERT FAIL reqID %s, objID %s, domain %s: %s", record.RequestID, record.ObjectID, record.Domain, err.Error())
+		return nil, ErrDataBase
+	}
+
+	metrics.UpdateSince("watchdog.insert.ok", queryStartTime)
+	log.Debugf("[watchdog] INSERT OK reqID %s, objID %s, domain %s, version %d", record.RequestID, record.ObjectID, record.Domain, record.ObjectVersion)
+	return &DeleteMarker{
+		objectID:      record.ObjectID,
+		domain:        record.Domain,
+		objectVersion: record.ObjectVersion,
+	}, nil
+}
+
 //InsertWithRequestID inserts a record with custom ID
 func (watchdog *SQLWatchdog) InsertWithRequestID(requestID string, record *ConsistencyRecord) (*DeleteMarker, error) {
 	record.RequestID = requestID
@@ -179,6 +238,13 @@ func (watchdog *SQLWatchdog) Delete(marker *DeleteMarker) error {
 		}
 	}()
 
+	if err == nil {
+		// some drivers (e.g. sqlite) execute the statement only when the rows are read
+		for rows.Next() {
+		}
+		err = rows.Err()
+	}
+
 	if err != nil {
 		metrics.UpdateSince("watchdog.delete.err", queryStartTime)
 		log.Debugf("[watchdog] DELETE FAIL objID %s, version <= %d: %s", marker.objectID, marker.objectVersion, err)
@@ -187,7 +253,26 @@ func (watchdog *SQLWatchdog) Delete(marker *DeleteMarker) error {
 
 	metrics.UpdateSince("watchdog.delete.ok", queryStartTime)
 
-	log.Debugf("[watchdog] DELETE OK objID, version <= %d", marker.objectID, marker.objectVersion)
+	log.Debugf("[watchdog] DELETE OK objID %s, version <= %d", marker.objectID, marker.objectVersion)
+	return nil
+}
+
+// DeleteRecord deletes the record inserted by the request from SQL db
+func (watchdog *SQLWatchdog) DeleteRecord(requestID string) error {
+	queryStartTime := time.Now()
+	deleteErr := watchdog.
+		dbConn.
+		Exec(deleteRecordByReqID, requestID).
+		Error
+
+	if deleteErr != nil {
+		metrics.UpdateSince("watchdog.deleterecord.err", queryStartTime)
+		log.Printf("[watchdog] DELETE RECORD FAIL reqID %s: %s", requestID, deleteErr.Error())
+		return ErrDataBase
+	}
+
+	metrics.UpdateSince("watchdog.deleterecord.ok", queryStartTime)
+	log.Debugf("[watchdog] DELETE RECORD OK reqID %s", requestID)
 	return nil
 }
 
@@ -197,42 +282,30 @@ func (watchdog *SQLWatchdog) UpdateExecutionDelay(delta *ExecutionDelay) error {
 	queryStartTime := time.Now()
 	updateErr := watchdog.
 		dbConn.
-		Exec(updateRecordExecutionTimeByReqID, fmt.Sprintf("%d minutes", uint64(delta.Delay.Minutes())), delta.RequestID).
+		Exec(updateRecordExecutionTimeByReqID, watchdog.sqlDialect().ExecutionDelay(delta.Delay), delta.RequestID).
 		Error
 
 	if updateErr != nil {
 		metrics.UpdateSince("watchdog.update.err", queryStartTime)
-		log.Printf("[watchdog] UPDATE EXEC FAIL delay reqID: %s", delta.RequestID, updateErr.Error())
+		log.Printf("[watchdog] UPDATE EXEC FAIL delay reqID %s: %s", delta.RequestID, updateErr.Error())
+		return ErrDataBase
 	}
 
-	log.Printf("[watchdog] UPDATE EXEC OK delay reqID ", delta.RequestID)
+	metrics.UpdateSince("watchdog.update.ok", queryStartTime)
+	log.Debugf("[watchdog] UPDATE EXEC OK delay reqID %s", delta.RequestID)
 	return nil
 }
 
 // SupplyRecordWithVersion queries database for NOW and sets it as object's version
 func (watchdog *SQLWatchdog) SupplyRecordWithVersion(record *ConsistencyRecord) error {
-	rows, err := watchdog.
-		dbConn.
-		Raw(selectNow).
-		Rows()
-
-	if err != nil {
-		log.Debugf("[watchdog] VERSION SUPPLY ERR reqID %s: %s", record.RequestID, err.Error())
-		return ErrDataBase
-	}
-
-	defer rows.Close()
-
-	if !rows.Next() {
+	objectVersion, err := watchdog.sqlDialect().ObjectVersion(watchdog.dbConn)
+	if err == sql.ErrNoRows {
 		log.Debugf("[watchdog] VERSION SUPPLY FAIL %s: Empty response from database", record.RequestID)
 		return ErrDataBase
 	}
-
-	var objectVersion int
-
-	err = rows.Scan(&objectVersion)
 	if err != nil {
-		return err
+		log.Debugf("[watchdog] VERSION SUPPLY ERR reqID %s: %s", record.RequestID, err.Error())
+		return ErrDataBase
 	}
 
 	record.ObjectVersion = objectVersion
@@ -245,6 +318,13 @@ func (watchdog *SQLWatchdog) GetVersionHeaderName() string {
 	return watchdog.versionHeaderName
 }
 
+func (watchdog *SQLWatchdog) sqlDialect() Dialect {
+	if watchdog.dialect == nil {
+		return PostgresDialect
+	}
+	return watchdog.dialect
+}
+
 //CreateWatchdogSQLClientProps creates watchdog reader/writer config
 func CreateWatchdogSQLClientProps(watchdogConfig *config.WatchdogConfig, readerConfig bool) map[string]string {
 	propPrefix := "writer"
@@ -117,6 +117,20 @@ func TestShouldDeleteRecordsByMarker(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestShouldDeleteRecordByRequestID(t *testing.T) {
+	_, dbMock, gormDbMock := createDBMock(t)
+	watchdog := SQLWatchdog{dbConn: gormDbMock, versionHeaderName: "x-version-header"}
+
+	dbMock.
+		ExpectExec(`DELETE FROM consistency_record WHERE request_id = \$1`).
+		WithArgs("upload-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := watchdog.DeleteRecord("upload-id")
+	assert.Nil(t, err)
+	assert.Nil(t, dbMock.ExpectationsWereMet())
+}
+
 func createDBMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *gorm.DB) {
 	db, dbMock, err := sqlmock.New()
 	assert.NoError(t, err)
@@ -0,0 +1,99 @@
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/watchdog/config"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	sqliteDefaultBusyTimeout = 5 * time.Second
+	// sqliteSchema is kept in sync with db-migrations/sqlite.sql
+	sqliteSchema = `CREATE TABLE IF NOT EXISTS consistency_record
+(
+  object_version  INTEGER       NOT NULL,
+  request_id      VARCHAR(36)   PRIMARY KEY,
+  object_id       VARCHAR(1024) NOT NULL,
+  method          VARCHAR(8)    NOT NULL,
+  domain          VARCHAR(254)  NOT NULL,
+  access_key      VARCHAR(128)  NOT NULL,
+  execution_delay INTEGER       NOT NULL,
+  inserted_at     TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at      TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  error           VARCHAR(1024)          DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS consistency_record__domain__object_id__inserted_at
+  ON consistency_record (domain, object_id, object_version);
+CREATE INDEX IF NOT EXISTS consistency_record__inserted_at
+  ON consistency_record (object_version DESC);
+CREATE TABLE IF NOT EXISTS bucket_metadata
+(
+  name                VARCHAR(254)  PRIMARY KEY,
+  pattern             VARCHAR(1024) NOT NULL DEFAULT '',
+  is_internal         BOOLEAN       NOT NULL DEFAULT FALSE,
+  allowed_access_keys VARCHAR(4096) NOT NULL DEFAULT '',
+  sharding_policy     VARCHAR(254)  NOT NULL DEFAULT '',
+  read_only           BOOLEAN       NOT NULL DEFAULT FALSE,
+  frozen              BOOLEAN       NOT NULL DEFAULT FALSE,
+  max_object_size     BIGINT        NOT NULL DEFAULT 0,
+  consistency_level   VARCHAR(16)   NOT NULL DEFAULT ''
+);`
+)
+
+// SQLiteWatchdogFactory creates instances of SQLWatchdog keeping the records in a SQLite file,
+// it's meant for single node deployments and tests
+type SQLiteWatchdogFactory struct{}
+
+// CreateWatchdogInstance creates instances of SQLWatchdog with SQLite dialect
+func (factory *SQLiteWatchdogFactory) CreateWatchdogInstance(config *config.WatchdogConfig) (ConsistencyWatchdog, error) {
+	if strings.ToLower(config.Type) != "sqlite" {
+		return nil, fmt.Errorf("SQLiteWatchdogFactory can't instantiate watchdog of type '%s'", config.Type)
+	}
+	db, err := OpenSQLiteStore(config.Props)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("SQLiteWatchdog setup successful, records kept in %s", config.Props["path"])
+	return &SQLWatchdog{dbConn: db, versionHeaderName: config.ObjectVersionHeaderName, dialect: SQLiteDialect}, nil
+}
+
+// SQLiteStoreFactory is a database.DBClientFactory opening the SQLite file of the sqlite watchdog
+type SQLiteStoreFactory struct{}
+
+// CreateConnection opens the SQLite file
+func (factory *SQLiteStoreFactory) CreateConnection(dbConfig map[string]string) (*gorm.DB, error) {
+	return OpenSQLiteStore(dbConfig)
+}
+
+// OpenSQLiteStore opens (and creates if needed) the SQLite file described by the watchdog props.
+// The file is shared by akubra and the brim feeder, so it's opened in WAL journal mode
+func OpenSQLiteStore(props map[string]string) (*gorm.DB, error) {
+	path := props["path"]
+	if path == "" {
+		return nil, errors.New("sqlite watchdog requires 'path' param")
+	}
+	busyTimeout := sqliteDefaultBusyTimeout
+	if props["busytimeout"] != "" {
+		var err error
+		busyTimeout, err = time.ParseDuration(props["busytimeout"])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse sqlite watchdog 'busytimeout': %s", err)
+		}
+	}
+	connString := fmt.Sprintf("file:%s?_busy_timeout=%d&_journal_mode=WAL", path, busyTimeout.Milliseconds())
+	db, err := gorm.Open(SQLiteDialect.Name(), connString)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open sqlite watchdog file %s: %s", path, err)
+	}
+	db.SetLogger(log.DefaultLogger)
+	if err := db.Exec(sqliteSchema).Error; err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("couldn't create sqlite watchdog schema in %s: %s", path, err)
+	}
+	return db, nil
+}
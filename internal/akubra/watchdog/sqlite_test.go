@@ -0,0 +1,101 @@
+package watchdog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/watchdog/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func createSQLiteWatchdog(t *testing.T) *SQLWatchdog {
+	watchdogConfig := &config.WatchdogConfig{
+		Type:                    "sqlite",
+		ObjectVersionHeaderName: "x-amz-meta-version",
+		Props:                   map[string]string{"path": filepath.Join(t.TempDir(), "watchdog.db")},
+	}
+	consistencyWatchdog, err := CreateWatchdog(watchdogConfig)
+	assert.NoError(t, err)
+	sqliteWatchdog, ok := consistencyWatchdog.(*SQLWatchdog)
+	assert.True(t, ok)
+	return sqliteWatchdog
+}
+
+func countRecords(t *testing.T, watchdog *SQLWatchdog) int {
+	var count int
+	assert.NoError(t, watchdog.dbConn.Table("consistency_record").Count(&count).Error)
+	return count
+}
+
+func TestSQLiteWatchdogShouldInsertRecordsAndDeleteThemWithMarker(t *testing.T) {
+	watchdog := createSQLiteWatchdog(t)
+	defer watchdog.Close()
+	watchdog.dialect = sqliteDialect{now: func() time.Time { return time.Unix(100, 0) }}
+
+	first := &ConsistencyRecord{RequestID: "1", ObjectID: "bucket/key", Domain: "local", AccessKey: "access", ExecutionDelay: fiveMinutes, Method: PUT}
+	_, err := watchdog.Insert(first)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*1000*1000, first.ObjectVersion)
+
+	second := &ConsistencyRecord{RequestID: "2", ObjectID: "bucket/key", Domain: "local", AccessKey: "access", ExecutionDelay: fiveMinutes, Method: PUT}
+	assert.NoError(t, watchdog.SupplyRecordWithVersion(second))
+	second.ObjectVersion++
+	marker, err := watchdog.Insert(second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, countRecords(t, watchdog))
+
+	_, err = watchdog.Insert(&ConsistencyRecord{RequestID: "2", ObjectID: "bucket/other", Domain: "local", AccessKey: "access", Method: PUT})
+	assert.Equal(t, ErrDataBase, err)
+
+	assert.NoError(t, watchdog.Delete(marker))
+	assert.Equal(t, 0, countRecords(t, watchdog))
+}
+
+func TestSQLiteWatchdogShouldUpdateExecutionDelay(t *testing.T) {
+	watchdog := createSQLiteWatchdog(t)
+	defer watchdog.Close()
+	_, err := watchdog.Insert(&ConsistencyRecord{RequestID: "1", ObjectID: "bucket/key", Domain: "local", AccessKey: "access", ExecutionDelay: fiveMinutes, Method: PUT})
+	assert.NoError(t, err)
+
+	assert.NoError(t, watchdog.UpdateExecutionDelay(&ExecutionDelay{RequestID: "1", Delay: time.Hour}))
+
+	record := SQLConsistencyRecord{}
+	assert.NoError(t, watchdog.dbConn.Where("request_id = ?", "1").First(&record).Error)
+	assert.Equal(t, "3600", record.ExecutionDelay)
+}
+
+func TestSQLiteWatchdogShouldDeleteRecordByRequestID(t *testing.T) {
+	watchdog := createSQLiteWatchdog(t)
+	defer watchdog.Close()
+	for _, requestID := range []string{"upload-1", "upload-2"} {
+		_, err := watchdog.Insert(&ConsistencyRecord{RequestID: requestID, ObjectID: "bucket/" + requestID, Domain: "local", AccessKey: "access", ExecutionDelay: oneWeek, Method: PUT})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, watchdog.DeleteRecord("upload-1"))
+
+	assert.Equal(t, 1, countRecords(t, watchdog))
+}
+
+func TestShouldCreateWatchdogOfConfiguredType(t *testing.T) {
+	consistencyWatchdog, err := CreateWatchdog(&config.WatchdogConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, consistencyWatchdog)
+
+	consistencyWatchdog, err = CreateWatchdog(&config.WatchdogConfig{Type: "unknown"})
+	assert.Error(t, err)
+	assert.Nil(t, consistencyWatchdog)
+
+	consistencyWatchdog, err = CreateWatchdog(&config.WatchdogConfig{Type: "SQLite", Props: map[string]string{}})
+	assert.Error(t, err)
+	assert.Nil(t, consistencyWatchdog)
+}
+
+func TestSQLiteSchemaShouldMatchMigration(t *testing.T) {
+	migration, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "db-migrations", "sqlite.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(migration)), sqliteSchema)
+}
@@ -72,6 +72,7 @@ type ConsistencyWatchdog interface {
 	Delete(marker *DeleteMarker) error
 	UpdateExecutionDelay(delta *ExecutionDelay) error
 	SupplyRecordWithVersion(record *ConsistencyRecord) error
+	DeleteRecord(requestID string) error
 }
 
 // ConsistencyRecordFactory creates records from http requests
@@ -59,6 +59,38 @@ type WALConf struct {
 	FeederTaskFailureDelay  time.Duration `yaml:"FeederTaskFailureDelay"`
 }
 
+// RebalanceConf configures the moves of objects between the shards of a rebalanced region
+type RebalanceConf struct {
+	AccessKeys         []string      `yaml:"AccessKeys"`
+	MaxConcurrentMoves int           `yaml:"MaxConcurrentMoves"`
+	ProgressInterval   time.Duration `yaml:"ProgressInterval"`
+}
+
+// ReconcileConf configures the scans comparing the buckets across the storages of a shard
+type ReconcileConf struct {
+	// AccessKeys own the scanned buckets
+	AccessKeys []string `yaml:"AccessKeys"`
+	// Buckets limits the scan to the listed buckets, all buckets of AccessKeys are scanned if empty
+	Buckets []string `yaml:"Buckets"`
+	// Emit is "records" to insert ConsistencyRecords for the regular brim run, or "tasks" to synchronize the objects
+	// right away
+	Emit string `yaml:"Emit"`
+	// MaxRequestsPerSecond limits the list and head requests sent to the storages, unlimited if not positive
+	MaxRequestsPerSecond float64 `yaml:"MaxRequestsPerSecond"`
+	// MaxConcurrentMigrations limits the migrations of the "tasks" mode
+	MaxConcurrentMigrations int `yaml:"MaxConcurrentMigrations"`
+	// RestoreMissing copies the objects missing on some storages back to them, they are only reported otherwise, as
+	// they may be deleted objects whose delete failed on some storages
+	RestoreMissing bool `yaml:"RestoreMissing"`
+	// SkipVersionCheck compares the keys and ETags only, the version headers are still read for the mismatched objects
+	SkipVersionCheck bool `yaml:"SkipVersionCheck"`
+	// MarkersFile keeps the listing markers of the buckets, so an interrupted scan is resumed
+	MarkersFile string `yaml:"MarkersFile"`
+	// ReportFile receives the mismatches found by a dry run, standard output is used if empty
+	ReportFile       string        `yaml:"ReportFile"`
+	ProgressInterval time.Duration `yaml:"ProgressInterval"`
+}
+
 // BrimConf is read from configuration file
 type BrimConf struct {
 	// Database    model.DBConfig   `yaml:"database"`
@@ -72,6 +104,8 @@ type BrimConf struct {
 	Supervisor                SupervisorConf `yaml:"Supervisor"`
 	WorkerCount               int            `yaml:"workercount"`
 	WALConf                   WALConf        `yaml:"WAL"`
+	Rebalance                 RebalanceConf  `yaml:"Rebalance"`
+	Reconcile                 ReconcileConf  `yaml:"Reconcile"`
 }
 
 // EndpointRegionMapping returns region to endpoint map
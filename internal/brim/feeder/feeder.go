@@ -1,6 +1,12 @@
 package feeder
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/allegro/akubra/internal/akubra/config"
+	"github.com/allegro/akubra/internal/akubra/database"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
 	"github.com/allegro/akubra/internal/brim/model"
 )
 
@@ -8,3 +14,21 @@ import (
 type WALFeeder interface {
 	CreateFeed() <-chan *model.WALEntry
 }
+
+//NewWALFeeder creates the WALFeeder consuming the store of the configured watchdog type
+func NewWALFeeder(akubraConfig *config.Config, feederConfig *WALFeederConfig) (WALFeeder, error) {
+	switch strings.ToLower(akubraConfig.Watchdog.Type) {
+	case "sql":
+		dialect, err := watchdog.DialectOf(&akubraConfig.Watchdog)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLWALFeeder(akubraConfig, feederConfig, database.NewDBClientFactory(
+			dialect.Name(),
+			dialect.ConnStringFormat(),
+			dialect.ConnStringParams()))
+	case "sqlite":
+		return NewSQLWALFeeder(akubraConfig, feederConfig, &watchdog.SQLiteStoreFactory{})
+	}
+	return nil, fmt.Errorf("no WAL feeder for watchdog of type '%s'", akubraConfig.Watchdog.Type)
+}
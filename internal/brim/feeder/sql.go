@@ -13,7 +13,6 @@ import (
 	"github.com/allegro/akubra/internal/akubra/watchdog"
 	"github.com/allegro/akubra/internal/brim/model"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/pkg/errors"
 )
 
@@ -24,27 +23,35 @@ type WALFeederConfig struct {
 	FailureDelay           time.Duration `yaml:"FailureDelay"`
 }
 
-//SQLWALFeeder is an implementation of WALFeeder that creates a feed from a SQL DB
+//SQLWALFeeder is an implementation of WALFeeder that creates a feed from a SQL DB. If the dialect supports it,
+//the fed records are locked in a transaction until they are processed, otherwise only one feeder should consume the DB
 type SQLWALFeeder struct {
 	WALFeeder
-	db     *gorm.DB
-	config *WALFeederConfig
+	db      *gorm.DB
+	dialect watchdog.Dialect
+	config  *WALFeederConfig
 }
 
 //NewSQLWALFeeder construct an instance of SQLWALFeeder
 func NewSQLWALFeeder(akubraConfig *config.Config,
 	sqlFeederConfig *WALFeederConfig,
 	dbClientFactory database.DBClientFactory) (WALFeeder, error) {
-	if strings.ToLower(akubraConfig.Watchdog.Type) != "sql" {
+	watchdogType := strings.ToLower(akubraConfig.Watchdog.Type)
+	if watchdogType != "sql" && watchdogType != "sqlite" {
 		return nil, errors.New("Can't create SQL feeder if no SQL watchdog is defined")
 	}
+	dialect, err := watchdog.DialectOf(&akubraConfig.Watchdog)
+	if err != nil {
+		return nil, err
+	}
 	db, err := dbClientFactory.CreateConnection(akubraConfig.Watchdog.Props)
 	if err != nil {
 		return nil, err
 	}
 	return &SQLWALFeeder{
-		db:     db,
-		config: sqlFeederConfig,
+		db:      db,
+		dialect: dialect,
+		config:  sqlFeederConfig,
 	}, nil
 }
 
@@ -63,30 +70,29 @@ func (feeder *SQLWALFeeder) queryDB(walEntriesChannel chan *model.WALEntry) {
 		consistencyRecords := make([]watchdog.SQLConsistencyRecord, feeder.config.MaxRecordsPerQuery)
 
 		startTime := time.Now()
-		tx := feeder.db.Begin()
+		lockingClause := feeder.dialect.LockingClause()
+		tx := feeder.db
+		query := tx
+		if lockingClause != "" {
+			tx = feeder.db.Begin()
+			query = tx.Set("gorm:query_option", lockingClause)
+		}
 
-		res := tx.
+		res := query.
 			Order("object_version DESC").
-			Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
-			Where("updated_at + execution_delay < NOW() AT TIME ZONE 'UTC'").
+			Where(feeder.dialect.DueRecordsCondition()).
 			Limit(feeder.config.MaxRecordsPerQuery).
 			Find(&consistencyRecords)
 
-		grouping := make(map[string]struct{})
-		distinctRecords := make([]*watchdog.SQLConsistencyRecord, 0)
-
-		for idx := range consistencyRecords {
-			obj := fmt.Sprintf("%s%s", consistencyRecords[idx].Domain, consistencyRecords[idx].ObjectID)
-			if _, seen := grouping[obj]; seen {
-				continue
-			}
-			grouping[obj] = struct{}{}
-			distinctRecords = append(distinctRecords, &consistencyRecords[idx])
-		}
+		distinctRecords := distinct(consistencyRecords)
 
 		if res.Error != nil {
 			log.Printf("Failed on querying database for tasks: %s", res.Error)
 			metrics.UpdateSince("watchdog.feeder.select.err", startTime)
+			if lockingClause != "" {
+				tx.Rollback()
+			}
+			time.Sleep(feeder.config.NoRecordsSleepDuration)
 			continue
 		}
 
@@ -95,7 +101,9 @@ func (feeder *SQLWALFeeder) queryDB(walEntriesChannel chan *model.WALEntry) {
 
 		wg := &sync.WaitGroup{}
 		wg.Add(len(distinctRecords))
-		go commitTransactionOnComplete(tx, wg)
+		if lockingClause != "" {
+			go commitTransactionOnComplete(tx, wg)
+		}
 
 		if len(distinctRecords) < 1 {
 			log.Printf("No entries in the log. Waiting %.2f seconds", feeder.config.NoRecordsSleepDuration.Seconds())
@@ -106,7 +114,7 @@ func (feeder *SQLWALFeeder) queryDB(walEntriesChannel chan *model.WALEntry) {
 			consistencyRecord := mapSQLToRecord(distinctRecords[idx])
 			walEntriesChannel <- &model.WALEntry{
 				Record:              consistencyRecord,
-				RecordProcessedHook: recordProcessedHook(tx, wg, feeder.config.FailureDelay, startTime),
+				RecordProcessedHook: recordProcessedHook(tx, feeder.dialect, wg, feeder.config.FailureDelay, startTime),
 			}
 		}
 		wg.Wait()
@@ -121,7 +129,7 @@ func commitTransactionOnComplete(tx *gorm.DB, wg *sync.WaitGroup) {
 	}
 }
 
-func recordProcessedHook(tx *gorm.DB, wg *sync.WaitGroup, failureDelay time.Duration, taskStartTime time.Time) func(record *watchdog.ConsistencyRecord, err error) error {
+func recordProcessedHook(tx *gorm.DB, dialect watchdog.Dialect, wg *sync.WaitGroup, failureDelay time.Duration, taskStartTime time.Time) func(record *watchdog.ConsistencyRecord, err error) error {
 	return func(record *watchdog.ConsistencyRecord, err error) error {
 		defer wg.Done()
 
@@ -131,7 +139,7 @@ func recordProcessedHook(tx *gorm.DB, wg *sync.WaitGroup, failureDelay time.Dura
 
 			log.Printf("Error during processing of task for requestID = '%s': %s", record.RequestID, err)
 
-			err := delayNextExecution(tx, record, failureDelay)
+			err := delayNextExecution(tx, dialect, record, failureDelay)
 			if err != nil {
 				log.Printf("Failed to extend execution delay for reqID = %s: %s", record.RequestID, err)
 			}
@@ -174,16 +182,36 @@ func compactRecord(tx *gorm.DB, record *watchdog.ConsistencyRecord) error {
 	return nil
 }
 
-func delayNextExecution(tx *gorm.DB, record *watchdog.ConsistencyRecord, delay time.Duration) error {
+func delayNextExecution(tx *gorm.DB, dialect watchdog.Dialect, record *watchdog.ConsistencyRecord, delay time.Duration) error {
 	rows, err := tx.
-		Raw("UPDATE consistency_record SET execution_delay = NOW() - updated_at + INTERVAL '5 minutes' WHERE request_id = ?", record.RequestID).
+		Raw(dialect.DelayNextExecutionQuery(delay), record.RequestID).
 		Rows()
 	if err != nil {
 		return err
 	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
 	return rows.Close()
 }
 
+func distinct(consistencyRecords []watchdog.SQLConsistencyRecord) []*watchdog.SQLConsistencyRecord {
+	grouping := make(map[string]struct{})
+	distinctRecords := make([]*watchdog.SQLConsistencyRecord, 0)
+	for idx := range consistencyRecords {
+		obj := fmt.Sprintf("%s%s", consistencyRecords[idx].Domain, consistencyRecords[idx].ObjectID)
+		if _, seen := grouping[obj]; seen {
+			continue
+		}
+		grouping[obj] = struct{}{}
+		distinctRecords = append(distinctRecords, &consistencyRecords[idx])
+	}
+	return distinctRecords
+}
+
 func mapSQLToRecord(record *watchdog.SQLConsistencyRecord) *watchdog.ConsistencyRecord {
 	return &watchdog.ConsistencyRecord{
 		ObjectID:      record.ObjectID,
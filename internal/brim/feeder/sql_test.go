@@ -198,3 +198,43 @@ func createDBFactoryMock(watchdogProps map[string]string, records []watchdog.SQL
 	dbFactoryMock.On("CreateConnection", watchdogProps).Return(gormDB, nil)
 	return dbFactoryMock, db, dbMock
 }
+
+func TestShouldLockAndDelayRecordsInMySQLDialect(t *testing.T) {
+	watchdogProps := map[string]string{"dialect": "mysql"}
+	akubraConfig := config.YamlConfig{
+		Watchdog: wc.WatchdogConfig{
+			Type:  "sql",
+			Props: watchdogProps,
+		}}
+	feederConfig := WALFeederConfig{NoRecordsSleepDuration: 10 * time.Second, MaxRecordsPerQuery: 10, FailureDelay: time.Minute}
+
+	db, dbMock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	gormDB, err := gorm.Open("mysql", db)
+	assert.NoError(t, err)
+	dbFactoryMock := &dbClientFactoryMock{}
+	dbFactoryMock.On("CreateConnection", watchdogProps).Return(gormDB, nil)
+
+	dbMock.ExpectBegin()
+	dbMock.
+		ExpectQuery("SELECT \\* FROM `consistency_record` WHERE \\(updated_at \\+ INTERVAL execution_delay SECOND < NOW\\(6\\)\\) .+ FOR UPDATE SKIP LOCKED").
+		WillReturnRows(sqlmock.NewRows([]string{"request_id", "object_id", "domain", "object_version"}).AddRow("1", "some/object", "test.qxlint", 1))
+	dbMock.
+		ExpectExec("UPDATE `consistency_record` SET `error` = .+").
+		WithArgs("Fail", AnyTime{}, "1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.
+		ExpectQuery(`UPDATE consistency_record SET execution_delay = TIMESTAMPDIFF\(SECOND, updated_at, NOW\(6\)\) \+ 60 WHERE request_id = \?`).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{}))
+	dbMock.ExpectCommit()
+
+	sqlWALFeeder, err := NewSQLWALFeeder(&config.Config{YamlConfig: akubraConfig}, &feederConfig, dbFactoryMock)
+	assert.NoError(t, err)
+
+	entry := <-sqlWALFeeder.CreateFeed()
+	assert.NoError(t, entry.RecordProcessedHook(entry.Record, errors.New("Fail")))
+	assert.Equal(t, "some/object", entry.Record.ObjectID)
+	assert.Eventually(t, func() bool { return dbMock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,73 @@
+package feeder
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/allegro/akubra/internal/akubra/config"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	wc "github.com/allegro/akubra/internal/akubra/watchdog/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteFeederShouldEmitDueRecordsFromWatchdogStore(t *testing.T) {
+	watchdogConfig := wc.WatchdogConfig{
+		Type:                    "sqlite",
+		ObjectVersionHeaderName: "x-amz-meta-version",
+		Props:                   map[string]string{"path": filepath.Join(t.TempDir(), "watchdog.db")},
+	}
+	consistencyWatchdog, err := watchdog.CreateWatchdog(&watchdogConfig)
+	assert.NoError(t, err)
+	records := []*watchdog.ConsistencyRecord{
+		{RequestID: "1", ObjectID: "bucket/key", Domain: "local", AccessKey: "access", ExecutionDelay: -time.Second, Method: watchdog.PUT, ObjectVersion: 1},
+		{RequestID: "2", ObjectID: "bucket/key", Domain: "local", AccessKey: "access", ExecutionDelay: -time.Second, Method: watchdog.PUT, ObjectVersion: 2},
+		{RequestID: "3", ObjectID: "bucket/other", Domain: "local", AccessKey: "access", ExecutionDelay: -time.Second, Method: watchdog.DELETE, ObjectVersion: 3},
+		{RequestID: "4", ObjectID: "bucket/later", Domain: "local", AccessKey: "access", ExecutionDelay: time.Hour, Method: watchdog.PUT, ObjectVersion: 4},
+	}
+	for _, record := range records {
+		_, err := consistencyWatchdog.Insert(record)
+		assert.NoError(t, err)
+	}
+
+	akubraConfig := &config.Config{YamlConfig: config.YamlConfig{Watchdog: watchdogConfig}}
+	feederConfig := &WALFeederConfig{NoRecordsSleepDuration: 10 * time.Millisecond, MaxRecordsPerQuery: 10, FailureDelay: time.Minute}
+	walFeeder, err := NewWALFeeder(akubraConfig, feederConfig)
+	assert.NoError(t, err)
+	sqliteFeeder := walFeeder.(*SQLWALFeeder)
+
+	feed := walFeeder.CreateFeed()
+	first := <-feed
+	second := <-feed
+
+	assert.Equal(t, "3", first.Record.RequestID)
+	assert.Equal(t, 3, first.Record.ObjectVersion)
+	assert.Equal(t, "2", second.Record.RequestID)
+	assert.Equal(t, watchdog.PUT, second.Record.Method)
+
+	assert.NoError(t, first.RecordProcessedHook(first.Record, errors.New("backend down")))
+	assert.NoError(t, second.RecordProcessedHook(second.Record, nil))
+
+	remaining := make([]watchdog.SQLConsistencyRecord, 0)
+	assert.NoError(t, sqliteFeeder.db.Order("request_id").Find(&remaining).Error)
+	assert.Len(t, remaining, 2)
+	assert.Equal(t, "3", remaining[0].RequestID)
+	assert.Equal(t, "backend down", remaining[0].Error)
+	assert.Equal(t, "4", remaining[1].RequestID)
+
+	select {
+	case entry := <-feed:
+		assert.Fail(t, "unexpected entry", entry.Record.RequestID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestShouldNotCreateFeederForUnsupportedWatchdog(t *testing.T) {
+	akubraConfig := &config.Config{YamlConfig: config.YamlConfig{Watchdog: wc.WatchdogConfig{Type: "unknown"}}}
+
+	walFeeder, err := NewWALFeeder(akubraConfig, &WALFeederConfig{})
+
+	assert.Nil(t, walFeeder)
+	assert.Error(t, err)
+}
@@ -18,4 +18,8 @@ type WALTask struct {
 	SourceClient        *s3.S3
 	DestinationsClients []*s3.S3
 	WALEntry            *WALEntry
+	//Action is ActionMove for the tasks which remove the object from ObsoleteClients once it's copied
+	//to the destinations
+	Action          string
+	ObsoleteClients []*s3.S3
 }
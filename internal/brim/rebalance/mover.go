@@ -0,0 +1,260 @@
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+	akubraConfig "github.com/allegro/akubra/internal/akubra/config"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	"github.com/allegro/akubra/internal/brim/model"
+	brimS3 "github.com/allegro/akubra/internal/brim/s3"
+	"github.com/allegro/akubra/internal/brim/worker"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	maxMoveAttempts     = 3
+	moveRetryDelay      = 5 * time.Second
+	moveRequestIDPrefix = "rebalance-"
+)
+
+// ErrMoverClosed is returned if a move is enqueued after the mover was closed
+var ErrMoverClosed = errors.New("mover closed")
+
+// Mover moves the enqueued objects with the brim workers. Every move is planned by checking the storages of both
+// shards and handed to the workers as a model.ActionMove task, which copies the newest object to every storage of
+// the destination shard which doesn't keep a newer one and then removes it from every storage of the source shard
+// which keeps it. Failed moves are planned again, up to maxMoveAttempts times
+type Mover struct {
+	conf       *akubraConfig.Config
+	region     string
+	resolver   ClientResolver
+	progress   *Progress
+	moves      chan *pendingMove
+	tasks      chan *model.WALTask
+	retryDelay time.Duration
+	closed     int32
+	pending    sync.WaitGroup
+	planners   sync.WaitGroup
+}
+
+type pendingMove struct {
+	*Move
+	attempt int
+	since   time.Time
+}
+
+// NewMover starts the planners of the Mover and the brim workers performing the moves
+func NewMover(conf *akubraConfig.Config, region string, resolver ClientResolver, progress *Progress,
+	maxConcurrentMoves int) *Mover {
+	if maxConcurrentMoves < 1 {
+		maxConcurrentMoves = 1
+	}
+	mover := &Mover{
+		conf:       conf,
+		region:     region,
+		resolver:   resolver,
+		progress:   progress,
+		moves:      make(chan *pendingMove, maxConcurrentMoves),
+		tasks:      make(chan *model.WALTask),
+		retryDelay: moveRetryDelay,
+	}
+	worker.NewTaskMigratorWALWorker(maxConcurrentMoves).Process(mover.tasks)
+	for planner := 0; planner < maxConcurrentMoves; planner++ {
+		mover.planners.Add(1)
+		go mover.plan()
+	}
+	return mover
+}
+
+// Enqueue schedules the move
+func (mover *Mover) Enqueue(move *Move) error {
+	if atomic.LoadInt32(&mover.closed) == 1 {
+		return ErrMoverClosed
+	}
+	mover.pending.Add(1)
+	mover.moves <- &pendingMove{Move: move, attempt: 1, since: time.Now()}
+	return nil
+}
+
+// Close waits until the enqueued moves are done, including their retries
+func (mover *Mover) Close() {
+	if !atomic.CompareAndSwapInt32(&mover.closed, 0, 1) {
+		return
+	}
+	mover.pending.Wait()
+	close(mover.moves)
+	mover.planners.Wait()
+	close(mover.tasks)
+}
+
+func (mover *Mover) plan() {
+	defer mover.planners.Done()
+	for move := range mover.moves {
+		task, err := mover.task(move)
+		if err != nil {
+			mover.finished(move, err)
+			continue
+		}
+		if task == nil {
+			atomic.AddInt64(&mover.progress.Skipped, 1)
+			log.Printf("Object %s/%s is gone from shard %s, nothing to move", move.Bucket, move.Key, move.From)
+			mover.pending.Done()
+			continue
+		}
+		mover.tasks <- task
+	}
+}
+
+// finished counts the outcome of the move, the failed move is planned again until it runs out of attempts
+func (mover *Mover) finished(move *pendingMove, err error) {
+	if err == nil {
+		atomic.AddInt64(&mover.progress.Moved, 1)
+		metrics.UpdateSince("rebalance.move.success", move.since)
+		log.Debugf("Moved object %s/%s from shard %s to shard %s", move.Bucket, move.Key, move.From, move.To)
+		mover.pending.Done()
+		return
+	}
+	if move.attempt < maxMoveAttempts {
+		log.Printf("Attempt %d of moving object %s/%s from shard %s to shard %s failed, retrying: %s",
+			move.attempt, move.Bucket, move.Key, move.From, move.To, err)
+		move.attempt++
+		go func() {
+			time.Sleep(mover.retryDelay)
+			mover.moves <- move
+		}()
+		return
+	}
+	atomic.AddInt64(&mover.progress.Failed, 1)
+	metrics.UpdateSince("rebalance.move.failure", move.since)
+	log.Printf("Failed to move object %s/%s from shard %s to shard %s: %s", move.Bucket, move.Key, move.From, move.To, err)
+	mover.pending.Done()
+}
+
+// task creates the model.ActionMove task of the move, or returns nil if no storage of the source shard keeps the
+// object. The replicas of the source shard may diverge, so the object is copied from the newest one
+func (mover *Mover) task(move *pendingMove) (*model.WALTask, error) {
+	sources, err := mover.clients(move.From, move.AccessKey)
+	if err != nil {
+		return nil, err
+	}
+	destinations, err := mover.clients(move.To, move.AccessKey)
+	if err != nil {
+		return nil, err
+	}
+	holders, newest, newestHead, err := mover.objectHolders(sources, move.Move)
+	if err != nil {
+		return nil, err
+	}
+	if len(holders) == 0 {
+		return nil, nil
+	}
+	var staleDestinations []*s3.S3
+	for _, destination := range destinations {
+		newer, err := mover.holdsNewerObject(destination, move.Move, newestHead)
+		if err != nil {
+			return nil, err
+		}
+		// the object written to the current shard during the rebalance must not be overwritten with the stale copy
+		if newer {
+			log.Debugf("Object %s/%s on %s is not older than on shard %s, skipping the copy",
+				move.Bucket, move.Key, destination.S3Endpoint, move.From)
+			continue
+		}
+		staleDestinations = append(staleDestinations, destination)
+	}
+	return &model.WALTask{
+		Action:              model.ActionMove,
+		SourceClient:        newest,
+		DestinationsClients: staleDestinations,
+		ObsoleteClients:     holders,
+		WALEntry: &model.WALEntry{
+			Record: &watchdog.ConsistencyRecord{
+				RequestID: moveRequestIDPrefix + uuid.Must(uuid.NewV4()).String(),
+				ObjectID:  fmt.Sprintf("%s/%s", move.Bucket, move.Key),
+				Method:    watchdog.PUT,
+				Domain:    mover.region,
+				AccessKey: move.AccessKey,
+			},
+			RecordProcessedHook: func(_ *watchdog.ConsistencyRecord, err error) error {
+				mover.finished(move, err)
+				return nil
+			},
+		},
+	}, nil
+}
+
+// objectHolders returns the storages keeping the object, with the one keeping the newest object and its head
+func (mover *Mover) objectHolders(storages []*s3.S3, move *Move) ([]*s3.S3, *s3.S3, *http.Response, error) {
+	var holders []*s3.S3
+	var newest *s3.S3
+	var newestHead *http.Response
+	for _, storage := range storages {
+		head, err := storage.Bucket(move.Bucket).Head(move.Key, http.Header{})
+		if err != nil {
+			if brimS3.GetHTTPStatusCodeFromError(err) == http.StatusNotFound {
+				continue
+			}
+			return nil, nil, nil, err
+		}
+		holders = append(holders, storage)
+		if newestHead == nil || !mover.notOlder(newestHead, head) {
+			newest, newestHead = storage, head
+		}
+	}
+	return holders, newest, newestHead, nil
+}
+
+// holdsNewerObject tells if the destination keeps the same or a newer object than the source
+func (mover *Mover) holdsNewerObject(destination *s3.S3, move *Move, sourceHead *http.Response) (bool, error) {
+	head, err := destination.Bucket(move.Bucket).Head(move.Key, http.Header{})
+	if err != nil {
+		if brimS3.GetHTTPStatusCodeFromError(err) == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return mover.notOlder(head, sourceHead), nil
+}
+
+// notOlder tells if the object of the head is the same or newer than the object of the other head, the versions are
+// compared if both objects have them, the modification times otherwise
+func (mover *Mover) notOlder(head, otherHead *http.Response) bool {
+	if versionHeader := mover.conf.Watchdog.ObjectVersionHeaderName; versionHeader != "" {
+		version, err := strconv.Atoi(head.Header.Get(versionHeader))
+		otherVersion, otherErr := strconv.Atoi(otherHead.Header.Get(versionHeader))
+		if err == nil && otherErr == nil {
+			return version >= otherVersion
+		}
+	}
+	modified, err := http.ParseTime(head.Header.Get("Last-Modified"))
+	otherModified, otherErr := http.ParseTime(otherHead.Header.Get("Last-Modified"))
+	if err != nil || otherErr != nil {
+		return false
+	}
+	return !modified.Before(otherModified)
+}
+
+func (mover *Mover) clients(shardName, accessKey string) ([]*s3.S3, error) {
+	shardStorages := storagesOf(mover.conf, shardName)
+	if len(shardStorages) == 0 {
+		return nil, fmt.Errorf("no storages defined for shard %s", shardName)
+	}
+	clients := make([]*s3.S3, 0, len(shardStorages))
+	for _, shardStorage := range shardStorages {
+		client, err := mover.resolver.ResolveClientForBackend(shardStorage.name, shardStorage.endpoint, accessKey)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
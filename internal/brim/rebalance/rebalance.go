@@ -0,0 +1,354 @@
+package rebalance
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+	akubraConfig "github.com/allegro/akubra/internal/akubra/config"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/sharding"
+	"github.com/allegro/akubra/internal/brim/auth"
+	bConf "github.com/allegro/akubra/internal/brim/config"
+	brimS3 "github.com/allegro/akubra/internal/brim/s3"
+)
+
+const (
+	listPageSize            = 1000
+	defaultProgressInterval = 10 * time.Second
+	// ProgressPath is the path the progress of the rebalance is served at by the brim status server
+	ProgressPath = "/rebalance/progress"
+)
+
+// Move is a task of moving an object from the shard of the previous ring to the shard of the current one
+type Move struct {
+	Bucket    string
+	Key       string
+	From      string
+	To        string
+	AccessKey string
+}
+
+// MoveQueue accepts the moves to perform
+type MoveQueue interface {
+	Enqueue(move *Move) error
+}
+
+// ClientResolver provides the s3 clients of the storages
+type ClientResolver interface {
+	ResolveClientForBackend(backendName, hostURL, access string) (*s3.S3, error)
+}
+
+// Progress counts the objects handled by the rebalance
+type Progress struct {
+	Listed   int64
+	Enqueued int64
+	Moved    int64
+	Skipped  int64
+	Failed   int64
+}
+
+func (progress *Progress) snapshot() Progress {
+	return Progress{
+		Listed:   atomic.LoadInt64(&progress.Listed),
+		Enqueued: atomic.LoadInt64(&progress.Enqueued),
+		Moved:    atomic.LoadInt64(&progress.Moved),
+		Skipped:  atomic.LoadInt64(&progress.Skipped),
+		Failed:   atomic.LoadInt64(&progress.Failed),
+	}
+}
+
+// Rebalancer finds the objects of a region which are kept by other shards than the ring points to
+type Rebalancer struct {
+	region     string
+	conf       *akubraConfig.Config
+	diff       *sharding.RingDiff
+	resolver   ClientResolver
+	accessKeys []string
+	queue      MoveQueue
+	progress   *Progress
+}
+
+// NewRebalancer creates a Rebalancer of the region, the region has to have PreviousShards configured
+func NewRebalancer(region string, conf *akubraConfig.Config, resolver ClientResolver, accessKeys []string,
+	queue MoveQueue, progress *Progress) (*Rebalancer, error) {
+	policies, ok := conf.ShardingPolicies[region]
+	if !ok {
+		return nil, fmt.Errorf("no sharding policy for region %s", region)
+	}
+	if len(policies.PreviousShards) == 0 {
+		return nil, fmt.Errorf("no previous shards defined for region %s", region)
+	}
+	return &Rebalancer{
+		region:     region,
+		conf:       conf,
+		diff:       sharding.NewRingDiff(policies.PreviousShards, policies.Shards),
+		resolver:   resolver,
+		accessKeys: accessKeys,
+		queue:      queue,
+		progress:   progress,
+	}, nil
+}
+
+// Scan lists the buckets of the previous shards and enqueues the moves of the objects which belong to other shards now
+func (rebalancer *Rebalancer) Scan() error {
+	previousShards := append([]string{}, rebalancer.diff.PreviousShards()...)
+	sort.Strings(previousShards)
+	for _, shardName := range previousShards {
+		if err := rebalancer.scanShard(shardName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rebalancer *Rebalancer) scanShard(shardName string) error {
+	shardStorages := storagesOf(rebalancer.conf, shardName)
+	if len(shardStorages) == 0 {
+		return fmt.Errorf("no storages defined for shard %s", shardName)
+	}
+	scannedBuckets := make(map[string]struct{})
+	for _, accessKey := range rebalancer.accessKeys {
+		clients := make([]*s3.S3, 0, len(shardStorages))
+		for _, shardStorage := range shardStorages {
+			client, err := rebalancer.resolver.ResolveClientForBackend(shardStorage.name, shardStorage.endpoint, accessKey)
+			if err != nil {
+				return err
+			}
+			clients = append(clients, client)
+		}
+		// the storages of a shard are replicas, but they may diverge, so all of them are listed
+		buckets, err := listBuckets(accessKey, shardStorages, clients)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range buckets {
+			if _, scanned := scannedBuckets[bucket]; scanned {
+				continue
+			}
+			scannedBuckets[bucket] = struct{}{}
+			if err := rebalancer.scanBucket(shardName, accessKey, bucket, clients); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listBuckets returns the sorted names of the buckets of the access key found on any of the storages
+func listBuckets(accessKey string, shardStorages []storage, clients []*s3.S3) ([]string, error) {
+	names := make(map[string]struct{})
+	for idx, client := range clients {
+		service, err := client.GetService()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list buckets of %s on %s: %s", accessKey, shardStorages[idx].name, err)
+		}
+		for _, bucket := range service.Buckets {
+			names[bucket.Name] = struct{}{}
+		}
+	}
+	buckets := make([]string, 0, len(names))
+	for name := range names {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+func (rebalancer *Rebalancer) scanBucket(shardName, accessKey, bucketName string, clients []*s3.S3) error {
+	listings := make([]*bucketListing, 0, len(clients))
+	for _, client := range clients {
+		listings = append(listings, &bucketListing{bucket: client.Bucket(bucketName)})
+	}
+	for {
+		keys, done, err := nextKeys(listings)
+		if err != nil {
+			return fmt.Errorf("failed to list bucket %s on shard %s: %s", bucketName, shardName, err)
+		}
+		for _, key := range keys {
+			atomic.AddInt64(&rebalancer.progress.Listed, 1)
+			from, to, moved := rebalancer.diff.Moved(fmt.Sprintf("/%s/%s", bucketName, key))
+			if !moved || from != shardName {
+				continue
+			}
+			move := &Move{Bucket: bucketName, Key: key, From: from, To: to, AccessKey: accessKey}
+			if err := rebalancer.queue.Enqueue(move); err != nil {
+				return err
+			}
+			atomic.AddInt64(&rebalancer.progress.Enqueued, 1)
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// nextKeys merges the listings of the storages, it returns the sorted keys listed by any of them up to the last key
+// every unfinished listing got to, so the keys are returned once and in order
+func nextKeys(listings []*bucketListing) ([]string, bool, error) {
+	bound, bounded := "", false
+	for _, listing := range listings {
+		if len(listing.keys) == 0 && !listing.exhausted {
+			if err := listing.fetch(); err != nil {
+				return nil, false, err
+			}
+		}
+		if listing.exhausted {
+			continue
+		}
+		if last := listing.keys[len(listing.keys)-1]; !bounded || last < bound {
+			bound, bounded = last, true
+		}
+	}
+	merged := make(map[string]struct{})
+	for _, listing := range listings {
+		for _, key := range listing.take(bound, bounded) {
+			merged[key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, !bounded, nil
+}
+
+// bucketListing pages through the listing of a bucket on a single storage
+type bucketListing struct {
+	bucket    *s3.Bucket
+	marker    string
+	keys      []string
+	exhausted bool
+}
+
+// fetch lists the page following the marker, the listing is exhausted once the storage returns the last page or
+// doesn't have the bucket
+func (listing *bucketListing) fetch() error {
+	result, err := listing.bucket.List("", "", listing.marker, listPageSize)
+	if err != nil {
+		if brimS3.GetHTTPStatusCodeFromError(err) == http.StatusNotFound {
+			listing.exhausted = true
+			return nil
+		}
+		return err
+	}
+	for _, object := range result.Contents {
+		listing.keys = append(listing.keys, object.Key)
+	}
+	if !result.IsTruncated || len(result.Contents) == 0 {
+		listing.exhausted = true
+		return nil
+	}
+	listing.marker = result.Contents[len(result.Contents)-1].Key
+	return nil
+}
+
+// take removes the fetched keys up to the bound, or all of them if there's no bound
+func (listing *bucketListing) take(bound string, bounded bool) []string {
+	idx := 0
+	for ; idx < len(listing.keys); idx++ {
+		if bounded && listing.keys[idx] > bound {
+			break
+		}
+	}
+	taken := listing.keys[:idx]
+	listing.keys = listing.keys[idx:]
+	return taken
+}
+
+// Progress returns the counters of the rebalance
+func (rebalancer *Rebalancer) Progress() Progress {
+	return rebalancer.progress.snapshot()
+}
+
+// ProgressHandler serves the counters of the rebalance as JSON
+func ProgressHandler(progress *Progress) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(progress.snapshot()); err != nil {
+			log.Debugf("Cannot write rebalance progress: %s", err)
+		}
+	})
+}
+
+// ReportProgress logs and publishes the progress metrics of the rebalance until done is closed
+func ReportProgress(region string, progress *Progress, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			reportProgress(region, progress.snapshot())
+			return
+		case <-ticker.C:
+			reportProgress(region, progress.snapshot())
+		}
+	}
+}
+
+func reportProgress(region string, progress Progress) {
+	normalizedRegion := metrics.Clean(region)
+	metrics.UpdateGauge(fmt.Sprintf("rebalance.%s.listed", normalizedRegion), progress.Listed)
+	metrics.UpdateGauge(fmt.Sprintf("rebalance.%s.enqueued", normalizedRegion), progress.Enqueued)
+	metrics.UpdateGauge(fmt.Sprintf("rebalance.%s.moved", normalizedRegion), progress.Moved)
+	metrics.UpdateGauge(fmt.Sprintf("rebalance.%s.skipped", normalizedRegion), progress.Skipped)
+	metrics.UpdateGauge(fmt.Sprintf("rebalance.%s.failed", normalizedRegion), progress.Failed)
+	log.Printf("Rebalance of region %s: listed %d, enqueued %d, moved %d, skipped %d, failed %d",
+		region, progress.Listed, progress.Enqueued, progress.Moved, progress.Skipped, progress.Failed)
+}
+
+type storage struct {
+	name     string
+	endpoint string
+}
+
+func storagesOf(conf *akubraConfig.Config, shardName string) []storage {
+	var shardStorages []storage
+	for _, shardStorage := range conf.Shards[shardName].Storages {
+		storageConf, ok := conf.Storages[shardStorage.Name]
+		if !ok || storageConf.Backend.URL == nil {
+			continue
+		}
+		shardStorages = append(shardStorages, storage{name: shardStorage.Name, endpoint: storageConf.Backend.String()})
+	}
+	return shardStorages
+}
+
+// Run rebalances the region, it returns once all objects of the previous shards are moved
+func Run(conf *akubraConfig.Config, brimConf *bConf.BrimConf, region string) error {
+	progressInterval := brimConf.Rebalance.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = defaultProgressInterval
+	}
+	resolver := auth.NewConfigBasedBackendResolver(conf, brimConf)
+	progress := &Progress{}
+	http.Handle(ProgressPath, ProgressHandler(progress))
+	mover := NewMover(conf, region, resolver, progress, brimConf.Rebalance.MaxConcurrentMoves)
+	rebalancer, err := NewRebalancer(region, conf, resolver, brimConf.Rebalance.AccessKeys, mover, progress)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		ReportProgress(region, progress, progressInterval, done)
+		close(reported)
+	}()
+	err = rebalancer.Scan()
+	mover.Close()
+	close(done)
+	<-reported
+	if err != nil {
+		return err
+	}
+	if failed := rebalancer.Progress().Failed; failed > 0 {
+		return fmt.Errorf("failed to move %d objects of region %s", failed, region)
+	}
+	return nil
+}
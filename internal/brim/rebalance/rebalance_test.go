@@ -0,0 +1,352 @@
+package rebalance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+	akubraConfig "github.com/allegro/akubra/internal/akubra/config"
+	regionsConfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	storagesConfig "github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/allegro/akubra/internal/akubra/types"
+	brimS3 "github.com/allegro/akubra/internal/brim/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type endpointResolver struct{}
+
+func (endpointResolver) ResolveClientForBackend(backendName, hostURL, access string) (*s3.S3, error) {
+	return brimS3.GetS3Client(&brimS3.MigrationAuth{Endpoint: hostURL, AccessKey: access, SecretKey: "secret"}), nil
+}
+
+type moveRecorder struct {
+	moves []*Move
+}
+
+func (recorder *moveRecorder) Enqueue(move *Move) error {
+	recorder.moves = append(recorder.moves, move)
+	return nil
+}
+
+type fakeStorage struct {
+	mx          sync.Mutex
+	objects     map[string]string
+	headers     map[string]http.Header
+	deletes     int
+	failDeletes int
+}
+
+func (storage *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	storage.mx.Lock()
+	defer storage.mx.Unlock()
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		_, _ = fmt.Fprint(w, "<ListAllMyBucketsResult><Buckets><Bucket><Name>bucket</Name></Bucket></Buckets></ListAllMyBucketsResult>")
+	case r.Method == http.MethodGet && !strings.Contains(path, "/"):
+		storage.list(w, r.URL.Query())
+	case r.Method == http.MethodGet && strings.Contains(r.URL.RawQuery, "acl"):
+		_, _ = fmt.Fprint(w, "<AccessControlPolicy><Owner><ID>owner</ID></Owner><AccessControlList></AccessControlList></AccessControlPolicy>")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := storage.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range storage.headers[path] {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		if r.Method == http.MethodGet {
+			_, _ = fmt.Fprint(w, content)
+		}
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		storage.objects[path] = string(body)
+	case r.Method == http.MethodDelete && storage.failDeletes > 0:
+		storage.failDeletes--
+		w.WriteHeader(http.StatusForbidden)
+	case r.Method == http.MethodDelete:
+		delete(storage.objects, path)
+		storage.deletes++
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list returns a single key per page to exercise the pagination
+func (storage *fakeStorage) list(w http.ResponseWriter, query url.Values) {
+	marker := query.Get("marker")
+	var next string
+	for path := range storage.objects {
+		key := strings.TrimPrefix(path, "bucket/")
+		if key > marker && (next == "" || key < next) {
+			next = key
+		}
+	}
+	if next == "" {
+		_, _ = fmt.Fprint(w, "<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated></ListBucketResult>")
+		return
+	}
+	_, _ = fmt.Fprintf(w, "<ListBucketResult><Name>bucket</Name><IsTruncated>true</IsTruncated><Contents><Key>%s</Key></Contents></ListBucketResult>", next)
+}
+
+func rebalancedConfig(t *testing.T, previousEndpoint, currentEndpoint string) *akubraConfig.Config {
+	previousURL, err := url.Parse(previousEndpoint)
+	require.NoError(t, err)
+	currentURL, err := url.Parse(currentEndpoint)
+	require.NoError(t, err)
+	conf := &akubraConfig.Config{}
+	conf.Watchdog.ObjectVersionHeaderName = "x-amz-meta-version"
+	conf.Storages = storagesConfig.StoragesMap{
+		"previous-storage": {Backend: types.YAMLUrl{URL: previousURL}},
+		"current-storage":  {Backend: types.YAMLUrl{URL: currentURL}},
+	}
+	conf.Shards = storagesConfig.ShardsMap{
+		"previous": {Storages: storagesConfig.Storages{{Name: "previous-storage"}}},
+		"current":  {Storages: storagesConfig.Storages{{Name: "current-storage"}}},
+	}
+	conf.ShardingPolicies = regionsConfig.ShardingPolicies{
+		"region": {
+			Shards:         []regionsConfig.Policy{{ShardName: "current", Weight: 1}},
+			PreviousShards: []regionsConfig.Policy{{ShardName: "previous", Weight: 1}},
+		},
+	}
+	return conf
+}
+
+func TestShouldEnqueueMovesOfObjectsKeptByPreviousShards(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{"bucket/a": "a", "bucket/b": "b", "bucket/c": "c"}}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	queue := &moveRecorder{}
+	progress := &Progress{}
+
+	rebalancer, err := NewRebalancer("region", rebalancedConfig(t, previousServer.URL, "http://current:8080"),
+		endpointResolver{}, []string{"access"}, queue, progress)
+	require.NoError(t, err)
+
+	require.NoError(t, rebalancer.Scan())
+
+	require.Len(t, queue.moves, 3)
+	for idx, key := range []string{"a", "b", "c"} {
+		assert.Equal(t, &Move{Bucket: "bucket", Key: key, From: "previous", To: "current", AccessKey: "access"}, queue.moves[idx])
+	}
+	assert.Equal(t, Progress{Listed: 3, Enqueued: 3}, rebalancer.Progress())
+}
+
+func TestShouldNotRebalanceRegionWithoutPreviousShards(t *testing.T) {
+	conf := rebalancedConfig(t, "http://previous:8080", "http://current:8080")
+	region := conf.ShardingPolicies["region"]
+	region.PreviousShards = nil
+	conf.ShardingPolicies["region"] = region
+
+	_, err := NewRebalancer("region", conf, endpointResolver{}, nil, &moveRecorder{}, &Progress{})
+
+	assert.Error(t, err)
+}
+
+func TestMoverShouldCopyObjectToCurrentShardAndRemoveItFromPreviousOne(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{"bucket/object": "content"}}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	current := &fakeStorage{objects: map[string]string{}}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+
+	mover := NewMover(rebalancedConfig(t, previousServer.URL, currentServer.URL), "region", endpointResolver{}, progress, 1)
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Moved: 1}, progress.snapshot())
+	assert.Equal(t, map[string]string{"bucket/object": "content"}, current.objects)
+	assert.Empty(t, previous.objects)
+	assert.Equal(t, ErrMoverClosed, mover.Enqueue(&Move{}))
+}
+
+func TestMoverShouldNotOverwriteNewerObjectOfCurrentShard(t *testing.T) {
+	previous := &fakeStorage{
+		objects: map[string]string{"bucket/object": "stale"},
+		headers: map[string]http.Header{"bucket/object": {"X-Amz-Meta-Version": {"1"}}},
+	}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	current := &fakeStorage{
+		objects: map[string]string{"bucket/object": "fresh"},
+		headers: map[string]http.Header{"bucket/object": {"X-Amz-Meta-Version": {"2"}}},
+	}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+
+	mover := NewMover(rebalancedConfig(t, previousServer.URL, currentServer.URL), "region", endpointResolver{}, progress, 1)
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Moved: 1}, progress.snapshot())
+	assert.Equal(t, map[string]string{"bucket/object": "fresh"}, current.objects)
+	assert.Empty(t, previous.objects)
+}
+
+func withSecondPreviousStorage(t *testing.T, conf *akubraConfig.Config, endpoint string) *akubraConfig.Config {
+	endpointURL, err := url.Parse(endpoint)
+	require.NoError(t, err)
+	conf.Storages["previous-replica"] = storagesConfig.Storage{Backend: types.YAMLUrl{URL: endpointURL}}
+	conf.Shards["previous"] = storagesConfig.Shard{
+		Storages: storagesConfig.Storages{{Name: "previous-storage"}, {Name: "previous-replica"}},
+	}
+	return conf
+}
+
+func TestShouldEnqueueMovesOfObjectsKeptByAnyReplicaOfPreviousShard(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{"bucket/a": "a", "bucket/c": "c"}}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	replica := &fakeStorage{objects: map[string]string{"bucket/b": "b", "bucket/c": "c", "bucket/d": "d"}}
+	replicaServer := httptest.NewServer(replica)
+	defer replicaServer.Close()
+	queue := &moveRecorder{}
+	progress := &Progress{}
+	conf := withSecondPreviousStorage(t, rebalancedConfig(t, previousServer.URL, "http://current:8080"), replicaServer.URL)
+
+	rebalancer, err := NewRebalancer("region", conf, endpointResolver{}, []string{"access"}, queue, progress)
+	require.NoError(t, err)
+
+	require.NoError(t, rebalancer.Scan())
+
+	require.Len(t, queue.moves, 4)
+	for idx, key := range []string{"a", "b", "c", "d"} {
+		assert.Equal(t, key, queue.moves[idx].Key)
+	}
+	assert.Equal(t, Progress{Listed: 4, Enqueued: 4}, rebalancer.Progress())
+}
+
+func TestMoverShouldMoveNewestObjectOfReplicasAndRemoveItFromAllOfThem(t *testing.T) {
+	previous := &fakeStorage{
+		objects: map[string]string{"bucket/object": "stale"},
+		headers: map[string]http.Header{"bucket/object": {"X-Amz-Meta-Version": {"1"}}},
+	}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	replica := &fakeStorage{
+		objects: map[string]string{"bucket/object": "fresh"},
+		headers: map[string]http.Header{"bucket/object": {"X-Amz-Meta-Version": {"2"}}},
+	}
+	replicaServer := httptest.NewServer(replica)
+	defer replicaServer.Close()
+	current := &fakeStorage{objects: map[string]string{}}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+	conf := withSecondPreviousStorage(t, rebalancedConfig(t, previousServer.URL, currentServer.URL), replicaServer.URL)
+
+	mover := NewMover(conf, "region", endpointResolver{}, progress, 1)
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Moved: 1}, progress.snapshot())
+	assert.Equal(t, map[string]string{"bucket/object": "fresh"}, current.objects)
+	assert.Empty(t, previous.objects)
+	assert.Empty(t, replica.objects)
+}
+
+func TestMoverShouldMoveObjectMissingOnFirstReplica(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{}}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	replica := &fakeStorage{objects: map[string]string{"bucket/object": "content"}}
+	replicaServer := httptest.NewServer(replica)
+	defer replicaServer.Close()
+	current := &fakeStorage{objects: map[string]string{}}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+	conf := withSecondPreviousStorage(t, rebalancedConfig(t, previousServer.URL, currentServer.URL), replicaServer.URL)
+
+	mover := NewMover(conf, "region", endpointResolver{}, progress, 1)
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Moved: 1}, progress.snapshot())
+	assert.Equal(t, map[string]string{"bucket/object": "content"}, current.objects)
+	assert.Empty(t, replica.objects)
+	assert.Zero(t, previous.deletes)
+}
+
+func TestMoverShouldRetryMoveUntilObjectIsRemovedFromAllReplicas(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{"bucket/object": "content"}}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	replica := &fakeStorage{objects: map[string]string{"bucket/object": "content"}, failDeletes: 1}
+	replicaServer := httptest.NewServer(replica)
+	defer replicaServer.Close()
+	current := &fakeStorage{objects: map[string]string{}}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+	conf := withSecondPreviousStorage(t, rebalancedConfig(t, previousServer.URL, currentServer.URL), replicaServer.URL)
+
+	mover := NewMover(conf, "region", endpointResolver{}, progress, 1)
+	mover.retryDelay = time.Millisecond
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Moved: 1}, progress.snapshot())
+	assert.Equal(t, map[string]string{"bucket/object": "content"}, current.objects)
+	assert.Empty(t, previous.objects)
+	assert.Empty(t, replica.objects)
+}
+
+func TestMoverShouldCountFailedMoveOnceItRunsOutOfAttempts(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{"bucket/object": "content"}, failDeletes: maxMoveAttempts}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	current := &fakeStorage{objects: map[string]string{}}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+
+	mover := NewMover(rebalancedConfig(t, previousServer.URL, currentServer.URL), "region", endpointResolver{}, progress, 1)
+	mover.retryDelay = time.Millisecond
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Failed: 1}, progress.snapshot())
+	assert.Equal(t, map[string]string{"bucket/object": "content"}, previous.objects)
+}
+
+func TestMoverShouldSkipObjectGoneFromPreviousShard(t *testing.T) {
+	previous := &fakeStorage{objects: map[string]string{}}
+	previousServer := httptest.NewServer(previous)
+	defer previousServer.Close()
+	current := &fakeStorage{objects: map[string]string{}}
+	currentServer := httptest.NewServer(current)
+	defer currentServer.Close()
+	progress := &Progress{}
+
+	mover := NewMover(rebalancedConfig(t, previousServer.URL, currentServer.URL), "region", endpointResolver{}, progress, 1)
+	require.NoError(t, mover.Enqueue(&Move{Bucket: "bucket", Key: "object", From: "previous", To: "current", AccessKey: "access"}))
+	mover.Close()
+
+	assert.Equal(t, Progress{Skipped: 1}, progress.snapshot())
+	assert.Empty(t, current.objects)
+}
+
+func TestProgressHandlerShouldServeCounters(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	ProgressHandler(&Progress{Listed: 4, Enqueued: 3, Moved: 1, Skipped: 1, Failed: 1}).
+		ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ProgressPath, nil))
+
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"Listed":4,"Enqueued":3,"Moved":1,"Skipped":1,"Failed":1}`, recorder.Body.String())
+}
@@ -0,0 +1,160 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AdRoll/goamz/s3"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	"github.com/allegro/akubra/internal/brim/model"
+	"github.com/allegro/akubra/internal/brim/worker"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// EmitRecords inserts a ConsistencyRecord for every mismatch, the regular brim run synchronizes the objects
+	EmitRecords = "records"
+	// EmitTasks synchronizes the mismatched objects right away
+	EmitTasks = "tasks"
+
+	recordRequestIDPrefix = "reconcile-"
+)
+
+// record creates the PUT record making brim copy the newest version of the object to the stale storages
+func (mismatch *Mismatch) record() *watchdog.ConsistencyRecord {
+	version := mismatch.States[mismatch.Source].Version
+	if version < 0 {
+		version = 0
+	}
+	return &watchdog.ConsistencyRecord{
+		RequestID:     recordRequestIDPrefix + uuid.Must(uuid.NewV4()).String(),
+		ObjectID:      fmt.Sprintf("%s/%s", mismatch.Bucket, mismatch.Key),
+		Method:        watchdog.PUT,
+		Domain:        mismatch.Domain,
+		AccessKey:     mismatch.AccessKey,
+		ObjectVersion: version,
+	}
+}
+
+// recordable tells if brim can synchronize the mismatch from its record, it needs the version of the source and
+// skips the storages keeping a different copy of the same version
+func (mismatch *Mismatch) recordable() bool {
+	sourceVersion := mismatch.States[mismatch.Source].Version
+	if sourceVersion < 0 {
+		return false
+	}
+	for _, stale := range mismatch.Stale {
+		if state := mismatch.States[stale]; state.Present && state.Version == sourceVersion {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordEmitter inserts the records of the mismatches into the consistency log, the mismatches the records can't
+// express are dropped
+type RecordEmitter struct {
+	watchdog watchdog.ConsistencyWatchdog
+	progress *Progress
+}
+
+// NewRecordEmitter creates RecordEmitter inserting the records with the watchdog
+func NewRecordEmitter(consistencyWatchdog watchdog.ConsistencyWatchdog, progress *Progress) *RecordEmitter {
+	return &RecordEmitter{watchdog: consistencyWatchdog, progress: progress}
+}
+
+// Emit implements Emitter interface
+func (emitter *RecordEmitter) Emit(mismatch *Mismatch) error {
+	if !mismatch.recordable() {
+		atomic.AddInt64(&emitter.progress.Dropped, 1)
+		log.Printf("Object %s/%s can't be synchronized from a record, its copies have no version or the same version, "+
+			"use the tasks mode", mismatch.Bucket, mismatch.Key)
+		return nil
+	}
+	record := mismatch.record()
+	if _, err := emitter.watchdog.Insert(record); err != nil {
+		return fmt.Errorf("failed to insert record of object %s: %s", record.ObjectID, err)
+	}
+	atomic.AddInt64(&emitter.progress.Emitted, 1)
+	return nil
+}
+
+// TaskEmitter synchronizes the mismatched objects with the brim workers
+type TaskEmitter struct {
+	tasks    chan *model.WALTask
+	wg       sync.WaitGroup
+	progress *Progress
+}
+
+// NewTaskEmitter creates TaskEmitter running at most maxConcurrentMigrations migrations at once
+func NewTaskEmitter(maxConcurrentMigrations int, progress *Progress) *TaskEmitter {
+	if maxConcurrentMigrations <= 0 {
+		maxConcurrentMigrations = 1
+	}
+	emitter := &TaskEmitter{tasks: make(chan *model.WALTask), progress: progress}
+	worker.NewTaskMigratorWALWorker(maxConcurrentMigrations).Process(emitter.tasks)
+	return emitter
+}
+
+// Emit implements Emitter interface
+func (emitter *TaskEmitter) Emit(mismatch *Mismatch) error {
+	destinations := make([]*s3.S3, 0, len(mismatch.Stale))
+	for _, stale := range mismatch.Stale {
+		destinations = append(destinations, mismatch.clients[stale])
+	}
+	emitter.wg.Add(1)
+	emitter.tasks <- &model.WALTask{
+		SourceClient:        mismatch.clients[mismatch.Source],
+		DestinationsClients: destinations,
+		WALEntry: &model.WALEntry{
+			Record:              mismatch.record(),
+			RecordProcessedHook: emitter.processed,
+		},
+	}
+	atomic.AddInt64(&emitter.progress.Emitted, 1)
+	return nil
+}
+
+func (emitter *TaskEmitter) processed(record *watchdog.ConsistencyRecord, err error) error {
+	defer emitter.wg.Done()
+	if err != nil {
+		log.Printf("Failed to synchronize object %s: %s", record.ObjectID, err)
+		atomic.AddInt64(&emitter.progress.Failed, 1)
+		return nil
+	}
+	atomic.AddInt64(&emitter.progress.Synced, 1)
+	return nil
+}
+
+// Close waits for the emitted tasks to finish
+func (emitter *TaskEmitter) Close() {
+	close(emitter.tasks)
+	emitter.wg.Wait()
+}
+
+// ReportEmitter writes the mismatches as JSON lines without changing the storages
+type ReportEmitter struct {
+	encoder  *json.Encoder
+	progress *Progress
+	mx       sync.Mutex
+}
+
+// NewReportEmitter creates ReportEmitter writing to the writer
+func NewReportEmitter(writer io.Writer, progress *Progress) *ReportEmitter {
+	return &ReportEmitter{encoder: json.NewEncoder(writer), progress: progress}
+}
+
+// Emit implements Emitter interface
+func (emitter *ReportEmitter) Emit(mismatch *Mismatch) error {
+	emitter.mx.Lock()
+	defer emitter.mx.Unlock()
+	if err := emitter.encoder.Encode(mismatch); err != nil {
+		return err
+	}
+	atomic.AddInt64(&emitter.progress.Emitted, 1)
+	return nil
+}
@@ -0,0 +1,112 @@
+package reconcile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+	"github.com/allegro/akubra/external/miniotweak/s3signer"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/storages/merger"
+	"github.com/allegro/akubra/internal/akubra/storages/merger/s3datatypes"
+)
+
+// bucketLister pages through the listing of a bucket on a single storage
+type bucketLister struct {
+	storage   string
+	client    *s3.S3
+	bucket    string
+	marker    string
+	buffer    s3datatypes.ObjectInfos
+	exhausted bool
+}
+
+// fetch lists the page following the marker, the lister is exhausted once the storage returns the last page
+func (lister *bucketLister) fetch(httpClient *http.Client) error {
+	query := url.Values{}
+	query.Set("max-keys", strconv.Itoa(listPageSize))
+	if lister.marker != "" {
+		query.Set("marker", lister.marker)
+	}
+	listURL := fmt.Sprintf("%s/%s?%s", strings.TrimSuffix(lister.client.S3Endpoint, "/"), lister.bucket, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, listURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req = s3signer.SignV2(req, lister.client.AccessKey, lister.client.SecretKey, nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Debugf("Cannot close list response body: %s", closeErr)
+		}
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		lister.exhausted = true
+		_, _ = ioutil.ReadAll(resp.Body)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	result, err := merger.ParseBucketListResult(resp.Body)
+	if err != nil {
+		return err
+	}
+	lister.buffer = result.Contents
+	if len(result.Contents) == 0 || !result.IsTruncated {
+		lister.exhausted = true
+		return nil
+	}
+	lister.marker = result.NextMarker
+	if lister.marker == "" {
+		lister.marker = result.Contents[len(result.Contents)-1].Key
+	}
+	return nil
+}
+
+// take removes the buffered objects up to the bound, or all of them if the listing is complete
+func (lister *bucketLister) take(bound string, complete bool) map[string]s3datatypes.ObjectInfo {
+	taken := make(map[string]s3datatypes.ObjectInfo)
+	idx := 0
+	for ; idx < len(lister.buffer); idx++ {
+		if !complete && lister.buffer[idx].Key > bound {
+			break
+		}
+		taken[lister.buffer[idx].Key] = lister.buffer[idx]
+	}
+	lister.buffer = lister.buffer[idx:]
+	return taken
+}
+
+// rateLimiter spaces the requests sent to the storages evenly
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / requestsPerSecond))}
+}
+
+func (limiter *rateLimiter) wait() {
+	if limiter.ticker != nil {
+		<-limiter.ticker.C
+	}
+}
+
+func (limiter *rateLimiter) stop() {
+	if limiter.ticker != nil {
+		limiter.ticker.Stop()
+	}
+}
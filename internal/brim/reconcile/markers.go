@@ -0,0 +1,109 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MarkerStore keeps the last compared key of every bucket and storage, so an interrupted scan is resumed
+// instead of listing the buckets from the beginning
+type MarkerStore struct {
+	path    string
+	markers map[string]map[string]string
+	mx      sync.Mutex
+}
+
+// NewMarkerStore loads the markers from the file, the markers are kept in memory only if the path is empty
+func NewMarkerStore(path string) (*MarkerStore, error) {
+	store := &MarkerStore{path: path, markers: make(map[string]map[string]string)}
+	if path == "" {
+		return store, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return store, nil
+	}
+	if err := json.Unmarshal(content, &store.markers); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Start returns the key the scan of the bucket should start after, the lowest marker of the storages is used,
+// so no key is skipped on any of them
+func (store *MarkerStore) Start(bucket string, storages []string) string {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+	bucketMarkers := store.markers[bucket]
+	start := ""
+	for idx, storage := range storages {
+		marker, ok := bucketMarkers[storage]
+		if !ok || marker == "" {
+			return ""
+		}
+		if idx == 0 || marker < start {
+			start = marker
+		}
+	}
+	return start
+}
+
+// Save records the marker of the bucket on the storages
+func (store *MarkerStore) Save(bucket string, storages []string, marker string) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+	bucketMarkers, ok := store.markers[bucket]
+	if !ok {
+		bucketMarkers = make(map[string]string, len(storages))
+		store.markers[bucket] = bucketMarkers
+	}
+	for _, storage := range storages {
+		bucketMarkers[storage] = marker
+	}
+	return store.persist()
+}
+
+// Done removes the markers of a completely scanned bucket
+func (store *MarkerStore) Done(bucket string) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+	if _, ok := store.markers[bucket]; !ok {
+		return nil
+	}
+	delete(store.markers, bucket)
+	return store.persist()
+}
+
+// persist replaces the markers file atomically, so a crash never leaves it partially written
+func (store *MarkerStore) persist() error {
+	if store.path == "" {
+		return nil
+	}
+	content, err := json.Marshal(store.markers)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), store.path)
+}
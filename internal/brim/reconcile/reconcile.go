@@ -0,0 +1,579 @@
+package reconcile
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+	akubraConfig "github.com/allegro/akubra/internal/akubra/config"
+	"github.com/allegro/akubra/internal/akubra/log"
+	"github.com/allegro/akubra/internal/akubra/metrics"
+	"github.com/allegro/akubra/internal/akubra/storages/merger/s3datatypes"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	"github.com/allegro/akubra/internal/brim/auth"
+	bConf "github.com/allegro/akubra/internal/brim/config"
+	brimS3 "github.com/allegro/akubra/internal/brim/s3"
+)
+
+const (
+	listPageSize            = 1000
+	defaultProgressInterval = 10 * time.Second
+	unknownVersion          = -1
+)
+
+var multipartETag = regexp.MustCompile(`^[0-9a-fA-F]{32}-[0-9]+$`)
+
+// ClientResolver provides the s3 clients of the storages
+type ClientResolver interface {
+	ResolveClientForBackend(backendName, hostURL, access string) (*s3.S3, error)
+}
+
+// ObjectState describes the object on a storage
+type ObjectState struct {
+	Present      bool      `json:"Present"`
+	ETag         string    `json:"ETag,omitempty"`
+	Size         int64     `json:"Size"`
+	Version      int       `json:"Version"`
+	LastModified time.Time `json:"LastModified"`
+}
+
+// Mismatch is an object which differs across the storages of a shard, Source keeps its newest copy and Stale
+// are the storages to be synchronized with it
+type Mismatch struct {
+	Shard     string                 `json:"Shard"`
+	Domain    string                 `json:"Domain"`
+	Bucket    string                 `json:"Bucket"`
+	Key       string                 `json:"Key"`
+	AccessKey string                 `json:"AccessKey"`
+	Source    string                 `json:"Source"`
+	Stale     []string               `json:"Stale"`
+	States    map[string]ObjectState `json:"States"`
+	clients   map[string]*s3.S3
+}
+
+// Emitter handles the mismatches found by the scan
+type Emitter interface {
+	Emit(mismatch *Mismatch) error
+}
+
+// Progress counts the objects handled by the scan
+type Progress struct {
+	Compared   int64
+	Mismatched int64
+	// Missing counts the objects missing on some storages which are reported only
+	Missing int64
+	Emitted int64
+	// Dropped counts the mismatches the emitter can't synchronize
+	Dropped int64
+	Synced  int64
+	Failed  int64
+}
+
+func (progress *Progress) snapshot() Progress {
+	return Progress{
+		Compared:   atomic.LoadInt64(&progress.Compared),
+		Mismatched: atomic.LoadInt64(&progress.Mismatched),
+		Missing:    atomic.LoadInt64(&progress.Missing),
+		Emitted:    atomic.LoadInt64(&progress.Emitted),
+		Dropped:    atomic.LoadInt64(&progress.Dropped),
+		Synced:     atomic.LoadInt64(&progress.Synced),
+		Failed:     atomic.LoadInt64(&progress.Failed),
+	}
+}
+
+// Scanner lists the buckets on every storage of a shard and emits the objects which differ across the storages
+type Scanner struct {
+	shard         string
+	domain        string
+	storages      []storage
+	resolver      ClientResolver
+	accessKeys    []string
+	buckets       map[string]struct{}
+	versionHeader string
+	checkVersions bool
+	// restoreMissing makes the objects missing on some storages emitted, they may be deleted objects as well
+	restoreMissing bool
+	limiter        *rateLimiter
+	markers        *MarkerStore
+	emitter        Emitter
+	progress       *Progress
+	httpClient     *http.Client
+}
+
+// NewScanner creates a Scanner of the shard, the shard has to have at least two storages
+func NewScanner(shard string, conf *akubraConfig.Config, reconcileConf bConf.ReconcileConf, resolver ClientResolver,
+	markers *MarkerStore, emitter Emitter, progress *Progress) (*Scanner, error) {
+	shardStorages := storagesOf(conf, shard)
+	if len(shardStorages) < 2 {
+		return nil, fmt.Errorf("shard %s needs at least two storages to be reconciled", shard)
+	}
+	buckets := make(map[string]struct{}, len(reconcileConf.Buckets))
+	for _, bucket := range reconcileConf.Buckets {
+		buckets[bucket] = struct{}{}
+	}
+	return &Scanner{
+		shard:          shard,
+		domain:         domainOf(conf, shard),
+		storages:       shardStorages,
+		resolver:       resolver,
+		accessKeys:     reconcileConf.AccessKeys,
+		buckets:        buckets,
+		versionHeader:  conf.Watchdog.ObjectVersionHeaderName,
+		checkVersions:  !reconcileConf.SkipVersionCheck,
+		restoreMissing: reconcileConf.RestoreMissing,
+		limiter:        newRateLimiter(reconcileConf.MaxRequestsPerSecond),
+		markers:        markers,
+		emitter:        emitter,
+		progress:       progress,
+		httpClient:     &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Scan compares the buckets of the access keys across the storages of the shard
+func (scanner *Scanner) Scan() error {
+	defer scanner.limiter.stop()
+	scannedBuckets := make(map[string]struct{})
+	for _, accessKey := range scanner.accessKeys {
+		clients, err := scanner.clients(accessKey)
+		if err != nil {
+			return err
+		}
+		buckets, err := scanner.listBuckets(accessKey, clients)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range buckets {
+			if _, scanned := scannedBuckets[bucket]; scanned {
+				continue
+			}
+			scannedBuckets[bucket] = struct{}{}
+			if err := scanner.scanBucket(accessKey, bucket, clients); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (scanner *Scanner) clients(accessKey string) ([]*s3.S3, error) {
+	clients := make([]*s3.S3, 0, len(scanner.storages))
+	for _, shardStorage := range scanner.storages {
+		client, err := scanner.resolver.ResolveClientForBackend(shardStorage.name, shardStorage.endpoint, accessKey)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// listBuckets returns the buckets found on any of the storages, a bucket missing on some of them is scanned too
+func (scanner *Scanner) listBuckets(accessKey string, clients []*s3.S3) ([]string, error) {
+	bucketsSet := make(map[string]struct{})
+	for idx, client := range clients {
+		scanner.limiter.wait()
+		service, err := client.GetService()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list buckets of %s on %s: %s", accessKey, scanner.storages[idx].name, err)
+		}
+		for _, bucket := range service.Buckets {
+			if _, listed := scanner.buckets[bucket.Name]; len(scanner.buckets) > 0 && !listed {
+				continue
+			}
+			bucketsSet[bucket.Name] = struct{}{}
+		}
+	}
+	buckets := make([]string, 0, len(bucketsSet))
+	for bucket := range bucketsSet {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+// scanBucket merges the sorted listings of the storages, the keys up to the lowest last listed key are complete
+// on every storage, so they are compared and the marker is moved past them
+func (scanner *Scanner) scanBucket(accessKey, bucket string, clients []*s3.S3) error {
+	storageNames := scanner.storageNames()
+	marker := scanner.markers.Start(bucket, storageNames)
+	if marker != "" {
+		log.Printf("Resuming reconciliation of bucket %s on shard %s after key %q", bucket, scanner.shard, marker)
+	}
+	listers := make([]*bucketLister, len(clients))
+	for idx, client := range clients {
+		listers[idx] = &bucketLister{storage: storageNames[idx], client: client, bucket: bucket, marker: marker}
+	}
+	for {
+		if err := scanner.fill(listers); err != nil {
+			return err
+		}
+		bound, complete := listedBound(listers)
+		if complete && bufferedCount(listers) == 0 {
+			return scanner.markers.Done(bucket)
+		}
+		listed := make([]map[string]s3datatypes.ObjectInfo, len(listers))
+		keysSet := make(map[string]struct{})
+		for idx, lister := range listers {
+			listed[idx] = lister.take(bound, complete)
+			for key := range listed[idx] {
+				keysSet[key] = struct{}{}
+			}
+		}
+		keys := make([]string, 0, len(keysSet))
+		for key := range keysSet {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := scanner.compare(accessKey, bucket, key, listed, clients); err != nil {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			if err := scanner.markers.Save(bucket, storageNames, keys[len(keys)-1]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fill lists the next pages of the storages with nothing buffered in parallel
+func (scanner *Scanner) fill(listers []*bucketLister) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(listers))
+	for idx, lister := range listers {
+		if lister.exhausted || len(lister.buffer) > 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, lister *bucketLister) {
+			defer wg.Done()
+			scanner.limiter.wait()
+			errs[idx] = lister.fetch(scanner.httpClient)
+		}(idx, lister)
+	}
+	wg.Wait()
+	for idx, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to list bucket %s on storage %s: %s", listers[idx].bucket, listers[idx].storage, err)
+		}
+	}
+	return nil
+}
+
+func (scanner *Scanner) compare(accessKey, bucket, key string, listed []map[string]s3datatypes.ObjectInfo, clients []*s3.S3) error {
+	atomic.AddInt64(&scanner.progress.Compared, 1)
+	states := make([]ObjectState, len(listed))
+	consistent := true
+	for idx := range listed {
+		object, present := listed[idx][key]
+		states[idx] = ObjectState{Present: present, Version: unknownVersion}
+		if present {
+			states[idx].ETag = strings.Trim(object.ETag, `"`)
+			states[idx].Size = object.Size
+			states[idx].LastModified = object.LastModified
+		}
+		consistent = consistent && present && sameContent(states[idx], states[0])
+	}
+	if consistent && !scanner.checkVersions {
+		return nil
+	}
+	// the versions are needed to tell the newest copy of the mismatched objects
+	for idx := range states {
+		if !states[idx].Present {
+			continue
+		}
+		if err := scanner.fetchVersion(clients[idx], bucket, key, &states[idx]); err != nil {
+			return fmt.Errorf("failed to check object %s/%s on storage %s: %s", bucket, key, scanner.storages[idx].name, err)
+		}
+	}
+	source := newest(states)
+	if source < 0 {
+		return nil
+	}
+	mismatch := &Mismatch{
+		Shard:     scanner.shard,
+		Domain:    scanner.domain,
+		Bucket:    bucket,
+		Key:       key,
+		AccessKey: accessKey,
+		Source:    scanner.storages[source].name,
+		States:    make(map[string]ObjectState, len(states)),
+		clients:   make(map[string]*s3.S3, len(states)),
+	}
+	for idx, state := range states {
+		name := scanner.storages[idx].name
+		mismatch.States[name] = state
+		mismatch.clients[name] = clients[idx]
+		if !state.Present || !sameContent(state, states[source]) || state.Version != states[source].Version {
+			mismatch.Stale = append(mismatch.Stale, name)
+		}
+	}
+	if len(mismatch.Stale) == 0 {
+		return nil
+	}
+	atomic.AddInt64(&scanner.progress.Mismatched, 1)
+	// the scan can't tell a missed write from a delete which failed on some storages
+	if mismatch.partial() && !scanner.restoreMissing {
+		atomic.AddInt64(&scanner.progress.Missing, 1)
+		log.Printf("Object %s/%s is missing on some storages of shard %s, newest on %s, not restored",
+			bucket, key, scanner.shard, mismatch.Source)
+		return nil
+	}
+	log.Debugf("Object %s/%s differs across the storages of shard %s, newest on %s, stale on %v",
+		bucket, key, scanner.shard, mismatch.Source, mismatch.Stale)
+	return scanner.emitter.Emit(mismatch)
+}
+
+func (scanner *Scanner) fetchVersion(client *s3.S3, bucket, key string, state *ObjectState) error {
+	scanner.limiter.wait()
+	resp, err := client.Bucket(bucket).Head(key, http.Header{})
+	if err != nil {
+		if brimS3.GetHTTPStatusCodeFromError(err) == http.StatusNotFound {
+			*state = ObjectState{Version: unknownVersion}
+			return nil
+		}
+		return err
+	}
+	if version, parseErr := strconv.Atoi(resp.Header.Get(scanner.versionHeader)); parseErr == nil {
+		state.Version = version
+	}
+	return nil
+}
+
+func (scanner *Scanner) storageNames() []string {
+	names := make([]string, len(scanner.storages))
+	for idx, shardStorage := range scanner.storages {
+		names[idx] = shardStorage.name
+	}
+	return names
+}
+
+// Progress returns the counters of the scan
+func (scanner *Scanner) Progress() Progress {
+	return scanner.progress.snapshot()
+}
+
+// partial tells if the object is missing on some of the storages
+func (mismatch *Mismatch) partial() bool {
+	for _, state := range mismatch.States {
+		if !state.Present {
+			return true
+		}
+	}
+	return false
+}
+
+// sameContent compares the ETags of the copies, the sizes are compared if any of them is a multipart ETag,
+// as a copy made by brim gets the ETag of a single upload
+func sameContent(first, second ObjectState) bool {
+	if first.ETag == second.ETag {
+		return true
+	}
+	if isMultipartETag(first.ETag) || isMultipartETag(second.ETag) {
+		return first.Size == second.Size
+	}
+	return false
+}
+
+func isMultipartETag(etag string) bool {
+	return multipartETag.MatchString(etag)
+}
+
+// newest picks the copy with the highest version, then the most recently modified one, -1 if there is no copy
+func newest(states []ObjectState) int {
+	source := -1
+	for idx, state := range states {
+		if !state.Present {
+			continue
+		}
+		if source < 0 || state.Version > states[source].Version ||
+			state.Version == states[source].Version && state.LastModified.After(states[source].LastModified) {
+			source = idx
+		}
+	}
+	return source
+}
+
+// listedBound returns the lowest last buffered key of the storages with more pages to list, the listing is
+// complete if all storages are listed to the end
+func listedBound(listers []*bucketLister) (string, bool) {
+	bound := ""
+	complete := true
+	for _, lister := range listers {
+		if lister.exhausted {
+			continue
+		}
+		last := lister.buffer[len(lister.buffer)-1].Key
+		if complete || last < bound {
+			bound = last
+		}
+		complete = false
+	}
+	return bound, complete
+}
+
+func bufferedCount(listers []*bucketLister) int {
+	count := 0
+	for _, lister := range listers {
+		count += len(lister.buffer)
+	}
+	return count
+}
+
+// ReportProgress logs and publishes the progress metrics of the scan until done is closed
+func ReportProgress(shard string, progress *Progress, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			reportProgress(shard, progress.snapshot())
+			return
+		case <-ticker.C:
+			reportProgress(shard, progress.snapshot())
+		}
+	}
+}
+
+func reportProgress(shard string, progress Progress) {
+	normalizedShard := metrics.Clean(shard)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.compared", normalizedShard), progress.Compared)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.mismatched", normalizedShard), progress.Mismatched)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.missing", normalizedShard), progress.Missing)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.emitted", normalizedShard), progress.Emitted)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.dropped", normalizedShard), progress.Dropped)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.synced", normalizedShard), progress.Synced)
+	metrics.UpdateGauge(fmt.Sprintf("reconcile.%s.failed", normalizedShard), progress.Failed)
+	log.Printf("Reconciliation of shard %s: compared %d, mismatched %d, missing %d, emitted %d, dropped %d, synced %d, failed %d",
+		shard, progress.Compared, progress.Mismatched, progress.Missing, progress.Emitted, progress.Dropped,
+		progress.Synced, progress.Failed)
+}
+
+type storage struct {
+	name     string
+	endpoint string
+}
+
+func storagesOf(conf *akubraConfig.Config, shardName string) []storage {
+	var shardStorages []storage
+	for _, shardStorage := range conf.Shards[shardName].Storages {
+		storageConf, ok := conf.Storages[shardStorage.Name]
+		if !ok || storageConf.Backend.URL == nil {
+			continue
+		}
+		shardStorages = append(shardStorages, storage{name: shardStorage.Name, endpoint: storageConf.Backend.String()})
+	}
+	return shardStorages
+}
+
+// domainOf returns the first domain of the sharding policies using the shard, the records of its objects are
+// assigned to it
+func domainOf(conf *akubraConfig.Config, shardName string) string {
+	policyNames := make([]string, 0, len(conf.ShardingPolicies))
+	for name := range conf.ShardingPolicies {
+		policyNames = append(policyNames, name)
+	}
+	sort.Strings(policyNames)
+	for _, name := range policyNames {
+		policy := conf.ShardingPolicies[name]
+		for _, shard := range policy.Shards {
+			if shard.ShardName == shardName && len(policy.Domains) > 0 {
+				return policy.Domains[0]
+			}
+		}
+	}
+	return ""
+}
+
+// Run reconciles the shard, a dry run reports the mismatches without changing the storages
+func Run(conf *akubraConfig.Config, brimConf *bConf.BrimConf, shard string, dryRun bool) error {
+	reconcileConf := brimConf.Reconcile
+	progressInterval := reconcileConf.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = defaultProgressInterval
+	}
+	progress := &Progress{}
+	markersFile := reconcileConf.MarkersFile
+	if dryRun {
+		markersFile = ""
+	}
+	markers, err := NewMarkerStore(markersFile)
+	if err != nil {
+		return fmt.Errorf("failed to load markers: %s", err)
+	}
+	emitter, closeEmitter, err := createEmitter(conf, reconcileConf, dryRun, progress)
+	if err != nil {
+		return err
+	}
+	scanner, err := NewScanner(shard, conf, reconcileConf, auth.NewConfigBasedBackendResolver(conf, brimConf),
+		markers, emitter, progress)
+	if err != nil {
+		closeEmitter()
+		return err
+	}
+	// the dry run reports all differing objects
+	scanner.restoreMissing = scanner.restoreMissing || dryRun
+	if !dryRun && reconcileConf.Emit != EmitTasks && scanner.domain == "" {
+		closeEmitter()
+		return fmt.Errorf("no domain served by shard %s, the records can't be assigned", shard)
+	}
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		ReportProgress(shard, progress, progressInterval, done)
+		close(reported)
+	}()
+	err = scanner.Scan()
+	closeEmitter()
+	close(done)
+	<-reported
+	if err != nil {
+		return err
+	}
+	if failed := scanner.Progress().Failed; failed > 0 {
+		return fmt.Errorf("failed to synchronize %d objects of shard %s", failed, shard)
+	}
+	return nil
+}
+
+func createEmitter(conf *akubraConfig.Config, reconcileConf bConf.ReconcileConf, dryRun bool,
+	progress *Progress) (Emitter, func(), error) {
+	if dryRun {
+		if reconcileConf.ReportFile == "" {
+			return NewReportEmitter(os.Stdout, progress), func() {}, nil
+		}
+		reportFile, err := os.Create(reconcileConf.ReportFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create report file: %s", err)
+		}
+		return NewReportEmitter(reportFile, progress), func() {
+			if closeErr := reportFile.Close(); closeErr != nil {
+				log.Printf("Cannot close report file: %s", closeErr)
+			}
+		}, nil
+	}
+	switch reconcileConf.Emit {
+	case EmitTasks:
+		emitter := NewTaskEmitter(reconcileConf.MaxConcurrentMigrations, progress)
+		return emitter, emitter.Close, nil
+	case EmitRecords, "":
+		consistencyWatchdog, err := watchdog.CreateWatchdog(&conf.Watchdog)
+		if err != nil {
+			return nil, nil, err
+		}
+		if consistencyWatchdog == nil {
+			return nil, nil, fmt.Errorf("no watchdog configured, the records can't be emitted")
+		}
+		return NewRecordEmitter(consistencyWatchdog, progress), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported emit mode '%s'", reconcileConf.Emit)
+}
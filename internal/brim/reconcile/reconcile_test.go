@@ -0,0 +1,362 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+	akubraConfig "github.com/allegro/akubra/internal/akubra/config"
+	regionsConfig "github.com/allegro/akubra/internal/akubra/regions/config"
+	storagesConfig "github.com/allegro/akubra/internal/akubra/storages/config"
+	"github.com/allegro/akubra/internal/akubra/types"
+	"github.com/allegro/akubra/internal/akubra/watchdog"
+	bConf "github.com/allegro/akubra/internal/brim/config"
+	brimS3 "github.com/allegro/akubra/internal/brim/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const versionHeader = "x-amz-meta-object-version"
+
+var modified = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type endpointResolver struct{}
+
+func (endpointResolver) ResolveClientForBackend(backendName, hostURL, access string) (*s3.S3, error) {
+	return brimS3.GetS3Client(&brimS3.MigrationAuth{Endpoint: hostURL, AccessKey: access, SecretKey: "secret"}), nil
+}
+
+type mismatchRecorder struct {
+	mismatches []*Mismatch
+}
+
+func (recorder *mismatchRecorder) Emit(mismatch *Mismatch) error {
+	recorder.mismatches = append(recorder.mismatches, mismatch)
+	return nil
+}
+
+type fakeObject struct {
+	etag     string
+	version  int
+	modified time.Time
+}
+
+type fakeStorage struct {
+	mx      sync.Mutex
+	objects map[string]fakeObject
+	sizes   map[string]int64
+	heads   int
+}
+
+func (storage *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	storage.mx.Lock()
+	defer storage.mx.Unlock()
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		_, _ = fmt.Fprint(w, "<ListAllMyBucketsResult><Buckets><Bucket><Name>bucket</Name></Bucket></Buckets></ListAllMyBucketsResult>")
+	case r.Method == http.MethodGet && !strings.Contains(path, "/"):
+		storage.list(w, r.URL.Query())
+	case r.Method == http.MethodHead:
+		storage.heads++
+		object, ok := storage.objects[strings.TrimPrefix(path, "bucket/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(versionHeader, fmt.Sprintf("%d", object.version))
+	}
+}
+
+// list returns a single key per page to exercise the merging of the listings
+func (storage *fakeStorage) list(w http.ResponseWriter, query url.Values) {
+	marker := query.Get("marker")
+	var next string
+	for key := range storage.objects {
+		if key > marker && (next == "" || key < next) {
+			next = key
+		}
+	}
+	if next == "" {
+		_, _ = fmt.Fprint(w, "<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated></ListBucketResult>")
+		return
+	}
+	object := storage.objects[next]
+	_, _ = fmt.Fprintf(w, "<ListBucketResult><Name>bucket</Name><IsTruncated>true</IsTruncated><Contents><Key>%s</Key>"+
+		"<ETag>&quot;%s&quot;</ETag><Size>%d</Size><LastModified>%s</LastModified></Contents></ListBucketResult>",
+		next, object.etag, storage.sizes[next], object.modified.Format(time.RFC3339))
+}
+
+func reconciledConfig(t *testing.T, firstEndpoint, secondEndpoint string) *akubraConfig.Config {
+	firstURL, err := url.Parse(firstEndpoint)
+	require.NoError(t, err)
+	secondURL, err := url.Parse(secondEndpoint)
+	require.NoError(t, err)
+	conf := &akubraConfig.Config{}
+	conf.Watchdog.ObjectVersionHeaderName = versionHeader
+	conf.Storages = storagesConfig.StoragesMap{
+		"first":  {Backend: types.YAMLUrl{URL: firstURL}},
+		"second": {Backend: types.YAMLUrl{URL: secondURL}},
+	}
+	conf.Shards = storagesConfig.ShardsMap{
+		"shard": {Storages: storagesConfig.Storages{{Name: "first"}, {Name: "second"}}},
+	}
+	conf.ShardingPolicies = regionsConfig.ShardingPolicies{
+		"region": {
+			Shards:  []regionsConfig.Policy{{ShardName: "shard", Weight: 1}},
+			Domains: []string{"akubra.local"},
+		},
+	}
+	return conf
+}
+
+func startStorages(t *testing.T, first, second *fakeStorage) *akubraConfig.Config {
+	firstServer := httptest.NewServer(first)
+	secondServer := httptest.NewServer(second)
+	t.Cleanup(firstServer.Close)
+	t.Cleanup(secondServer.Close)
+	return reconciledConfig(t, firstServer.URL, secondServer.URL)
+}
+
+func TestScannerShouldEmitObjectsDifferingAcrossStorages(t *testing.T) {
+	first := &fakeStorage{objects: map[string]fakeObject{
+		"a": {"etag-a", 1, modified},
+		"b": {"etag-b", 1, modified},
+		"c": {"etag-c", 2, modified},
+		"d": {"etag-d", 1, modified},
+	}}
+	second := &fakeStorage{objects: map[string]fakeObject{
+		"a": {"etag-a", 1, modified},
+		"c": {"etag-c", 1, modified},
+		"d": {"etag-d2", 1, modified.Add(time.Minute)},
+		"e": {"etag-e", 3, modified},
+	}}
+	conf := startStorages(t, first, second)
+	markers, err := NewMarkerStore("")
+	require.NoError(t, err)
+	recorder := &mismatchRecorder{}
+	progress := &Progress{}
+
+	reconcileConf := bConf.ReconcileConf{AccessKeys: []string{"access"}, RestoreMissing: true}
+	scanner, err := NewScanner("shard", conf, reconcileConf, endpointResolver{}, markers, recorder, progress)
+	require.NoError(t, err)
+	require.NoError(t, scanner.Scan())
+
+	require.Len(t, recorder.mismatches, 4)
+	expected := []struct {
+		key    string
+		source string
+		stale  []string
+	}{
+		{"b", "first", []string{"second"}},
+		{"c", "first", []string{"second"}},
+		{"d", "second", []string{"first"}},
+		{"e", "second", []string{"first"}},
+	}
+	for idx, expectedMismatch := range expected {
+		mismatch := recorder.mismatches[idx]
+		assert.Equal(t, expectedMismatch.key, mismatch.Key)
+		assert.Equal(t, expectedMismatch.source, mismatch.Source)
+		assert.Equal(t, expectedMismatch.stale, mismatch.Stale)
+		assert.Equal(t, "akubra.local", mismatch.Domain)
+		assert.Equal(t, "access", mismatch.AccessKey)
+	}
+	assert.Equal(t, 2, recorder.mismatches[1].States["first"].Version)
+	assert.Equal(t, "etag-d2", recorder.mismatches[2].States["second"].ETag)
+	assert.False(t, recorder.mismatches[3].States["first"].Present)
+	assert.Equal(t, Progress{Compared: 5, Mismatched: 4}, scanner.Progress())
+}
+
+func TestScannerShouldSkipHeadRequestsOfMatchingObjectsWithoutVersionCheck(t *testing.T) {
+	first := &fakeStorage{objects: map[string]fakeObject{"a": {"etag-a", 2, modified}, "b": {"etag-b", 1, modified}}}
+	second := &fakeStorage{objects: map[string]fakeObject{"a": {"etag-a", 1, modified}}}
+	conf := startStorages(t, first, second)
+	markers, err := NewMarkerStore("")
+	require.NoError(t, err)
+	recorder := &mismatchRecorder{}
+	reconcileConf := bConf.ReconcileConf{AccessKeys: []string{"access"}, SkipVersionCheck: true, RestoreMissing: true}
+
+	scanner, err := NewScanner("shard", conf, reconcileConf, endpointResolver{}, markers, recorder, &Progress{})
+	require.NoError(t, err)
+	require.NoError(t, scanner.Scan())
+
+	require.Len(t, recorder.mismatches, 1)
+	assert.Equal(t, "b", recorder.mismatches[0].Key)
+	assert.Equal(t, 1, first.heads)
+	assert.Equal(t, 0, second.heads)
+}
+
+func TestScannerShouldOnlyCountObjectsMissingOnSomeStoragesByDefault(t *testing.T) {
+	first := &fakeStorage{objects: map[string]fakeObject{"a": {"etag-a", 1, modified}, "b": {"etag-b", 2, modified}}}
+	second := &fakeStorage{objects: map[string]fakeObject{"b": {"etag-b", 1, modified}}}
+	conf := startStorages(t, first, second)
+	markers, err := NewMarkerStore("")
+	require.NoError(t, err)
+	recorder := &mismatchRecorder{}
+
+	scanner, err := NewScanner("shard", conf, bConf.ReconcileConf{AccessKeys: []string{"access"}}, endpointResolver{},
+		markers, recorder, &Progress{})
+	require.NoError(t, err)
+	require.NoError(t, scanner.Scan())
+
+	require.Len(t, recorder.mismatches, 1)
+	assert.Equal(t, "b", recorder.mismatches[0].Key)
+	assert.Equal(t, Progress{Compared: 2, Mismatched: 2, Missing: 1}, scanner.Progress())
+}
+
+func TestScannerShouldCompareSizesOfMultipartCopies(t *testing.T) {
+	first := &fakeStorage{objects: map[string]fakeObject{
+		"a": {"d41d8cd98f00b204e9800998ecf8427e-3", 1, modified},
+		"b": {"d41d8cd98f00b204e9800998ecf8427e-3", 1, modified},
+	}}
+	second := &fakeStorage{objects: map[string]fakeObject{
+		"a": {"0cc175b9c0f1b6a831c399e269772661", 1, modified},
+		"b": {"0cc175b9c0f1b6a831c399e269772661", 1, modified},
+	}}
+	first.sizes = map[string]int64{"a": 10, "b": 10}
+	second.sizes = map[string]int64{"a": 10, "b": 12}
+	conf := startStorages(t, first, second)
+	markers, err := NewMarkerStore("")
+	require.NoError(t, err)
+	recorder := &mismatchRecorder{}
+
+	scanner, err := NewScanner("shard", conf, bConf.ReconcileConf{AccessKeys: []string{"access"}}, endpointResolver{},
+		markers, recorder, &Progress{})
+	require.NoError(t, err)
+	require.NoError(t, scanner.Scan())
+
+	require.Len(t, recorder.mismatches, 1)
+	assert.Equal(t, "b", recorder.mismatches[0].Key)
+}
+
+func TestScannerShouldResumeFromStoredMarkers(t *testing.T) {
+	first := &fakeStorage{objects: map[string]fakeObject{"a": {"etag-a", 1, modified}, "c": {"etag-c", 1, modified}}}
+	second := &fakeStorage{objects: map[string]fakeObject{}}
+	conf := startStorages(t, first, second)
+	markersFile := filepath.Join(t.TempDir(), "markers.json")
+	require.NoError(t, ioutil.WriteFile(markersFile, []byte(`{"bucket":{"first":"b","second":"b"},"other":{"first":"x"}}`), 0644))
+	markers, err := NewMarkerStore(markersFile)
+	require.NoError(t, err)
+	recorder := &mismatchRecorder{}
+
+	reconcileConf := bConf.ReconcileConf{AccessKeys: []string{"access"}, RestoreMissing: true}
+	scanner, err := NewScanner("shard", conf, reconcileConf, endpointResolver{}, markers, recorder, &Progress{})
+	require.NoError(t, err)
+	require.NoError(t, scanner.Scan())
+
+	require.Len(t, recorder.mismatches, 1)
+	assert.Equal(t, "c", recorder.mismatches[0].Key)
+	content, err := ioutil.ReadFile(markersFile)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"other":{"first":"x"}}`, string(content))
+}
+
+func TestMarkerStoreShouldStartFromLowestMarkerOfStorages(t *testing.T) {
+	markersFile := filepath.Join(t.TempDir(), "markers.json")
+	markers, err := NewMarkerStore(markersFile)
+	require.NoError(t, err)
+
+	assert.Equal(t, "", markers.Start("bucket", []string{"first", "second"}))
+	require.NoError(t, markers.Save("bucket", []string{"first"}, "k"))
+	require.NoError(t, markers.Save("bucket", []string{"second"}, "f"))
+	assert.Equal(t, "", markers.Start("bucket", []string{"first", "second", "third"}))
+
+	reloaded, err := NewMarkerStore(markersFile)
+	require.NoError(t, err)
+	assert.Equal(t, "f", reloaded.Start("bucket", []string{"first", "second"}))
+}
+
+type watchdogMock struct {
+	*mock.Mock
+}
+
+func (wm *watchdogMock) Insert(record *watchdog.ConsistencyRecord) (*watchdog.DeleteMarker, error) {
+	args := wm.Called(record)
+	return nil, args.Error(0)
+}
+
+func (wm *watchdogMock) Delete(marker *watchdog.DeleteMarker) error {
+	return wm.Called(marker).Error(0)
+}
+
+func (wm *watchdogMock) UpdateExecutionDelay(delta *watchdog.ExecutionDelay) error {
+	return wm.Called(delta).Error(0)
+}
+
+func (wm *watchdogMock) SupplyRecordWithVersion(record *watchdog.ConsistencyRecord) error {
+	return wm.Called(record).Error(0)
+}
+
+func (wm *watchdogMock) DeleteRecord(requestID string) error {
+	return wm.Called(requestID).Error(0)
+}
+
+func TestRecordEmitterShouldInsertPutRecordWithVersionOfSource(t *testing.T) {
+	consistencyWatchdog := &watchdogMock{Mock: &mock.Mock{}}
+	consistencyWatchdog.On("Insert", mock.MatchedBy(func(record *watchdog.ConsistencyRecord) bool {
+		return strings.HasPrefix(record.RequestID, recordRequestIDPrefix) &&
+			record.ObjectID == "bucket/dir/key" &&
+			record.Method == watchdog.PUT &&
+			record.Domain == "akubra.local" &&
+			record.AccessKey == "access" &&
+			record.ObjectVersion == 7
+	})).Return(nil)
+	progress := &Progress{}
+
+	err := NewRecordEmitter(consistencyWatchdog, progress).Emit(&Mismatch{
+		Domain:    "akubra.local",
+		Bucket:    "bucket",
+		Key:       "dir/key",
+		AccessKey: "access",
+		Source:    "first",
+		Stale:     []string{"second"},
+		States:    map[string]ObjectState{"first": {Present: true, Version: 7}, "second": {Version: unknownVersion}},
+	})
+
+	require.NoError(t, err)
+	consistencyWatchdog.AssertExpectations(t)
+	assert.Equal(t, int64(1), progress.snapshot().Emitted)
+}
+
+func TestRecordEmitterShouldDropMismatchesBrimCantSynchronizeFromRecord(t *testing.T) {
+	consistencyWatchdog := &watchdogMock{Mock: &mock.Mock{}}
+	progress := &Progress{}
+	emitter := NewRecordEmitter(consistencyWatchdog, progress)
+
+	for _, states := range []map[string]ObjectState{
+		{"first": {Present: true, ETag: "a", Version: unknownVersion}, "second": {Version: unknownVersion}},
+		{"first": {Present: true, ETag: "a", Version: 3}, "second": {Present: true, ETag: "b", Version: 3}},
+	} {
+		require.NoError(t, emitter.Emit(&Mismatch{Bucket: "bucket", Key: "key", Source: "first", Stale: []string{"second"}, States: states}))
+	}
+
+	consistencyWatchdog.AssertNotCalled(t, "Insert", mock.Anything)
+	assert.Equal(t, Progress{Dropped: 2}, progress.snapshot())
+}
+
+func TestReportEmitterShouldWriteMismatchesAsJSONLines(t *testing.T) {
+	report := &bytes.Buffer{}
+	emitter := NewReportEmitter(report, &Progress{})
+
+	require.NoError(t, emitter.Emit(&Mismatch{Bucket: "bucket", Key: "a", Source: "first", Stale: []string{"second"}}))
+	require.NoError(t, emitter.Emit(&Mismatch{Bucket: "bucket", Key: "b", Source: "second", Stale: []string{"first"}}))
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	require.Len(t, lines, 2)
+	var mismatch Mismatch
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &mismatch))
+	assert.Equal(t, "b", mismatch.Key)
+	assert.Equal(t, "second", mismatch.Source)
+	assert.Equal(t, []string{"first"}, mismatch.Stale)
+}
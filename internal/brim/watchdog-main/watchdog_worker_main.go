@@ -2,7 +2,6 @@ package watchdog
 
 import (
 	"github.com/allegro/akubra/internal/akubra/config"
-	"github.com/allegro/akubra/internal/akubra/database"
 	"github.com/allegro/akubra/internal/akubra/log"
 	"github.com/allegro/akubra/internal/brim/auth"
 	bConf "github.com/allegro/akubra/internal/brim/config"
@@ -16,26 +15,21 @@ import (
 
 func RunWatchdogWorker(akubraConf *config.Config, brimConf *bConf.BrimConf) {
 
-	sqlFeeder, err := feeder.NewSQLWALFeeder(
+	walFeeder, err := feeder.NewWALFeeder(
 		akubraConf,
 		&feeder.WALFeederConfig{MaxRecordsPerQuery: uint(brimConf.WALConf.MaxRecordsPerQuery),
 			NoRecordsSleepDuration: brimConf.WALConf.NoRecordsSleepDuration,
-			FailureDelay:           brimConf.WALConf.FeederTaskFailureDelay},
-		database.NewDBClientFactory(
-			akubraConf.Watchdog.Props["dialect"],
-			"sslmode=disable dbname=:dbname: user=:user: password=:password: host=:host: port=:port: connect_timeout=:conntimeout:",
-			[]string{"user", "password", "dbname", "host", "port", "conntimeout"},
-		))
+			FailureDelay:           brimConf.WALConf.FeederTaskFailureDelay})
 
 	if err != nil {
 		log.Fatalf("Failed to configure WAL: %s", err)
 	}
 
-	sqlRecordsFeed := sqlFeeder.CreateFeed()
+	walRecordsFeed := walFeeder.CreateFeed()
 	feedProxyChannel := make(chan interface{})
 
 	go func() {
-		for e := range sqlRecordsFeed {
+		for e := range walRecordsFeed {
 			feedProxyChannel <- e
 		}
 	}()
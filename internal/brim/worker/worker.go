@@ -72,13 +72,18 @@ func (walWorker *TaskMigratorWALWorker) processTask(walTask *model.WALTask) erro
 	var err error
 	since := time.Now()
 	operation := "migration"
-	switch walTask.WALEntry.Record.Method {
-	case watchdog.PUT:
+	switch {
+	case walTask.Action == model.ActionMove:
+		operation = "move"
+		log.Debugf("Moving object %s in domain %s from %s to destinations %s",
+			walTask.WALEntry.Record.ObjectID, walTask.WALEntry.Record.Domain, walTask.SourceClient.S3Endpoint, dstEndpoints)
+		err = walWorker.performMove(walTask)
+	case walTask.WALEntry.Record.Method == watchdog.PUT:
 		log.Debugf("Performing migration of object %s in domain %s to version %s. Source %s -> destinations %s",
 			walTask.WALEntry.Record.ObjectID, walTask.WALEntry.Record.Domain, walTask.WALEntry.Record.ObjectVersion,
 			walTask.SourceClient.S3Endpoint, dstEndpoints)
 		err = walWorker.performMigration(walTask)
-	case watchdog.DELETE:
+	case walTask.WALEntry.Record.Method == watchdog.DELETE:
 		operation = "delete"
 		log.Debugf("Deleting object %s in domain %s from storages %s",
 			walTask.WALEntry.Record.ObjectID, walTask.WALEntry.Record.Domain, dstEndpoints)
@@ -132,6 +137,16 @@ func (walWorker *TaskMigratorWALWorker) performMigration(task *model.WALTask) er
 		task.WALEntry.Record.ObjectID, task.WALEntry.Record.Domain)
 	return nil
 }
+//performMove copies the object to the destinations and removes it from the obsolete storages once all copies succeed
+func (walWorker *TaskMigratorWALWorker) performMove(task *model.WALTask) error {
+	if len(task.DestinationsClients) > 0 {
+		if err := walWorker.performMigration(task); err != nil {
+			return err
+		}
+	}
+	return walWorker.performDelete(&model.WALTask{DestinationsClients: task.ObsoleteClients, WALEntry: task.WALEntry})
+}
+
 func copyObjectTask(srcEndpoint string, dstEndpoint string, bucket string, key string) s3.MigrationTaskData {
 	return s3.NewMigrationTaskData("copy", model2.ACLCopyFromSource,
 		srcEndpoint, dstEndpoint,